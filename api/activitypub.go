@@ -0,0 +1,620 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	activityStreamsContext = "https://www.w3.org/ns/activitystreams"
+	securityContext        = "https://w3id.org/security/v1"
+	publicCollection       = "https://www.w3.org/ns/activitystreams#Public"
+
+	activityJSONType = "application/activity+json"
+	outboxSize       = 20
+)
+
+// federationClient is used for all requests to remote ActivityPub servers.
+var federationClient = newPublicClient(10 * time.Second)
+
+func actorIRI(base, username string) string {
+	return base + "/ap/users/" + username
+}
+
+func articleIRI(base string, postID uint) string {
+	return fmt.Sprintf("%s/ap/posts/%d", base, postID)
+}
+
+// writeActivityJSON writes an ActivityPub document.
+func writeActivityJSON(w http.ResponseWriter, status int, doc interface{}) {
+	w.Header().Set("Content-Type", activityJSONType+"; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(doc)
+}
+
+// actorKeyFor returns the signing key pair of a user's actor, generating it
+// the first time it is needed.
+func actorKeyFor(userID uint) (ActorKey, error) {
+	var key ActorKey
+	result := DB.Where("user_id = ?", userID).First(&key)
+	if result.Error == nil {
+		return key, nil
+	}
+	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return key, result.Error
+	}
+
+	publicPEM, privatePEM, err := generateKeyPair()
+	if err != nil {
+		return key, err
+	}
+	key = ActorKey{UserID: userID, PublicKeyPEM: publicPEM, PrivateKeyPEM: privatePEM}
+	// Another request may have generated the key concurrently; keep whichever was stored first
+	if result := DB.Where("user_id = ?", userID).FirstOrCreate(&key); result.Error != nil {
+		return key, result.Error
+	}
+	return key, nil
+}
+
+// articleObject renders a post as an ActivityPub Article.
+func articleObject(r *http.Request, post Post, username string) map[string]interface{} {
+	base := apiBaseURL(r)
+	actor := actorIRI(base, username)
+
+	tags := []map[string]string{}
+	for _, tag := range post.Tags {
+		tags = append(tags, map[string]string{
+			"type": "Hashtag",
+			"name": "#" + strings.ReplaceAll(tag.Name, " ", ""),
+			"href": base + "/api/tags/" + tag.Slug + "/posts",
+		})
+	}
+
+	article := map[string]interface{}{
+		"id":           articleIRI(base, post.ID),
+		"type":         "Article",
+		"attributedTo": actor,
+		"name":         post.Title,
+		"summary":      post.Excerpt,
+		"content":      RenderPostContent(post.Content),
+		"mediaType":    "text/html",
+		"url":          postURL(publicBaseURL(r), post),
+		"published":    post.PublishedAt.UTC().Format(time.RFC3339),
+		"updated":      post.UpdatedAt.UTC().Format(time.RFC3339),
+		"to":           []string{publicCollection},
+		"cc":           []string{actor + "/followers"},
+		"tag":          tags,
+	}
+	if post.CoverImageURL != "" {
+		image := post.CoverImageURL
+		if strings.HasPrefix(image, "/") {
+			image = base + image
+		}
+		article["image"] = map[string]string{"type": "Image", "url": image}
+	}
+	return article
+}
+
+// wrapActivity wraps an object in an activity of the given type performed by actor.
+func wrapActivity(activityType, actor string, object interface{}, published time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"@context":  activityStreamsContext,
+		"id":        actor + "#activities/" + uuid.New().String(),
+		"type":      activityType,
+		"actor":     actor,
+		"published": published.UTC().Format(time.RFC3339),
+		"to":        []string{publicCollection},
+		"cc":        []string{actor + "/followers"},
+		"object":    object,
+	}
+}
+
+// WebFinger handles WebFinger lookups of acct: resources, which is how
+// fediverse servers discover a user's actor from their handle.
+func WebFinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	if !strings.HasPrefix(resource, "acct:") {
+		http.Error(w, "Unsupported resource", http.StatusBadRequest)
+		return
+	}
+
+	handle := strings.TrimPrefix(strings.TrimPrefix(resource, "acct:"), "@")
+	at := strings.LastIndexByte(handle, '@')
+	if at < 0 {
+		http.Error(w, "Unsupported resource", http.StatusBadRequest)
+		return
+	}
+	username, domain := handle[:at], handle[at+1:]
+
+	base := apiBaseURL(r)
+	if parsed, err := url.Parse(base); err != nil || !strings.EqualFold(parsed.Host, domain) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var user User
+	if result := DB.Where("username = ?", username).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jrd+json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"subject": "acct:" + user.Username + "@" + domain,
+		"aliases": []string{actorIRI(base, user.Username)},
+		"links": []map[string]string{
+			{"rel": "self", "type": activityJSONType, "href": actorIRI(base, user.Username)},
+			{"rel": "http://webfinger.net/rel/profile-page", "type": "text/html", "href": portfolioURL(publicBaseURL(r), user.Username)},
+		},
+	})
+}
+
+// GetActor handles getting the ActivityPub actor of a user.
+func GetActor(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if result := DB.Where("username = ?", mux.Vars(r)["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	key, err := actorKeyFor(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load actor key", http.StatusInternalServerError)
+		return
+	}
+
+	base := apiBaseURL(r)
+	actor := actorIRI(base, user.Username)
+	doc := map[string]interface{}{
+		"@context":          []string{activityStreamsContext, securityContext},
+		"id":                actor,
+		"type":              "Person",
+		"preferredUsername": user.Username,
+		"name":              user.Username,
+		"summary":           user.Bio,
+		"url":               portfolioURL(publicBaseURL(r), user.Username),
+		"inbox":             actor + "/inbox",
+		"outbox":            actor + "/outbox",
+		"followers":         actor + "/followers",
+		"publicKey": map[string]string{
+			"id":           actor + "#main-key",
+			"owner":        actor,
+			"publicKeyPem": key.PublicKeyPEM,
+		},
+	}
+	if user.ProfilePictureURL != "" {
+		icon := user.ProfilePictureURL
+		if strings.HasPrefix(icon, "/") {
+			icon = base + icon
+		}
+		doc["icon"] = map[string]string{"type": "Image", "url": icon}
+	}
+
+	writeActivityJSON(w, http.StatusOK, doc)
+}
+
+// GetOutbox handles getting a user's outbox: their most recent published
+// posts, each wrapped in a Create activity.
+func GetOutbox(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if result := DB.Where("username = ?", mux.Vars(r)["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	published := DB.Model(&Post{}).Where("user_id = ? AND published_at <= ?", user.ID, time.Now())
+
+	var total int64
+	if result := published.Count(&total); result.Error != nil {
+		http.Error(w, "Failed to retrieve posts", http.StatusInternalServerError)
+		return
+	}
+
+	var posts []Post
+	if result := published.Preload("Tags").Order("published_at desc").Limit(outboxSize).Find(&posts); result.Error != nil {
+		http.Error(w, "Failed to retrieve posts", http.StatusInternalServerError)
+		return
+	}
+
+	actor := actorIRI(apiBaseURL(r), user.Username)
+	items := make([]map[string]interface{}, len(posts))
+	for i, post := range posts {
+		activity := wrapActivity("Create", actor, articleObject(r, post, user.Username), post.PublishedAt)
+		activity["id"] = articleIRI(apiBaseURL(r), post.ID) + "/activity"
+		delete(activity, "@context")
+		items[i] = activity
+	}
+
+	writeActivityJSON(w, http.StatusOK, map[string]interface{}{
+		"@context":     activityStreamsContext,
+		"id":           actor + "/outbox",
+		"type":         "OrderedCollection",
+		"totalItems":   total,
+		"orderedItems": items,
+	})
+}
+
+// GetFollowersCollection handles getting the collection of a user's remote
+// followers. Only the count is disclosed.
+func GetFollowersCollection(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if result := DB.Where("username = ?", mux.Vars(r)["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var total int64
+	if result := DB.Model(&Follower{}).Where("user_id = ?", user.ID).Count(&total); result.Error != nil {
+		http.Error(w, "Failed to retrieve followers", http.StatusInternalServerError)
+		return
+	}
+
+	writeActivityJSON(w, http.StatusOK, map[string]interface{}{
+		"@context":   activityStreamsContext,
+		"id":         actorIRI(apiBaseURL(r), user.Username) + "/followers",
+		"type":       "OrderedCollection",
+		"totalItems": total,
+	})
+}
+
+// GetArticle handles getting a published post as an ActivityPub Article.
+// Deleted posts are answered with a Tombstone.
+func GetArticle(w http.ResponseWriter, r *http.Request) {
+	postID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	var post Post
+	if result := DB.Unscoped().Preload("Tags").Where("published_at <= ?", time.Now()).First(&post, postID); result.Error != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	if post.DeletedAt.Valid {
+		writeActivityJSON(w, http.StatusGone, map[string]interface{}{
+			"@context": activityStreamsContext,
+			"id":       articleIRI(apiBaseURL(r), post.ID),
+			"type":     "Tombstone",
+		})
+		return
+	}
+
+	var user User
+	if result := DB.First(&user, post.UserID); result.Error != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	article := articleObject(r, post, user.Username)
+	article["@context"] = activityStreamsContext
+	writeActivityJSON(w, http.StatusOK, article)
+}
+
+// remoteActor holds the parts of a remote actor document we rely on.
+type remoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	Endpoints struct {
+		SharedInbox string `json:"sharedInbox"`
+	} `json:"endpoints"`
+	PublicKey struct {
+		ID           string `json:"id"`
+		Owner        string `json:"owner"`
+		PublicKeyPem string `json:"publicKeyPem"`
+	} `json:"publicKey"`
+}
+
+// fetchActor retrieves a remote actor document. The request is signed with
+// the local user's key, as servers in secure mode require.
+func fetchActor(iri string, key ActorKey, keyID string) (remoteActor, error) {
+	var actor remoteActor
+
+	req, err := http.NewRequest(http.MethodGet, iri, nil)
+	if err != nil {
+		return actor, err
+	}
+	req.Header.Set("Accept", activityJSONType)
+	privateKey, err := parsePrivateKey(key.PrivateKeyPEM)
+	if err != nil {
+		return actor, err
+	}
+	if err := signRequest(req, keyID, privateKey, nil); err != nil {
+		return actor, err
+	}
+
+	resp, err := federationClient.Do(req)
+	if err != nil {
+		return actor, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return actor, fmt.Errorf("fetching actor %s: %s", iri, resp.Status)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&actor); err != nil {
+		return actor, err
+	}
+	if actor.ID == "" || actor.Inbox == "" {
+		return actor, fmt.Errorf("actor %s is missing an id or inbox", iri)
+	}
+	return actor, nil
+}
+
+// actorPublicKey returns the PEM encoded key keyID of an actor fetched from
+// iri, the key id without its fragment. The actor must be the one at iri and
+// own the key, or anyone could sign with a key copied into their own actor
+// document.
+func actorPublicKey(actor remoteActor, iri, keyID string) (string, error) {
+	if actor.ID != iri || actor.PublicKey.Owner != actor.ID ||
+		(actor.PublicKey.ID != keyID && actor.ID != keyID) {
+		return "", errors.New("key does not belong to actor")
+	}
+	return actor.PublicKey.PublicKeyPem, nil
+}
+
+// inboxActivity holds the parts of an incoming activity we rely on.
+type inboxActivity struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// objectID returns the id of an activity's object, whether it is embedded or
+// given as a bare IRI, along with its type when embedded.
+func (a inboxActivity) objectID() (string, string) {
+	var iri string
+	if err := json.Unmarshal(a.Object, &iri); err == nil {
+		return iri, ""
+	}
+	var obj struct {
+		ID     string          `json:"id"`
+		Type   string          `json:"type"`
+		Object json.RawMessage `json:"object"`
+	}
+	json.Unmarshal(a.Object, &obj)
+	return obj.ID, obj.Type
+}
+
+// PostInbox handles activities delivered to a user's inbox. Requests must
+// carry a valid HTTP signature from the activity's actor. Follow and
+// Undo(Follow) manage the user's followers; other activities are accepted
+// and ignored.
+func PostInbox(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if result := DB.Where("username = ?", mux.Vars(r)["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	key, err := actorKeyFor(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load actor key", http.StatusInternalServerError)
+		return
+	}
+
+	base := apiBaseURL(r)
+	local := actorIRI(base, user.Username)
+
+	var signer remoteActor
+	body, _, err := verifyRequest(r, func(keyID string) (string, error) {
+		iri := keyID
+		if i := strings.IndexByte(iri, '#'); i >= 0 {
+			iri = iri[:i]
+		}
+		signer, err = fetchActor(iri, key, local+"#main-key")
+		if err != nil {
+			return "", err
+		}
+		return actorPublicKey(signer, iri, keyID)
+	})
+	if err != nil {
+		// The error may describe whatever the key ID pointed at
+		log.Printf("Rejected inbox delivery for %s: %v", user.Username, err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var activity inboxActivity
+	if err := json.Unmarshal(body, &activity); err != nil {
+		http.Error(w, "Invalid activity", http.StatusBadRequest)
+		return
+	}
+	if activity.Actor != signer.ID {
+		http.Error(w, "Activity actor does not match signature", http.StatusUnauthorized)
+		return
+	}
+
+	objectID, objectType := activity.objectID()
+	switch activity.Type {
+	case "Follow":
+		if objectID != local {
+			http.Error(w, "Follow target does not match inbox", http.StatusBadRequest)
+			return
+		}
+
+		follower := Follower{UserID: user.ID, ActorID: signer.ID}
+		attrs := Follower{Inbox: signer.Inbox, SharedInbox: signer.Endpoints.SharedInbox}
+		if result := DB.Where(follower).Assign(attrs).FirstOrCreate(&follower); result.Error != nil {
+			http.Error(w, "Failed to store follower", http.StatusInternalServerError)
+			return
+		}
+
+		var follow interface{}
+		json.Unmarshal(body, &follow)
+		accept := wrapActivity("Accept", local, follow, time.Now())
+		delete(accept, "to")
+		delete(accept, "cc")
+		if err := enqueueActivity(key, local+"#main-key", []string{signer.Inbox}, accept); err != nil {
+			log.Printf("Failed to queue Accept for %s: %v", signer.ID, err)
+		}
+
+	case "Undo":
+		if objectType == "Follow" || objectType == "" {
+			if result := DB.Unscoped().Where("user_id = ? AND actor_id = ?", user.ID, signer.ID).Delete(&Follower{}); result.Error != nil {
+				http.Error(w, "Failed to remove follower", http.StatusInternalServerError)
+				return
+			}
+		}
+
+	case "Delete":
+		// The remote account itself was deleted
+		if objectID == signer.ID {
+			DB.Unscoped().Where("actor_id = ?", signer.ID).Delete(&Follower{})
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// apDelivery is an activity waiting to be posted to a remote inbox.
+type apDelivery struct {
+	Inbox    string
+	Body     []byte
+	KeyID    string
+	KeyPEM   string
+	Attempts int
+}
+
+const maxDeliveryAttempts = 5
+
+var deliveryQueue = make(chan apDelivery, 1000)
+
+// queueDelivery adds a delivery to the queue, dropping it if the queue is
+// full rather than piling up goroutines waiting for room.
+func queueDelivery(d apDelivery) {
+	select {
+	case deliveryQueue <- d:
+	default:
+		log.Printf("Delivery queue is full, dropping activity for %s", d.Inbox)
+	}
+}
+
+// StartFederationWorkers starts the background workers that deliver
+// activities to remote inboxes.
+func StartFederationWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for d := range deliveryQueue {
+				if err := deliverActivity(d); err != nil {
+					d.Attempts++
+					if d.Attempts >= maxDeliveryAttempts {
+						log.Printf("Giving up delivering to %s: %v", d.Inbox, err)
+						continue
+					}
+					// Back off exponentially: 30s, 1m, 2m, 4m
+					retry := d
+					time.AfterFunc(time.Duration(1<<uint(d.Attempts-1))*30*time.Second, func() {
+						queueDelivery(retry)
+					})
+				}
+			}
+		}()
+	}
+}
+
+// deliverActivity posts a signed activity to a remote inbox.
+func deliverActivity(d apDelivery) error {
+	key, err := parsePrivateKey(d.KeyPEM)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.Inbox, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", activityJSONType)
+	if err := signRequest(req, d.KeyID, key, d.Body); err != nil {
+		return err
+	}
+
+	resp, err := federationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("inbox responded %s", resp.Status)
+	}
+	return nil
+}
+
+// enqueueActivity queues an activity for delivery to each of the inboxes.
+func enqueueActivity(key ActorKey, keyID string, inboxes []string, activity map[string]interface{}) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	for _, inbox := range inboxes {
+		queueDelivery(apDelivery{Inbox: inbox, Body: body, KeyID: keyID, KeyPEM: key.PrivateKeyPEM})
+	}
+	return nil
+}
+
+// federatePost tells the remote followers of a post's author that the post
+// was created, updated or deleted. activityType is "Create", "Update" or
+// "Delete". Failures are logged rather than reported to the caller.
+func federatePost(r *http.Request, activityType string, post Post) {
+	var followers []Follower
+	if result := DB.Where("user_id = ?", post.UserID).Find(&followers); result.Error != nil || len(followers) == 0 {
+		return
+	}
+	if post.PublishedAt.After(time.Now()) {
+		return
+	}
+
+	var user User
+	if result := DB.First(&user, post.UserID); result.Error != nil {
+		return
+	}
+	key, err := actorKeyFor(user.ID)
+	if err != nil {
+		log.Printf("Failed to load actor key for user %d: %v", user.ID, err)
+		return
+	}
+
+	// Deliver once per server where a shared inbox is available
+	seen := make(map[string]bool)
+	var inboxes []string
+	for _, f := range followers {
+		inbox := f.Inbox
+		if f.SharedInbox != "" {
+			inbox = f.SharedInbox
+		}
+		if !seen[inbox] {
+			seen[inbox] = true
+			inboxes = append(inboxes, inbox)
+		}
+	}
+
+	base := apiBaseURL(r)
+	actor := actorIRI(base, user.Username)
+	var object interface{}
+	if activityType == "Delete" {
+		object = map[string]string{"id": articleIRI(base, post.ID), "type": "Tombstone"}
+	} else {
+		DB.Model(&post).Association("Tags").Find(&post.Tags)
+		object = articleObject(r, post, user.Username)
+	}
+
+	if err := enqueueActivity(key, actor+"#main-key", inboxes, wrapActivity(activityType, actor, object, time.Now())); err != nil {
+		log.Printf("Failed to queue %s of post %d: %v", activityType, post.ID, err)
+	}
+}
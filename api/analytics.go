@@ -0,0 +1,543 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Views of portfolios, projects and posts are counted without cookies and without
+// storing anything that identifies a visitor. A visitor is recognised by a
+// hash of their IP address and user agent salted with a random value that is
+// replaced every day and then forgotten, so visits can be told apart within a
+// day but can't be linked across days or traced back to anyone. The network
+// a view came from is hashed the same way without the user agent, for counts
+// that a visitor shouldn't be able to inflate by changing it.
+
+// botPattern matches the user agents of crawlers, link previewers and
+// scripts, whose requests aren't counted as views.
+var botPattern = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|archiver|facebookexternalhit|embedly|preview|headless|lighthouse|pingdom|uptime|monitor|curl|wget|httpie|python-|java/|go-http-client|okhttp|node-fetch|axios|libwww|scrapy`)
+
+// isBot reports whether a request was made by something other than a person
+// browsing.
+func isBot(r *http.Request) bool {
+	ua := r.UserAgent()
+	return ua == "" || botPattern.MatchString(ua)
+}
+
+// wantsNoTracking reports whether the visitor has asked not to be tracked.
+func wantsNoTracking(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// trustedProxies holds the proxies in front of the API, read from
+// TRUSTED_PROXIES as a comma-separated list of addresses and CIDR ranges.
+// Only they are believed about the address a request was made from.
+var trustedProxies struct {
+	once     sync.Once
+	prefixes []netip.Prefix
+}
+
+// parseTrustedProxies parses a list of addresses and CIDR ranges, skipping
+// any that aren't valid.
+func parseTrustedProxies(list string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				log.Printf("Ignoring trusted proxy %q: %v", entry, err)
+				continue
+			}
+			addr = addr.Unmap()
+			entry = netip.PrefixFrom(addr, addr.BitLen()).String()
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			log.Printf("Ignoring trusted proxy %q: %v", entry, err)
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+// isTrustedProxy reports whether addr is one of the proxies in front of the
+// API.
+func isTrustedProxy(addr netip.Addr) bool {
+	trustedProxies.once.Do(func() {
+		trustedProxies.prefixes = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	})
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address a request came from. When it came through
+// one of the trusted proxies, the address they report is used instead:
+// X-Forwarded-For is read from the right, skipping the trusted proxies, so
+// an address the client put there itself is never taken, and X-Real-IP is
+// used if there's no X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	if !isTrustedProxy(remote) {
+		return remote.Unmap().String()
+	}
+
+	client := remote
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(client); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop
+	}
+	if client == remote {
+		if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			client = real
+		}
+	}
+	return client.Unmap().String()
+}
+
+// analyticsSalts caches the current day's salt.
+var analyticsSalts struct {
+	sync.Mutex
+	day  string
+	salt []byte
+}
+
+// dailySalt returns the salt for the current day, creating it and deleting
+// every earlier one when the day changes. Salts are kept in the database so
+// all instances of the API, and restarts, agree on them.
+func dailySalt() ([]byte, error) {
+	day := time.Now().UTC().Format("2006-01-02")
+
+	analyticsSalts.Lock()
+	defer analyticsSalts.Unlock()
+	if analyticsSalts.day == day {
+		return analyticsSalts.salt, nil
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	// Whichever instance gets there first decides the day's salt
+	candidate := AnalyticsSalt{Day: day, Salt: hex.EncodeToString(salt)}
+	if result := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&candidate); result.Error != nil {
+		return nil, result.Error
+	}
+	var stored AnalyticsSalt
+	if result := DB.Where("day = ?", day).First(&stored); result.Error != nil {
+		return nil, result.Error
+	}
+	if result := DB.Unscoped().Where("day < ?", day).Delete(&AnalyticsSalt{}); result.Error != nil {
+		log.Printf("Failed to remove old analytics salts: %v", result.Error)
+	}
+
+	analyticsSalts.day = day
+	analyticsSalts.salt, _ = hex.DecodeString(stored.Salt)
+	return analyticsSalts.salt, nil
+}
+
+// visitorHash identifies a visitor to one owner's pages for the current day.
+// The owner is part of the hash so a visitor can't be followed across sites.
+func visitorHash(r *http.Request, ownerID uint) (string, error) {
+	return dailyHash(ownerID, clientIP(r)+"\x00"+r.UserAgent())
+}
+
+// networkHash identifies the network a view of one owner's pages came from
+// for the current day: the /24 of an IPv4 address or the /48 of an IPv6 one.
+// Unlike visitorHash it leaves out everything the client chooses, such as
+// its user agent, so it can't be varied to pass as many visitors.
+func networkHash(r *http.Request, ownerID uint) (string, error) {
+	network := clientIP(r)
+	if addr, err := netip.ParseAddr(network); err == nil {
+		bits := 48
+		if addr.Is4() {
+			bits = 24
+		}
+		prefix, _ := addr.Prefix(bits)
+		network = prefix.String()
+	}
+	return dailyHash(ownerID, network)
+}
+
+// dailyHash hashes value with the current day's salt and the owner, so it
+// can't be linked across days or across owners' sites.
+func dailyHash(ownerID uint, value string) (string, error) {
+	salt, err := dailySalt()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(strconv.FormatUint(uint64(ownerID), 10) + "\x00" + value))
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// viewReferrer returns the host of the page a visitor came from, or "" if
+// they came directly or from elsewhere on the site. The frontend passes the
+// page's referrer in X-Referrer, as the Referer of its own requests is always
+// itself; requests made straight to the API, as on custom domains, have
+// their Referer used.
+func viewReferrer(r *http.Request) string {
+	ref := r.Header.Get("X-Referrer")
+	if ref == "" {
+		ref = r.Referer()
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	host := strings.TrimPrefix(hostname(u.Host), "www.")
+	if siteHosts()[host] || host == strings.TrimPrefix(hostname(r.Host), "www.") {
+		return ""
+	}
+	return host
+}
+
+// geoRange is a range of addresses located in a country.
+type geoRange struct {
+	first, last netip.Addr
+	country     string
+}
+
+// geoIP holds the ranges read from the file GEOIP_FILE names, sorted by
+// their first address. Without the file no countries are recorded.
+var geoIP struct {
+	once   sync.Once
+	ranges []geoRange
+}
+
+// loadGeoIP reads a CSV file of first address, last address and country
+// code, such as the freely available DB-IP "IP to Country Lite" database.
+// Lines that can't be parsed are skipped.
+func loadGeoIP(path string) ([]geoRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	var ranges []geoRange
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			continue
+		}
+		first, err1 := netip.ParseAddr(strings.TrimSpace(record[0]))
+		last, err2 := netip.ParseAddr(strings.TrimSpace(record[1]))
+		country := strings.ToUpper(strings.TrimSpace(record[2]))
+		if err1 != nil || err2 != nil || len(country) != 2 {
+			continue
+		}
+		ranges = append(ranges, geoRange{first.Unmap(), last.Unmap(), country})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first.Less(ranges[j].first) })
+	return ranges, nil
+}
+
+// lookupCountry returns the code of the country an address is in, or "" if
+// it isn't known.
+func lookupCountry(ip string) string {
+	geoIP.once.Do(func() {
+		path := os.Getenv("GEOIP_FILE")
+		if path == "" {
+			return
+		}
+		ranges, err := loadGeoIP(path)
+		if err != nil {
+			log.Printf("Failed to load GeoIP database: %v", err)
+			return
+		}
+		geoIP.ranges = ranges
+	})
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	// The last range starting at or before the address
+	i := sort.Search(len(geoIP.ranges), func(i int) bool { return addr.Less(geoIP.ranges[i].first) }) - 1
+	if i < 0 || geoIP.ranges[i].last.Less(addr) {
+		return ""
+	}
+	return geoIP.ranges[i].country
+}
+
+// pageViews holds views waiting to be written, so a burst of them is
+// written by a fixed number of workers and anything beyond the queue is
+// dropped rather than piling up.
+var pageViews = make(chan PageView, 1000)
+
+// StartViewRecorders starts the background workers that write counted
+// views.
+func StartViewRecorders(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for view := range pageViews {
+				if result := DB.Create(&view); result.Error != nil {
+					log.Printf("Failed to record view: %v", result.Error)
+				}
+			}
+		}()
+	}
+}
+
+// recordView counts a view of the portfolio, project or post view is for in
+// the background. Views by bots, by the owner themselves and by visitors who
+// asked not to be tracked aren't counted.
+func recordView(r *http.Request, view PageView) {
+	if isBot(r) || wantsNoTracking(r) {
+		return
+	}
+	if userID, err := getUserIDFromContext(r); err == nil && userID == view.OwnerID {
+		return
+	}
+
+	var err error
+	if view.VisitorHash, err = visitorHash(r, view.OwnerID); err != nil {
+		log.Printf("Failed to record view: %v", err)
+		return
+	}
+	if view.NetworkHash, err = networkHash(r, view.OwnerID); err != nil {
+		log.Printf("Failed to record view: %v", err)
+		return
+	}
+	view.Referrer = viewReferrer(r)
+	view.Country = lookupCountry(clientIP(r))
+	select {
+	case pageViews <- view:
+	default:
+		log.Printf("Dropped view for user %d: queue full", view.OwnerID)
+	}
+}
+
+// recordPostView counts a view of a post once it has been published.
+func recordPostView(r *http.Request, post Post) {
+	if post.PublishedAt.IsZero() || post.PublishedAt.After(time.Now()) {
+		return
+	}
+	recordView(r, PageView{OwnerID: post.UserID, PostID: &post.ID})
+}
+
+// analyticsRanges are the time ranges the dashboard offers, with the size
+// of the buckets views are counted in.
+var analyticsRanges = map[string]struct {
+	Length time.Duration
+	Bucket string
+}{
+	"24h":  {24 * time.Hour, "hour"},
+	"7d":   {7 * 24 * time.Hour, "day"},
+	"30d":  {30 * 24 * time.Hour, "day"},
+	"90d":  {90 * 24 * time.Hour, "day"},
+	"365d": {365 * 24 * time.Hour, "week"},
+}
+
+const topAnalyticsEntries = 10
+
+// AnalyticsPoint is the number of views in one bucket of a time range.
+type AnalyticsPoint struct {
+	Time           time.Time `json:"time"`
+	Views          int64     `json:"views"`
+	UniqueVisitors int64     `json:"unique_visitors"`
+}
+
+// AnalyticsEntry is a referrer, country or page with its number of views.
+type AnalyticsEntry struct {
+	Key   string `json:"key"`
+	Label string `json:"label,omitempty"`
+	Views int64  `json:"views"`
+}
+
+// Analytics is the summary of views of a user's pages over a time range.
+// Visitors are only recognised within a day, so a visitor returning on
+// another day counts as another unique visitor.
+type Analytics struct {
+	Range          string           `json:"range"`
+	From           time.Time        `json:"from"`
+	To             time.Time        `json:"to"`
+	Views          int64            `json:"views"`
+	UniqueVisitors int64            `json:"unique_visitors"`
+	Series         []AnalyticsPoint `json:"series"`
+	TopReferrers   []AnalyticsEntry `json:"top_referrers"`
+	TopCountries   []AnalyticsEntry `json:"top_countries"`
+	TopPages       []AnalyticsEntry `json:"top_pages"`
+}
+
+// topViews counts views grouped by a column, most viewed first.
+func topViews(views func() *gorm.DB, column string) ([]AnalyticsEntry, error) {
+	entries := []AnalyticsEntry{}
+	result := views().Select(column + " AS key, COUNT(*) AS views").
+		Where(column + " <> ''").Group(column).Order("views desc, key asc").
+		Limit(topAnalyticsEntries).Scan(&entries)
+	return entries, result.Error
+}
+
+// topPages counts views of each portfolio, project and post, most viewed first.
+func topPages(views func() *gorm.DB) ([]AnalyticsEntry, error) {
+	var rows []struct {
+		PortfolioID *uint
+		ProjectID   *uint
+		PostID      *uint
+		Views       int64
+	}
+	result := views().Select("portfolio_id, project_id, post_id, COUNT(*) AS views").
+		Group("portfolio_id, project_id, post_id").Order("views desc").Limit(topAnalyticsEntries).Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	entries := make([]AnalyticsEntry, 0, len(rows))
+	for _, row := range rows {
+		entry := AnalyticsEntry{Views: row.Views}
+		switch {
+		case row.PortfolioID != nil:
+			entry.Key = "portfolio:" + strconv.FormatUint(uint64(*row.PortfolioID), 10)
+			var portfolio Portfolio
+			if DB.Unscoped().First(&portfolio, *row.PortfolioID).Error == nil {
+				entry.Label = portfolio.Title
+			}
+		case row.ProjectID != nil:
+			entry.Key = "project:" + strconv.FormatUint(uint64(*row.ProjectID), 10)
+			var project Project
+			if DB.Unscoped().First(&project, *row.ProjectID).Error == nil {
+				entry.Label = project.Title
+			}
+		case row.PostID != nil:
+			entry.Key = "post:" + strconv.FormatUint(uint64(*row.PostID), 10)
+			var post Post
+			if DB.Unscoped().First(&post, *row.PostID).Error == nil {
+				entry.Label = post.Title
+			}
+		default:
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// GetAnalytics handles summarising views of the authenticated user's
+// portfolios, projects and posts. The range query parameter is one of 24h, 7d,
+// 30d (the default), 90d and 365d; portfolio, project or post narrow it to a
+// single page by ID.
+func GetAnalytics(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	rangeName := query.Get("range")
+	if rangeName == "" {
+		rangeName = "30d"
+	}
+	span, ok := analyticsRanges[rangeName]
+	if !ok {
+		http.Error(w, "Invalid range; expected 24h, 7d, 30d, 90d or 365d", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-span.Length)
+	filters := map[string]uint64{}
+	for _, param := range []string{"portfolio", "project", "post"} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid "+param+" ID", http.StatusBadRequest)
+			return
+		}
+		filters[param+"_id = ?"] = id
+	}
+	views := func() *gorm.DB {
+		q := DB.Model(&PageView{}).Where("owner_id = ? AND created_at >= ? AND created_at < ?", userID, from, to)
+		for condition, id := range filters {
+			q = q.Where(condition, id)
+		}
+		return q
+	}
+
+	analytics := Analytics{Range: rangeName, From: from, To: to, Series: []AnalyticsPoint{}}
+	var totals struct {
+		Views          int64
+		UniqueVisitors int64
+	}
+	if result := views().Select("COUNT(*) AS views, COUNT(DISTINCT visitor_hash) AS unique_visitors").Scan(&totals); result.Error != nil {
+		http.Error(w, "Failed to retrieve analytics", http.StatusInternalServerError)
+		return
+	}
+	analytics.Views, analytics.UniqueVisitors = totals.Views, totals.UniqueVisitors
+
+	bucket := "date_trunc('" + span.Bucket + "', created_at AT TIME ZONE 'UTC')"
+	if result := views().Select(bucket + " AS time, COUNT(*) AS views, COUNT(DISTINCT visitor_hash) AS unique_visitors").
+		Group(bucket).Order("time asc").Scan(&analytics.Series); result.Error != nil {
+		http.Error(w, "Failed to retrieve analytics", http.StatusInternalServerError)
+		return
+	}
+
+	if analytics.TopReferrers, err = topViews(views, "referrer"); err != nil {
+		http.Error(w, "Failed to retrieve analytics", http.StatusInternalServerError)
+		return
+	}
+	if analytics.TopCountries, err = topViews(views, "country"); err != nil {
+		http.Error(w, "Failed to retrieve analytics", http.StatusInternalServerError)
+		return
+	}
+	if analytics.TopPages, err = topPages(views); err != nil {
+		http.Error(w, "Failed to retrieve analytics", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(analytics)
+}
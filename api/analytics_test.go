@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	trustedProxies.once.Do(func() {})
+	previous := trustedProxies.prefixes
+	trustedProxies.prefixes = parseTrustedProxies("10.0.0.0/8, 2001:db8::1, invalid")
+	defer func() { trustedProxies.prefixes = previous }()
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{remoteAddr: "[::ffff:192.0.2.1]:1234", want: "192.0.2.1"},
+		// Headers from anyone but a trusted proxy are ignored
+		{remoteAddr: "192.0.2.1:1234", forwarded: []string{"198.51.100.7"}, realIP: "198.51.100.8", want: "192.0.2.1"},
+		{remoteAddr: "10.0.0.2:1234", forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{remoteAddr: "[2001:db8::1]:1234", forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{remoteAddr: "10.0.0.2:1234", realIP: "198.51.100.8", want: "198.51.100.8"},
+		// An address the client made up is left of the one the proxy added
+		{remoteAddr: "10.0.0.2:1234", forwarded: []string{"203.0.113.9, 198.51.100.7"}, want: "198.51.100.7"},
+		{remoteAddr: "10.0.0.2:1234", forwarded: []string{"203.0.113.9", "198.51.100.7, 10.0.0.3"}, want: "198.51.100.7"},
+		{remoteAddr: "10.0.0.2:1234", forwarded: []string{"garbage, 10.0.0.3"}, want: "10.0.0.3"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, value := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("clientIP(%s, %q, %q) = %s, want %s", tt.remoteAddr, tt.forwarded, tt.realIP, got, tt.want)
+		}
+	}
+}
+
+func TestNetworkHash(t *testing.T) {
+	analyticsSalts.Lock()
+	analyticsSalts.day = time.Now().UTC().Format("2006-01-02")
+	analyticsSalts.salt = []byte("salt")
+	analyticsSalts.Unlock()
+
+	hash := func(remoteAddr, userAgent string, ownerID uint) string {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("User-Agent", userAgent)
+		h, err := networkHash(r, ownerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	base := hash("192.0.2.1:1234", "Firefox", 1)
+	if got := hash("192.0.2.200:1234", "Chrome", 1); got != base {
+		t.Error("another user agent on the same /24 hashed differently")
+	}
+	if got := hash("192.0.3.1:1234", "Firefox", 1); got == base {
+		t.Error("another /24 hashed the same")
+	}
+	if got := hash("192.0.2.1:1234", "Firefox", 2); got == base {
+		t.Error("another owner hashed the same")
+	}
+	if hash("[2001:db8:1:2::1]:1234", "Firefox", 1) != hash("[2001:db8:1:3::1]:1234", "Chrome", 1) {
+		t.Error("addresses in the same /48 hashed differently")
+	}
+}
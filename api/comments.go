@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CommentNode is a comment together with its author and replies, as returned
+// by GetComments. Deleted comments that still have replies keep their place
+// in the thread but say nothing about who wrote them.
+type CommentNode struct {
+	ID        uint           `json:"id"`
+	ParentID  *uint          `json:"parent_id"`
+	UserID    uint           `json:"user_id,omitempty"`
+	Author    string         `json:"author"`
+	Content   string         `json:"content"`
+	Status    string         `json:"status"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Replies   []*CommentNode `json:"replies"`
+}
+
+// PublicPost is a blog post as returned by the public post endpoints.
+type PublicPost struct {
+	Post
+	CommentsCount int64      `json:"comments_count"`
+	Series        *SeriesNav `json:"series,omitempty"` // Only included for single posts
+}
+
+// approvedCommentsCountExpr counts the visible comments of the post in the current row.
+const approvedCommentsCountExpr = "(SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id " +
+	"AND comments.status = 'approved' AND comments.deleted_at IS NULL)"
+
+// commentCounts returns the number of approved comments for each of the given posts.
+func commentCounts(postIDs []uint) (map[uint]int64, error) {
+	counts := make(map[uint]int64)
+	if len(postIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		PostID uint
+		Count  int64
+	}
+	result := DB.Model(&Comment{}).
+		Select("post_id, COUNT(*) AS count").
+		Where("post_id IN ? AND status = ?", postIDs, CommentApproved).
+		Group("post_id").
+		Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for _, row := range rows {
+		counts[row.PostID] = row.Count
+	}
+	return counts, nil
+}
+
+// toPublicPosts attaches comment counts to posts.
+func toPublicPosts(posts []Post) ([]PublicPost, error) {
+	ids := make([]uint, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	counts, err := commentCounts(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	publicPosts := make([]PublicPost, len(posts))
+	for i, post := range posts {
+		publicPosts[i] = PublicPost{Post: post, CommentsCount: counts[post.ID]}
+	}
+	return publicPosts, nil
+}
+
+// findPostFromRoute loads the post identified by the "id" route variable.
+func findPostFromRoute(r *http.Request) (Post, int, string) {
+	postID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return Post{}, http.StatusBadRequest, "Invalid post ID"
+	}
+
+	var post Post
+	if result := DB.First(&post, postID); result.Error != nil {
+		return Post{}, http.StatusNotFound, "Post not found"
+	}
+	return post, 0, ""
+}
+
+// findCommentFromRoute loads the comment identified by the "id" route
+// variable along with the post it belongs to.
+func findCommentFromRoute(r *http.Request) (Comment, Post, int, string) {
+	commentID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return Comment{}, Post{}, http.StatusBadRequest, "Invalid comment ID"
+	}
+
+	var comment Comment
+	if result := DB.First(&comment, commentID); result.Error != nil {
+		return Comment{}, Post{}, http.StatusNotFound, "Comment not found"
+	}
+
+	var post Post
+	if result := DB.First(&post, comment.PostID); result.Error != nil {
+		return Comment{}, Post{}, http.StatusNotFound, "Post not found"
+	}
+	return comment, post, 0, ""
+}
+
+// GetComments handles getting the comment threads of a post. Approved and
+// deleted comments are shown to everyone; the post's author also sees
+// pending and hidden comments, and commenters see their own pending ones.
+func GetComments(w http.ResponseWriter, r *http.Request) {
+	currentUserID, _ := getUserIDFromContext(r)
+
+	post, status, msg := findPostFromRoute(r)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	query := DB.Where("post_id = ?", post.ID)
+	if currentUserID != post.UserID {
+		query = query.Where("(status IN ? OR (status = ? AND user_id = ?))",
+			[]string{CommentApproved, CommentDeleted}, CommentPending, currentUserID)
+	}
+
+	var comments []Comment
+	if result := query.Order("created_at asc").Find(&comments); result.Error != nil {
+		http.Error(w, "Failed to retrieve comments", http.StatusInternalServerError)
+		return
+	}
+
+	userIDs := make([]uint, len(comments))
+	for i, c := range comments {
+		userIDs[i] = c.UserID
+	}
+	authors, err := usernamesByID(userIDs)
+	if err != nil {
+		http.Error(w, "Failed to retrieve comments", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(buildCommentTree(comments, authors))
+}
+
+// buildCommentTree arranges comments into threads. Replies whose parent is
+// not visible are shown at the top level.
+func buildCommentTree(comments []Comment, authors map[uint]string) []*CommentNode {
+	nodes := make(map[uint]*CommentNode, len(comments))
+	for _, c := range comments {
+		node := &CommentNode{
+			ID:        c.ID,
+			ParentID:  c.ParentID,
+			UserID:    c.UserID,
+			Author:    authors[c.UserID],
+			Content:   c.Content,
+			Status:    c.Status,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+			Replies:   []*CommentNode{},
+		}
+		if c.Status == CommentDeleted {
+			node.UserID, node.Author, node.Content = 0, "", ""
+		}
+		nodes[c.ID] = node
+	}
+
+	roots := []*CommentNode{}
+	for _, c := range comments {
+		node := nodes[c.ID]
+		if c.ParentID != nil {
+			if parent, ok := nodes[*c.ParentID]; ok {
+				parent.Replies = append(parent.Replies, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots
+}
+
+// CreateComment handles the authenticated user commenting on a post or
+// replying to another comment.
+func CreateComment(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	post, status, msg := findPostFromRoute(r)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+	if post.CommentsLocked {
+		http.Error(w, "Comments are locked", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Content  string `json:"content"`
+		ParentID *uint  `json:"parent_id"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		http.Error(w, "Comment cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	if req.ParentID != nil {
+		var parent Comment
+		if result := DB.Where("post_id = ?", post.ID).First(&parent, *req.ParentID); result.Error != nil {
+			http.Error(w, "Parent comment not found", http.StatusBadRequest)
+			return
+		}
+		if parent.Status == CommentDeleted || parent.Status == CommentHidden {
+			http.Error(w, "Cannot reply to this comment", http.StatusBadRequest)
+			return
+		}
+	}
+
+	comment := Comment{
+		PostID:   post.ID,
+		UserID:   userID,
+		ParentID: req.ParentID,
+		Content:  req.Content,
+		Status:   CommentApproved,
+	}
+	if post.ModerateComments && userID != post.UserID {
+		comment.Status = CommentPending
+	}
+
+	if result := DB.Create(&comment); result.Error != nil {
+		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(comment)
+}
+
+// UpdateComment handles the authenticated user editing one of their comments.
+func UpdateComment(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comment, post, status, msg := findCommentFromRoute(r)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+	if comment.UserID != userID || comment.Status == CommentDeleted {
+		http.Error(w, "Comment not found or not authorized", http.StatusNotFound)
+		return
+	}
+	if post.CommentsLocked {
+		http.Error(w, "Comments are locked", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Content string `json:"content"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		http.Error(w, "Comment cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	comment.Content = req.Content
+	// Edits to approved comments on moderated posts need approving again
+	if post.ModerateComments && userID != post.UserID && comment.Status == CommentApproved {
+		comment.Status = CommentPending
+	}
+
+	if result := DB.Save(&comment); result.Error != nil {
+		http.Error(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(comment)
+}
+
+// DeleteComment handles deleting a comment, either by its author or by the
+// author of the post. Comments with replies are kept as an empty placeholder
+// so the thread stays intact.
+func DeleteComment(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comment, post, status, msg := findCommentFromRoute(r)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+	if comment.UserID != userID && post.UserID != userID {
+		http.Error(w, "Comment not found or not authorized", http.StatusNotFound)
+		return
+	}
+
+	var replies int64
+	if result := DB.Model(&Comment{}).Where("parent_id = ?", comment.ID).Count(&replies); result.Error != nil {
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	if replies > 0 {
+		comment.Content = ""
+		comment.Status = CommentDeleted
+		if result := DB.Save(&comment); result.Error != nil {
+			http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+			return
+		}
+	} else if result := DB.Delete(&comment); result.Error != nil {
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ModerateComment handles the post's author approving or hiding a comment.
+// The new status is taken from the "action" route variable.
+func ModerateComment(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comment, post, status, msg := findCommentFromRoute(r)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+	if post.UserID != userID {
+		http.Error(w, "Comment not found or not authorized", http.StatusNotFound)
+		return
+	}
+	if comment.Status == CommentDeleted {
+		http.Error(w, "Comment has been deleted", http.StatusBadRequest)
+		return
+	}
+
+	switch mux.Vars(r)["action"] {
+	case "approve":
+		comment.Status = CommentApproved
+	case "hide":
+		comment.Status = CommentHidden
+	default:
+		http.Error(w, "Unknown moderation action", http.StatusBadRequest)
+		return
+	}
+
+	if result := DB.Save(&comment); result.Error != nil {
+		http.Error(w, "Failed to moderate comment", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(comment)
+}
+
+// UpdateCommentSettings handles the post's author locking the comment thread
+// or turning pre-moderation on or off.
+func UpdateCommentSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	post, status, msg := findOwnedPost(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var req struct {
+		Locked    *bool `json:"locked"`
+		Moderated *bool `json:"moderated"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.Locked != nil {
+		post.CommentsLocked = *req.Locked
+	}
+	if req.Moderated != nil {
+		post.ModerateComments = *req.Moderated
+	}
+
+	if result := DB.Save(&post); result.Error != nil {
+		http.Error(w, "Failed to update comment settings", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(post)
+}
+
+// GetPendingComments handles listing the comments awaiting approval on the
+// authenticated user's posts.
+func GetPendingComments(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var comments []Comment
+	result := DB.Where("status = ? AND post_id IN (SELECT id FROM posts WHERE user_id = ? AND deleted_at IS NULL)", CommentPending, userID).
+		Order("created_at asc").
+		Find(&comments)
+	if result.Error != nil {
+		http.Error(w, "Failed to retrieve comments", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(comments)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBuildCommentTree(t *testing.T) {
+	parent := uint(1)
+	missing := uint(99)
+	comments := []Comment{
+		{PostID: 1, UserID: 10, Status: CommentDeleted},
+		{PostID: 1, UserID: 11, ParentID: &parent, Content: "Reply", Status: CommentApproved},
+		{PostID: 1, UserID: 12, ParentID: &missing, Content: "Orphan", Status: CommentApproved},
+	}
+	for i := range comments {
+		comments[i].ID = uint(i + 1)
+	}
+	authors := map[uint]string{10: "alice", 11: "bob", 12: "carol"}
+
+	roots := buildCommentTree(comments, authors)
+	if len(roots) != 2 || roots[0].ID != 1 || roots[1].ID != 3 {
+		t.Fatalf("roots = %+v, want the deleted comment and the orphaned reply", roots)
+	}
+	if len(roots[0].Replies) != 1 || roots[0].Replies[0].Author != "bob" || roots[0].Replies[0].UserID != 11 {
+		t.Errorf("replies of the deleted comment = %+v", roots[0].Replies)
+	}
+
+	// Nothing about the author of a deleted comment is returned
+	data, err := json.Marshal(roots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deleted map[string]interface{}
+	if err := json.Unmarshal(data, &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := deleted["user_id"]; ok || deleted["author"] != "" || deleted["content"] != "" {
+		t.Errorf("deleted comment = %s", data)
+	}
+	for _, field := range []string{"UserID", "PostID", "DeletedAt", "alice"} {
+		if strings.Contains(string(data), field) {
+			t.Errorf("deleted comment %s contains %q", data, field)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"os"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -21,7 +23,51 @@ func ConnectDB() {
 
 	log.Println("Database connection successfully opened")
 
+	// Users used to have a single portfolio, enforced by a unique constraint
+	// on portfolios.user_id that older gorm versions named either way. They
+	// can now have several, so a lookup by user_id alone picks an arbitrary
+	// one: use mainPortfolio, or order by is_default for the one shown at
+	// /api/portfolio/{username}.
+	DB.Exec("ALTER TABLE IF EXISTS portfolios DROP CONSTRAINT IF EXISTS portfolios_user_id_key")
+	DB.Exec("ALTER TABLE IF EXISTS portfolios DROP CONSTRAINT IF EXISTS uni_portfolios_user_id")
+
+	// Revision numbers weren't unique before they were indexed
+	renumberPostRevisions()
+	// Nor were slugs
+	dedupeSlugs()
+	// Nor verified custom domains
+	unverifyDuplicateDomains()
+	// Nor social links, which were migrated again on every start
+	dedupeSocialLinks()
+
+	// Chosen post covers were stored with the derived ones
+	splitCovers := !DB.Migrator().HasColumn(&Post{}, "CoverImage")
+
 	// Migrate the schema
-	DB.AutoMigrate(&User{}, &Portfolio{}, &Project{}, &Achievement{})
+	DB.AutoMigrate(&User{}, &Portfolio{}, &Project{}, &ProjectMedia{}, &ProjectRepository{}, &Achievement{}, &Experience{}, &Education{}, &Skill{}, &Like{}, &Post{}, &PostRevision{}, &Comment{}, &SlugHistory{}, &Tag{}, &Category{}, &Series{}, &ActorKey{}, &Follower{}, &Follow{}, &Webmention{}, &SentWebmention{}, &PortfolioProject{}, &PortfolioAchievement{}, &ShareLink{}, &ShareLinkAccess{}, &CustomDomain{}, &ExportSettings{}, &SocialLink{}, &LinkCheck{}, &PageView{}, &AnalyticsSalt{}, &ProjectScore{})
+	backfillSlugs()
+	backfillDefaultPortfolios()
+	backfillLayouts()
+	backfillProjectMedia()
+	migrateSocialMediaLinks()
+	if splitCovers {
+		splitPostCoverImages()
+	}
+	backfillPostMetadata()
 	log.Println("Database migrated")
 }
+
+// isUniqueViolation reports whether err is a violation of one of the named
+// unique indexes.
+func isUniqueViolation(err error, indexes ...string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return false
+	}
+	for _, index := range indexes {
+		if pgErr.ConstraintName == index {
+			return true
+		}
+	}
+	return false
+}
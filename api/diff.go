@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// DiffOp identifies how a token changed between two texts.
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = "equal"
+	DiffInsert DiffOp = "insert"
+	DiffDelete DiffOp = "delete"
+)
+
+// DiffSegment is a run of consecutive tokens sharing the same DiffOp.
+type DiffSegment struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+const (
+	// maxDiffEdits bounds the edit distance diffTokens searches for. Texts
+	// further apart than this are shown as entirely replaced; the frontiers
+	// kept for the search grow with the square of the distance.
+	maxDiffEdits = 2000
+	// maxDiffTokens bounds the tokens compared once the common prefix and
+	// suffix are set aside.
+	maxDiffTokens = 50000
+)
+
+// diffTokens computes the shortest edit script between a and b and returns
+// one operation per token. Tokens shared at the start and end are matched
+// directly and the rest is diffed with Myers' O(ND) algorithm.
+func diffTokens(a, b []string) []DiffSegment {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []DiffSegment
+	for _, token := range a[:prefix] {
+		ops = append(ops, DiffSegment{Op: DiffEqual, Text: token})
+	}
+	ops = append(ops, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, token := range a[len(a)-suffix:] {
+		ops = append(ops, DiffSegment{Op: DiffEqual, Text: token})
+	}
+	return ops
+}
+
+// replaceTokens is the edit script deleting all of a and inserting all of b.
+func replaceTokens(a, b []string) []DiffSegment {
+	ops := make([]DiffSegment, 0, len(a)+len(b))
+	for _, token := range a {
+		ops = append(ops, DiffSegment{Op: DiffDelete, Text: token})
+	}
+	for _, token := range b {
+		ops = append(ops, DiffSegment{Op: DiffInsert, Text: token})
+	}
+	return ops
+}
+
+// myersDiff computes the shortest edit script between a and b. Only the
+// frontier of each step is kept, indexed by diagonal k+d, rather than a copy
+// of the whole search state.
+func myersDiff(a, b []string) []DiffSegment {
+	n, m := len(a), len(b)
+	if n+m == 0 {
+		return nil
+	}
+	if n+m > maxDiffTokens {
+		return replaceTokens(a, b)
+	}
+
+	var trace [][]int
+	for d := 0; ; d++ {
+		if d > maxDiffEdits {
+			return replaceTokens(a, b)
+		}
+		v := make([]int, 2*d+1)
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			switch {
+			case d == 0:
+				x = 0
+			case k == -d || (k != d && trace[d-1][k-1+d-1] < trace[d-1][k+1+d-1]):
+				x = trace[d-1][k+1+d-1]
+			default:
+				x = trace[d-1][k-1+d-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+d] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		trace = append(trace, v)
+		if done {
+			break
+		}
+	}
+
+	// Walk the trace backwards to recover the edit script.
+	var ops []DiffSegment
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, DiffSegment{Op: DiffEqual, Text: a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, DiffSegment{Op: DiffInsert, Text: b[y]})
+		} else {
+			x--
+			ops = append(ops, DiffSegment{Op: DiffDelete, Text: a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, DiffSegment{Op: DiffEqual, Text: a[x]})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// splitLines splits text into lines, dropping the trailing newline of each.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// splitWords splits text into alternating runs of whitespace and
+// non-whitespace so that joining the tokens reproduces the original text.
+func splitWords(text string) []string {
+	var tokens []string
+	start := 0
+	prevSpace := false
+	for i, r := range text {
+		space := unicode.IsSpace(r)
+		if i > 0 && space != prevSpace {
+			tokens = append(tokens, text[start:i])
+			start = i
+		}
+		prevSpace = space
+	}
+	if start < len(text) {
+		tokens = append(tokens, text[start:])
+	}
+	return tokens
+}
+
+// WordDiff returns a word-level diff of two texts, merging adjacent tokens
+// with the same operation into a single segment.
+func WordDiff(from, to string) []DiffSegment {
+	var segments []DiffSegment
+	for _, op := range diffTokens(splitWords(from), splitWords(to)) {
+		if n := len(segments); n > 0 && segments[n-1].Op == op.Op {
+			segments[n-1].Text += op.Text
+			continue
+		}
+		segments = append(segments, op)
+	}
+	return segments
+}
+
+// UnifiedDiff returns a line-based diff of two texts in unified format with
+// the given number of context lines around each change.
+func UnifiedDiff(fromName, toName, from, to string, context int) string {
+	ops := diffTokens(splitLines(from), splitLines(to))
+
+	changed := false
+	for _, op := range ops {
+		if op.Op != DiffEqual {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Line numbers (1-based) of each op in the old and new text.
+	oldLine := make([]int, len(ops))
+	newLine := make([]int, len(ops))
+	o, n := 1, 1
+	for i, op := range ops {
+		oldLine[i], newLine[i] = o, n
+		switch op.Op {
+		case DiffEqual:
+			o++
+			n++
+		case DiffDelete:
+			o++
+		case DiffInsert:
+			n++
+		}
+	}
+
+	i := 0
+	for i < len(ops) {
+		// Find the next change.
+		for i < len(ops) && ops[i].Op == DiffEqual {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk until more than 2*context equal lines follow a
+		// change; equalRun counts the equal lines included since the last one.
+		end := i
+		equalRun := 0
+		for end < len(ops) {
+			if ops[end].Op == DiffEqual {
+				if equalRun == 2*context {
+					break
+				}
+				equalRun++
+			} else {
+				equalRun = 0
+			}
+			end++
+		}
+		if equalRun > context {
+			end -= equalRun - context
+		}
+
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.Op != DiffInsert {
+				oldCount++
+			}
+			if op.Op != DiffDelete {
+				newCount++
+			}
+		}
+		oldStart, newStart := oldLine[start], newLine[start]
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, op := range ops[start:end] {
+			switch op.Op {
+			case DiffEqual:
+				sb.WriteString(" ")
+			case DiffDelete:
+				sb.WriteString("-")
+			case DiffInsert:
+				sb.WriteString("+")
+			}
+			sb.WriteString(op.Text)
+			sb.WriteString("\n")
+		}
+		i = end
+	}
+
+	return sb.String()
+}
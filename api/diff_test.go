@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiffContext(t *testing.T) {
+	from := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	to := "1\n2\n3\n4\nX\n6\n7\n8\n9\n"
+	want := "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+X\n 6\n 7\n 8\n"
+	if got := UnifiedDiff("a", "b", from, to, 3); got != want {
+		t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDiffTokensReplacesDistantTexts(t *testing.T) {
+	a := make([]string, 3*maxDiffEdits)
+	b := make([]string, 3*maxDiffEdits)
+	for i := range a {
+		a[i] = "a" + strings.Repeat("x", i%7)
+		b[i] = "b" + strings.Repeat("y", i%5)
+	}
+	ops := diffTokens(a, b)
+	if len(ops) != len(a)+len(b) {
+		t.Fatalf("got %d operations, want %d", len(ops), len(a)+len(b))
+	}
+	for _, op := range ops {
+		if op.Op == DiffEqual {
+			t.Fatalf("unexpected equal token %q", op.Text)
+		}
+	}
+}
+
+func TestWordDiff(t *testing.T) {
+	got := WordDiff("the quick brown fox", "the slow brown fox")
+	want := []DiffSegment{
+		{Op: DiffEqual, Text: "the "},
+		{Op: DiffDelete, Text: "quick"},
+		{Op: DiffInsert, Text: "slow"},
+		{Op: DiffEqual, Text: " brown fox"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("WordDiff() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("segment %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
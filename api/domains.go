@@ -0,0 +1,585 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// domainTXTPrefix is prepended to a domain to find its verification TXT record.
+	domainTXTPrefix = "_portfolio-verify."
+	// domainTXTValuePrefix starts the value of the verification TXT record.
+	domainTXTValuePrefix = "portfolio-verification="
+	// domainWellKnownPath is where a domain's own server publishes the verification token.
+	domainWellKnownPath = "/.well-known/portfolio-verification"
+
+	domainCacheTTL  = time.Minute
+	domainCacheSize = 10000 // Lookups kept before the cache is cleared
+)
+
+// domainResolver answers the DNS and address lookups made while verifying
+// custom domains. It is the system resolver unless DOMAIN_RESOLVER_FILE names
+// a stand-in file, which lets domains be verified without real DNS.
+var domainResolver = newDomainResolver()
+
+// DomainResolver looks up the records custom domain verification relies on.
+type DomainResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	// LookupAddr returns the address to dial for host:port, or "" to dial it as given.
+	LookupAddr(ctx context.Context, hostport string) (string, error)
+}
+
+type systemResolver struct{}
+
+func (systemResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return net.DefaultResolver.LookupTXT(ctx, name)
+}
+
+func (systemResolver) LookupAddr(ctx context.Context, hostport string) (string, error) {
+	return "", nil
+}
+
+// fileResolver is a local stand-in for DNS, read from a JSON file such as
+//
+//	{
+//	  "txt":   {"_portfolio-verify.jane.dev": ["portfolio-verification=..."]},
+//	  "hosts": {"jane.dev": "127.0.0.1:8080"}
+//	}
+//
+// TXT records are looked up by name. Hosts map a domain to the address its
+// HTTP verification request is sent to.
+type fileResolver struct {
+	TXT   map[string][]string `json:"txt"`
+	Hosts map[string]string   `json:"hosts"`
+}
+
+func (f *fileResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := f.TXT[strings.TrimSuffix(strings.ToLower(name), ".")]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func (f *fileResolver) LookupAddr(ctx context.Context, hostport string) (string, error) {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", err
+	}
+	return f.Hosts[strings.ToLower(host)], nil
+}
+
+func newDomainResolver() DomainResolver {
+	path := os.Getenv("DOMAIN_RESOLVER_FILE")
+	if path == "" {
+		return systemResolver{}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read domain resolver file: %v", err)
+	}
+	var f fileResolver
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Fatalf("Failed to parse domain resolver file: %v", err)
+	}
+	return &f
+}
+
+// domainClient fetches well-known verification tokens, dialing through
+// domainResolver. Domains must serve the token themselves, so redirects
+// aren't followed, and only public addresses are dialled unless the
+// stand-in resolver, which is the operator's own configuration, names one.
+var domainClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if override, err := domainResolver.LookupAddr(ctx, addr); err != nil {
+				return nil, err
+			} else if override != "" {
+				var d net.Dialer
+				return d.DialContext(ctx, network, override)
+			}
+			d := net.Dialer{Control: dialPublicOnly}
+			return d.DialContext(ctx, network, addr)
+		},
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+var errInvalidDomain = errors.New("invalid domain name")
+
+// normalizeDomain lowercases a domain name and checks that it is a plausible
+// registrable name rather than an IP address or a single label.
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if len(domain) == 0 || len(domain) > 253 || net.ParseIP(domain) != nil || !strings.Contains(domain, ".") {
+		return "", errInvalidDomain
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", errInvalidDomain
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return "", errInvalidDomain
+			}
+		}
+	}
+	return domain, nil
+}
+
+// hostname returns the host of a Host header without its port.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+var (
+	siteHostsOnce sync.Once
+	siteHostNames map[string]bool
+)
+
+// siteHosts returns the hosts the site itself is served from, which can't be
+// claimed as custom domains. They are read from the environment on first use.
+func siteHosts() map[string]bool {
+	siteHostsOnce.Do(func() {
+		siteHostNames = map[string]bool{"localhost": true}
+		for _, env := range []string{"PUBLIC_URL", "API_URL"} {
+			if u, err := url.Parse(os.Getenv(env)); err == nil && u.Host != "" {
+				siteHostNames[hostname(u.Host)] = true
+			}
+		}
+	})
+	return siteHostNames
+}
+
+// Verification errors are shown to the user and stored, so they say what
+// was wrong without the details of the failed request or lookup.
+var (
+	errTXTRecordNotFound = errors.New("TXT record was not found")
+	errTXTLookupFailed   = errors.New("TXT record could not be looked up")
+	errTXTRecordMismatch = errors.New("TXT record does not contain the verification token")
+	errWellKnownFailed   = errors.New("well-known file could not be fetched")
+	errWellKnownMismatch = errors.New("well-known file does not contain the verification token")
+)
+
+// verifyDomainDNS checks the domain's verification TXT record.
+func verifyDomainDNS(ctx context.Context, domain CustomDomain) error {
+	records, err := domainResolver.LookupTXT(ctx, domainTXTPrefix+domain.Domain)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return errTXTRecordNotFound
+	} else if err != nil {
+		log.Printf("Failed to look up TXT record of %s: %v", domain.Domain, err)
+		return errTXTLookupFailed
+	}
+	for _, record := range records {
+		if strings.TrimSpace(record) == domainTXTValuePrefix+domain.Token {
+			return nil
+		}
+	}
+	return errTXTRecordMismatch
+}
+
+// verifyDomainHTTP checks the token served at the domain's well-known URL.
+func verifyDomainHTTP(ctx context.Context, domain CustomDomain) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+domain.Domain+domainWellKnownPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := domainClient.Do(req)
+	if err != nil {
+		return errWellKnownFailed
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errWellKnownFailed
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return errWellKnownFailed
+	}
+	if strings.TrimSpace(string(body)) != domain.Token {
+		return errWellKnownMismatch
+	}
+	return nil
+}
+
+// unverifyDuplicateDomains keeps only the earliest verification of domains
+// verified by several users, which was possible before verified domains were
+// uniquely indexed.
+func unverifyDuplicateDomains() {
+	if !DB.Migrator().HasTable(&CustomDomain{}) {
+		return
+	}
+	result := DB.Exec(`UPDATE custom_domains SET verified_at = NULL
+WHERE verified_at IS NOT NULL AND deleted_at IS NULL AND id NOT IN
+(SELECT DISTINCT ON (domain) id FROM custom_domains WHERE verified_at IS NOT NULL AND deleted_at IS NULL ORDER BY domain, verified_at, id)`)
+	if result.Error != nil {
+		log.Printf("Failed to unverify duplicate custom domains: %v", result.Error)
+	}
+}
+
+// domainRoute is a verified custom domain as used for routing.
+type domainRoute struct {
+	Username      string
+	PortfolioSlug string // Empty to serve the user's default portfolio
+	expires       time.Time
+}
+
+// domainRoutes caches custom domain lookups by host, including misses.
+var domainRoutes = struct {
+	sync.Mutex
+	m map[string]*domainRoute
+}{m: make(map[string]*domainRoute)}
+
+// lookupDomainRoute finds the verified custom domain for a host. It returns
+// nil if there is none.
+func lookupDomainRoute(host string) *domainRoute {
+	domainRoutes.Lock()
+	cached, ok := domainRoutes.m[host]
+	domainRoutes.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		if cached.Username == "" {
+			return nil
+		}
+		return cached
+	}
+
+	route := &domainRoute{expires: time.Now().Add(domainCacheTTL)}
+	var domains []CustomDomain
+	if result := DB.Where("domain = ? AND verified_at IS NOT NULL", host).Limit(1).Find(&domains); result.Error == nil && len(domains) > 0 {
+		var user User
+		if DB.First(&user, domains[0].UserID).Error == nil {
+			route.Username = user.Username
+			if domains[0].PortfolioID != nil {
+				var portfolio Portfolio
+				if DB.First(&portfolio, *domains[0].PortfolioID).Error == nil {
+					route.PortfolioSlug = portfolio.Slug
+				}
+			}
+		}
+	}
+
+	domainRoutes.Lock()
+	// Arbitrary Host headers could otherwise grow the cache without bound
+	if len(domainRoutes.m) >= domainCacheSize {
+		domainRoutes.m = make(map[string]*domainRoute)
+	}
+	domainRoutes.m[host] = route
+	domainRoutes.Unlock()
+	if route.Username == "" {
+		return nil
+	}
+	return route
+}
+
+// forgetDomainRoute drops a cached lookup after a domain changes.
+func forgetDomainRoute(host string) {
+	domainRoutes.Lock()
+	delete(domainRoutes.m, host)
+	domainRoutes.Unlock()
+}
+
+// DomainRouter serves requests made to users' custom domains. It maps paths
+// on the custom domain onto the API routes of the domain's owner:
+//
+//	/                     their portfolio
+//	/posts                their blog posts
+//	/posts/{slug}         one of their posts
+//	/projects/{slug}      one of their projects
+//	/feed.{rss|atom|json} their blog feed
+//
+// /api/ and /uploads/ pass through unchanged. Requests to any other host,
+// including domains that haven't been verified, go straight to next. The
+// well-known verification file is deliberately not served here: it must come
+// from the domain's own server, or anyone could claim a domain pointed at us.
+func DomainRouter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := hostname(r.Host)
+		if siteHosts()[host] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := lookupDomainRoute(host)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		path := r.URL.Path
+		query := r.URL.Query()
+		switch {
+		case strings.HasPrefix(path, "/api/"), strings.HasPrefix(path, "/uploads/"):
+		case path == "/" || path == "":
+			path = "/api/portfolio/" + route.Username
+			if route.PortfolioSlug != "" {
+				path += "/" + route.PortfolioSlug
+			}
+		case path == "/posts":
+			path = "/api/posts"
+			query.Set("author", route.Username)
+		case strings.HasPrefix(path, "/posts/"):
+			path = "/api/users/" + route.Username + path
+		case strings.HasPrefix(path, "/projects/"):
+			path = "/api/users/" + route.Username + path
+		case strings.HasPrefix(path, "/feed."):
+			path = "/users/" + route.Username + path
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		routed := r.Clone(r.Context())
+		routed.URL.Path = path
+		routed.URL.RawPath = ""
+		routed.URL.RawQuery = query.Encode()
+		routed.RequestURI = routed.URL.RequestURI()
+		next.ServeHTTP(w, routed)
+	})
+}
+
+// DomainInstructions tells the user how to prove they own a domain.
+type DomainInstructions struct {
+	TXTName  string `json:"txt_name"`
+	TXTValue string `json:"txt_value"`
+	HTTPURL  string `json:"http_url"`
+	HTTPBody string `json:"http_body"`
+}
+
+// OwnDomain is one of the authenticated user's custom domains with its
+// verification instructions.
+type OwnDomain struct {
+	CustomDomain
+	Instructions DomainInstructions `json:"instructions"`
+}
+
+func toOwnDomain(domain CustomDomain) OwnDomain {
+	return OwnDomain{
+		CustomDomain: domain,
+		Instructions: DomainInstructions{
+			TXTName:  domainTXTPrefix + domain.Domain,
+			TXTValue: domainTXTValuePrefix + domain.Token,
+			HTTPURL:  "http://" + domain.Domain + domainWellKnownPath,
+			HTTPBody: domain.Token,
+		},
+	}
+}
+
+// findOwnedDomain loads the custom domain identified by the "id" route
+// variable if it belongs to the authenticated user.
+func findOwnedDomain(r *http.Request, userID uint) (CustomDomain, int, string) {
+	domainID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return CustomDomain{}, http.StatusBadRequest, "Invalid domain ID"
+	}
+
+	var domain CustomDomain
+	if result := DB.Where("user_id = ?", userID).First(&domain, domainID); result.Error != nil {
+		return CustomDomain{}, http.StatusNotFound, "Domain not found or not authorized"
+	}
+	return domain, 0, ""
+}
+
+// GetDomains handles listing the authenticated user's custom domains.
+func GetDomains(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var domains []CustomDomain
+	if result := DB.Where("user_id = ?", userID).Order("domain asc").Find(&domains); result.Error != nil {
+		http.Error(w, "Failed to retrieve domains", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]OwnDomain, len(domains))
+	for i, domain := range domains {
+		response[i] = toOwnDomain(domain)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// CreateDomain handles registering a custom domain for the authenticated
+// user. The domain serves nothing until it is verified. A portfolio id may be
+// given to serve that portfolio instead of the user's default one.
+func CreateDomain(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Domain      string `json:"domain"`
+		PortfolioID *uint  `json:"portfolio_id"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	name, err := normalizeDomain(req.Domain)
+	if err != nil || siteHosts()[name] {
+		http.Error(w, "Invalid domain name", http.StatusBadRequest)
+		return
+	}
+
+	if req.PortfolioID != nil {
+		var portfolio Portfolio
+		if result := DB.Where("user_id = ?", userID).First(&portfolio, *req.PortfolioID); result.Error != nil {
+			http.Error(w, "Portfolio not found or not authorized", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var taken int64
+	if result := DB.Model(&CustomDomain{}).Where("domain = ? AND (user_id = ? OR verified_at IS NOT NULL)", name, userID).Count(&taken); result.Error != nil {
+		http.Error(w, "Failed to register domain", http.StatusInternalServerError)
+		return
+	}
+	if taken > 0 {
+		http.Error(w, "Domain is already registered", http.StatusConflict)
+		return
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Failed to register domain", http.StatusInternalServerError)
+		return
+	}
+
+	domain := CustomDomain{
+		UserID:      userID,
+		Domain:      name,
+		PortfolioID: req.PortfolioID,
+		Token:       hex.EncodeToString(buf),
+	}
+	if result := DB.Create(&domain); result.Error != nil {
+		http.Error(w, "Failed to register domain", http.StatusInternalServerError)
+		return
+	}
+	forgetDomainRoute(domain.Domain)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toOwnDomain(domain))
+}
+
+// VerifyDomain handles checking that the authenticated user controls one of
+// their custom domains, through its DNS TXT record or its well-known HTTP
+// file. The "method" query parameter picks "dns" or "http"; both are tried by
+// default.
+func VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	domain, status, msg := findOwnedDomain(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var checks []func(context.Context, CustomDomain) error
+	switch r.URL.Query().Get("method") {
+	case "dns":
+		checks = append(checks, verifyDomainDNS)
+	case "http":
+		checks = append(checks, verifyDomainHTTP)
+	case "":
+		checks = append(checks, verifyDomainDNS, verifyDomainHTTP)
+	default:
+		http.Error(w, "method must be dns or http", http.StatusBadRequest)
+		return
+	}
+
+	var problems []string
+	for _, check := range checks {
+		err := check(r.Context(), domain)
+		if err == nil {
+			problems = nil
+			break
+		}
+		problems = append(problems, err.Error())
+	}
+
+	if len(problems) > 0 {
+		domain.LastError = strings.Join(problems, "; ")
+		DB.Model(&domain).Update("last_error", domain.LastError)
+		http.Error(w, "Domain could not be verified: "+domain.LastError, http.StatusBadRequest)
+		return
+	}
+
+	// Someone else may have verified the domain in the meantime
+	var taken int64
+	if result := DB.Model(&CustomDomain{}).Where("domain = ? AND id <> ? AND verified_at IS NOT NULL", domain.Domain, domain.ID).Count(&taken); result.Error != nil {
+		http.Error(w, "Failed to verify domain", http.StatusInternalServerError)
+		return
+	}
+	if taken > 0 {
+		http.Error(w, "Domain is already registered", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	domain.VerifiedAt = &now
+	domain.LastError = ""
+	if result := DB.Save(&domain); isUniqueViolation(result.Error, "idx_custom_domains_verified") {
+		http.Error(w, "Domain is already registered", http.StatusConflict)
+		return
+	} else if result.Error != nil {
+		http.Error(w, "Failed to verify domain", http.StatusInternalServerError)
+		return
+	}
+	forgetDomainRoute(domain.Domain)
+
+	json.NewEncoder(w).Encode(toOwnDomain(domain))
+}
+
+// DeleteDomain handles removing one of the authenticated user's custom domains.
+func DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	domain, status, msg := findOwnedDomain(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	if result := DB.Delete(&domain); result.Error != nil {
+		http.Error(w, "Failed to delete domain", http.StatusInternalServerError)
+		return
+	}
+	forgetDomainRoute(domain.Domain)
+
+	w.WriteHeader(http.StatusNoContent)
+}
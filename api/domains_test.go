@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useDomainResolver points domain verification at a stand-in resolver for
+// the rest of the test.
+func useDomainResolver(t *testing.T, resolver DomainResolver) {
+	previous := domainResolver
+	domainResolver = resolver
+	t.Cleanup(func() { domainResolver = previous })
+}
+
+func TestVerifyDomainDNS(t *testing.T) {
+	useDomainResolver(t, &fileResolver{TXT: map[string][]string{
+		"_portfolio-verify.jane.dev":  {"v=spf1 -all", "portfolio-verification=token"},
+		"_portfolio-verify.other.dev": {"portfolio-verification=another"},
+	}})
+
+	tests := []struct {
+		domain string
+		want   error
+	}{
+		{"jane.dev", nil},
+		{"other.dev", errTXTRecordMismatch},
+		{"missing.dev", errTXTRecordNotFound},
+	}
+	for _, tt := range tests {
+		err := verifyDomainDNS(context.Background(), CustomDomain{Domain: tt.domain, Token: "token"})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("verifyDomainDNS(%s) = %v, want %v", tt.domain, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyDomainHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != domainWellKnownPath {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Host {
+		case "jane.dev":
+			w.Write([]byte("token\n"))
+		case "wrong.dev":
+			w.Write([]byte("another"))
+		case "moved.dev":
+			http.Redirect(w, r, "http://jane.dev"+domainWellKnownPath, http.StatusFound)
+		default:
+			http.Error(w, "teapot", http.StatusTeapot)
+		}
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+	useDomainResolver(t, &fileResolver{Hosts: map[string]string{
+		"jane.dev": addr, "wrong.dev": addr, "moved.dev": addr, "broken.dev": addr,
+	}})
+
+	tests := []struct {
+		domain string
+		want   error
+	}{
+		{"jane.dev", nil},
+		{"wrong.dev", errWellKnownMismatch},
+		{"moved.dev", errWellKnownFailed},
+		{"broken.dev", errWellKnownFailed},
+	}
+	for _, tt := range tests {
+		err := verifyDomainHTTP(context.Background(), CustomDomain{Domain: tt.domain, Token: "token"})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("verifyDomainHTTP(%s) = %v, want %v", tt.domain, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyDomainHTTPRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("token"))
+	}))
+	defer server.Close()
+
+	// localhost resolves without the stand-in, so its address is checked
+	useDomainResolver(t, systemResolver{})
+	host := strings.Replace(strings.TrimPrefix(server.URL, "http://"), "127.0.0.1", "localhost", 1)
+	if err := verifyDomainHTTP(context.Background(), CustomDomain{Domain: host, Token: "token"}); err == nil {
+		t.Error("token served from a loopback address accepted")
+	}
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Work experience, education and skills belong to the user's main portfolio,
+// like their projects, and are shown on every one of their portfolios.
+
+var (
+	// errInvalidDateRange is wrapped by errors describing an invalid start or
+	// end date.
+	errInvalidDateRange = errors.New("invalid date range")
+	// errMissingField is wrapped by errors naming a required field that was
+	// left empty.
+	errMissingField = errors.New("missing required field")
+	// errInvalidSkillLevel is returned for a skill level not in skillLevels.
+	errInvalidSkillLevel = errors.New("invalid skill level")
+)
+
+// presentDate is sent as the end date of roles and studies that are ongoing.
+const presentDate = "present"
+
+// skillLevels are the levels a skill may be given; a skill needn't have one.
+var skillLevels = []string{"beginner", "intermediate", "advanced", "expert"}
+
+// dateRangeInput is a start and end date as sent by clients, either as
+// "2006-01" or "2006-01-02". An end date of "present", or none at all, means
+// the range is ongoing.
+type dateRangeInput struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+// parseRangeDate parses a date sent as a month or a day.
+func parseRangeDate(field, value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %s must be a date like 2021-09 or 2021-09-01", errInvalidDateRange, field)
+}
+
+// parse returns the start date and the end date, which is nil for ongoing ranges.
+func (in dateRangeInput) parse() (time.Time, *time.Time, error) {
+	start, err := parseRangeDate("start_date", strings.TrimSpace(in.StartDate))
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	end := strings.TrimSpace(in.EndDate)
+	if end == "" || strings.EqualFold(end, presentDate) {
+		return start, nil, nil
+	}
+	endDate, err := parseRangeDate("end_date", end)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	if endDate.Before(start) {
+		return time.Time{}, nil, fmt.Errorf("%w: end_date is before start_date", errInvalidDateRange)
+	}
+	return start, &endDate, nil
+}
+
+// requireFields returns an error naming the first of fields, given as name
+// and value pairs, that is empty.
+func requireFields(fields ...string) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if strings.TrimSpace(fields[i+1]) == "" {
+			return fmt.Errorf("%w: %s", errMissingField, fields[i])
+		}
+	}
+	return nil
+}
+
+// writeSectionError writes the response for an experience, education or
+// skill that failed to save.
+func writeSectionError(w http.ResponseWriter, err error, fallback string) {
+	if errors.Is(err, errInvalidDateRange) || errors.Is(err, errMissingField) || errors.Is(err, errInvalidSkillLevel) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeSaveError(w, err, fallback)
+}
+
+// dateRangeOrder lists ongoing entries first, then the most recently ended.
+const dateRangeOrder = "end_date IS NULL desc, end_date desc, start_date desc, id desc"
+
+// portfolioBackground returns the work experience, education and skills shown
+// on a portfolio.
+func portfolioBackground(portfolio Portfolio) ([]Experience, []Education, []Skill, error) {
+	primary, err := mainPortfolio(DB, portfolio.UserID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var experiences []Experience
+	if result := DB.Where("portfolio_id = ?", primary.ID).Order(dateRangeOrder).Find(&experiences); result.Error != nil {
+		return nil, nil, nil, result.Error
+	}
+	var educations []Education
+	if result := DB.Where("portfolio_id = ?", primary.ID).Order(dateRangeOrder).Find(&educations); result.Error != nil {
+		return nil, nil, nil, result.Error
+	}
+	var skills []Skill
+	if result := DB.Where("portfolio_id = ?", primary.ID).Order("position asc, id asc").Find(&skills); result.Error != nil {
+		return nil, nil, nil, result.Error
+	}
+	return experiences, educations, skills, nil
+}
+
+// findOwnedItem loads the experience, education or skill identified by the
+// "id" route variable into item if it belongs to the authenticated user.
+func findOwnedItem(r *http.Request, userID uint, item interface{}, name string) (int, string) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return http.StatusBadRequest, "Invalid " + name + " ID"
+	}
+
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
+		return http.StatusNotFound, "Portfolio not found for user"
+	}
+	if result := DB.Where("id = ? AND portfolio_id = ?", id, portfolio.ID).First(item); result.Error != nil {
+		return http.StatusNotFound, strings.ToUpper(name[:1]) + name[1:] + " not found or not authorized"
+	}
+	return 0, ""
+}
+
+// ExperienceRequest is the body of a request creating or updating a work
+// experience entry.
+type ExperienceRequest struct {
+	Title        string `json:"title"`
+	Organization string `json:"organization"`
+	Location     string `json:"location"`
+	Description  string `json:"description"`
+	dateRangeInput
+}
+
+// apply validates the request and copies it onto experience.
+func (req ExperienceRequest) apply(experience *Experience) error {
+	if err := requireFields("title", req.Title, "organization", req.Organization); err != nil {
+		return err
+	}
+	start, end, err := req.parse()
+	if err != nil {
+		return err
+	}
+	experience.Title = strings.TrimSpace(req.Title)
+	experience.Organization = strings.TrimSpace(req.Organization)
+	experience.Location = strings.TrimSpace(req.Location)
+	experience.Description = req.Description
+	experience.StartDate = start
+	experience.EndDate = end
+	return nil
+}
+
+// CreateExperience handles adding work experience to the authenticated user's portfolio.
+func CreateExperience(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
+		return
+	}
+
+	var req ExperienceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	experience := Experience{PortfolioID: portfolio.ID}
+	if err := req.apply(&experience); err != nil {
+		writeSectionError(w, err, "Failed to create experience")
+		return
+	}
+	if result := DB.Create(&experience); result.Error != nil {
+		http.Error(w, "Failed to create experience", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(experience)
+}
+
+// GetExperiences handles listing the work experience of the authenticated
+// user's portfolio, current roles first.
+func GetExperiences(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
+		return
+	}
+
+	var experiences []Experience
+	if result := DB.Where("portfolio_id = ?", portfolio.ID).Order(dateRangeOrder).Find(&experiences); result.Error != nil {
+		http.Error(w, "Failed to retrieve experience", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(experiences)
+}
+
+// UpdateExperience handles updating work experience belonging to the authenticated user's portfolio.
+func UpdateExperience(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var experience Experience
+	if status, message := findOwnedItem(r, userID, &experience, "experience"); status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	var req ExperienceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.apply(&experience); err != nil {
+		writeSectionError(w, err, "Failed to update experience")
+		return
+	}
+	if result := DB.Save(&experience); result.Error != nil {
+		http.Error(w, "Failed to update experience", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(experience)
+}
+
+// DeleteExperience handles deleting work experience belonging to the authenticated user's portfolio.
+func DeleteExperience(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var experience Experience
+	if status, message := findOwnedItem(r, userID, &experience, "experience"); status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	if result := DB.Delete(&experience); result.Error != nil {
+		http.Error(w, "Failed to delete experience", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EducationRequest is the body of a request creating or updating an
+// education entry.
+type EducationRequest struct {
+	Institution  string `json:"institution"`
+	Degree       string `json:"degree"`
+	FieldOfStudy string `json:"field_of_study"`
+	Description  string `json:"description"`
+	dateRangeInput
+}
+
+// apply validates the request and copies it onto education.
+func (req EducationRequest) apply(education *Education) error {
+	if err := requireFields("institution", req.Institution); err != nil {
+		return err
+	}
+	start, end, err := req.parse()
+	if err != nil {
+		return err
+	}
+	education.Institution = strings.TrimSpace(req.Institution)
+	education.Degree = strings.TrimSpace(req.Degree)
+	education.FieldOfStudy = strings.TrimSpace(req.FieldOfStudy)
+	education.Description = req.Description
+	education.StartDate = start
+	education.EndDate = end
+	return nil
+}
+
+// CreateEducation handles adding education to the authenticated user's portfolio.
+func CreateEducation(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
+		return
+	}
+
+	var req EducationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	education := Education{PortfolioID: portfolio.ID}
+	if err := req.apply(&education); err != nil {
+		writeSectionError(w, err, "Failed to create education")
+		return
+	}
+	if result := DB.Create(&education); result.Error != nil {
+		http.Error(w, "Failed to create education", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(education)
+}
+
+// GetEducations handles listing the education of the authenticated user's
+// portfolio, ongoing studies first.
+func GetEducations(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
+		return
+	}
+
+	var educations []Education
+	if result := DB.Where("portfolio_id = ?", portfolio.ID).Order(dateRangeOrder).Find(&educations); result.Error != nil {
+		http.Error(w, "Failed to retrieve education", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(educations)
+}
+
+// UpdateEducation handles updating education belonging to the authenticated user's portfolio.
+func UpdateEducation(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var education Education
+	if status, message := findOwnedItem(r, userID, &education, "education"); status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	var req EducationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.apply(&education); err != nil {
+		writeSectionError(w, err, "Failed to update education")
+		return
+	}
+	if result := DB.Save(&education); result.Error != nil {
+		http.Error(w, "Failed to update education", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(education)
+}
+
+// DeleteEducation handles deleting education belonging to the authenticated user's portfolio.
+func DeleteEducation(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var education Education
+	if status, message := findOwnedItem(r, userID, &education, "education"); status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	if result := DB.Delete(&education); result.Error != nil {
+		http.Error(w, "Failed to delete education", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SkillRequest is the body of a request creating or updating a skill.
+type SkillRequest struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Level    string `json:"level"`
+}
+
+// apply validates the request and copies it onto skill.
+func (req SkillRequest) apply(skill *Skill) error {
+	if err := requireFields("name", req.Name); err != nil {
+		return err
+	}
+	level := strings.ToLower(strings.TrimSpace(req.Level))
+	if level != "" && !containsString(skillLevels, level) {
+		return fmt.Errorf("%w: level must be one of %q", errInvalidSkillLevel, skillLevels)
+	}
+	skill.Name = strings.TrimSpace(req.Name)
+	skill.Category = strings.TrimSpace(req.Category)
+	skill.Level = level
+	return nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateSkill handles adding a skill to the authenticated user's portfolio,
+// after their existing skills.
+func CreateSkill(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
+		return
+	}
+
+	var req SkillRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	skill := Skill{PortfolioID: portfolio.ID}
+	if err := req.apply(&skill); err != nil {
+		writeSectionError(w, err, "Failed to create skill")
+		return
+	}
+	if skill.Position, err = nextPosition(DB, &Skill{}, portfolio.ID); err != nil {
+		http.Error(w, "Failed to create skill", http.StatusInternalServerError)
+		return
+	}
+	if result := DB.Create(&skill); result.Error != nil {
+		http.Error(w, "Failed to create skill", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(skill)
+}
+
+// GetSkills handles listing the skills of the authenticated user's portfolio in order.
+func GetSkills(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
+		return
+	}
+
+	var skills []Skill
+	if result := DB.Where("portfolio_id = ?", portfolio.ID).Order("position asc, id asc").Find(&skills); result.Error != nil {
+		http.Error(w, "Failed to retrieve skills", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(skills)
+}
+
+// UpdateSkill handles updating a skill belonging to the authenticated user's portfolio.
+func UpdateSkill(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var skill Skill
+	if status, message := findOwnedItem(r, userID, &skill, "skill"); status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	var req SkillRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.apply(&skill); err != nil {
+		writeSectionError(w, err, "Failed to update skill")
+		return
+	}
+	if result := DB.Save(&skill); result.Error != nil {
+		http.Error(w, "Failed to update skill", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(skill)
+}
+
+// DeleteSkill handles deleting a skill belonging to the authenticated user's portfolio.
+func DeleteSkill(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var skill Skill
+	if status, message := findOwnedItem(r, userID, &skill, "skill"); status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	if result := DB.Delete(&skill); result.Error != nil {
+		http.Error(w, "Failed to delete skill", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReorderSkills handles setting the order of the skills of the authenticated
+// user's portfolio. The request lists every skill id in the new order; the
+// whole order is applied or none of it is.
+func ReorderSkills(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		SkillIDs []uint `json:"skill_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		return applyOrder(tx, &Skill{}, portfolio.ID, req.SkillIDs)
+	})
+	if errors.Is(err, errOrderMismatch) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reorder skills", http.StatusInternalServerError)
+		return
+	}
+
+	var skills []Skill
+	if result := DB.Where("portfolio_id = ?", portfolio.ID).Order("position asc, id asc").Find(&skills); result.Error != nil {
+		http.Error(w, "Failed to retrieve skills", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(skills)
+}
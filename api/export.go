@@ -0,0 +1,831 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"embed"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// exportThemeFS holds the themes static sites are rendered with. Templates in
+// exportthemes/layout.html are shared; each theme is a directory with a
+// style.css and optionally .html files redefining some of the templates.
+//
+//go:embed exportthemes
+var exportThemeFS embed.FS
+
+const (
+	defaultExportTheme = "default"
+	exportDir          = "./public/exports"
+	// exportDelay is how long automatic regeneration waits for further changes.
+	exportDelay = 5 * time.Second
+	// exportRecentPosts is the number of posts listed on the home page.
+	exportRecentPosts = 5
+)
+
+var (
+	// errUnknownExportTheme is returned for a theme that doesn't exist.
+	errUnknownExportTheme = errors.New("unknown theme")
+	// errInvalidBaseURL is returned for a base URL that isn't an absolute http(s) URL.
+	errInvalidBaseURL = errors.New("base URL must be an absolute http(s) URL")
+	// errExportPortfolioNotFound is returned for a portfolio that isn't the user's.
+	errExportPortfolioNotFound = errors.New("portfolio not found")
+)
+
+// exportThemes returns the names of the available themes.
+func exportThemes() []string {
+	entries, err := fs.ReadDir(exportThemeFS, "exportthemes")
+	if err != nil {
+		return nil
+	}
+	var themes []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			themes = append(themes, entry.Name())
+		}
+	}
+	return themes
+}
+
+// loadExportTheme parses the shared templates and the theme's own on top of
+// them, and returns them with the theme's stylesheet.
+func loadExportTheme(name string) (*template.Template, []byte, error) {
+	dir := "exportthemes/" + name
+	if name == "" || strings.ContainsAny(name, "./") {
+		return nil, nil, errUnknownExportTheme
+	}
+	css, err := fs.ReadFile(exportThemeFS, dir+"/style.css")
+	if err != nil {
+		return nil, nil, errUnknownExportTheme
+	}
+
+	funcs := template.FuncMap{"rel": relativeAsset}
+	tmpl, err := template.New("layout.html").Funcs(funcs).ParseFS(exportThemeFS, "exportthemes/layout.html")
+	if err != nil {
+		return nil, nil, err
+	}
+	if overrides, _ := fs.Glob(exportThemeFS, dir+"/*.html"); len(overrides) > 0 {
+		if tmpl, err = tmpl.ParseFS(exportThemeFS, overrides...); err != nil {
+			return nil, nil, err
+		}
+	}
+	return tmpl, css, nil
+}
+
+// relativeAsset prefixes a site-relative path with root, the way back to the
+// top of the site from the current page. Absolute URLs are left alone.
+func relativeAsset(root, u string) string {
+	if u == "" || strings.HasPrefix(u, "/") || strings.Contains(u, "://") {
+		return u
+	}
+	return root + u
+}
+
+// exportFontStacks maps the fonts themes can be set in to CSS font stacks.
+var exportFontStacks = map[string]string{
+	"sans":  `system-ui, -apple-system, "Segoe UI", Roboto, sans-serif`,
+	"serif": `Georgia, "Times New Roman", serif`,
+	"mono":  `ui-monospace, SFMono-Regular, Menlo, Consolas, monospace`,
+}
+
+// settingsCSS overrides the defaults of a theme's stylesheet with a
+// portfolio's theme settings, which have already been validated.
+func settingsCSS(settings ThemeSettings) string {
+	var sb strings.Builder
+	sb.WriteString("\n/* Portfolio theme settings */\n:root {\n")
+	for _, v := range []struct{ property, key string }{
+		{"--accent", "accent_color"},
+		{"--text", "text_color"},
+		{"--background", "background_color"},
+	} {
+		if color, ok := settings[v.key].(string); ok {
+			fmt.Fprintf(&sb, "  %s: %s;\n", v.property, color)
+		}
+	}
+	if stack, ok := exportFontStacks[fmt.Sprint(settings["font"])]; ok {
+		fmt.Fprintf(&sb, "  --font: %s;\n", stack)
+	}
+	if columns, ok := settings["columns"].(int); ok {
+		fmt.Fprintf(&sb, "  --columns: %d;\n", columns)
+	}
+	sb.WriteString("}\n")
+	if show, ok := settings["show_project_images"].(bool); ok && !show {
+		sb.WriteString(".project img { display: none; }\n")
+	}
+	return sb.String()
+}
+
+// exportOptions describes a static site to export.
+type exportOptions struct {
+	UserID      uint
+	PortfolioID *uint  // The user's default portfolio if nil
+	Theme       string // The portfolio's layout if empty
+	BaseURL     string // Where the site will be hosted; needed for the sitemap and feed
+}
+
+// exportSite is the data shared by every page of an exported site.
+type exportSite struct {
+	Title        string
+	Username     string
+	Portfolio    Portfolio
+	Settings     ThemeSettings // The portfolio's theme settings, with defaults filled in
+	About        []string      // Paragraphs of the portfolio's about section
+	Projects     []exportProject
+	Achievements []Achievement
+	Posts        []exportPost
+	RecentPosts  []exportPost
+	HasFeed      bool
+	Year         int
+}
+
+type exportProject struct {
+	Project
+	Page         string // Site-relative path of the project's page
+	ImageURL     string // Site-relative for uploaded images
+	Technologies []string
+}
+
+type exportPost struct {
+	Title         string
+	Page          string // Site-relative path of the post's page
+	Excerpt       string
+	ReadingTime   int
+	CoverImageURL string
+	Content       template.HTML
+	Tags          []string
+	Published     time.Time
+	Updated       time.Time
+	source        Post
+}
+
+// exportPage is the data a page template is executed with.
+type exportPage struct {
+	Site        *exportSite
+	Root        string // Relative path from the page to the top of the site
+	Title       string
+	Description string
+	Canonical   string
+	Project     *exportProject
+	Post        *exportPost
+}
+
+// siteBuilder collects the files of an exported site into a ZIP archive.
+type siteBuilder struct {
+	zip     *zip.Writer
+	tmpl    *template.Template
+	baseURL string
+	hosts   map[string]bool
+	images  map[string]bool // Names of uploads the site refers to
+	pages   []sitemapURL
+}
+
+// uploadName returns the file name of an image uploaded to this site, or
+// false if u points elsewhere.
+func (b *siteBuilder) uploadName(u string) (string, bool) {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Host != "" && !b.hosts[hostname(parsed.Host)]) {
+		return "", false
+	}
+	if !strings.HasPrefix(parsed.Path, "/uploads/") {
+		return "", false
+	}
+	name := path.Base(parsed.Path)
+	if name == "." || name == ".." || name == "/" {
+		return "", false
+	}
+	return name, true
+}
+
+// asset returns the site-relative path of an uploaded image, which is copied
+// into the site, or u unchanged if it isn't an upload.
+func (b *siteBuilder) asset(u string) string {
+	name, ok := b.uploadName(u)
+	if !ok {
+		return u
+	}
+	b.images[name] = true
+	return "images/" + name
+}
+
+var exportAssetAttr = regexp.MustCompile(`(?i)\b(src|href)="([^"]*)"`)
+
+// rewriteAssets points the uploaded images referred to by content at their
+// copies, prefixing them with prefix.
+func (b *siteBuilder) rewriteAssets(content, prefix string) string {
+	return exportAssetAttr.ReplaceAllStringFunc(content, func(attr string) string {
+		m := exportAssetAttr.FindStringSubmatch(attr)
+		if _, ok := b.uploadName(m[2]); !ok {
+			return attr
+		}
+		return m[1] + `="` + prefix + b.asset(m[2]) + `"`
+	})
+}
+
+func (b *siteBuilder) writeFile(name string, data []byte) error {
+	f, err := b.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// writePage renders a template to a page of the site and lists it in the sitemap.
+func (b *siteBuilder) writePage(name, tmpl string, page exportPage, modified time.Time) error {
+	if b.baseURL != "" {
+		page.Canonical = b.baseURL + "/" + name
+		b.pages = append(b.pages, sitemapURL{Loc: page.Canonical, LastMod: modified.UTC().Format("2006-01-02")})
+	}
+	var buf bytes.Buffer
+	if err := b.tmpl.ExecuteTemplate(&buf, tmpl, page); err != nil {
+		return err
+	}
+	return b.writeFile(name, buf.Bytes())
+}
+
+// copyImages adds the uploaded images the site refers to. Missing files are
+// skipped so one deleted upload doesn't prevent exporting the rest.
+func (b *siteBuilder) copyImages() error {
+	names := make([]string, 0, len(b.images))
+	for name := range b.images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		src, err := os.Open(filepath.Join("./public/uploads", name))
+		if err != nil {
+			log.Printf("Skipping missing upload %s in static export: %v", name, err)
+			continue
+		}
+		dst, err := b.zip.Create("images/" + name)
+		if err == nil {
+			_, err = io.Copy(dst, src)
+		}
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// exportBaseURL returns where an exported portfolio will be hosted: the base
+// URL chosen by the user, otherwise the verified custom domain serving the
+// portfolio, otherwise empty.
+func exportBaseURL(portfolio Portfolio, chosen string) string {
+	if chosen != "" {
+		return strings.TrimRight(chosen, "/")
+	}
+	var domain CustomDomain
+	query := DB.Where("user_id = ? AND verified_at IS NOT NULL", portfolio.UserID)
+	if portfolio.IsDefault {
+		query = query.Where("portfolio_id = ? OR portfolio_id IS NULL", portfolio.ID)
+	} else {
+		query = query.Where("portfolio_id = ?", portfolio.ID)
+	}
+	if result := query.Order("id asc").First(&domain); result.Error != nil {
+		return ""
+	}
+	return "https://" + domain.Domain
+}
+
+// exportPagePath returns the file name of an exported project or post page.
+func exportPagePath(dir, slug string, id uint) string {
+	if slug == "" {
+		slug = strconv.FormatUint(uint64(id), 10)
+	}
+	return dir + "/" + slug + ".html"
+}
+
+// splitParagraphs splits text on blank lines.
+func splitParagraphs(text string) []string {
+	var paragraphs []string
+	for _, p := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+// buildStaticSite renders a user's portfolio, its projects and achievements
+// and the user's published posts as a self-contained static site, written to
+// w as a ZIP archive. The sitemap and feed need absolute URLs, so they are
+// only included when the site's base URL is known.
+func buildStaticSite(w io.Writer, opts exportOptions) error {
+	var user User
+	if result := DB.First(&user, opts.UserID); result.Error != nil {
+		return result.Error
+	}
+
+	var portfolio Portfolio
+	query := DB.Where("user_id = ?", user.ID)
+	if opts.PortfolioID != nil {
+		query = query.Where("id = ?", *opts.PortfolioID)
+	}
+	if result := query.Order("is_default desc, id asc").First(&portfolio); result.Error != nil {
+		return result.Error
+	}
+
+	layout, settings := portfolioTheme(portfolio)
+	theme := opts.Theme
+	if theme == "" {
+		theme = defaultExportTheme
+		if _, err := fs.Stat(exportThemeFS, "exportthemes/"+layout.Name); err == nil {
+			theme = layout.Name
+		}
+	}
+	tmpl, css, err := loadExportTheme(theme)
+	if err != nil {
+		return err
+	}
+	css = append(css, settingsCSS(settings)...)
+
+	projects, achievements, err := portfolioContents(portfolio)
+	if err != nil {
+		return err
+	}
+	if portfolio.HideBrokenLinks {
+		if projects, _, err = hideBrokenLinks(projects, nil); err != nil {
+			return err
+		}
+	}
+	var posts []Post
+	if result := DB.Preload("Tags").Where("user_id = ? AND published_at IS NOT NULL AND published_at <= ?", user.ID, time.Now()).
+		Order("published_at desc").Find(&posts); result.Error != nil {
+		return result.Error
+	}
+
+	b := &siteBuilder{
+		zip:     zip.NewWriter(w),
+		tmpl:    tmpl,
+		baseURL: exportBaseURL(portfolio, opts.BaseURL),
+		hosts:   siteHosts(),
+		images:  make(map[string]bool),
+	}
+
+	site := &exportSite{
+		Title:        portfolio.Title,
+		Username:     user.Username,
+		Portfolio:    portfolio,
+		Settings:     settings,
+		About:        splitParagraphs(portfolio.AboutMe),
+		Achievements: achievements,
+		HasFeed:      b.baseURL != "" && len(posts) > 0,
+		Year:         time.Now().Year(),
+	}
+	if site.Title == "" {
+		site.Title = user.Username
+	}
+
+	for _, project := range projects {
+		p := exportProject{
+			Project:  project,
+			Page:     exportPagePath("projects", project.Slug, project.ID),
+			ImageURL: b.asset(project.ImageURL),
+		}
+		for _, tech := range strings.Split(project.Technologies, ",") {
+			if tech = strings.TrimSpace(tech); tech != "" {
+				p.Technologies = append(p.Technologies, tech)
+			}
+		}
+		site.Projects = append(site.Projects, p)
+	}
+
+	for _, post := range posts {
+		p := exportPost{
+			Title:         post.Title,
+			Page:          exportPagePath("blog", post.Slug, post.ID),
+			Excerpt:       post.Excerpt,
+			ReadingTime:   post.ReadingTime,
+			CoverImageURL: b.asset(post.CoverImageURL),
+			Content:       template.HTML(b.rewriteAssets(RenderPostContent(post.Content), "../")),
+			Published:     post.PublishedAt,
+			Updated:       post.UpdatedAt,
+			source:        post,
+		}
+		for _, tag := range post.Tags {
+			p.Tags = append(p.Tags, tag.Name)
+		}
+		site.Posts = append(site.Posts, p)
+	}
+	site.RecentPosts = site.Posts
+	if len(site.RecentPosts) > exportRecentPosts {
+		site.RecentPosts = site.RecentPosts[:exportRecentPosts]
+	}
+
+	err = b.writePage("index.html", "index", exportPage{
+		Site:        site,
+		Title:       site.Title,
+		Description: portfolio.Description,
+	}, portfolio.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	for i := range site.Projects {
+		project := &site.Projects[i]
+		err := b.writePage(project.Page, "project", exportPage{
+			Site:        site,
+			Root:        "../",
+			Title:       project.Title + " | " + site.Title,
+			Description: project.Description,
+			Project:     project,
+		}, project.UpdatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(site.Posts) > 0 {
+		err := b.writePage("blog.html", "blog", exportPage{
+			Site:  site,
+			Title: "Blog | " + site.Title,
+		}, site.Posts[0].Updated)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range site.Posts {
+		post := &site.Posts[i]
+		err := b.writePage(post.Page, "post", exportPage{
+			Site:        site,
+			Root:        "../",
+			Title:       post.Title + " | " + site.Title,
+			Description: post.Excerpt,
+			Post:        post,
+		}, post.Updated)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := b.writeFile("style.css", css); err != nil {
+		return err
+	}
+
+	if b.baseURL != "" {
+		sitemap, err := marshalXML(sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: b.pages})
+		if err != nil {
+			return err
+		}
+		if err := b.writeFile("sitemap.xml", sitemap); err != nil {
+			return err
+		}
+	}
+
+	if site.HasFeed {
+		f := feed{
+			Title:       site.Title,
+			Description: portfolio.Description,
+			HomeURL:     b.baseURL + "/index.html",
+			FeedURL:     b.baseURL + "/feed.xml",
+		}
+		for i, post := range site.Posts {
+			if i == feedSize {
+				break
+			}
+			if post.Updated.After(f.Updated) {
+				f.Updated = post.Updated
+			}
+			f.Entries = append(f.Entries, feedEntry{
+				ID:        b.baseURL + "/" + post.Page,
+				URL:       b.baseURL + "/" + post.Page,
+				Title:     post.Title,
+				Author:    user.Username,
+				AuthorURL: b.baseURL + "/index.html",
+				Content:   b.rewriteAssets(RenderPostContent(post.source.Content), b.baseURL+"/"),
+				Tags:      post.Tags,
+				Published: post.Published,
+				Updated:   post.Updated,
+			})
+		}
+		atom, err := f.Atom()
+		if err != nil {
+			return err
+		}
+		if err := b.writeFile("feed.xml", atom); err != nil {
+			return err
+		}
+	}
+
+	if err := b.copyImages(); err != nil {
+		return err
+	}
+	return b.zip.Close()
+}
+
+// validateExportOptions checks the theme, portfolio and base URL chosen for an export.
+func validateExportOptions(opts *exportOptions) error {
+	if opts.Theme != "" {
+		if _, _, err := loadExportTheme(opts.Theme); err != nil {
+			return err
+		}
+	}
+	if opts.PortfolioID != nil {
+		var count int64
+		DB.Model(&Portfolio{}).Where("id = ? AND user_id = ?", *opts.PortfolioID, opts.UserID).Count(&count)
+		if count == 0 {
+			return errExportPortfolioNotFound
+		}
+	}
+	if opts.BaseURL != "" {
+		u, err := parseHTTPURL(opts.BaseURL)
+		if err != nil {
+			return errInvalidBaseURL
+		}
+		u.RawQuery, u.Fragment = "", ""
+		opts.BaseURL = strings.TrimRight(u.String(), "/")
+	}
+	return nil
+}
+
+// ExportSite handles downloading the authenticated user's portfolio and blog
+// as a static site in a ZIP archive. The theme, portfolio_id and base_url
+// query parameters override the saved export settings.
+func ExportSite(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var settings ExportSettings
+	DB.Where("user_id = ?", userID).First(&settings)
+
+	opts := exportOptions{
+		UserID:      userID,
+		PortfolioID: settings.PortfolioID,
+		Theme:       settings.Theme,
+		BaseURL:     settings.BaseURL,
+	}
+	q := r.URL.Query()
+	if s := q.Get("theme"); s != "" {
+		opts.Theme = s
+	}
+	if s := q.Get("portfolio_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid portfolio ID", http.StatusBadRequest)
+			return
+		}
+		portfolioID := uint(id)
+		opts.PortfolioID = &portfolioID
+	}
+	if s := q.Get("base_url"); s != "" {
+		opts.BaseURL = s
+	}
+	if err := validateExportOptions(&opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := buildStaticSite(&buf, opts); err != nil {
+		log.Printf("Failed to export site for user %d: %v", userID, err)
+		http.Error(w, "Failed to export site", http.StatusInternalServerError)
+		return
+	}
+
+	var user User
+	DB.First(&user, userID)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-site.zip"`, user.Username))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// exportPath returns where a user's automatically generated site is stored.
+func exportPath(userID uint) string {
+	return filepath.Join(exportDir, fmt.Sprintf("%d.zip", userID))
+}
+
+// ExportSettingsResponse is a user's export settings together with the
+// available themes.
+type ExportSettingsResponse struct {
+	ExportSettings
+	Themes []string `json:"themes"`
+}
+
+// GetExportSettings handles getting the authenticated user's export settings.
+func GetExportSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings := ExportSettings{UserID: userID}
+	result := DB.Where("user_id = ?", userID).First(&settings)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		http.Error(w, "Failed to retrieve export settings", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ExportSettingsResponse{ExportSettings: settings, Themes: exportThemes()})
+}
+
+// UpdateExportSettings handles updating the authenticated user's export
+// settings. With automatic regeneration on, the site is regenerated straight
+// away and then after every change to the user's content.
+func UpdateExportSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Theme          string `json:"theme"`
+		PortfolioID    *uint  `json:"portfolio_id"`
+		BaseURL        string `json:"base_url"`
+		AutoRegenerate bool   `json:"auto_regenerate"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	opts := exportOptions{UserID: userID, PortfolioID: req.PortfolioID, Theme: req.Theme, BaseURL: req.BaseURL}
+	if err := validateExportOptions(&opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings := ExportSettings{UserID: userID}
+	DB.Where("user_id = ?", userID).First(&settings)
+	settings.Theme = opts.Theme
+	settings.PortfolioID = opts.PortfolioID
+	settings.BaseURL = opts.BaseURL
+	settings.AutoRegenerate = req.AutoRegenerate
+	if result := DB.Save(&settings); result.Error != nil {
+		http.Error(w, "Failed to update export settings", http.StatusInternalServerError)
+		return
+	}
+
+	if !settings.AutoRegenerate {
+		os.Remove(exportPath(userID))
+	}
+
+	json.NewEncoder(w).Encode(ExportSettingsResponse{ExportSettings: settings, Themes: exportThemes()})
+}
+
+// GetLatestExport handles downloading the authenticated user's automatically
+// regenerated site.
+func GetLatestExport(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	f, err := os.Open(exportPath(userID))
+	if err != nil {
+		http.Error(w, "No generated site; enable automatic regeneration first", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Failed to read generated site", http.StatusInternalServerError)
+		return
+	}
+
+	var user User
+	DB.First(&user, userID)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-site.zip"`, user.Username))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+var (
+	exportTimersMu sync.Mutex
+	exportTimers   = make(map[uint]*time.Timer)
+)
+
+// scheduleExport regenerates a user's site once their changes settle down.
+func scheduleExport(userID uint) {
+	exportTimersMu.Lock()
+	defer exportTimersMu.Unlock()
+
+	if timer, ok := exportTimers[userID]; ok {
+		timer.Reset(exportDelay)
+		return
+	}
+	exportTimers[userID] = time.AfterFunc(exportDelay, func() {
+		exportTimersMu.Lock()
+		delete(exportTimers, userID)
+		exportTimersMu.Unlock()
+		regenerateExport(userID)
+	})
+}
+
+// regenerateExport rebuilds a user's site if they have automatic
+// regeneration on, replacing the previous archive only once the new one is
+// complete.
+func regenerateExport(userID uint) {
+	var settings ExportSettings
+	if result := DB.Where("user_id = ? AND auto_regenerate = ?", userID, true).First(&settings); result.Error != nil {
+		return
+	}
+
+	err := writeExport(settings)
+	updates := map[string]interface{}{"last_error": ""}
+	if err != nil {
+		log.Printf("Failed to regenerate site for user %d: %v", userID, err)
+		updates["last_error"] = err.Error()
+	} else {
+		updates["generated_at"] = time.Now()
+	}
+	DB.Model(&settings).UpdateColumns(updates)
+}
+
+func writeExport(settings ExportSettings) error {
+	if err := os.MkdirAll(exportDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(exportDir, "export-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = buildStaticSite(tmp, exportOptions{
+		UserID:      settings.UserID,
+		PortfolioID: settings.PortfolioID,
+		Theme:       settings.Theme,
+		BaseURL:     settings.BaseURL,
+	})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), exportPath(settings.UserID))
+}
+
+// statusRecorder remembers the status code a handler responded with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// RegenerateExportOnChange is a middleware for authenticated routes that
+// schedules regenerating the user's static site after every successful
+// change they make.
+func RegenerateExportOnChange(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status >= 400 {
+			return
+		}
+		if userID, err := getUserIDFromContext(r); err == nil {
+			scheduleExport(userID)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRelativeAsset(t *testing.T) {
+	tests := []struct {
+		root, in, want string
+	}{
+		{"../", "images/a.png", "../images/a.png"},
+		{"", "images/a.png", "images/a.png"},
+		{"../", "", ""},
+		{"../", "/uploads/a.png", "/uploads/a.png"},
+		{"../", "https://example.com/a.png", "https://example.com/a.png"},
+	}
+	for _, test := range tests {
+		if got := relativeAsset(test.root, test.in); got != test.want {
+			t.Errorf("relativeAsset(%q, %q) = %q, want %q", test.root, test.in, got, test.want)
+		}
+	}
+}
+
+func TestRewriteAssets(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`<img src="/uploads/a.png">`, `<img src="../images/a.png">`},
+		{`<img SRC="https://example.com/uploads/b.png">`, `<img SRC="../images/b.png">`},
+		{`<a href="https://other.org/uploads/c.png">`, `<a href="https://other.org/uploads/c.png">`},
+		{`<a href="/posts/d">`, `<a href="/posts/d">`},
+		{`<img src="/uploads/..">`, `<img src="/uploads/..">`},
+	}
+	b := &siteBuilder{hosts: map[string]bool{"example.com": true}, images: make(map[string]bool)}
+	for _, test := range tests {
+		if got := b.rewriteAssets(test.in, "../"); got != test.want {
+			t.Errorf("rewriteAssets(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+	if len(b.images) != 2 || !b.images["a.png"] || !b.images["b.png"] {
+		t.Errorf("images to copy = %v, want a.png and b.png", b.images)
+	}
+}
+
+func TestSettingsCSS(t *testing.T) {
+	tests := []struct {
+		settings ThemeSettings
+		want     []string
+		unwanted []string
+	}{
+		{
+			settings: ThemeSettings{},
+			want:     []string{":root {\n}\n"},
+			unwanted: []string{"--", "display: none"},
+		},
+		{
+			settings: ThemeSettings{"accent_color": "#ff0000", "font": "mono", "columns": 3, "show_project_images": false},
+			want:     []string{"--accent: #ff0000;", "--font: ui-monospace", "--columns: 3;", ".project img { display: none; }"},
+			unwanted: []string{"--text", "--background"},
+		},
+		{
+			settings: ThemeSettings{"font": "comic", "show_project_images": true},
+			unwanted: []string{"--font", "display: none"},
+		},
+	}
+	for _, test := range tests {
+		got := settingsCSS(test.settings)
+		for _, s := range test.want {
+			if !strings.Contains(got, s) {
+				t.Errorf("settingsCSS(%v) = %q, want it to contain %q", test.settings, got, s)
+			}
+		}
+		for _, s := range test.unwanted {
+			if strings.Contains(got, s) {
+				t.Errorf("settingsCSS(%v) = %q, don't want %q", test.settings, got, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// feedSize is the number of most recent posts included in a feed.
+const feedSize = 50
+
+// feedMediaTypes maps feed formats to the media type they are served as.
+var feedMediaTypes = map[string]string{
+	"rss":  "application/rss+xml",
+	"atom": "application/atom+xml",
+	"json": "application/feed+json",
+}
+
+// feed is the format-independent representation of a blog feed.
+type feed struct {
+	Title       string
+	Description string
+	HomeURL     string
+	FeedURL     string
+	Updated     time.Time
+	Entries     []feedEntry
+}
+
+type feedEntry struct {
+	ID        string
+	URL       string
+	Title     string
+	Author    string
+	AuthorURL string
+	Content   string // Rendered HTML
+	Tags      []string
+	Published time.Time
+	Updated   time.Time
+}
+
+// GetFeed handles serving the site-wide blog feed, or a single user's feed
+// when the route has a username, as RSS, Atom or JSON Feed.
+func GetFeed(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	format := vars["format"]
+	username := vars["username"]
+
+	base := publicBaseURL(r)
+	f := feed{
+		Title:       "Blog",
+		Description: "Latest posts",
+		HomeURL:     base + "/blog",
+		FeedURL:     requestBaseURL(r) + r.URL.Path,
+	}
+
+	query := DB.Where("published_at IS NOT NULL AND published_at <= ?", time.Now())
+	if username != "" {
+		var user User
+		if result := DB.Where("username = ?", username).First(&user); result.Error != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		query = query.Where("user_id = ?", user.ID)
+		f.Title = user.Username + "'s Blog"
+		f.Description = "Latest posts by " + user.Username
+		f.HomeURL = portfolioURL(base, user.Username)
+		// Until they post, the feed is as old as the author
+		f.Updated = user.CreatedAt
+	}
+
+	var posts []Post
+	if result := query.Preload("Tags").Order("published_at desc").Limit(feedSize).Find(&posts); result.Error != nil {
+		http.Error(w, "Failed to retrieve posts", http.StatusInternalServerError)
+		return
+	}
+
+	authors, err := usernamesByID(postAuthorIDs(posts))
+	if err != nil {
+		http.Error(w, "Failed to retrieve posts", http.StatusInternalServerError)
+		return
+	}
+
+	for _, post := range posts {
+		if post.UpdatedAt.After(f.Updated) {
+			f.Updated = post.UpdatedAt
+		}
+		author := authors[post.UserID]
+		var tags []string
+		for _, tag := range post.Tags {
+			tags = append(tags, tag.Name)
+		}
+		f.Entries = append(f.Entries, feedEntry{
+			ID:        postURL(base, post),
+			URL:       postURL(base, post),
+			Title:     post.Title,
+			Author:    author,
+			AuthorURL: portfolioURL(base, author),
+			Content:   RenderPostContent(post.Content),
+			Tags:      tags,
+			Published: post.PublishedAt,
+			Updated:   post.UpdatedAt,
+		})
+	}
+
+	var body []byte
+	switch format {
+	case "rss":
+		body, err = f.RSS()
+	case "atom":
+		body, err = f.Atom()
+	case "json":
+		body, err = f.JSON()
+	default:
+		http.Error(w, "Unknown feed format", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to render feed", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	if notModified(w, r, hex.EncodeToString(sum[:16]), f.Updated) {
+		return
+	}
+
+	w.Header().Set("Content-Type", feedMediaTypes[format]+"; charset=utf-8")
+	w.Write(body)
+}
+
+// postAuthorIDs returns the distinct author ids of posts.
+func postAuthorIDs(posts []Post) []uint {
+	seen := make(map[uint]bool)
+	var ids []uint
+	for _, post := range posts {
+		if !seen[post.UserID] {
+			seen[post.UserID] = true
+			ids = append(ids, post.UserID)
+		}
+	}
+	return ids
+}
+
+// usernamesByID looks up the usernames of the given users.
+func usernamesByID(ids []uint) (map[uint]string, error) {
+	names := make(map[uint]string)
+	if len(ids) == 0 {
+		return names, nil
+	}
+
+	var users []User
+	if result := DB.Select("id", "username").Where("id IN ?", ids).Find(&users); result.Error != nil {
+		return nil, result.Error
+	}
+	for _, user := range users {
+		names[user.ID] = user.Username
+	}
+	return names, nil
+}
+
+// feedLinks returns the alternate links for the site-wide feeds, or a user's
+// feeds when username is set, for use in a Link header so clients can
+// discover them.
+func feedLinks(r *http.Request, username string) string {
+	path := "/feeds/posts."
+	if username != "" {
+		path = "/users/" + username + "/feed."
+	}
+
+	base := requestBaseURL(r)
+	links := ""
+	for _, format := range []string{"rss", "atom", "json"} {
+		if links != "" {
+			links += ", "
+		}
+		links += fmt.Sprintf(`<%s%s%s>; rel="alternate"; type="%s"`, base, path, format, feedMediaTypes[format])
+	}
+	return links
+}
+
+type rssFeed struct {
+	XMLName      xml.Name   `xml:"rss"`
+	Version      string     `xml:"version,attr"`
+	AtomNS       string     `xml:"xmlns:atom,attr"`
+	ContentNS    string     `xml:"xmlns:content,attr"`
+	DublinCoreNS string     `xml:"xmlns:dc,attr"`
+	Channel      rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	SelfLink      rssLink   `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"dc:creator,omitempty"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"content:encoded"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// RSS renders the feed as RSS 2.0.
+func (f feed) RSS() ([]byte, error) {
+	doc := rssFeed{
+		Version:      "2.0",
+		AtomNS:       "http://www.w3.org/2005/Atom",
+		ContentNS:    "http://purl.org/rss/1.0/modules/content/",
+		DublinCoreNS: "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.HomeURL,
+			Description: f.Description,
+			SelfLink:    rssLink{Href: f.FeedURL, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if !f.Updated.IsZero() {
+		doc.Channel.LastBuildDate = f.Updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, e := range f.Entries {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       e.Title,
+			Link:        e.URL,
+			GUID:        rssGUID{IsPermaLink: true, Value: e.ID},
+			PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+			Creator:     e.Author,
+			Categories:  e.Tags,
+			Description: e.Content,
+			Content:     e.Content,
+		})
+	}
+	return marshalXML(doc)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     atomAuthor     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Atom renders the feed as Atom 1.0.
+func (f feed) Atom() ([]byte, error) {
+	// Atom requires a time; an empty feed gets a fixed one so it, and its
+	// ETag, stay the same between requests
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+	doc := atomFeed{
+		ID:    f.FeedURL,
+		Title: f.Title,
+		Links: []atomLink{
+			{Href: f.FeedURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.HomeURL, Rel: "alternate", Type: "text/html"},
+		},
+		Updated: updated.UTC().Format(time.RFC3339),
+	}
+	for _, e := range f.Entries {
+		var categories []atomCategory
+		for _, tag := range e.Tags {
+			categories = append(categories, atomCategory{Term: tag})
+		}
+		doc.Entries = append(doc.Entries, atomEntry{
+			ID:         e.ID,
+			Title:      e.Title,
+			Link:       atomLink{Href: e.URL, Rel: "alternate", Type: "text/html"},
+			Published:  e.Published.UTC().Format(time.RFC3339),
+			Updated:    e.Updated.UTC().Format(time.RFC3339),
+			Author:     atomAuthor{Name: e.Author, URI: e.AuthorURL},
+			Categories: categories,
+			Content:    atomContent{Type: "html", Value: e.Content},
+		})
+	}
+	return marshalXML(doc)
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+// JSON renders the feed as JSON Feed 1.1.
+func (f feed) JSON() ([]byte, error) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		Description: f.Description,
+		HomePageURL: f.HomeURL,
+		FeedURL:     f.FeedURL,
+		Items:       []jsonFeedItem{},
+	}
+	for _, e := range f.Entries {
+		doc.Items = append(doc.Items, jsonFeedItem{
+			ID:            e.ID,
+			URL:           e.URL,
+			Title:         e.Title,
+			ContentHTML:   e.Content,
+			DatePublished: e.Published.UTC().Format(time.RFC3339),
+			DateModified:  e.Updated.UTC().Format(time.RFC3339),
+			Authors:       []jsonFeedAuthor{{Name: e.Author, URL: e.AuthorURL}},
+			Tags:          e.Tags,
+		})
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEmptyAtomFeedIsStable(t *testing.T) {
+	f := feed{Title: "Blog", FeedURL: "https://example.com/feed.atom", HomeURL: "https://example.com/blog"}
+	first, err := f.Atom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := f.Atom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("empty feed changed between renders:\n%s\n%s", first, second)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Users follow each other to see what the people they follow add, in their
+// feed. This is separate from ActivityPub followers, which are remote actors
+// following a user's blog.
+
+// publicAchievementCondition limits a query to achievements anyone may see,
+// as publicProjectCondition does for projects.
+const publicAchievementCondition = "(achievements.portfolio_id IN (SELECT id FROM portfolios WHERE visibility = 'public' AND deleted_at IS NULL) OR " +
+	"achievements.id IN (SELECT portfolio_achievements.achievement_id FROM portfolio_achievements " +
+	"JOIN portfolios ON portfolios.id = portfolio_achievements.portfolio_id WHERE portfolios.visibility = 'public' AND portfolios.deleted_at IS NULL))"
+
+// followCounts returns how many users follow a user and how many they follow.
+func followCounts(userID uint) (followers, following int64, err error) {
+	if result := DB.Model(&Follow{}).Where("following_id = ?", userID).Count(&followers); result.Error != nil {
+		return 0, 0, result.Error
+	}
+	if result := DB.Model(&Follow{}).Where("follower_id = ?", userID).Count(&following); result.Error != nil {
+		return 0, 0, result.Error
+	}
+	return followers, following, nil
+}
+
+// isFollowing reports whether one user follows another.
+func isFollowing(followerID, followingID uint) bool {
+	var count int64
+	DB.Model(&Follow{}).Where("follower_id = ? AND following_id = ?", followerID, followingID).Count(&count)
+	return count > 0
+}
+
+// FollowStatus is whether the authenticated user follows a user, with the
+// user's follower count.
+type FollowStatus struct {
+	Following      bool  `json:"following"`
+	FollowersCount int64 `json:"followers_count"`
+}
+
+// findFollowTarget loads the user identified by the "username" route variable.
+func findFollowTarget(r *http.Request, userID uint) (User, int, string) {
+	var user User
+	if result := DB.Where("username = ?", mux.Vars(r)["username"]).First(&user); result.Error != nil {
+		return user, http.StatusNotFound, "User not found"
+	}
+	if user.ID == userID {
+		return user, http.StatusBadRequest, "You can't follow yourself"
+	}
+	return user, 0, ""
+}
+
+// writeFollowStatus writes whether the authenticated user follows a user.
+func writeFollowStatus(w http.ResponseWriter, status int, following bool, user User) {
+	followers, _, err := followCounts(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve followers", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(FollowStatus{Following: following, FollowersCount: followers})
+}
+
+// FollowUser handles the authenticated user following another user.
+// Following a user already followed changes nothing.
+func FollowUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, status, msg := findFollowTarget(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	follow := Follow{FollowerID: userID, FollowingID: user.ID}
+	if result := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&follow); result.Error != nil {
+		http.Error(w, "Failed to follow user", http.StatusInternalServerError)
+		return
+	}
+
+	writeFollowStatus(w, http.StatusCreated, true, user)
+}
+
+// UnfollowUser handles the authenticated user no longer following a user.
+func UnfollowUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, status, msg := findFollowTarget(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	// Removed outright so the user can be followed again
+	if result := DB.Unscoped().Where("follower_id = ? AND following_id = ?", userID, user.ID).Delete(&Follow{}); result.Error != nil {
+		http.Error(w, "Failed to unfollow user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// followListSpec describes the listing of a user's followers or of the users
+// they follow, most recently followed first.
+var followListSpec = ListSpec{
+	Table: "follows",
+	SortKeys: map[string]ListSortKey{
+		"date": {Expr: "follows.created_at", IsTime: true},
+	},
+	DefaultSort: "date",
+}
+
+// FollowUserSummary is a user in a list of followers or followed users.
+type FollowUserSummary struct {
+	ID                uint      `json:"-"` // Of the follow, for pagination
+	Username          string    `json:"username"`
+	Bio               string    `json:"bio"`
+	ProfilePictureURL string    `json:"profile_picture_url"`
+	FollowedAt        time.Time `json:"followed_at"`
+}
+
+// writeFollowList lists the users on one side of a user's follows a page at
+// a time. listed is the column of the users listed and other the column of
+// the user whose follows they are.
+func writeFollowList(w http.ResponseWriter, r *http.Request, listed, other string) {
+	var user User
+	if result := DB.Where("username = ?", mux.Vars(r)["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	opts, err := ParseListOptions(r, followListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	base := DB.Table("follows").
+		Select("follows.id, follows.created_at AS followed_at, users.username, users.bio, users.profile_picture_url").
+		Joins("JOIN users ON users.id = follows."+listed).
+		Where("follows."+other+" = ? AND follows.deleted_at IS NULL AND users.deleted_at IS NULL", user.ID)
+	query, err := followListSpec.Apply(base, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	users := []FollowUserSummary{}
+	if result := query.Scan(&users); result.Error != nil {
+		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+		return
+	}
+	if len(users) > opts.Limit {
+		users = users[:opts.Limit]
+		if err := followListSpec.SetNextPage(w, r, opts, users[len(users)-1].ID); err != nil {
+			http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(users)
+}
+
+// GetFollowers handles listing the users following a user.
+func GetFollowers(w http.ResponseWriter, r *http.Request) {
+	writeFollowList(w, r, "follower_id", "following_id")
+}
+
+// GetFollowing handles listing the users a user follows.
+func GetFollowing(w http.ResponseWriter, r *http.Request) {
+	writeFollowList(w, r, "following_id", "follower_id")
+}
+
+// feedListSpec describes the feed. Its items come from several tables, so
+// it only borrows the parsing of the listing parameters; feedPage orders
+// and pages the items itself, breaking ties by type as well as ID.
+var feedListSpec = ListSpec{
+	Table: "items",
+	SortKeys: map[string]ListSortKey{
+		"date": {Expr: "items.time", IsTime: true},
+	},
+	DefaultSort: "date",
+}
+
+// feedItemsQuery selects the type, ID, time and author of everything that
+// appears in feeds: projects and achievements when they are added, and posts
+// when they are published. Only what anyone may see is included.
+const feedItemsQuery = `SELECT 'project' AS type, projects.id, projects.created_at AS time, portfolios.user_id
+FROM projects JOIN portfolios ON portfolios.id = projects.portfolio_id
+WHERE projects.deleted_at IS NULL AND ` + publicProjectCondition + `
+UNION ALL
+SELECT 'achievement', achievements.id, achievements.created_at, portfolios.user_id
+FROM achievements JOIN portfolios ON portfolios.id = achievements.portfolio_id
+WHERE achievements.deleted_at IS NULL AND ` + publicAchievementCondition + `
+UNION ALL
+SELECT 'post', posts.id, posts.published_at, posts.user_id
+FROM posts
+WHERE posts.deleted_at IS NULL AND posts.published_at IS NOT NULL AND posts.published_at <= NOW()`
+
+// FeedAuthor is the user a feed item is from.
+type FeedAuthor struct {
+	Username          string `json:"username"`
+	ProfilePictureURL string `json:"profile_picture_url"`
+}
+
+// FeedItem is a project, achievement or post in a feed. Only the field
+// matching its type is set.
+type FeedItem struct {
+	Type        string       `json:"type"`
+	Time        time.Time    `json:"time"`
+	Author      FeedAuthor   `json:"author"`
+	Project     *Project     `json:"project,omitempty"`
+	Achievement *Achievement `json:"achievement,omitempty"`
+	Post        *PostSummary `json:"post,omitempty"`
+}
+
+// feedItemRef is an item of the feed before what it refers to is loaded.
+type feedItemRef struct {
+	Type   string
+	ID     uint
+	Time   time.Time
+	UserID uint
+}
+
+// feedPage narrows the feed items of the users followed by userID to the
+// page described by opts, with one extra item to tell whether another page
+// exists.
+func feedPage(userID uint, opts ListOptions) (*gorm.DB, error) {
+	items := DB.Table("("+feedItemsQuery+") AS items").
+		Where("items.user_id IN (?)", DB.Model(&Follow{}).Select("following_id").Where("follower_id = ?", userID))
+	if opts.Since != nil {
+		items = items.Where("items.time >= ?", *opts.Since)
+	}
+	if opts.Until != nil {
+		items = items.Where("items.time <= ?", *opts.Until)
+	}
+
+	cmp := "<"
+	if opts.Order == "asc" {
+		cmp = ">"
+	}
+	if opts.Cursor != nil {
+		t, err := time.Parse(time.RFC3339Nano, opts.Cursor.Value)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		items = items.Where("(items.time, items.type, items.id) "+cmp+" (?, ?, ?)", t, opts.Cursor.Kind, opts.Cursor.ID)
+	}
+	order := strings.ToUpper(opts.Order)
+	return items.Order(fmt.Sprintf("items.time %s, items.type %s, items.id %s", order, order, order)).Limit(opts.Limit + 1), nil
+}
+
+// feedCursor returns the cursor of the page following ref.
+func feedCursor(opts ListOptions, ref feedItemRef) listCursor {
+	return listCursor{Sort: opts.Sort, Order: opts.Order, Value: ref.Time.UTC().Format(time.RFC3339Nano), ID: ref.ID, Kind: ref.Type}
+}
+
+// GetActivityFeed handles listing the projects, achievements and posts of the users
+// the authenticated user follows, newest first, a page at a time. It takes
+// the limit, cursor, order, since and until query parameters; the cursor of
+// the next page is in the X-Next-Cursor and Link headers.
+func GetActivityFeed(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	opts, err := ParseListOptions(r, feedListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	items, err := feedPage(userID, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var refs []feedItemRef
+	if result := items.Scan(&refs); result.Error != nil {
+		http.Error(w, "Failed to retrieve feed", http.StatusInternalServerError)
+		return
+	}
+	if len(refs) > opts.Limit {
+		refs = refs[:opts.Limit]
+		setNextCursor(w, r, feedCursor(opts, refs[len(refs)-1]))
+	}
+
+	// Load what the page refers to
+	ids := map[string][]uint{}
+	var userIDs []uint
+	for _, ref := range refs {
+		ids[ref.Type] = append(ids[ref.Type], ref.ID)
+		userIDs = append(userIDs, ref.UserID)
+	}
+	var projects []Project
+	if result := DB.Preload("Media", orderedMedia).Where("id IN ?", ids["project"]).Find(&projects); result.Error != nil {
+		http.Error(w, "Failed to retrieve feed", http.StatusInternalServerError)
+		return
+	}
+	var achievements []Achievement
+	if result := DB.Where("id IN ?", ids["achievement"]).Find(&achievements); result.Error != nil {
+		http.Error(w, "Failed to retrieve feed", http.StatusInternalServerError)
+		return
+	}
+	var posts []Post
+	if result := DB.Omit("Content").Preload("Tags").Where("id IN ?", ids["post"]).Find(&posts); result.Error != nil {
+		http.Error(w, "Failed to retrieve feed", http.StatusInternalServerError)
+		return
+	}
+	var users []User
+	if result := DB.Where("id IN ?", userIDs).Find(&users); result.Error != nil {
+		http.Error(w, "Failed to retrieve feed", http.StatusInternalServerError)
+		return
+	}
+	summaries, err := toPostSummaries(posts)
+	if err != nil {
+		http.Error(w, "Failed to retrieve feed", http.StatusInternalServerError)
+		return
+	}
+	projects, err = hideBrokenProjectLinks(projects)
+	if err != nil {
+		http.Error(w, "Failed to retrieve feed", http.StatusInternalServerError)
+		return
+	}
+
+	projectByID := make(map[uint]*Project, len(projects))
+	for i := range projects {
+		projectByID[projects[i].ID] = &projects[i]
+	}
+	achievementByID := make(map[uint]*Achievement, len(achievements))
+	for i := range achievements {
+		achievementByID[achievements[i].ID] = &achievements[i]
+	}
+	postByID := make(map[uint]*PostSummary, len(summaries))
+	for i := range summaries {
+		postByID[summaries[i].ID] = &summaries[i]
+	}
+	authorByID := make(map[uint]FeedAuthor, len(users))
+	for _, user := range users {
+		authorByID[user.ID] = FeedAuthor{Username: user.Username, ProfilePictureURL: user.ProfilePictureURL}
+	}
+
+	feed := make([]FeedItem, 0, len(refs))
+	for _, ref := range refs {
+		item := FeedItem{Type: ref.Type, Time: ref.Time, Author: authorByID[ref.UserID]}
+		switch ref.Type {
+		case "project":
+			item.Project = projectByID[ref.ID]
+		case "achievement":
+			item.Achievement = achievementByID[ref.ID]
+		case "post":
+			item.Post = postByID[ref.ID]
+		}
+		feed = append(feed, item)
+	}
+
+	json.NewEncoder(w).Encode(feed)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// useDryRunDB replaces the database for the rest of the test with one that
+// builds statements without running them.
+func useDryRunDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 port=1"}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := DB
+	DB = db
+	t.Cleanup(func() { DB = previous })
+}
+
+func TestFeedCursorRoundTrip(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/feed?limit=5", nil)
+	opts, err := ParseListOptions(r, feedListSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := feedItemRef{Type: "post", ID: 7, Time: time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.FixedZone("CEST", 2*60*60))}
+
+	w := httptest.NewRecorder()
+	setNextCursor(w, r, feedCursor(opts, ref))
+	cursor := w.Header().Get("X-Next-Cursor")
+	if link := w.Header().Get("Link"); !strings.Contains(link, "cursor="+cursor) || !strings.Contains(link, "limit=5") {
+		t.Errorf("Link = %q, want the next page with cursor %s and the same limit", link, cursor)
+	}
+
+	next, err := ParseListOptions(httptest.NewRequest(http.MethodGet, "/api/feed?limit=5&cursor="+cursor, nil), feedListSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := time.Parse(time.RFC3339Nano, next.Cursor.Value)
+	if err != nil || !got.Equal(ref.Time) || next.Cursor.Kind != ref.Type || next.Cursor.ID != ref.ID {
+		t.Errorf("cursor decoded as %+v, want %+v", next.Cursor, ref)
+	}
+
+	// The cursor belongs to the order it was issued for
+	if _, err := ParseListOptions(httptest.NewRequest(http.MethodGet, "/api/feed?order=asc&cursor="+cursor, nil), feedListSpec); err == nil {
+		t.Error("cursor of a newest-first page accepted for an oldest-first one")
+	}
+	if _, err := ParseListOptions(httptest.NewRequest(http.MethodGet, "/api/feed?cursor=not-a-cursor", nil), feedListSpec); err == nil {
+		t.Error("malformed cursor accepted")
+	}
+}
+
+func TestFeedPageOrdering(t *testing.T) {
+	useDryRunDB(t)
+	cursor := feedCursor(ListOptions{Sort: "date", Order: "desc"}, feedItemRef{Type: "post", ID: 7, Time: time.Now()})
+
+	tests := []struct {
+		query     string
+		cursor    *listCursor
+		want      []string
+		wantLimit int
+	}{
+		{query: "", want: []string{"ORDER BY items.time DESC, items.type DESC, items.id DESC"}, wantLimit: defaultListLimit + 1},
+		{query: "?limit=5", cursor: &cursor, want: []string{"(items.time, items.type, items.id) < (", "ORDER BY items.time DESC, items.type DESC, items.id DESC"}, wantLimit: 6},
+		{query: "?order=asc&since=2024-01-01", want: []string{"items.time >= ", "ORDER BY items.time ASC, items.type ASC, items.id ASC"}, wantLimit: defaultListLimit + 1},
+	}
+	for _, tt := range tests {
+		opts, err := ParseListOptions(httptest.NewRequest(http.MethodGet, "/api/feed"+tt.query, nil), feedListSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.Cursor = tt.cursor
+		page, err := feedPage(1, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var refs []feedItemRef
+		stmt := page.Scan(&refs).Statement
+		sql := stmt.SQL.String()
+		for _, want := range tt.want {
+			if !strings.Contains(sql, want) {
+				t.Errorf("feed query for %q = %s, want %q in it", tt.query, sql, want)
+			}
+		}
+		if limit := stmt.Vars[len(stmt.Vars)-1]; !strings.HasSuffix(sql, "LIMIT $"+strconv.Itoa(len(stmt.Vars))) || limit != tt.wantLimit {
+			t.Errorf("feed query for %q limited to %v, want %d", tt.query, limit, tt.wantLimit)
+		}
+	}
+
+	if _, err := feedPage(1, ListOptions{Sort: "date", Order: "desc", Limit: 1, Cursor: &listCursor{Value: "yesterday"}}); err == nil {
+		t.Error("cursor with an invalid time accepted")
+	}
+}
@@ -0,0 +1,722 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Projects can be linked to a repository on a git hosting service. The
+// repository's details are pulled periodically and, as the owner chooses,
+// copied onto the project.
+
+const (
+	repoSyncInterval = 6 * time.Hour // How often linked repositories are pulled
+	maxReadmeSize    = 256 << 10     // Bytes of a README that are kept
+	maxRepoResponse  = 1 << 20       // Bytes of any other API response that are read
+)
+
+var (
+	// errUnsupportedRepository is returned for a URL no provider recognises.
+	errUnsupportedRepository = errors.New("not a repository URL of a supported git host; choose a provider for self-hosted instances, which must use https")
+	// errRepositoryNotFound is returned when the provider doesn't have the repository.
+	errRepositoryNotFound = errors.New("repository not found")
+	// errRepositoryUnavailable is returned when the provider can't be reached
+	// or answers unexpectedly. The details are logged rather than shown, as
+	// self-hosted instances can be any server.
+	errRepositoryUnavailable = errors.New("the git host could not be reached or returned an unexpected response")
+)
+
+var (
+	// repoClient is used to call the APIs of hosts named in repository URLs.
+	repoClient = newPublicClient(15 * time.Second)
+	// configuredRepoClient is used to call the APIs configured for the
+	// server, which may be on a private network.
+	configuredRepoClient = &http.Client{Timeout: 15 * time.Second}
+)
+
+// RepoRef identifies a repository on a git host.
+type RepoRef struct {
+	BaseURL string // e.g., "https://codeberg.org"; empty for the configured GitHub and GitLab APIs
+	Owner   string // The namespace, which may have several levels on GitLab
+	Name    string
+}
+
+// RepoInfo is what a provider reports about a repository.
+type RepoInfo struct {
+	URL          string // The repository's web page
+	Homepage     string // Website set for the repository, if any
+	Description  string
+	Topics       []string
+	Languages    []string // Most used first
+	Stars        int
+	LastCommitAt *time.Time
+	Readme       string // Raw README, usually Markdown
+}
+
+// RepoProvider fetches repositories from a git hosting service.
+type RepoProvider interface {
+	// ParseURL returns the repository a web URL points to. Providers for
+	// self-hosted services accept any https host when explicitly chosen.
+	ParseURL(u *url.URL, chosen bool) (RepoRef, bool)
+	// Fetch returns the current details of a repository.
+	Fetch(ctx context.Context, ref RepoRef) (RepoInfo, error)
+}
+
+// repoProviders are the supported git hosts by name. GITHUB_API_URL and
+// GITLAB_API_URL can point the GitHub and GitLab providers at an enterprise
+// instance or a local stand-in.
+var repoProviders = map[string]RepoProvider{
+	"github": githubProvider{API: repoAPI{
+		BaseURL: envOr("GITHUB_API_URL", "https://api.github.com"),
+		Client:  configuredRepoClient,
+		Token:   tokenHeader("Authorization", "token ", os.Getenv("GITHUB_TOKEN")),
+	}},
+	"gitlab": gitlabProvider{API: repoAPI{
+		BaseURL: envOr("GITLAB_API_URL", "https://gitlab.com/api/v4"),
+		Client:  configuredRepoClient,
+		Token:   tokenHeader("PRIVATE-TOKEN", "", os.Getenv("GITLAB_TOKEN")),
+	}},
+	"gitea": giteaProvider{},
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return strings.TrimSuffix(value, "/")
+	}
+	return fallback
+}
+
+// tokenHeader returns the header carrying a token, or nil if there is none.
+func tokenHeader(name, prefix, token string) http.Header {
+	if token == "" {
+		return nil
+	}
+	return http.Header{name: {prefix + token}}
+}
+
+// repoPath splits a URL path of the form /{owner}/{name}, ignoring anything
+// after it and a trailing ".git".
+func repoPath(u *url.URL) (string, string, bool) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], strings.TrimSuffix(parts[1], ".git"), true
+}
+
+// selfHostedBaseURL returns the base URL of a self-hosted instance serving
+// u. Instances must be reached over https, as their API responses are
+// trusted enough to be copied onto projects.
+func selfHostedBaseURL(u *url.URL) (string, bool) {
+	if u.Scheme != "https" || u.User != nil {
+		return "", false
+	}
+	return "https://" + strings.ToLower(u.Host), true
+}
+
+// repoAPI calls the API of a git host.
+type repoAPI struct {
+	BaseURL string
+	Client  *http.Client
+	Token   http.Header // Sent with every request, if any
+}
+
+// get requests an API resource, returning nil and no error for a 404.
+func (a repoAPI) get(ctx context.Context, path, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.BaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	for name, values := range a.Token {
+		req.Header[name] = values
+	}
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		log.Printf("Failed to fetch %s: %v", req.URL, err)
+		return nil, errRepositoryUnavailable
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		log.Printf("Failed to fetch %s: %s", req.URL, resp.Status)
+		return nil, errRepositoryUnavailable
+	}
+	return resp, nil
+}
+
+// getJSON fetches an API resource into v. A 404 is reported as
+// errRepositoryNotFound.
+func (a repoAPI) getJSON(ctx context.Context, path string, v interface{}) error {
+	resp, err := a.get(ctx, path, "application/json")
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		return errRepositoryNotFound
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxRepoResponse)).Decode(v); err != nil {
+		log.Printf("Failed to decode %s: %v", resp.Request.URL, err)
+		return errRepositoryUnavailable
+	}
+	return nil
+}
+
+// getText fetches a raw file, returning "" if there is none.
+func (a repoAPI) getText(ctx context.Context, path, accept string) (string, error) {
+	resp, err := a.get(ctx, path, accept)
+	if err != nil || resp == nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxReadmeSize))
+	if err != nil {
+		return "", errRepositoryUnavailable
+	}
+	return string(data), nil
+}
+
+// languagesBySize orders languages by the bytes of code written in them.
+func languagesBySize(sizes map[string]int64) []string {
+	languages := make([]string, 0, len(sizes))
+	for language := range sizes {
+		languages = append(languages, language)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		if sizes[languages[i]] != sizes[languages[j]] {
+			return sizes[languages[i]] > sizes[languages[j]]
+		}
+		return languages[i] < languages[j]
+	})
+	return languages
+}
+
+// repoCommit is a commit as listed by the GitHub and Gitea APIs.
+type repoCommit struct {
+	Commit struct {
+		Committer struct {
+			Date time.Time `json:"date"`
+		} `json:"committer"`
+	} `json:"commit"`
+}
+
+// githubProvider fetches repositories through the GitHub REST API.
+type githubProvider struct {
+	API repoAPI
+}
+
+func (p githubProvider) ParseURL(u *url.URL, chosen bool) (RepoRef, bool) {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if host != "github.com" {
+		return RepoRef{}, false
+	}
+	owner, name, ok := repoPath(u)
+	return RepoRef{Owner: owner, Name: name}, ok
+}
+
+func (p githubProvider) Fetch(ctx context.Context, ref RepoRef) (RepoInfo, error) {
+	base := "/repos/" + url.PathEscape(ref.Owner) + "/" + url.PathEscape(ref.Name)
+
+	var repo struct {
+		HTMLURL       string   `json:"html_url"`
+		Homepage      string   `json:"homepage"`
+		Description   string   `json:"description"`
+		Topics        []string `json:"topics"`
+		Stars         int      `json:"stargazers_count"`
+		DefaultBranch string   `json:"default_branch"`
+	}
+	if err := p.API.getJSON(ctx, base, &repo); err != nil {
+		return RepoInfo{}, err
+	}
+	info := RepoInfo{URL: repo.HTMLURL, Homepage: repo.Homepage, Description: repo.Description, Topics: repo.Topics, Stars: repo.Stars}
+
+	var sizes map[string]int64
+	if err := p.API.getJSON(ctx, base+"/languages", &sizes); err != nil {
+		return RepoInfo{}, err
+	}
+	info.Languages = languagesBySize(sizes)
+
+	var commits []repoCommit
+	if err := p.API.getJSON(ctx, base+"/commits?per_page=1&sha="+url.QueryEscape(repo.DefaultBranch), &commits); err != nil && !errors.Is(err, errRepositoryNotFound) {
+		return RepoInfo{}, err
+	}
+	if len(commits) > 0 {
+		date := commits[0].Commit.Committer.Date
+		info.LastCommitAt = &date
+	}
+
+	readme, err := p.API.getText(ctx, base+"/readme", "application/vnd.github.raw")
+	if err != nil {
+		return RepoInfo{}, err
+	}
+	info.Readme = readme
+	return info, nil
+}
+
+// gitlabProvider fetches repositories through the GitLab REST API, of
+// gitlab.com or, when chosen, of the self-hosted instance a URL is on. The
+// token is only sent to the configured API.
+type gitlabProvider struct {
+	API repoAPI
+}
+
+func (p gitlabProvider) ParseURL(u *url.URL, chosen bool) (RepoRef, bool) {
+	var ref RepoRef
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if host != "gitlab.com" {
+		base, ok := selfHostedBaseURL(u)
+		if !chosen || !ok {
+			return RepoRef{}, false
+		}
+		ref.BaseURL = base
+	}
+
+	// Projects can be nested in groups: /{group}/{subgroup}/{name}/-/tree/main
+	path := strings.Trim(u.Path, "/")
+	if i := strings.Index(path, "/-/"); i >= 0 {
+		path = path[:i]
+	}
+	i := strings.LastIndexByte(path, '/')
+	if i <= 0 || i == len(path)-1 {
+		return RepoRef{}, false
+	}
+	ref.Owner, ref.Name = path[:i], strings.TrimSuffix(path[i+1:], ".git")
+	return ref, true
+}
+
+func (p gitlabProvider) Fetch(ctx context.Context, ref RepoRef) (RepoInfo, error) {
+	api := p.API
+	if ref.BaseURL != "" {
+		api = repoAPI{BaseURL: ref.BaseURL + "/api/v4", Client: repoClient}
+	}
+	base := "/projects/" + url.PathEscape(ref.Owner+"/"+ref.Name)
+
+	var repo struct {
+		WebURL        string   `json:"web_url"`
+		Description   string   `json:"description"`
+		Topics        []string `json:"topics"`
+		Stars         int      `json:"star_count"`
+		DefaultBranch string   `json:"default_branch"`
+	}
+	if err := api.getJSON(ctx, base, &repo); err != nil {
+		return RepoInfo{}, err
+	}
+	info := RepoInfo{URL: repo.WebURL, Description: repo.Description, Topics: repo.Topics, Stars: repo.Stars}
+
+	// Percentages of the code rather than sizes
+	var shares map[string]float64
+	if err := api.getJSON(ctx, base+"/languages", &shares); err != nil {
+		return RepoInfo{}, err
+	}
+	sizes := make(map[string]int64, len(shares))
+	for language, share := range shares {
+		sizes[language] = int64(share * 100)
+	}
+	info.Languages = languagesBySize(sizes)
+
+	var commits []struct {
+		CommittedDate time.Time `json:"committed_date"`
+	}
+	if err := api.getJSON(ctx, base+"/repository/commits?per_page=1&ref_name="+url.QueryEscape(repo.DefaultBranch), &commits); err != nil && !errors.Is(err, errRepositoryNotFound) {
+		return RepoInfo{}, err
+	}
+	if len(commits) > 0 {
+		info.LastCommitAt = &commits[0].CommittedDate
+	}
+
+	for _, name := range []string{"README.md", "README", "readme.md", "README.rst", "README.txt"} {
+		readme, err := api.getText(ctx, base+"/repository/files/"+url.PathEscape(name)+"/raw?ref="+url.QueryEscape(repo.DefaultBranch), "text/plain")
+		if err != nil {
+			return RepoInfo{}, err
+		}
+		if readme != "" {
+			info.Readme = readme
+			break
+		}
+	}
+	return info, nil
+}
+
+// giteaProvider fetches public repositories through the API of a Gitea or
+// Forgejo instance, which is the host of the repository's URL. No token is
+// sent, as the instance may be anyone's.
+type giteaProvider struct{}
+
+// giteaHosts are public instances recognised without choosing the provider.
+var giteaHosts = map[string]bool{"codeberg.org": true, "gitea.com": true}
+
+func (p giteaProvider) ParseURL(u *url.URL, chosen bool) (RepoRef, bool) {
+	var base string
+	if host := strings.ToLower(u.Host); giteaHosts[host] {
+		base = "https://" + host
+	} else {
+		var ok bool
+		if base, ok = selfHostedBaseURL(u); !chosen || !ok {
+			return RepoRef{}, false
+		}
+	}
+	owner, name, ok := repoPath(u)
+	return RepoRef{BaseURL: base, Owner: owner, Name: name}, ok
+}
+
+func (p giteaProvider) Fetch(ctx context.Context, ref RepoRef) (RepoInfo, error) {
+	api := repoAPI{BaseURL: ref.BaseURL + "/api/v1", Client: repoClient}
+	base := "/repos/" + url.PathEscape(ref.Owner) + "/" + url.PathEscape(ref.Name)
+
+	var repo struct {
+		HTMLURL       string `json:"html_url"`
+		Website       string `json:"website"`
+		Description   string `json:"description"`
+		Stars         int    `json:"stars_count"`
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := api.getJSON(ctx, base, &repo); err != nil {
+		return RepoInfo{}, err
+	}
+	info := RepoInfo{URL: repo.HTMLURL, Homepage: repo.Website, Description: repo.Description, Stars: repo.Stars}
+
+	var topics struct {
+		Topics []string `json:"topics"`
+	}
+	if err := api.getJSON(ctx, base+"/topics", &topics); err != nil {
+		return RepoInfo{}, err
+	}
+	info.Topics = topics.Topics
+
+	var sizes map[string]int64
+	if err := api.getJSON(ctx, base+"/languages", &sizes); err != nil {
+		return RepoInfo{}, err
+	}
+	info.Languages = languagesBySize(sizes)
+
+	var commits []repoCommit
+	if err := api.getJSON(ctx, base+"/commits?limit=1&stat=false&sha="+url.QueryEscape(repo.DefaultBranch), &commits); err != nil && !errors.Is(err, errRepositoryNotFound) {
+		return RepoInfo{}, err
+	}
+	if len(commits) > 0 {
+		date := commits[0].Commit.Committer.Date
+		info.LastCommitAt = &date
+	}
+
+	for _, name := range []string{"README.md", "README", "readme.md", "README.rst", "README.txt"} {
+		readme, err := api.getText(ctx, base+"/raw/"+name+"?ref="+url.QueryEscape(repo.DefaultBranch), "text/plain")
+		if err != nil {
+			return RepoInfo{}, err
+		}
+		if readme != "" {
+			info.Readme = readme
+			break
+		}
+	}
+	return info, nil
+}
+
+// parseRepositoryURL finds the provider and repository for a URL. An empty
+// provider means any provider that recognises the URL's host.
+func parseRepositoryURL(raw, provider string) (string, RepoRef, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return "", RepoRef{}, errUnsupportedRepository
+	}
+
+	if provider != "" {
+		p, ok := repoProviders[provider]
+		if !ok {
+			return "", RepoRef{}, fmt.Errorf("unknown provider %q", provider)
+		}
+		if ref, ok := p.ParseURL(u, true); ok {
+			return provider, ref, nil
+		}
+		return "", RepoRef{}, errUnsupportedRepository
+	}
+
+	names := make([]string, 0, len(repoProviders))
+	for name := range repoProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ref, ok := repoProviders[name].ParseURL(u, false); ok {
+			return name, ref, nil
+		}
+	}
+	return "", RepoRef{}, errUnsupportedRepository
+}
+
+// syncRepository pulls a linked repository and copies the details the owner
+// chose onto the project. Failures are recorded on the link.
+func syncRepository(ctx context.Context, link *ProjectRepository) error {
+	now := time.Now()
+	link.SyncedAt = &now
+
+	provider, ok := repoProviders[link.Provider]
+	var info RepoInfo
+	var err error
+	if !ok {
+		err = fmt.Errorf("unknown provider %q", link.Provider)
+	} else {
+		info, err = provider.Fetch(ctx, RepoRef{BaseURL: link.BaseURL, Owner: link.Owner, Name: link.Name})
+	}
+	if err != nil {
+		link.SyncError = err.Error()
+		DB.Model(link).Updates(map[string]interface{}{"synced_at": now, "sync_error": link.SyncError})
+		return err
+	}
+
+	applyRepoInfo(link, info)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(link).Error; err != nil {
+			return err
+		}
+		updates := repoProjectUpdates(link, info)
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(&Project{}).Where("id = ?", link.ProjectID).Updates(updates).Error
+	})
+}
+
+// applyRepoInfo copies a successful pull onto the link. The host reports the
+// repository's URL and homepage, so only web links are kept; anything else
+// could end up as a link on the portfolio.
+func applyRepoInfo(link *ProjectRepository, info RepoInfo) {
+	link.SyncError = ""
+	link.Description = info.Description
+	link.Topics = strings.Join(info.Topics, ",")
+	link.Languages = strings.Join(info.Languages, ",")
+	link.Stars = info.Stars
+	link.LastCommitAt = info.LastCommitAt
+	link.Readme = info.Readme
+	if _, err := parseHTTPURL(info.URL); err == nil {
+		link.URL = info.URL
+	}
+	link.Homepage = ""
+	if _, err := parseHTTPURL(info.Homepage); err == nil {
+		link.Homepage = info.Homepage
+	}
+}
+
+// repoProjectUpdates returns the project columns the owner chose to have
+// overwritten by a pull already applied to the link.
+func repoProjectUpdates(link *ProjectRepository, info RepoInfo) map[string]interface{} {
+	updates := make(map[string]interface{})
+	if link.OverwriteDescription && info.Description != "" {
+		updates["description"] = info.Description
+	}
+	if link.OverwriteTechnologies {
+		if technologies := repoTechnologies(info); technologies != "" {
+			updates["technologies"] = technologies
+		}
+	}
+	if link.OverwriteLink {
+		if link.Homepage != "" {
+			updates["link"] = link.Homepage
+		} else if _, err := parseHTTPURL(link.URL); err == nil {
+			updates["link"] = link.URL
+		}
+	}
+	return updates
+}
+
+// repoTechnologies lists a repository's languages followed by its topics,
+// without repeats, in the comma-separated form projects use.
+func repoTechnologies(info RepoInfo) string {
+	seen := make(map[string]bool)
+	var technologies []string
+	for _, technology := range append(append([]string{}, info.Languages...), info.Topics...) {
+		key := strings.ToLower(technology)
+		if technology == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		technologies = append(technologies, technology)
+	}
+	return strings.Join(technologies, ", ")
+}
+
+// StartRepositorySync starts the background job that pulls linked
+// repositories not synced within the sync interval.
+func StartRepositorySync() {
+	go func() {
+		for {
+			syncStaleRepositories()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func syncStaleRepositories() {
+	var links []ProjectRepository
+	if result := DB.Where("synced_at IS NULL OR synced_at < ?", time.Now().Add(-repoSyncInterval)).Find(&links); result.Error != nil {
+		log.Printf("Failed to load repositories to sync: %v", result.Error)
+		return
+	}
+	for i := range links {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := syncRepository(ctx, &links[i]); err != nil {
+			log.Printf("Failed to sync repository %s/%s: %v", links[i].Owner, links[i].Name, err)
+		}
+		cancel()
+	}
+}
+
+// RepositoryRequest is the body of a request linking a project to a
+// repository. The Overwrite fields choose which of the project's fields are
+// replaced by the repository's on every sync.
+type RepositoryRequest struct {
+	URL                   string `json:"url"`
+	Provider              string `json:"provider"` // Needed for self-hosted instances
+	OverwriteDescription  bool   `json:"overwrite_description"`
+	OverwriteTechnologies bool   `json:"overwrite_technologies"` // With the languages and topics
+	OverwriteLink         bool   `json:"overwrite_link"`         // With the homepage, or else the repository
+}
+
+// GetProjectRepository handles getting the repository a project of the
+// authenticated user is linked to.
+func GetProjectRepository(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	project, status, message := findOwnedProject(r, userID)
+	if status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	var link ProjectRepository
+	if result := DB.Where("project_id = ?", project.ID).First(&link); result.Error != nil {
+		http.Error(w, "Project is not linked to a repository", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(link)
+}
+
+// LinkProjectRepository handles linking a project of the authenticated user
+// to a repository, or changing the link, and pulls the repository straight
+// away. A failed pull is reported in the link's SyncError and retried later.
+func LinkProjectRepository(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	project, status, message := findOwnedProject(r, userID)
+	if status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	var req RepositoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	provider, ref, err := parseRepositoryURL(req.URL, strings.ToLower(strings.TrimSpace(req.Provider)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var link ProjectRepository
+	DB.Where("project_id = ?", project.ID).First(&link)
+	if link.Provider != provider || link.BaseURL != ref.BaseURL || link.Owner != ref.Owner || link.Name != ref.Name {
+		// A different repository; nothing pulled from the old one applies
+		link = ProjectRepository{Model: link.Model, ProjectID: project.ID}
+	}
+	link.Provider = provider
+	link.BaseURL = ref.BaseURL
+	link.Owner = ref.Owner
+	link.Name = ref.Name
+	if link.URL == "" {
+		link.URL = strings.TrimSpace(req.URL)
+	}
+	link.OverwriteDescription = req.OverwriteDescription
+	link.OverwriteTechnologies = req.OverwriteTechnologies
+	link.OverwriteLink = req.OverwriteLink
+	if result := DB.Save(&link); result.Error != nil {
+		http.Error(w, "Failed to link repository", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	syncRepository(ctx, &link)
+
+	json.NewEncoder(w).Encode(link)
+}
+
+// SyncProjectRepository handles pulling a project's repository now rather
+// than waiting for the periodic sync.
+func SyncProjectRepository(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	project, status, message := findOwnedProject(r, userID)
+	if status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	var link ProjectRepository
+	if result := DB.Where("project_id = ?", project.ID).First(&link); result.Error != nil {
+		http.Error(w, "Project is not linked to a repository", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	if err := syncRepository(ctx, &link); err != nil {
+		http.Error(w, "Failed to sync repository: "+link.SyncError, http.StatusBadGateway)
+		return
+	}
+
+	json.NewEncoder(w).Encode(link)
+}
+
+// UnlinkProjectRepository handles unlinking a project from its repository.
+// Values already copied onto the project are kept.
+func UnlinkProjectRepository(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	project, status, message := findOwnedProject(r, userID)
+	if status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	if result := DB.Unscoped().Where("project_id = ?", project.ID).Delete(&ProjectRepository{}); result.Error != nil {
+		http.Error(w, "Failed to unlink repository", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRepositoryURL(t *testing.T) {
+	tests := []struct {
+		url, provider string
+		wantProvider  string
+		want          RepoRef
+		wantErr       bool
+	}{
+		{url: "https://github.com/jane/site", wantProvider: "github", want: RepoRef{Owner: "jane", Name: "site"}},
+		{url: "https://www.github.com/jane/site.git/tree/main", wantProvider: "github", want: RepoRef{Owner: "jane", Name: "site"}},
+		{url: "https://gitlab.com/group/sub/site/-/tree/main", wantProvider: "gitlab", want: RepoRef{Owner: "group/sub", Name: "site"}},
+		{url: "https://gitlab.example.org/jane/site", provider: "gitlab", wantProvider: "gitlab", want: RepoRef{BaseURL: "https://gitlab.example.org", Owner: "jane", Name: "site"}},
+		{url: "http://codeberg.org/jane/site", wantProvider: "gitea", want: RepoRef{BaseURL: "https://codeberg.org", Owner: "jane", Name: "site"}},
+		{url: "https://git.example.org:3000/jane/site", provider: "gitea", wantProvider: "gitea", want: RepoRef{BaseURL: "https://git.example.org:3000", Owner: "jane", Name: "site"}},
+		{url: "https://git.example.org/jane/site", wantErr: true},                   // Self-hosted needs the provider chosen
+		{url: "http://git.example.org/jane/site", provider: "gitea", wantErr: true}, // and https
+		{url: "http://gitlab.example.org/jane/site", provider: "gitlab", wantErr: true},
+		{url: "https://jane@git.example.org/jane/site", provider: "gitea", wantErr: true},
+		{url: "https://github.com/jane", wantErr: true},
+		{url: "https://gitlab.com/site", wantErr: true},
+		{url: "https://github.com/jane/site", provider: "svn", wantErr: true},
+	}
+	for _, tt := range tests {
+		provider, ref, err := parseRepositoryURL(tt.url, tt.provider)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseRepositoryURL(%q, %q) = %s %+v, want an error", tt.url, tt.provider, provider, ref)
+			}
+			continue
+		}
+		if err != nil || provider != tt.wantProvider || ref != tt.want {
+			t.Errorf("parseRepositoryURL(%q, %q) = %s %+v, %v, want %s %+v", tt.url, tt.provider, provider, ref, err, tt.wantProvider, tt.want)
+		}
+	}
+}
+
+// serveRepoAPI starts a server answering the given paths, with their query,
+// and 404 for anything else.
+func serveRepoAPI(t *testing.T, responses map[string]string) *httptest.Server {
+	t.Helper()
+	allowPrivateAddresses = true
+	t.Cleanup(func() { allowPrivateAddresses = false })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func checkRepoInfo(t *testing.T, got RepoInfo, want RepoInfo) {
+	t.Helper()
+	if (got.LastCommitAt == nil) != (want.LastCommitAt == nil) || got.LastCommitAt != nil && !got.LastCommitAt.Equal(*want.LastCommitAt) {
+		t.Errorf("LastCommitAt = %v, want %v", got.LastCommitAt, want.LastCommitAt)
+	}
+	got.LastCommitAt, want.LastCommitAt = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Fetch() = %+v, want %+v", got, want)
+	}
+}
+
+var testCommitDate = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestGitHubFetch(t *testing.T) {
+	server := serveRepoAPI(t, map[string]string{
+		"/repos/jane/site":                             `{"html_url":"https://github.com/jane/site","homepage":"https://jane.dev","description":"My site","topics":["web"],"stargazers_count":7,"default_branch":"main"}`,
+		"/repos/jane/site/languages":                   `{"CSS":200,"Go":1000}`,
+		"/repos/jane/site/commits?per_page=1&sha=main": `[{"commit":{"committer":{"date":"2024-05-01T12:00:00Z"}}}]`,
+		"/repos/jane/site/readme":                      "# Site",
+	})
+	p := githubProvider{API: repoAPI{BaseURL: server.URL, Client: configuredRepoClient}}
+
+	info, err := p.Fetch(context.Background(), RepoRef{Owner: "jane", Name: "site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRepoInfo(t, info, RepoInfo{
+		URL: "https://github.com/jane/site", Homepage: "https://jane.dev", Description: "My site",
+		Topics: []string{"web"}, Languages: []string{"Go", "CSS"}, Stars: 7, LastCommitAt: &testCommitDate, Readme: "# Site",
+	})
+
+	if _, err := p.Fetch(context.Background(), RepoRef{Owner: "jane", Name: "missing"}); !errors.Is(err, errRepositoryNotFound) {
+		t.Errorf("Fetch() of a missing repository = %v, want errRepositoryNotFound", err)
+	}
+}
+
+func TestGitLabFetch(t *testing.T) {
+	server := serveRepoAPI(t, map[string]string{
+		"/api/v4/projects/group%2Fsub%2Fsite":                                             `{"web_url":"https://gitlab.example.org/group/sub/site","description":"My site","topics":["web"],"star_count":3,"default_branch":"main"}`,
+		"/api/v4/projects/group%2Fsub%2Fsite/languages":                                   `{"Go":80.5,"Shell":19.5}`,
+		"/api/v4/projects/group%2Fsub%2Fsite/repository/commits?per_page=1&ref_name=main": `[{"committed_date":"2024-05-01T12:00:00Z"}]`,
+		"/api/v4/projects/group%2Fsub%2Fsite/repository/files/README/raw?ref=main":        "Site",
+	})
+
+	// A self-hosted instance is called at the repository's host
+	info, err := gitlabProvider{}.Fetch(context.Background(), RepoRef{BaseURL: server.URL, Owner: "group/sub", Name: "site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRepoInfo(t, info, RepoInfo{
+		URL: "https://gitlab.example.org/group/sub/site", Description: "My site",
+		Topics: []string{"web"}, Languages: []string{"Go", "Shell"}, Stars: 3, LastCommitAt: &testCommitDate, Readme: "Site",
+	})
+}
+
+func TestGiteaFetch(t *testing.T) {
+	server := serveRepoAPI(t, map[string]string{
+		"/api/v1/repos/jane/site":                                     `{"html_url":"https://codeberg.org/jane/site","website":"https://jane.dev","description":"My site","stars_count":2,"default_branch":"main"}`,
+		"/api/v1/repos/jane/site/topics":                              `{"topics":["web"]}`,
+		"/api/v1/repos/jane/site/languages":                           `{"Go":10}`,
+		"/api/v1/repos/jane/site/commits?limit=1&stat=false&sha=main": `[{"commit":{"committer":{"date":"2024-05-01T12:00:00Z"}}}]`,
+		"/api/v1/repos/jane/site/raw/README.md?ref=main":              "# Site",
+	})
+
+	info, err := giteaProvider{}.Fetch(context.Background(), RepoRef{BaseURL: server.URL, Owner: "jane", Name: "site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRepoInfo(t, info, RepoInfo{
+		URL: "https://codeberg.org/jane/site", Homepage: "https://jane.dev", Description: "My site",
+		Topics: []string{"web"}, Languages: []string{"Go"}, Stars: 2, LastCommitAt: &testCommitDate, Readme: "# Site",
+	})
+}
+
+func TestRepoFetchHidesUpstreamDetails(t *testing.T) {
+	server := serveRepoAPI(t, nil)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal details", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	_, err := giteaProvider{}.Fetch(context.Background(), RepoRef{BaseURL: broken.URL, Owner: "jane", Name: "site"})
+	if !errors.Is(err, errRepositoryUnavailable) {
+		t.Errorf("Fetch() from a failing host = %v, want errRepositoryUnavailable", err)
+	}
+	for _, detail := range []string{broken.URL, "500", "internal details"} {
+		if err != nil && strings.Contains(err.Error(), detail) {
+			t.Errorf("error %q reveals %q", err, detail)
+		}
+	}
+
+	// Self-hosted instances may not be on private addresses
+	allowPrivateAddresses = false
+	if _, err := (gitlabProvider{}).Fetch(context.Background(), RepoRef{BaseURL: server.URL, Owner: "jane", Name: "site"}); !errors.Is(err, errRepositoryUnavailable) {
+		t.Errorf("Fetch() from a loopback instance = %v, want errRepositoryUnavailable", err)
+	}
+}
+
+func TestRepoInfoKeepsWebLinksOnly(t *testing.T) {
+	tests := []struct {
+		url, homepage     string
+		wantURL, wantLink string
+	}{
+		{"https://git.example.org/jane/site", "https://jane.dev", "https://git.example.org/jane/site", "https://jane.dev"},
+		{"https://git.example.org/jane/site", "javascript:alert(1)", "https://git.example.org/jane/site", "https://git.example.org/jane/site"},
+		{"javascript:alert(1)", "data:text/html,hi", "https://git.example.org/old/site", "https://git.example.org/old/site"},
+		{"", "", "https://git.example.org/old/site", "https://git.example.org/old/site"},
+	}
+	for _, tt := range tests {
+		link := ProjectRepository{URL: "https://git.example.org/old/site", OverwriteLink: true}
+		info := RepoInfo{URL: tt.url, Homepage: tt.homepage}
+		applyRepoInfo(&link, info)
+		updates := repoProjectUpdates(&link, info)
+		if link.URL != tt.wantURL || updates["link"] != tt.wantLink {
+			t.Errorf("pull of %q, %q set URL %q and link %v, want %q and %q", tt.url, tt.homepage, link.URL, updates["link"], tt.wantURL, tt.wantLink)
+		}
+		if link.Homepage != "" && link.Homepage != tt.homepage {
+			t.Errorf("pull of homepage %q kept %q", tt.homepage, link.Homepage)
+		}
+	}
+
+	// A link that was never a web URL isn't copied onto the project
+	link := ProjectRepository{URL: "javascript:alert(1)", OverwriteLink: true}
+	applyRepoInfo(&link, RepoInfo{})
+	if updates := repoProjectUpdates(&link, RepoInfo{}); updates["link"] != nil {
+		t.Errorf("link overwritten with %v", updates["link"])
+	}
+}
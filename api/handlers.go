@@ -2,14 +2,17 @@ package main
 
 import (
 	"encoding/json"
-	"net/http"
-	"strconv"
 	"io"
+	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
 // getUserIDFromContext retrieves the UserID from the request context.
@@ -45,9 +48,11 @@ func RegisterUser(w http.ResponseWriter, r *http.Request) {
 	// Create an empty portfolio for the new user
 	portfolio := Portfolio{
 		UserID:      user.ID,
+		IsDefault:   true,
 		Title:       user.Username + "'s Portfolio",
 		Description: "A place to showcase my work.",
 	}
+	portfolio.Slug, _ = uniqueSlug(DB, slugKindPortfolio, user.ID, portfolio.Title, 0)
 	if result := DB.Create(&portfolio); result.Error != nil {
 		// Log the error but don't fail registration, as portfolio can be created later
 		// In a real app, you might want to handle this more robustly (e.g., retry, queue)
@@ -97,27 +102,29 @@ func LoginUser(w http.ResponseWriter, r *http.Request) {
 
 type PublicPortfolio struct {
 	Portfolio
-	User PublicUser `json:"user"`
+	User     PublicUser      `json:"user"`
 	Projects []PublicProject `json:"projects"`
 }
 
 type PublicProject struct {
 	Project
-	LikesCount int64 `json:"likes_count"`
-	LikedByUser bool `json:"liked_by_user"`
+	LikesCount  int64 `json:"likes_count"`
+	LikedByUser bool  `json:"liked_by_user"`
 }
 
 type PublicUser struct {
-	Username string `json:"username"`
-	Bio string `json:"bio"`
-	SocialMediaLinks string `json:"social_media_links"`
-	ProfilePictureURL string `json:"profile_picture_url"`
+	Username          string       `json:"username"`
+	Bio               string       `json:"bio"`
+	SocialLinks       []SocialLink `json:"social_links"`
+	ProfilePictureURL string       `json:"profile_picture_url"`
+	FollowersCount    int64        `json:"followers_count"`
+	FollowingCount    int64        `json:"following_count"`
+	FollowedByUser    bool         `json:"followed_by_user"` // Whether the authenticated user follows them
 }
 
 func GetPortfolio(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	username := vars["username"]
-	currentUserID, _ := getUserIDFromContext(r)
 
 	var user User
 	if result := DB.Where("username = ?", username).First(&user); result.Error != nil {
@@ -126,16 +133,56 @@ func GetPortfolio(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var portfolio Portfolio
-	if result := DB.Preload("Projects.Likes").Preload("Achievements").Where("user_id = ?", user.ID).First(&portfolio); result.Error != nil {
+	if result := DB.Where("user_id = ?", user.ID).Order("is_default desc, id asc").First(&portfolio); result.Error != nil {
 		http.Error(w, "Portfolio not found", http.StatusNotFound)
 		return
 	}
 
+	writePublicPortfolio(w, r, user, portfolio)
+}
+
+// writePublicPortfolio writes a portfolio together with its owner's profile
+// and the projects and achievements it shows. Portfolios the caller may not
+// see are reported as missing, or as needing a password.
+func writePublicPortfolio(w http.ResponseWriter, r *http.Request, user User, portfolio Portfolio) {
+	currentUserID, _ := getUserIDFromContext(r)
+
+	if status := checkPortfolioAccess(r, portfolio); status != 0 {
+		writePortfolioAccessError(w, status)
+		return
+	}
+	setPortfolioVisibilityHeaders(w, portfolio)
+
+	projects, achievements, err := portfolioContents(portfolio)
+	if err != nil {
+		http.Error(w, "Failed to retrieve portfolio", http.StatusInternalServerError)
+		return
+	}
+	socialLinks, err := userSocialLinks(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve portfolio", http.StatusInternalServerError)
+		return
+	}
+	if portfolio.HideBrokenLinks {
+		projects, socialLinks, err = hideBrokenLinks(projects, socialLinks)
+		if err != nil {
+			http.Error(w, "Failed to retrieve portfolio", http.StatusInternalServerError)
+			return
+		}
+	}
+	portfolio.Projects = projects
+	portfolio.Achievements = achievements
+	portfolio.Experiences, portfolio.Educations, portfolio.Skills, err = portfolioBackground(portfolio)
+	if err != nil {
+		http.Error(w, "Failed to retrieve portfolio", http.StatusInternalServerError)
+		return
+	}
+
 	publicProjects := make([]PublicProject, len(portfolio.Projects))
 	for i, p := range portfolio.Projects {
 		publicProjects[i] = PublicProject{
-			Project: p,
-			LikesCount: int64(len(p.Likes)),
+			Project:     p,
+			LikesCount:  int64(len(p.Likes)),
 			LikedByUser: false, // Default to false
 		}
 		for _, like := range p.Likes {
@@ -146,13 +193,26 @@ func GetPortfolio(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	followers, following, err := followCounts(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve portfolio", http.StatusInternalServerError)
+		return
+	}
+
+	recordView(r, PageView{OwnerID: user.ID, PortfolioID: &portfolio.ID})
+
+	w.Header().Set("Link", feedLinks(r, user.Username))
+
 	publicPortfolio := PublicPortfolio{
 		Portfolio: portfolio,
 		User: PublicUser{
-			Username: user.Username,
-			Bio: user.Bio,
-			SocialMediaLinks: user.SocialMediaLinks,
+			Username:          user.Username,
+			Bio:               user.Bio,
+			SocialLinks:       socialLinks,
 			ProfilePictureURL: user.ProfilePictureURL,
+			FollowersCount:    followers,
+			FollowingCount:    following,
+			FollowedByUser:    currentUserID != 0 && isFollowing(currentUserID, user.ID),
 		},
 		Projects: publicProjects,
 	}
@@ -160,7 +220,7 @@ func GetPortfolio(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(publicPortfolio)
 }
 
-// UpdatePortfolio handles updating the authenticated user's portfolio.
+// UpdatePortfolio handles updating the authenticated user's main portfolio.
 func UpdatePortfolio(w http.ResponseWriter, r *http.Request) {
 	userID, err := getUserIDFromContext(r)
 	if err != nil {
@@ -175,8 +235,8 @@ func UpdatePortfolio(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var portfolio Portfolio
-	if result := DB.Where("user_id = ?", userID).First(&portfolio); result.Error != nil {
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
 		http.Error(w, "Portfolio not found", http.StatusNotFound)
 		return
 	}
@@ -186,7 +246,11 @@ func UpdatePortfolio(w http.ResponseWriter, r *http.Request) {
 	portfolio.Description = updatedPortfolio.Description
 	portfolio.AboutMe = updatedPortfolio.AboutMe
 	portfolio.ContactInfo = updatedPortfolio.ContactInfo
-	portfolio.Layout = updatedPortfolio.Layout
+	portfolio.HideBrokenLinks = updatedPortfolio.HideBrokenLinks
+	if err := applyTheme(&portfolio, updatedPortfolio.Layout, updatedPortfolio.ThemeSettings); err != nil {
+		writeSaveError(w, err, "Failed to update portfolio")
+		return
+	}
 
 	if result := DB.Save(&portfolio); result.Error != nil {
 		http.Error(w, "Failed to update portfolio", http.StatusInternalServerError)
@@ -204,8 +268,8 @@ func CreateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var portfolio Portfolio
-	if result := DB.Where("user_id = ?", userID).First(&portfolio); result.Error != nil {
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
 		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
 		return
 	}
@@ -218,8 +282,27 @@ func CreateProject(w http.ResponseWriter, r *http.Request) {
 	}
 
 	project.PortfolioID = portfolio.ID
-	if result := DB.Create(&project); result.Error != nil {
-		http.Error(w, "Failed to create project", http.StatusInternalServerError)
+	// The image becomes the cover of the project's gallery once it exists
+	imageURL := project.ImageURL
+	project.ImageURL = ""
+	err = saveWithSlug(&project.Slug, func() error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			slug, err := chooseSlug(tx, slugKindProject, portfolio.ID, 0, "", project.Slug, project.Title)
+			if err != nil {
+				return err
+			}
+			project.Slug = slug
+			if project.Position, err = nextPosition(tx, &Project{}, portfolio.ID); err != nil {
+				return err
+			}
+			if err := tx.Create(&project).Error; err != nil {
+				return err
+			}
+			return setProjectImage(tx, &project, imageURL)
+		})
+	})
+	if err != nil {
+		writeSaveError(w, err, "Failed to create project")
 		return
 	}
 
@@ -227,7 +310,7 @@ func CreateProject(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(project)
 }
 
-// GetProjects handles getting all projects for the authenticated user's portfolio.
+// GetProjects handles listing the projects of the authenticated user's portfolio a page at a time.
 func GetProjects(w http.ResponseWriter, r *http.Request) {
 	userID, err := getUserIDFromContext(r)
 	if err != nil {
@@ -235,17 +318,35 @@ func GetProjects(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var portfolio Portfolio
-	if result := DB.Where("user_id = ?", userID).First(&portfolio); result.Error != nil {
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
 		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
 		return
 	}
 
+	opts, err := ParseListOptions(r, projectListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, err := projectListSpec.Apply(DB.Preload("Media", orderedMedia).Where("portfolio_id = ?", portfolio.ID), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var projects []Project
-	if result := DB.Where("portfolio_id = ?", portfolio.ID).Find(&projects); result.Error != nil {
+	if result := query.Find(&projects); result.Error != nil {
 		http.Error(w, "Failed to retrieve projects", http.StatusInternalServerError)
 		return
 	}
+	if len(projects) > opts.Limit {
+		projects = projects[:opts.Limit]
+		if err := projectListSpec.SetNextPage(w, r, opts, projects[len(projects)-1].ID); err != nil {
+			http.Error(w, "Failed to retrieve projects", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	json.NewEncoder(w).Encode(projects)
 }
@@ -263,10 +364,11 @@ func UpdateProject(w http.ResponseWriter, r *http.Request) {
 	projectID, err := strconv.ParseUint(projectIDStr, 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid project ID", http.StatusBadRequest)
-		return	}
+		return
+	}
 
-	var portfolio Portfolio
-	if result := DB.Where("user_id = ?", userID).First(&portfolio); result.Error != nil {
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
 		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
 		return
 	}
@@ -275,7 +377,7 @@ func UpdateProject(w http.ResponseWriter, r *http.Request) {
 	if result := DB.Where("id = ? AND portfolio_id = ?", projectID, portfolio.ID).First(&project); result.Error != nil {
 		http.Error(w, "Project not found or not authorized", http.StatusNotFound)
 		return
-		}
+	}
 
 	var updatedProject Project
 	err = json.NewDecoder(r.Body).Decode(&updatedProject)
@@ -288,11 +390,27 @@ func UpdateProject(w http.ResponseWriter, r *http.Request) {
 	project.Description = updatedProject.Description
 	project.Technologies = updatedProject.Technologies
 	project.Link = updatedProject.Link
-	project.ImageURL = updatedProject.ImageURL
 	project.Featured = updatedProject.Featured
 
-	if result := DB.Save(&project); result.Error != nil {
-		http.Error(w, "Failed to update project", http.StatusInternalServerError)
+	err = saveWithSlug(&project.Slug, func() error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			slug, err := chooseSlug(tx, slugKindProject, portfolio.ID, project.ID, project.Slug, updatedProject.Slug, project.Title)
+			if err != nil {
+				return err
+			}
+			if err := recordSlugChange(tx, slugKindProject, portfolio.ID, project.ID, project.Slug, slug); err != nil {
+				return err
+			}
+			project.Slug = slug
+			if err := tx.Save(&project).Error; err != nil {
+				return err
+			}
+			// The image is the cover's thumbnail, so a new one goes through the gallery
+			return setProjectImage(tx, &project, updatedProject.ImageURL)
+		})
+	})
+	if err != nil {
+		writeSaveError(w, err, "Failed to update project")
 		return
 	}
 
@@ -315,13 +433,23 @@ func DeleteProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var portfolio Portfolio
-	if result := DB.Where("user_id = ?", userID).First(&portfolio); result.Error != nil {
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
 		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
 		return
 	}
 
-	if result := DB.Where("id = ? AND portfolio_id = ?", projectID, portfolio.ID).Delete(&Project{}); result.Error != nil {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND portfolio_id = ?", projectID, portfolio.ID).Delete(&Project{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if err := tx.Unscoped().Where("project_id = ?", projectID).Delete(&ProjectRepository{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("project_id = ?", projectID).Delete(&ProjectMedia{}).Error
+	})
+	if err != nil {
 		http.Error(w, "Failed to delete project or not authorized", http.StatusInternalServerError)
 		return
 	}
@@ -337,8 +465,8 @@ func CreateAchievement(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var portfolio Portfolio
-	if result := DB.Where("user_id = ?", userID).First(&portfolio); result.Error != nil {
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
 		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
 		return
 	}
@@ -351,6 +479,10 @@ func CreateAchievement(w http.ResponseWriter, r *http.Request) {
 	}
 
 	achievement.PortfolioID = portfolio.ID
+	if achievement.Position, err = nextPosition(DB, &Achievement{}, portfolio.ID); err != nil {
+		http.Error(w, "Failed to create achievement", http.StatusInternalServerError)
+		return
+	}
 	if result := DB.Create(&achievement); result.Error != nil {
 		http.Error(w, "Failed to create achievement", http.StatusInternalServerError)
 		return
@@ -360,7 +492,7 @@ func CreateAchievement(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(achievement)
 }
 
-// GetAchievements handles getting all achievements for the authenticated user's portfolio.
+// GetAchievements handles listing the achievements of the authenticated user's portfolio a page at a time.
 func GetAchievements(w http.ResponseWriter, r *http.Request) {
 	userID, err := getUserIDFromContext(r)
 	if err != nil {
@@ -368,17 +500,35 @@ func GetAchievements(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var portfolio Portfolio
-	if result := DB.Where("user_id = ?", userID).First(&portfolio); result.Error != nil {
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
 		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
 		return
 	}
 
+	opts, err := ParseListOptions(r, achievementListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, err := achievementListSpec.Apply(DB.Where("portfolio_id = ?", portfolio.ID), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var achievements []Achievement
-	if result := DB.Where("portfolio_id = ?", portfolio.ID).Find(&achievements); result.Error != nil {
+	if result := query.Find(&achievements); result.Error != nil {
 		http.Error(w, "Failed to retrieve achievements", http.StatusInternalServerError)
 		return
 	}
+	if len(achievements) > opts.Limit {
+		achievements = achievements[:opts.Limit]
+		if err := achievementListSpec.SetNextPage(w, r, opts, achievements[len(achievements)-1].ID); err != nil {
+			http.Error(w, "Failed to retrieve achievements", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	json.NewEncoder(w).Encode(achievements)
 }
@@ -399,8 +549,8 @@ func UpdateAchievement(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var portfolio Portfolio
-	if result := DB.Where("user_id = ?", userID).First(&portfolio); result.Error != nil {
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
 		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
 		return
 	}
@@ -446,10 +596,11 @@ func DeleteAchievement(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var portfolio Portfolio
-	if result := DB.Where("user_id = ?", userID).First(&portfolio); result.Error != nil {
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
 		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
-		return	}
+		return
+	}
 
 	if result := DB.Where("id = ? AND portfolio_id = ?", achievementID, portfolio.ID).Delete(&Achievement{}); result.Error != nil {
 		http.Error(w, "Failed to delete achievement or not authorized", http.StatusInternalServerError)
@@ -484,7 +635,6 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	user.Username = updatedUser.Username
 	user.Email = updatedUser.Email
 	user.Bio = updatedUser.Bio
-	user.SocialMediaLinks = updatedUser.SocialMediaLinks
 	user.ProfilePictureURL = updatedUser.ProfilePictureURL
 
 	if result := DB.Save(&user); result.Error != nil {
@@ -671,25 +821,78 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 
 	post.UserID = userID
 	post.PublishedAt = time.Now()
+	// Series membership is managed through the series endpoints
+	post.SeriesID = nil
+	post.SeriesPosition = 0
+
+	err = saveWithSlug(&post.Slug, func() error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			slug, err := chooseSlug(tx, slugKindPost, userID, 0, "", post.Slug, post.Title)
+			if err != nil {
+				return err
+			}
+			post.Slug = slug
+
+			if post.CategoryID, err = resolveCategory(tx, userID, post.CategoryID); err != nil {
+				return err
+			}
+			if post.Tags, err = resolveTags(tx, post.Tags); err != nil {
+				return err
+			}
 
-	if result := DB.Create(&post); result.Error != nil {
-		http.Error(w, "Failed to create post", http.StatusInternalServerError)
+			if result := tx.Create(&post); result.Error != nil {
+				return result.Error
+			}
+			_, err = recordPostRevision(tx, &post, userID, "")
+			return err
+		})
+	})
+	if err != nil {
+		writeSaveError(w, err, "Failed to create post")
 		return
 	}
+	federatePost(r, "Create", post)
+	sendPostWebmentions(r, post)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(post)
 }
 
-// GetPosts handles getting all blog posts.
+// GetPosts handles listing blog posts a page at a time. Posts are returned as
+// summaries without their content.
 func GetPosts(w http.ResponseWriter, r *http.Request) {
+	opts, err := ParseListOptions(r, postListSpec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, err := postListSpec.Apply(DB.Model(&Post{}), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var posts []Post
-	if result := DB.Find(&posts); result.Error != nil {
+	if result := query.Omit("Content").Preload("Tags").Find(&posts); result.Error != nil {
+		http.Error(w, "Failed to retrieve posts", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Link", feedLinks(r, opts.Author))
+	if len(posts) > opts.Limit {
+		posts = posts[:opts.Limit]
+		if err := postListSpec.SetNextPage(w, r, opts, posts[len(posts)-1].ID); err != nil {
+			http.Error(w, "Failed to retrieve posts", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	summaries, err := toPostSummaries(posts)
+	if err != nil {
 		http.Error(w, "Failed to retrieve posts", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(posts)
+	json.NewEncoder(w).Encode(summaries)
 }
 
 // GetPost handles getting a single blog post by ID.
@@ -703,12 +906,13 @@ func GetPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var post Post
-	if result := DB.First(&post, postID); result.Error != nil {
+	if result := DB.Preload("Tags").First(&post, postID); result.Error != nil {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
 
-	json.NewEncoder(w).Encode(post)
+	recordPostView(r, post)
+	writePublicPost(w, post)
 }
 
 // UpdatePost handles updating a blog post.
@@ -740,13 +944,58 @@ func UpdatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	post.Title = updatedPost.Title
-	post.Content = updatedPost.Content
+	// Every update is stored as a new revision so earlier versions can be restored
+	err = saveWithSlug(&post.Slug, func() error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			if err := ensurePostRevisionBaseline(tx, &post); err != nil {
+				return err
+			}
+
+			post.Title = updatedPost.Title
+			post.Content = updatedPost.Content
+			post.Summary = updatedPost.Summary
+			post.CoverImage = updatedPost.CoverImage
+
+			slug, err := chooseSlug(tx, slugKindPost, userID, post.ID, post.Slug, updatedPost.Slug, post.Title)
+			if err != nil {
+				return err
+			}
+			if err := recordSlugChange(tx, slugKindPost, userID, post.ID, post.Slug, slug); err != nil {
+				return err
+			}
+			post.Slug = slug
+
+			// Category and tags are only changed when they are sent
+			if updatedPost.CategoryID != nil {
+				if post.CategoryID, err = resolveCategory(tx, userID, updatedPost.CategoryID); err != nil {
+					return err
+				}
+			}
+
+			if result := tx.Save(&post); result.Error != nil {
+				return result.Error
+			}
+
+			if updatedPost.Tags != nil {
+				tags, err := resolveTags(tx, updatedPost.Tags)
+				if err != nil {
+					return err
+				}
+				if err := tx.Model(&post).Association("Tags").Replace(tags); err != nil {
+					return err
+				}
+			}
 
-	if result := DB.Save(&post); result.Error != nil {
-		http.Error(w, "Failed to update post", http.StatusInternalServerError)
+			_, err = recordPostRevision(tx, &post, userID, "")
+			return err
+		})
+	})
+	if err != nil {
+		writeSaveError(w, err, "Failed to update post")
 		return
 	}
+	federatePost(r, "Update", post)
+	sendPostWebmentions(r, post)
 
 	json.NewEncoder(w).Encode(post)
 }
@@ -777,6 +1026,7 @@ func DeletePost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to delete post", http.StatusInternalServerError)
 		return
 	}
+	federatePost(r, "Delete", post)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// signatureMaxAge is how far a signed request's Date may be from now, to
+// limit how long a captured request can be replayed.
+const signatureMaxAge = 5 * time.Minute
+
+// requiredSignedHeaders must be covered by the signature of every incoming
+// request, and digest by that of requests with a body, so a signature can't
+// be reused for another method, path, host or time.
+var requiredSignedHeaders = []string{"(request-target)", "host", "date"}
+
+// generateKeyPair creates an RSA key pair encoded as PEM, as used to sign
+// ActivityPub requests.
+func generateKeyPair() (publicPEM, privatePEM string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	publicPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	privatePEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	return publicPEM, privatePEM, nil
+}
+
+func parsePrivateKey(privatePEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		return nil, errors.New("invalid private key")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func parsePublicKey(publicPEM string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicPEM))
+	if block == nil {
+		return nil, errors.New("invalid public key")
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		if rsaKey, ok := key.(*rsa.PublicKey); ok {
+			return rsaKey, nil
+		}
+		return nil, errors.New("public key is not RSA")
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
+
+// bodyDigest returns the value of the Digest header for body.
+func bodyDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signingString builds the string covered by an HTTP signature from the
+// listed headers of a request.
+func signingString(r *http.Request, headers []string) (string, error) {
+	lines := make([]string, len(headers))
+	for i, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines[i] = h + ": " + strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "host":
+			host := r.Host
+			if host == "" {
+				host = r.URL.Host
+			}
+			lines[i] = "host: " + host
+		default:
+			value := r.Header.Get(h)
+			if value == "" {
+				return "", fmt.Errorf("missing signed header %q", h)
+			}
+			lines[i] = h + ": " + value
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// signRequest adds Date, Digest and Signature headers to an outgoing request
+// following the draft-cavage HTTP Signatures scheme used by ActivityPub
+// servers. body must be the request's body, or nil for GET requests.
+func signRequest(r *http.Request, keyID string, key *rsa.PrivateKey, body []byte) error {
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		r.Header.Set("Digest", bodyDigest(body))
+		headers = append(headers, "digest")
+	}
+
+	str, err := signingString(r, headers)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(str))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// httpSignature is a parsed Signature header.
+type httpSignature struct {
+	KeyID     string
+	Algorithm string
+	Headers   []string
+	Signature []byte
+}
+
+func parseSignatureHeader(header string) (httpSignature, error) {
+	var sig httpSignature
+	sig.Headers = []string{"date"}
+
+	for _, part := range strings.Split(header, ",") {
+		eq := strings.IndexByte(part, '=')
+		if eq < 0 {
+			continue
+		}
+		name := strings.TrimSpace(part[:eq])
+		value := strings.Trim(strings.TrimSpace(part[eq+1:]), `"`)
+		switch name {
+		case "keyId":
+			sig.KeyID = value
+		case "algorithm":
+			sig.Algorithm = value
+		case "headers":
+			sig.Headers = strings.Fields(strings.ToLower(value))
+		case "signature":
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return sig, errors.New("invalid signature encoding")
+			}
+			sig.Signature = decoded
+		}
+	}
+
+	if sig.KeyID == "" || sig.Signature == nil {
+		return sig, errors.New("incomplete signature header")
+	}
+	return sig, nil
+}
+
+func signsHeader(sig httpSignature, header string) bool {
+	for _, h := range sig.Headers {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyRequest checks the HTTP signature of an incoming request. It reads
+// and returns the request body, checking it against the Digest header, and
+// returns the id of the key that signed the request. lookupKey resolves a key
+// id to its PEM encoded public key.
+func verifyRequest(r *http.Request, lookupKey func(keyID string) (string, error)) ([]byte, string, error) {
+	header := r.Header.Get("Signature")
+	if header == "" {
+		return nil, "", errors.New("request is not signed")
+	}
+	sig, err := parseSignatureHeader(header)
+	if err != nil {
+		return nil, "", err
+	}
+	if sig.Algorithm != "" && sig.Algorithm != "rsa-sha256" && sig.Algorithm != "hs2019" {
+		return nil, "", fmt.Errorf("unsupported signature algorithm %q", sig.Algorithm)
+	}
+	required := requiredSignedHeaders
+	if r.Method == http.MethodPost {
+		required = append([]string{"digest"}, required...)
+	}
+	for _, h := range required {
+		if !signsHeader(sig, h) {
+			return nil, "", fmt.Errorf("signature does not cover %q", h)
+		}
+	}
+
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return nil, "", errors.New("missing or invalid Date header")
+	}
+	if age := time.Since(date); age > signatureMaxAge || age < -signatureMaxAge {
+		return nil, "", errors.New("signature has expired")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if r.Method == http.MethodPost {
+		if r.Header.Get("Digest") != bodyDigest(body) {
+			return nil, "", errors.New("body digest does not match")
+		}
+	}
+
+	publicPEM, err := lookupKey(sig.KeyID)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := parsePublicKey(publicPEM)
+	if err != nil {
+		return nil, "", err
+	}
+
+	str, err := signingString(r, sig.Headers)
+	if err != nil {
+		return nil, "", err
+	}
+	hash := sha256.Sum256([]byte(str))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig.Signature); err != nil {
+		return nil, "", errors.New("invalid signature")
+	}
+	return body, sig.KeyID, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testActors serves actor documents for signature tests. Each actor is
+// served at /actors/{name} and owns the key {actor}#main-key, unless the
+// document is altered by edit.
+type testActors struct {
+	server  *httptest.Server
+	private map[string]string
+	edit    func(name string, actor map[string]interface{})
+}
+
+func newTestActors(t *testing.T, names ...string) *testActors {
+	t.Helper()
+	allowPrivateAddresses = true
+	t.Cleanup(func() { allowPrivateAddresses = false })
+
+	actors := &testActors{private: make(map[string]string)}
+	public := make(map[string]string)
+	for _, name := range names {
+		pub, priv, err := generateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		public[name], actors.private[name] = pub, priv
+	}
+	actors.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/actors/")
+		if public[name] == "" {
+			http.NotFound(w, r)
+			return
+		}
+		id := actors.iri(name)
+		actor := map[string]interface{}{
+			"id":    id,
+			"inbox": id + "/inbox",
+			"publicKey": map[string]interface{}{
+				"id":           id + "#main-key",
+				"owner":        id,
+				"publicKeyPem": public[name],
+			},
+		}
+		if actors.edit != nil {
+			actors.edit(name, actor)
+		}
+		json.NewEncoder(w).Encode(actor)
+	}))
+	t.Cleanup(actors.server.Close)
+	return actors
+}
+
+func (a *testActors) iri(name string) string {
+	return a.server.URL + "/actors/" + name
+}
+
+// lookup resolves key ids the way PostInbox does.
+func (a *testActors) lookup() func(keyID string) (string, error) {
+	return func(keyID string) (string, error) {
+		iri := strings.SplitN(keyID, "#", 2)[0]
+		actor, err := fetchActor(iri, ActorKey{PrivateKeyPEM: a.private["local"]}, a.iri("local")+"#main-key")
+		if err != nil {
+			return "", err
+		}
+		return actorPublicKey(actor, iri, keyID)
+	}
+}
+
+// inbox starts a server verifying the requests it receives, and returns its
+// URL and a channel receiving each verification's error.
+func (a *testActors) inbox(t *testing.T) (string, chan error) {
+	results := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, err := verifyRequest(r, a.lookup())
+		results <- err
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/inbox", results
+}
+
+// post signs a request from an actor and sends it after tamper has had a
+// chance to alter it, returning the inbox's verdict.
+func (a *testActors) post(t *testing.T, signer, keyID string, tamper func(*http.Request)) error {
+	t.Helper()
+	inbox, results := a.inbox(t)
+	body := []byte(`{"type":"Follow"}`)
+	req, err := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parsePrivateKey(a.private[signer])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signRequest(req, keyID, key, body); err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		tamper(req)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return <-results
+}
+
+func TestSignatureRoundTrip(t *testing.T) {
+	actors := newTestActors(t, "local", "alice")
+	if err := actors.post(t, "alice", actors.iri("alice")+"#main-key", nil); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+}
+
+func TestSignatureTampered(t *testing.T) {
+	actors := newTestActors(t, "local", "alice")
+	keyID := actors.iri("alice") + "#main-key"
+
+	tests := map[string]func(*http.Request){
+		"body": func(r *http.Request) {
+			body := []byte(`{"type":"Undo"}`)
+			r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+		},
+		"digest": func(r *http.Request) {
+			r.Header.Set("Digest", bodyDigest([]byte(`{"type":"Undo"}`)))
+		},
+		"date": func(r *http.Request) {
+			r.Header.Set("Date", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+		},
+		"path": func(r *http.Request) {
+			r.URL.Path = "/other/inbox"
+		},
+		"expired": func(r *http.Request) {
+			r.Header.Set("Date", time.Now().Add(-2*signatureMaxAge).UTC().Format(http.TimeFormat))
+		},
+		"uncovered headers": func(r *http.Request) {
+			r.Header.Set("Signature", strings.Replace(r.Header.Get("Signature"), `headers="(request-target) host date digest"`, `headers="date"`, 1))
+		},
+	}
+	for name, tamper := range tests {
+		if err := actors.post(t, "alice", keyID, tamper); err == nil {
+			t.Errorf("%s: tampered request accepted", name)
+		}
+	}
+}
+
+func TestSignatureWrongOwner(t *testing.T) {
+	actors := newTestActors(t, "local", "alice", "mallory")
+
+	// Mallory signs with their own key but claims it is Alice's
+	if err := actors.post(t, "mallory", actors.iri("alice")+"#main-key", nil); err == nil {
+		t.Error("signature by another actor's key accepted")
+	}
+
+	// Mallory's document claims Alice owns the key
+	actors.edit = func(name string, actor map[string]interface{}) {
+		if name == "mallory" {
+			actor["publicKey"].(map[string]interface{})["owner"] = actors.iri("alice")
+		}
+	}
+	if err := actors.post(t, "mallory", actors.iri("mallory")+"#main-key", nil); err == nil {
+		t.Error("key owned by another actor accepted")
+	}
+
+	// Mallory's document says it is Alice
+	actors.edit = func(name string, actor map[string]interface{}) {
+		if name == "mallory" {
+			actor["id"] = actors.iri("alice")
+			actor["publicKey"].(map[string]interface{})["owner"] = actors.iri("alice")
+		}
+	}
+	if err := actors.post(t, "mallory", actors.iri("mallory")+"#main-key", nil); err == nil {
+		t.Error("actor document for another id accepted")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// requestBaseURL returns the scheme and host the request was made to, which is
+// where the API itself is reachable.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// publicBaseURL returns the absolute URL of the public site, without a
+// trailing slash. It is read from PUBLIC_URL and falls back to the URL the
+// request was made to.
+func publicBaseURL(r *http.Request) string {
+	if base := os.Getenv("PUBLIC_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return requestBaseURL(r)
+}
+
+// apiBaseURL returns the absolute URL the API is publicly reachable at,
+// without a trailing slash. It is read from API_URL and falls back to the URL
+// the request was made to. Federated identifiers are built from it, so it
+// should be set in production.
+func apiBaseURL(r *http.Request) string {
+	if base := os.Getenv("API_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return requestBaseURL(r)
+}
+
+// postURL returns the public URL of a blog post.
+func postURL(base string, post Post) string {
+	return fmt.Sprintf("%s/blog/%d", base, post.ID)
+}
+
+// portfolioURL returns the public URL of a user's portfolio.
+func portfolioURL(base, username string) string {
+	return base + "/users/" + username
+}
+
+// notModified sets the ETag and Last-Modified headers and reports whether the
+// request's conditional headers show the client already has this version, in
+// which case a 304 response has been written.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	etag = `"` + etag + `"`
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := r.Header.Get("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		if err == nil && !lastModified.Truncate(time.Second).After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The link checker periodically probes the links of projects and the social
+// links of users, so owners find out about links that have stopped working.
+// Results are stored per URL, so a URL used in several places is probed once.
+
+// Statuses of a checked link.
+const (
+	LinkUnchecked = ""        // Not probed yet, or not an http(s) URL
+	LinkOK        = "ok"      // Answered successfully, possibly after redirects
+	LinkBlocked   = "blocked" // Refused to answer us, e.g. 403 or 429; likely fine for people
+	LinkFailing   = "failing" // Failed, but not often enough in a row to be broken
+	LinkBroken    = "broken"  // Failed brokenAfterFailures times in a row
+)
+
+const (
+	linkCheckInterval   = 24 * time.Hour  // How often each link is probed
+	linkHostInterval    = 5 * time.Second // Least time between requests to one host
+	brokenAfterFailures = 3               // Failed probes in a row before a link counts as broken
+	maxLinkRedirects    = 10
+	linkCheckWorkers    = 4
+)
+
+// linkClient probes links, connecting only to public addresses. Redirects
+// are followed by probeLink so that they can be counted and rate limited.
+var linkClient = func() *http.Client {
+	client := newPublicClient(15 * time.Second)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return client
+}()
+
+// hostLimiter spaces out requests to the same host.
+type hostLimiter struct {
+	mu       sync.Mutex
+	next     map[string]time.Time
+	interval time.Duration
+}
+
+var linkLimiter = &hostLimiter{next: make(map[string]time.Time), interval: linkHostInterval}
+
+// Wait blocks until a request may be sent to host.
+func (l *hostLimiter) Wait(ctx context.Context, host string) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// linkProbe is the outcome of probing a link.
+type linkProbe struct {
+	StatusCode int
+	FinalURL   string
+	Redirects  int
+	Err        error
+}
+
+// probeLink requests a link, following redirects, and reports where it ended
+// up. HEAD is tried first; servers that don't support it are sent a GET.
+func probeLink(ctx context.Context, link string) linkProbe {
+	current := link
+	for redirects := 0; ; redirects++ {
+		u, err := url.Parse(current)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return linkProbe{FinalURL: current, Redirects: redirects, Err: fmt.Errorf("invalid URL %q", current)}
+		}
+
+		resp, err := requestLink(ctx, http.MethodHead, u)
+		if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented ||
+			resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound) {
+			// Some servers answer HEAD differently from GET
+			resp, err = requestLink(ctx, http.MethodGet, u)
+		}
+		if errors.Is(err, errPrivateAddress) {
+			// Where the link leads inside a network isn't the owner's business
+			return linkProbe{FinalURL: link, Redirects: redirects, Err: errors.New("the link leads to an address that isn't public")}
+		}
+		if err != nil {
+			return linkProbe{FinalURL: current, Redirects: redirects, Err: linkRequestError(err)}
+		}
+
+		location := resp.Header.Get("Location")
+		if resp.StatusCode < 300 || resp.StatusCode >= 400 || location == "" {
+			return linkProbe{StatusCode: resp.StatusCode, FinalURL: current, Redirects: redirects}
+		}
+		if redirects == maxLinkRedirects {
+			return linkProbe{StatusCode: resp.StatusCode, FinalURL: current, Redirects: redirects, Err: fmt.Errorf("more than %d redirects", maxLinkRedirects)}
+		}
+		next, err := u.Parse(location)
+		if err != nil {
+			return linkProbe{StatusCode: resp.StatusCode, FinalURL: current, Redirects: redirects, Err: fmt.Errorf("invalid redirect to %q", location)}
+		}
+		current = next.String()
+	}
+}
+
+// linkRequestError describes why a request for a link failed, without the
+// addresses and other details of the underlying error.
+func linkRequestError(err error) error {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return errors.New("the host was not found")
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errors.New("the request timed out")
+	default:
+		return errors.New("the request failed")
+	}
+}
+
+func requestLink(ctx context.Context, method string, u *url.URL) (*http.Response, error) {
+	if err := linkLimiter.Wait(ctx, strings.ToLower(u.Host)); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; PortfolioLinkChecker/1.0)")
+	req.Header.Set("Accept", "text/html,*/*;q=0.8")
+	resp, err := linkClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp, nil
+}
+
+// linkProbeStatus classifies a probe as a success, a failure or neither.
+func linkProbeStatus(probe linkProbe) string {
+	switch {
+	case probe.Err != nil:
+		return LinkFailing
+	case probe.StatusCode >= 200 && probe.StatusCode < 300:
+		return LinkOK
+	case probe.StatusCode == http.StatusNotFound, probe.StatusCode == http.StatusGone, probe.StatusCode >= 500 && probe.StatusCode < 600:
+		return LinkFailing
+	default:
+		// 401, 403, 429 and the like, and LinkedIn's 999
+		return LinkBlocked
+	}
+}
+
+// checkLink probes a link and records the outcome. The same link may be
+// checked periodically and on request at once, so failures are counted by
+// the database rather than from the copy of the check.
+func checkLink(ctx context.Context, check LinkCheck) {
+	probe := probeLink(ctx, check.URL)
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	status := linkProbeStatus(probe)
+	updates := map[string]interface{}{
+		"checked_at":  now,
+		"status_code": probe.StatusCode,
+		"final_url":   probe.FinalURL,
+		"redirects":   probe.Redirects,
+		"error":       "",
+		"status":      status,
+	}
+	if probe.Err != nil {
+		updates["error"] = probe.Err.Error()
+	}
+	switch status {
+	case LinkOK:
+		updates["failures"] = 0
+		updates["last_success_at"] = now
+	case LinkFailing:
+		updates["failures"] = gorm.Expr("failures + 1")
+		updates["status"] = gorm.Expr("CASE WHEN failures + 1 >= ? THEN ? ELSE ? END", brokenAfterFailures, LinkBroken, LinkFailing)
+	}
+
+	if result := DB.Model(&LinkCheck{}).Where("id = ?", check.ID).UpdateColumns(updates); result.Error != nil {
+		log.Printf("Failed to record check of %s: %v", check.URL, result.Error)
+	}
+}
+
+// checkLinks probes links with a few workers. Links are interleaved by host
+// so that workers aren't all waiting on the same host.
+func checkLinks(ctx context.Context, checks []LinkCheck) {
+	byHost := make(map[string][]LinkCheck)
+	var hosts []string
+	for _, check := range checks {
+		host := check.URL
+		if u, err := url.Parse(check.URL); err == nil {
+			host = strings.ToLower(u.Host)
+		}
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], check)
+	}
+	sort.Strings(hosts)
+
+	queue := make(chan LinkCheck)
+	var wg sync.WaitGroup
+	for i := 0; i < linkCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for check := range queue {
+				checkLink(ctx, check)
+			}
+		}()
+	}
+	for round := 0; len(hosts) > 0; round++ {
+		remaining := hosts[:0]
+		for _, host := range hosts {
+			if round < len(byHost[host]) {
+				queue <- byHost[host][round]
+				if round+1 < len(byHost[host]) {
+					remaining = append(remaining, host)
+				}
+			}
+		}
+		hosts = remaining
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// isCheckableLink reports whether a stored link is one the checker probes.
+func isCheckableLink(link string) bool {
+	u, err := url.Parse(strings.TrimSpace(link))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// trackLinks makes sure every link is known to the checker.
+func trackLinks(links []string) error {
+	var checks []LinkCheck
+	seen := make(map[string]bool)
+	for _, link := range links {
+		link = strings.TrimSpace(link)
+		if !isCheckableLink(link) || seen[link] {
+			continue
+		}
+		seen[link] = true
+		checks = append(checks, LinkCheck{URL: link})
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+	return DB.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "url"}}, DoNothing: true}).Create(&checks).Error
+}
+
+// storedLinks returns the links of all projects and social links.
+func storedLinks() ([]string, error) {
+	var links []string
+	err := DB.Raw(`SELECT TRIM(link) FROM projects WHERE deleted_at IS NULL AND TRIM(link) <> ''
+		UNION SELECT url FROM social_links WHERE deleted_at IS NULL`).Scan(&links).Error
+	return links, err
+}
+
+// StartLinkChecker starts the background job that probes links not checked
+// within the check interval.
+func StartLinkChecker() {
+	go func() {
+		for {
+			checkStaleLinks()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func checkStaleLinks() {
+	links, err := storedLinks()
+	if err != nil {
+		log.Printf("Failed to load links to check: %v", err)
+		return
+	}
+	if err := trackLinks(links); err != nil {
+		log.Printf("Failed to track links: %v", err)
+		return
+	}
+	// Forget links that are no longer used anywhere
+	if result := DB.Unscoped().Where(`NOT EXISTS (SELECT 1 FROM projects WHERE deleted_at IS NULL AND TRIM(link) = link_checks.url)
+		AND NOT EXISTS (SELECT 1 FROM social_links WHERE deleted_at IS NULL AND url = link_checks.url)`).Delete(&LinkCheck{}); result.Error != nil {
+		log.Printf("Failed to remove unused link checks: %v", result.Error)
+	}
+
+	var checks []LinkCheck
+	if result := DB.Where("checked_at IS NULL OR checked_at < ?", time.Now().Add(-linkCheckInterval)).Find(&checks); result.Error != nil {
+		log.Printf("Failed to load links to check: %v", result.Error)
+		return
+	}
+	checkLinks(context.Background(), checks)
+}
+
+// linkChecks returns what is known about links, by URL.
+func linkChecks(links []string) (map[string]LinkCheck, error) {
+	checks := make(map[string]LinkCheck)
+	if len(links) == 0 {
+		return checks, nil
+	}
+	var found []LinkCheck
+	if result := DB.Where("url IN ?", links).Find(&found); result.Error != nil {
+		return nil, result.Error
+	}
+	for _, check := range found {
+		checks[check.URL] = check
+	}
+	return checks, nil
+}
+
+// hideBrokenLinks removes the links of a portfolio's projects and its
+// owner's social links that are known to be broken.
+func hideBrokenLinks(projects []Project, socialLinks []SocialLink) ([]Project, []SocialLink, error) {
+	var links []string
+	for _, project := range projects {
+		links = append(links, strings.TrimSpace(project.Link))
+	}
+	for _, link := range socialLinks {
+		links = append(links, link.URL)
+	}
+	checks, err := linkChecks(links)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range projects {
+		if checks[strings.TrimSpace(projects[i].Link)].Status == LinkBroken {
+			projects[i].Link = ""
+		}
+	}
+	working := make([]SocialLink, 0, len(socialLinks))
+	for _, link := range socialLinks {
+		if checks[link.URL].Status != LinkBroken {
+			working = append(working, link)
+		}
+	}
+	return projects, working, nil
+}
+
+// hideBrokenProjectLinks removes the known-broken links of projects shown
+// outside of a portfolio page, such as on their own page or in the activity
+// feed, whose owner's default portfolio hides them.
+func hideBrokenProjectLinks(projects []Project) ([]Project, error) {
+	if len(projects) == 0 {
+		return projects, nil
+	}
+	portfolioIDs := make([]uint, 0, len(projects))
+	for _, project := range projects {
+		portfolioIDs = append(portfolioIDs, project.PortfolioID)
+	}
+	var hiding []uint
+	owners := DB.Model(&Portfolio{}).Select("user_id").Where("is_default AND hide_broken_links")
+	if result := DB.Model(&Portfolio{}).Where("id IN ? AND user_id IN (?)", portfolioIDs, owners).Pluck("id", &hiding); result.Error != nil {
+		return nil, result.Error
+	}
+	hides := make(map[uint]bool, len(hiding))
+	for _, id := range hiding {
+		hides[id] = true
+	}
+
+	var indexes []int
+	var hidden []Project
+	for i, project := range projects {
+		if hides[project.PortfolioID] {
+			indexes = append(indexes, i)
+			hidden = append(hidden, project)
+		}
+	}
+	if len(hidden) == 0 {
+		return projects, nil
+	}
+	hidden, _, err := hideBrokenLinks(hidden, nil)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range indexes {
+		projects[i] = hidden[j]
+	}
+	return projects, nil
+}
+
+// LinkHealth is the state of one of the authenticated user's links, as shown
+// on their dashboard.
+type LinkHealth struct {
+	Source        string     `json:"source"` // "project" or "social_link"
+	SourceID      uint       `json:"source_id"`
+	Label         string     `json:"label"` // Project title or platform
+	URL           string     `json:"url"`
+	Status        string     `json:"status"`
+	StatusCode    int        `json:"status_code"`
+	FinalURL      string     `json:"final_url"`
+	Redirects     int        `json:"redirects"`
+	Error         string     `json:"error"`
+	CheckedAt     *time.Time `json:"checked_at"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+}
+
+// userLinkHealth returns the state of the links of a user's projects and
+// their social links.
+func userLinkHealth(userID uint) ([]LinkHealth, error) {
+	primary, err := mainPortfolio(DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	var projects []Project
+	if result := DB.Where("portfolio_id = ? AND link <> ''", primary.ID).Order("position asc, id asc").Find(&projects); result.Error != nil {
+		return nil, result.Error
+	}
+	socialLinks, err := userSocialLinks(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var health []LinkHealth
+	for _, project := range projects {
+		health = append(health, LinkHealth{Source: "project", SourceID: project.ID, Label: project.Title, URL: strings.TrimSpace(project.Link)})
+	}
+	for _, link := range socialLinks {
+		health = append(health, LinkHealth{Source: "social_link", SourceID: link.ID, Label: link.Platform, URL: link.URL})
+	}
+
+	links := make([]string, len(health))
+	for i, h := range health {
+		links[i] = h.URL
+	}
+	checks, err := linkChecks(links)
+	if err != nil {
+		return nil, err
+	}
+	for i := range health {
+		check, ok := checks[health[i].URL]
+		if !ok {
+			continue
+		}
+		health[i].Status = check.Status
+		health[i].StatusCode = check.StatusCode
+		health[i].FinalURL = check.FinalURL
+		health[i].Redirects = check.Redirects
+		health[i].Error = check.Error
+		health[i].CheckedAt = check.CheckedAt
+		health[i].LastSuccessAt = check.LastSuccessAt
+	}
+	return health, nil
+}
+
+// GetLinkHealth handles listing the state of the authenticated user's project
+// and social links. With ?status=broken only broken links are listed.
+func GetLinkHealth(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	health, err := userLinkHealth(userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve links", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Links  []LinkHealth `json:"links"`
+		Broken int          `json:"broken"`
+	}{Links: []LinkHealth{}}
+	status := r.URL.Query().Get("status")
+	for _, h := range health {
+		if h.Status == LinkBroken {
+			response.Broken++
+		}
+		if status == "" || h.Status == status {
+			response.Links = append(response.Links, h)
+		}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// linkChecksInFlight holds the users whose links are being checked on
+// request, so each user has at most one such check running.
+var linkChecksInFlight = struct {
+	sync.Mutex
+	users map[uint]bool
+}{users: make(map[uint]bool)}
+
+// CheckLinksNow handles queueing the authenticated user's links to be probed
+// now rather than at their next periodic check.
+func CheckLinksNow(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	health, err := userLinkHealth(userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve links", http.StatusInternalServerError)
+		return
+	}
+	links := make([]string, len(health))
+	for i, h := range health {
+		links[i] = h.URL
+	}
+	if err := trackLinks(links); err != nil {
+		http.Error(w, "Failed to check links", http.StatusInternalServerError)
+		return
+	}
+
+	var checks []LinkCheck
+	if result := DB.Where("url IN ?", append(links, "")).Find(&checks); result.Error != nil {
+		http.Error(w, "Failed to check links", http.StatusInternalServerError)
+		return
+	}
+
+	linkChecksInFlight.Lock()
+	busy := linkChecksInFlight.users[userID]
+	linkChecksInFlight.users[userID] = true
+	linkChecksInFlight.Unlock()
+	if busy {
+		http.Error(w, "Links are already being checked", http.StatusConflict)
+		return
+	}
+	go func() {
+		checkLinks(context.Background(), checks)
+		linkChecksInFlight.Lock()
+		delete(linkChecksInFlight.users, userID)
+		linkChecksInFlight.Unlock()
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveLinks starts a server for probe tests and lifts the per-host rate
+// limit for the rest of the test.
+func serveLinks(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	previous := linkLimiter
+	linkLimiter = &hostLimiter{next: make(map[string]time.Time)}
+	server := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		server.Close()
+		linkLimiter = previous
+		allowPrivateAddresses = false
+	})
+	return server
+}
+
+func TestProbeLink(t *testing.T) {
+	server := serveLinks(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			w.WriteHeader(http.StatusOK)
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	allowPrivateAddresses = true
+
+	tests := []struct {
+		path      string
+		status    string
+		finalURL  string
+		redirects int
+	}{
+		{"/old", LinkOK, "/new", 1},
+		{"/no-head", LinkOK, "/no-head", 0},
+		{"/gone", LinkFailing, "/gone", 0},
+		{"/loop", LinkFailing, "/loop", maxLinkRedirects},
+	}
+	for _, tt := range tests {
+		probe := probeLink(context.Background(), server.URL+tt.path)
+		if got := linkProbeStatus(probe); got != tt.status || probe.FinalURL != server.URL+tt.finalURL || probe.Redirects != tt.redirects {
+			t.Errorf("probeLink(%s) = %s at %s after %d redirects (%v), want %s at %s after %d",
+				tt.path, got, probe.FinalURL, probe.Redirects, probe.Err, tt.status, tt.finalURL, tt.redirects)
+		}
+	}
+}
+
+func TestProbeLinkHidesPrivateAddresses(t *testing.T) {
+	internal := serveLinks(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("admin"))
+	})
+
+	link := strings.Replace(internal.URL, "127.0.0.1", "localhost", 1) + "/admin"
+	probe := probeLink(context.Background(), link)
+	if probe.Err == nil || probe.StatusCode != 0 || probe.FinalURL != link {
+		t.Fatalf("probe of a loopback address got status %d at %s, %v", probe.StatusCode, probe.FinalURL, probe.Err)
+	}
+	for _, detail := range []string{"127.0.0.1", "localhost", "admin"} {
+		if strings.Contains(probe.Err.Error(), detail) {
+			t.Errorf("error %q reveals %q", probe.Err, detail)
+		}
+	}
+
+	// Errors for public hosts don't carry addresses either
+	probe = probeLink(context.Background(), "https://example.invalid.test/profile")
+	if probe.Err == nil || strings.Contains(probe.Err.Error(), "example.invalid.test") {
+		t.Errorf("probe of an unknown host = %v", probe.Err)
+	}
+}
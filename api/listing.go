@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// ListSortKey describes a column or expression a collection can be sorted by.
+type ListSortKey struct {
+	Expr   string // SQL expression evaluated per row
+	IsTime bool   // Whether the expression yields a timestamp rather than a number
+}
+
+// ListSpec describes how a collection endpoint can be paginated, sorted and
+// filtered. Filters left empty are not supported by the endpoint and are
+// rejected when requested.
+type ListSpec struct {
+	Table        string                 // Table name used to qualify the id column
+	SortKeys     map[string]ListSortKey // Keyed by the value of the "sort" parameter
+	DefaultSort  string
+	DefaultOrder string                                 // "asc" or "desc"; empty for "desc"
+	AuthorFilter string                                 // SQL condition taking a username argument
+	TagFilter    func(db *gorm.DB, tag string) *gorm.DB // Restricts the query to items with the given tag
+	// Further filters specific to the collection, keyed by query parameter
+	ExtraFilters map[string]func(db *gorm.DB, value string) *gorm.DB
+}
+
+// ListOptions holds the parsed listing parameters of a request.
+type ListOptions struct {
+	Limit  int
+	Sort   string
+	Order  string // "asc" or "desc"
+	Author string
+	Since  *time.Time
+	Until  *time.Time
+	Tag    string
+	Extra  map[string]string
+	Cursor *listCursor
+}
+
+// listCursor marks the position of the last item of a page. It records the
+// sort order it was issued for so it cannot be replayed against another one.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+	Kind  string `json:"k,omitempty"` // Type of the item in collections mixing types whose IDs can repeat
+}
+
+func encodeListCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(s string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// parseListTime accepts either an RFC 3339 timestamp or a plain date.
+func parseListTime(s string) (*time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", s)
+	}
+	return &t, nil
+}
+
+// ParseListOptions reads the limit, cursor, sort, order, author, since, until
+// and tag query parameters and validates them against spec.
+func ParseListOptions(r *http.Request, spec ListSpec) (ListOptions, error) {
+	q := r.URL.Query()
+	opts := ListOptions{
+		Limit: defaultListLimit,
+		Sort:  spec.DefaultSort,
+		Order: "desc",
+	}
+	if spec.DefaultOrder != "" {
+		opts.Order = spec.DefaultOrder
+	}
+
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			return opts, errors.New("invalid limit")
+		}
+		if limit > maxListLimit {
+			limit = maxListLimit
+		}
+		opts.Limit = limit
+	}
+
+	if s := q.Get("sort"); s != "" {
+		if _, ok := spec.SortKeys[s]; !ok {
+			return opts, fmt.Errorf("unsupported sort %q", s)
+		}
+		opts.Sort = s
+	}
+
+	if s := q.Get("order"); s != "" {
+		if s != "asc" && s != "desc" {
+			return opts, errors.New("order must be asc or desc")
+		}
+		opts.Order = s
+	}
+
+	if s := q.Get("author"); s != "" {
+		if spec.AuthorFilter == "" {
+			return opts, errors.New("filtering by author is not supported here")
+		}
+		opts.Author = s
+	}
+
+	if s := q.Get("tag"); s != "" {
+		if spec.TagFilter == nil {
+			return opts, errors.New("filtering by tag is not supported here")
+		}
+		opts.Tag = s
+	}
+
+	for param := range spec.ExtraFilters {
+		if s := q.Get(param); s != "" {
+			if opts.Extra == nil {
+				opts.Extra = make(map[string]string)
+			}
+			opts.Extra[param] = s
+		}
+	}
+
+	for param, dst := range map[string]**time.Time{"since": &opts.Since, "until": &opts.Until} {
+		if s := q.Get(param); s != "" {
+			if _, ok := spec.SortKeys["date"]; !ok {
+				return opts, errors.New("filtering by date is not supported here")
+			}
+			t, err := parseListTime(s)
+			if err != nil {
+				return opts, err
+			}
+			*dst = t
+		}
+	}
+
+	if s := q.Get("cursor"); s != "" {
+		cursor, err := decodeListCursor(s)
+		if err != nil {
+			return opts, err
+		}
+		if cursor.Sort != opts.Sort || cursor.Order != opts.Order {
+			return opts, errors.New("cursor does not match the requested sort order")
+		}
+		opts.Cursor = cursor
+	}
+
+	return opts, nil
+}
+
+// Apply adds the filters, cursor position, ordering and limit described by
+// opts to db. One extra row beyond the limit is requested so callers can tell
+// whether another page exists.
+func (spec ListSpec) Apply(db *gorm.DB, opts ListOptions) (*gorm.DB, error) {
+	key := spec.SortKeys[opts.Sort]
+	idColumn := spec.Table + ".id"
+
+	if opts.Author != "" {
+		db = db.Where(spec.AuthorFilter, opts.Author)
+	}
+	if opts.Tag != "" {
+		db = spec.TagFilter(db, opts.Tag)
+	}
+	for param, value := range opts.Extra {
+		db = spec.ExtraFilters[param](db, value)
+	}
+	if date, ok := spec.SortKeys["date"]; ok {
+		if opts.Since != nil {
+			db = db.Where(date.Expr+" >= ?", *opts.Since)
+		}
+		if opts.Until != nil {
+			db = db.Where(date.Expr+" <= ?", *opts.Until)
+		}
+	}
+
+	cmp := "<"
+	if opts.Order == "asc" {
+		cmp = ">"
+	}
+
+	if opts.Cursor != nil {
+		var value interface{}
+		if key.IsTime {
+			t, err := time.Parse(time.RFC3339Nano, opts.Cursor.Value)
+			if err != nil {
+				return nil, errors.New("invalid cursor")
+			}
+			value = t
+		} else {
+			n, err := strconv.ParseFloat(opts.Cursor.Value, 64)
+			if err != nil {
+				return nil, errors.New("invalid cursor")
+			}
+			value = n
+		}
+		db = db.Where(
+			fmt.Sprintf("((%s %s ?) OR (%s = ? AND %s %s ?))", key.Expr, cmp, key.Expr, idColumn, cmp),
+			value, value, opts.Cursor.ID,
+		)
+	}
+
+	order := strings.ToUpper(opts.Order)
+	return db.Order(fmt.Sprintf("%s %s, %s %s", key.Expr, order, idColumn, order)).Limit(opts.Limit + 1), nil
+}
+
+// SetNextPage adds the Link and X-Next-Cursor headers pointing at the page
+// that follows the item with the given id.
+func (spec ListSpec) SetNextPage(w http.ResponseWriter, r *http.Request, opts ListOptions, lastID uint) error {
+	key := spec.SortKeys[opts.Sort]
+	row := DB.Table(spec.Table).Select(key.Expr).Where(spec.Table+".id = ?", lastID).Row()
+
+	var value string
+	if key.IsTime {
+		var t time.Time
+		if err := row.Scan(&t); err != nil {
+			return err
+		}
+		value = t.UTC().Format(time.RFC3339Nano)
+	} else {
+		var n float64
+		if err := row.Scan(&n); err != nil {
+			return err
+		}
+		value = strconv.FormatFloat(n, 'f', -1, 64)
+	}
+
+	setNextCursor(w, r, listCursor{Sort: opts.Sort, Order: opts.Order, Value: value, ID: lastID})
+	return nil
+}
+
+// setNextCursor adds the Link and X-Next-Cursor headers pointing at the
+// page that follows the position c.
+func setNextCursor(w http.ResponseWriter, r *http.Request, c listCursor) {
+	cursor := encodeListCursor(c)
+	q := r.URL.Query()
+	q.Set("cursor", cursor)
+	next := *r.URL
+	next.RawQuery = q.Encode()
+
+	w.Header().Set("X-Next-Cursor", cursor)
+	w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
+
+// likesCountExpr counts the likes of the project in the current row.
+const likesCountExpr = "(SELECT COUNT(*) FROM likes WHERE likes.project_id = projects.id AND likes.deleted_at IS NULL)"
+
+// postListSpec describes the public blog post listing.
+var postListSpec = ListSpec{
+	Table: "posts",
+	SortKeys: map[string]ListSortKey{
+		"date":    {Expr: "posts.published_at", IsTime: true},
+		"popular": {Expr: approvedCommentsCountExpr},
+	},
+	DefaultSort:  "date",
+	AuthorFilter: "posts.user_id IN (SELECT id FROM users WHERE username = ? AND deleted_at IS NULL)",
+	TagFilter: func(db *gorm.DB, tag string) *gorm.DB {
+		return db.Where("posts.id IN (SELECT post_tags.post_id FROM post_tags "+
+			"JOIN tags ON tags.id = post_tags.tag_id WHERE tags.slug = ?)", slugify(tag))
+	},
+	ExtraFilters: map[string]func(db *gorm.DB, value string) *gorm.DB{
+		// Category slugs are only unique per author, so they are usually combined with the author filter
+		"category": func(db *gorm.DB, slug string) *gorm.DB {
+			return db.Where("posts.category_id IN (SELECT id FROM categories WHERE slug = ? AND deleted_at IS NULL)", slugify(slug))
+		},
+	},
+}
+
+// projectListSpec describes the listing of a portfolio's projects. Tags match
+// the project's comma-separated technologies.
+var projectListSpec = ListSpec{
+	Table: "projects",
+	SortKeys: map[string]ListSortKey{
+		"date":     {Expr: "projects.created_at", IsTime: true},
+		"popular":  {Expr: likesCountExpr},
+		"position": {Expr: "projects.position"},
+	},
+	DefaultSort: "date",
+	AuthorFilter: "projects.portfolio_id IN (SELECT portfolios.id FROM portfolios " +
+		"JOIN users ON users.id = portfolios.user_id WHERE users.username = ? AND users.deleted_at IS NULL)",
+	TagFilter: func(db *gorm.DB, tag string) *gorm.DB {
+		return db.Where(`? = ANY(regexp_split_to_array(LOWER(projects.technologies), '\s*,\s*'))`, strings.ToLower(strings.TrimSpace(tag)))
+	},
+}
+
+// achievementListSpec describes the listing of a portfolio's achievements.
+var achievementListSpec = ListSpec{
+	Table: "achievements",
+	SortKeys: map[string]ListSortKey{
+		"date":     {Expr: "achievements.date", IsTime: true},
+		"position": {Expr: "achievements.position"},
+	},
+	DefaultSort: "date",
+}
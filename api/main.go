@@ -19,6 +19,27 @@ func main() {
 	// Initialize database
 	ConnectDB()
 
+	// Deliver ActivityPub activities in the background
+	StartFederationWorkers(4)
+
+	// Write counted views in the background
+	StartViewRecorders(2)
+
+	// Verify and send Webmentions in the background
+	StartWebmentionWorkers(2)
+
+	// Verify rel="me" social links in the background
+	StartSocialLinkVerifiers(1)
+
+	// Pull linked git repositories periodically
+	StartRepositorySync()
+
+	// Check project and social links periodically
+	StartLinkChecker()
+
+	// Rank projects across the platform periodically
+	StartProjectRanking()
+
 	// Initialize router
 	r := mux.NewRouter()
 
@@ -29,29 +50,159 @@ func main() {
 	api.HandleFunc("/login", LoginUser).Methods("POST")
 	api.Handle("/portfolio/{username}", OptionalAuthMiddleware(http.HandlerFunc(GetPortfolio))).Methods("GET") // Public portfolio view
 	api.HandleFunc("/contact", ContactForm).Methods("POST")
+	api.HandleFunc("/themes", GetThemes).Methods("GET")
+	api.HandleFunc("/social-platforms", GetSocialPlatforms).Methods("GET")
 
 	// Blog post public routes
 	api.HandleFunc("/posts", GetPosts).Methods("GET")
-	api.HandleFunc("/posts/{id}", GetPost).Methods("GET")
+	api.Handle("/posts/{id}", OptionalAuthMiddleware(http.HandlerFunc(GetPost))).Methods("GET")
+	api.Handle("/posts/{id}/comments", OptionalAuthMiddleware(http.HandlerFunc(GetComments))).Methods("GET")
+
+	// Permalinks
+	api.Handle("/users/{username}/posts/{slug}", OptionalAuthMiddleware(http.HandlerFunc(GetPostBySlug))).Methods("GET")
+	api.Handle("/users/{username}/projects/{slug}", OptionalAuthMiddleware(http.HandlerFunc(GetProjectBySlug))).Methods("GET")
+	api.Handle("/projects/trending", OptionalAuthMiddleware(http.HandlerFunc(GetTrendingProjects))).Methods("GET")
+	api.Handle("/projects/top", OptionalAuthMiddleware(http.HandlerFunc(GetTopProjects))).Methods("GET")
+
+	// Follower and following lists
+	api.HandleFunc("/users/{username}/followers", GetFollowers).Methods("GET")
+	api.HandleFunc("/users/{username}/following", GetFollowing).Methods("GET")
+
+	// Blog organisation
+	api.HandleFunc("/tags", GetTags).Methods("GET")
+	api.HandleFunc("/tags/{slug}/posts", GetTagPosts).Methods("GET")
+	api.HandleFunc("/users/{username}/categories", GetCategories).Methods("GET")
+	api.HandleFunc("/users/{username}/categories/{slug}/posts", GetCategoryPosts).Methods("GET")
+	api.HandleFunc("/users/{username}/series", GetUserSeries).Methods("GET")
+	api.HandleFunc("/users/{username}/series/{slug}", GetSeriesBySlug).Methods("GET")
+
+	// Blog feeds
+	r.HandleFunc("/feeds/posts.{format:rss|atom|json}", GetFeed).Methods("GET")
+	r.HandleFunc("/users/{username}/feed.{format:rss|atom|json}", GetFeed).Methods("GET")
+
+	// Webmentions
+	api.HandleFunc("/webmention", ReceiveWebmention).Methods("POST")
+	api.HandleFunc("/posts/{id}/webmentions", GetPostWebmentions).Methods("GET")
+	api.HandleFunc("/portfolio/{username}/webmentions", GetPortfolioWebmentions).Methods("GET")
+
+	// Résumé
+	api.Handle("/portfolio/{username}/resume.pdf", OptionalAuthMiddleware(http.HandlerFunc(GetResumePDF))).Methods("GET")
+
+	// Open Graph images and meta tags for sharing
+	api.Handle("/og/{kind:portfolio|project|post}/{key}.png", OptionalAuthMiddleware(http.HandlerFunc(GetOGImage))).Methods("GET")
+	api.Handle("/og/{kind:portfolio|project|post}/{key}/meta", OptionalAuthMiddleware(http.HandlerFunc(GetOGMeta))).Methods("GET")
+
+	// A user's other portfolios; registered after the routes above so their paths take precedence
+	api.Handle("/portfolio/{username}/{slug}", OptionalAuthMiddleware(http.HandlerFunc(GetPortfolioBySlug))).Methods("GET")
+
+	// ActivityPub federation
+	r.HandleFunc("/.well-known/webfinger", WebFinger).Methods("GET")
+	ap := r.PathPrefix("/ap").Subrouter()
+	ap.HandleFunc("/users/{username}", GetActor).Methods("GET")
+	ap.HandleFunc("/users/{username}/inbox", PostInbox).Methods("POST")
+	ap.HandleFunc("/users/{username}/outbox", GetOutbox).Methods("GET")
+	ap.HandleFunc("/users/{username}/followers", GetFollowersCollection).Methods("GET")
+	ap.HandleFunc("/posts/{id}", GetArticle).Methods("GET")
 
 	// Authenticated routes
 	auth := r.PathPrefix("/api/auth").Subrouter()
 	auth.Use(AuthMiddleware)
+	auth.Use(RegenerateExportOnChange) // Keeps automatically exported static sites up to date
 
 	// Blog post authenticated routes
 	auth.HandleFunc("/posts", CreatePost).Methods("POST")
 	auth.HandleFunc("/posts/{id}", UpdatePost).Methods("PUT")
 	auth.HandleFunc("/posts/{id}", DeletePost).Methods("DELETE")
 
+	// Blog post revision routes
+	auth.HandleFunc("/posts/{id}/revisions", GetPostRevisions).Methods("GET")
+	auth.HandleFunc("/posts/{id}/revisions/diff", DiffPostRevisions).Methods("GET")
+	auth.HandleFunc("/posts/{id}/revisions/{revision:[0-9]+}", GetPostRevision).Methods("GET")
+	auth.HandleFunc("/posts/{id}/revisions/{revision:[0-9]+}/restore", RestorePostRevision).Methods("POST")
+
+	// Category and series routes
+	auth.HandleFunc("/categories", CreateCategory).Methods("POST")
+	auth.HandleFunc("/categories/{id}", UpdateCategory).Methods("PUT")
+	auth.HandleFunc("/categories/{id}", DeleteCategory).Methods("DELETE")
+	auth.HandleFunc("/series", CreateSeries).Methods("POST")
+	auth.HandleFunc("/series/{id}", UpdateSeries).Methods("PUT")
+	auth.HandleFunc("/series/{id}", DeleteSeries).Methods("DELETE")
+	auth.HandleFunc("/series/{id}/posts", SetSeriesPosts).Methods("PUT")
+
+	// Comment routes
+	auth.HandleFunc("/posts/{id}/comments", CreateComment).Methods("POST")
+	auth.HandleFunc("/posts/{id}/comments/settings", UpdateCommentSettings).Methods("PUT")
+	auth.HandleFunc("/comments/pending", GetPendingComments).Methods("GET")
+	auth.HandleFunc("/comments/{id}", UpdateComment).Methods("PUT")
+	auth.HandleFunc("/comments/{id}", DeleteComment).Methods("DELETE")
+	auth.HandleFunc("/comments/{id}/{action:approve|hide}", ModerateComment).Methods("POST")
+
+	// Webmention moderation routes
+	auth.HandleFunc("/webmentions", GetReceivedWebmentions).Methods("GET")
+	auth.HandleFunc("/webmentions/{id}", DeleteWebmention).Methods("DELETE")
+
+	// Social link routes
+	auth.HandleFunc("/social-links", GetSocialLinks).Methods("GET")
+	auth.HandleFunc("/social-links", UpdateSocialLinks).Methods("PUT")
+	auth.HandleFunc("/social-links/{id}/verify", VerifySocialLink).Methods("POST")
+
+	// Static site export routes
+	auth.HandleFunc("/export", ExportSite).Methods("GET")
+	auth.HandleFunc("/export/latest", GetLatestExport).Methods("GET")
+	auth.HandleFunc("/export/settings", GetExportSettings).Methods("GET")
+	auth.HandleFunc("/export/settings", UpdateExportSettings).Methods("PUT")
+
+	// Link health routes
+	auth.HandleFunc("/links/health", GetLinkHealth).Methods("GET")
+	auth.HandleFunc("/links/health/check", CheckLinksNow).Methods("POST")
+
+	// Follow and feed routes
+	auth.HandleFunc("/users/{username}/follow", FollowUser).Methods("POST")
+	auth.HandleFunc("/users/{username}/follow", UnfollowUser).Methods("DELETE")
+	auth.HandleFunc("/feed", GetActivityFeed).Methods("GET")
+
+	// Analytics routes
+	auth.HandleFunc("/analytics", GetAnalytics).Methods("GET")
+
+	// Custom domain routes
+	auth.HandleFunc("/domains", GetDomains).Methods("GET")
+	auth.HandleFunc("/domains", CreateDomain).Methods("POST")
+	auth.HandleFunc("/domains/{id}/verify", VerifyDomain).Methods("POST")
+	auth.HandleFunc("/domains/{id}", DeleteDomain).Methods("DELETE")
+
 	// Portfolio routes
-	auth.HandleFunc("/portfolio", UpdatePortfolio).Methods("PUT") // Update authenticated user's portfolio
+	auth.HandleFunc("/portfolio", UpdatePortfolio).Methods("PUT") // Update authenticated user's main portfolio
+	auth.HandleFunc("/portfolios", GetOwnPortfolios).Methods("GET")
+	auth.HandleFunc("/portfolios", CreatePortfolio).Methods("POST")
+	auth.HandleFunc("/portfolios/{id}", UpdatePortfolioByID).Methods("PUT")
+	auth.HandleFunc("/portfolios/{id}", DeletePortfolio).Methods("DELETE")
+	auth.HandleFunc("/portfolios/{id}/default", SetDefaultPortfolio).Methods("POST")
+	auth.HandleFunc("/portfolios/{id}/shares", GetShareLinks).Methods("GET")
+	auth.HandleFunc("/portfolios/{id}/shares", CreateShareLink).Methods("POST")
+	auth.HandleFunc("/portfolios/{id}/shares/{share}", RevokeShareLink).Methods("DELETE")
+	auth.HandleFunc("/portfolios/{id}/shares/{share}/accesses", GetShareLinkAccesses).Methods("GET")
 
 	// Project routes (for authenticated user's portfolio)
 	auth.HandleFunc("/portfolio/projects", CreateProject).Methods("POST")
 	auth.HandleFunc("/portfolio/projects", GetProjects).Methods("GET")
+	auth.HandleFunc("/portfolio/projects/order", ReorderProjects).Methods("PUT")
 	auth.HandleFunc("/portfolio/projects/{id}", UpdateProject).Methods("PUT")
 	auth.HandleFunc("/portfolio/projects/{id}", DeleteProject).Methods("DELETE")
 
+	// Project media routes
+	auth.HandleFunc("/portfolio/projects/{id}/media", GetProjectMedia).Methods("GET")
+	auth.HandleFunc("/portfolio/projects/{id}/media", CreateProjectMedia).Methods("POST")
+	auth.HandleFunc("/portfolio/projects/{id}/media/order", ReorderProjectMedia).Methods("PUT")
+	auth.HandleFunc("/portfolio/projects/{id}/media/{media}", UpdateProjectMedia).Methods("PUT")
+	auth.HandleFunc("/portfolio/projects/{id}/media/{media}", DeleteProjectMedia).Methods("DELETE")
+	auth.HandleFunc("/portfolio/projects/{id}/media/{media}/cover", SetProjectCover).Methods("POST")
+
+	// Git repository routes
+	auth.HandleFunc("/portfolio/projects/{id}/repository", GetProjectRepository).Methods("GET")
+	auth.HandleFunc("/portfolio/projects/{id}/repository", LinkProjectRepository).Methods("PUT")
+	auth.HandleFunc("/portfolio/projects/{id}/repository", UnlinkProjectRepository).Methods("DELETE")
+	auth.HandleFunc("/portfolio/projects/{id}/repository/sync", SyncProjectRepository).Methods("POST")
+
 	// Like routes
 	auth.HandleFunc("/portfolio/projects/{id}/like", LikeProject).Methods("POST")
 	auth.HandleFunc("/portfolio/projects/{id}/like", UnlikeProject).Methods("DELETE")
@@ -59,9 +210,25 @@ func main() {
 	// Achievement routes (for authenticated user's portfolio)
 	auth.HandleFunc("/portfolio/achievements", CreateAchievement).Methods("POST")
 	auth.HandleFunc("/portfolio/achievements", GetAchievements).Methods("GET")
+	auth.HandleFunc("/portfolio/achievements/order", ReorderAchievements).Methods("PUT")
 	auth.HandleFunc("/portfolio/achievements/{id}", UpdateAchievement).Methods("PUT")
 	auth.HandleFunc("/portfolio/achievements/{id}", DeleteAchievement).Methods("DELETE")
 
+	// Work experience, education and skill routes (for authenticated user's portfolio)
+	auth.HandleFunc("/portfolio/experience", CreateExperience).Methods("POST")
+	auth.HandleFunc("/portfolio/experience", GetExperiences).Methods("GET")
+	auth.HandleFunc("/portfolio/experience/{id}", UpdateExperience).Methods("PUT")
+	auth.HandleFunc("/portfolio/experience/{id}", DeleteExperience).Methods("DELETE")
+	auth.HandleFunc("/portfolio/education", CreateEducation).Methods("POST")
+	auth.HandleFunc("/portfolio/education", GetEducations).Methods("GET")
+	auth.HandleFunc("/portfolio/education/{id}", UpdateEducation).Methods("PUT")
+	auth.HandleFunc("/portfolio/education/{id}", DeleteEducation).Methods("DELETE")
+	auth.HandleFunc("/portfolio/skills", CreateSkill).Methods("POST")
+	auth.HandleFunc("/portfolio/skills", GetSkills).Methods("GET")
+	auth.HandleFunc("/portfolio/skills/order", ReorderSkills).Methods("PUT")
+	auth.HandleFunc("/portfolio/skills/{id}", UpdateSkill).Methods("PUT")
+	auth.HandleFunc("/portfolio/skills/{id}", DeleteSkill).Methods("DELETE")
+
 	// User profile routes
 	auth.HandleFunc("/user", UpdateUser).Methods("PUT")
 	auth.HandleFunc("/user/password", ChangePassword).Methods("PUT")
@@ -73,13 +240,13 @@ func main() {
 	r.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir("./public/uploads"))))
 
 	// CORS headers
-	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
+	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", "X-Portfolio-Password", "X-Referrer"})
 	methods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE"})
 	origins := handlers.AllowedOrigins([]string{"*"}) // Replace with your frontend URL in production
 
 	// Start server
 	log.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", handlers.CORS(headers, methods, origins)(r)); err != nil {
+	if err := http.ListenAndServe(":8080", handlers.CORS(headers, methods, origins)(DomainRouter(r))); err != nil {
 		log.Fatal(err)
 	}
-}
\ No newline at end of file
+}
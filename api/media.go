@@ -0,0 +1,611 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Kinds of project media.
+const (
+	MediaImage   = "image"   // An uploaded or linked image
+	MediaVideo   = "video"   // A video file played by the browser
+	MediaYouTube = "youtube" // A YouTube video, shown in an embedded player
+	MediaVimeo   = "vimeo"   // A Vimeo video, shown in an embedded player
+)
+
+// maxProjectMedia is the most images and videos a project can have.
+const maxProjectMedia = 30
+
+var (
+	// errInvalidMedia is wrapped by errors describing media that can't be added.
+	errInvalidMedia = errors.New("invalid media")
+	// errTooManyMedia is returned when a project already has maxProjectMedia items.
+	errTooManyMedia = fmt.Errorf("a project can have at most %d images and videos", maxProjectMedia)
+)
+
+// videoExtensions are the file extensions of videos browsers can play.
+var videoExtensions = []string{".mp4", ".webm", ".ogv", ".mov", ".m4v"}
+
+var (
+	youTubeIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	vimeoIDPattern   = regexp.MustCompile(`^[0-9]+$`)
+	vimeoHashPattern = regexp.MustCompile(`^[0-9a-f]+$`)
+)
+
+// MediaRequest is the body of a request adding media to a project. The kind
+// is worked out from the URL when it isn't given.
+type MediaRequest struct {
+	Kind         string `json:"kind"`
+	URL          string `json:"url"`
+	Caption      string `json:"caption"`
+	AltText      string `json:"alt_text"`
+	ThumbnailURL string `json:"thumbnail_url"` // Poster for videos; ignored for images and YouTube
+}
+
+// mediaURL parses a media URL, which must be absolute or an upload.
+func mediaURL(field, raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return u, nil
+	}
+	if err == nil && u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/uploads/") {
+		return u, nil
+	}
+	return nil, fmt.Errorf("%w: %s must be an http(s) URL or an uploaded file", errInvalidMedia, field)
+}
+
+// youTubeID returns the id of the YouTube video u links to.
+func youTubeID(u *url.URL) (string, bool) {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	var id string
+	switch host {
+	case "youtu.be":
+		id = strings.Trim(u.Path, "/")
+	case "youtube.com", "m.youtube.com", "youtube-nocookie.com":
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		switch {
+		case parts[0] == "watch":
+			id = u.Query().Get("v")
+		case len(parts) == 2 && (parts[0] == "embed" || parts[0] == "shorts" || parts[0] == "live" || parts[0] == "v"):
+			id = parts[1]
+		}
+	default:
+		return "", false
+	}
+	return id, youTubeIDPattern.MatchString(id)
+}
+
+// vimeoID returns the id of the Vimeo video u links to, and the hash that
+// unlisted videos need.
+func vimeoID(u *url.URL) (string, string, bool) {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	hash := u.Query().Get("h")
+	switch host {
+	case "player.vimeo.com":
+		if len(parts) != 2 || parts[0] != "video" {
+			return "", "", false
+		}
+		parts = parts[1:]
+	case "vimeo.com":
+		// vimeo.com/{id}, vimeo.com/{id}/{hash} or vimeo.com/channels/{channel}/{id}
+		if len(parts) == 3 && parts[0] == "channels" {
+			parts = parts[2:]
+		} else if len(parts) == 2 && vimeoHashPattern.MatchString(parts[1]) {
+			hash = parts[1]
+			parts = parts[:1]
+		}
+	default:
+		return "", "", false
+	}
+	if len(parts) != 1 || !vimeoIDPattern.MatchString(parts[0]) {
+		return "", "", false
+	}
+	if !vimeoHashPattern.MatchString(hash) {
+		hash = ""
+	}
+	return parts[0], hash, true
+}
+
+// isVideoFile reports whether u names a video file.
+func isVideoFile(u *url.URL) bool {
+	ext := strings.ToLower(path.Ext(u.Path))
+	for _, videoExt := range videoExtensions {
+		if ext == videoExt {
+			return true
+		}
+	}
+	return false
+}
+
+// detectMediaKind works out the kind of media u links to.
+func detectMediaKind(u *url.URL) string {
+	if _, ok := youTubeID(u); ok {
+		return MediaYouTube
+	}
+	if _, _, ok := vimeoID(u); ok {
+		return MediaVimeo
+	}
+	if isVideoFile(u) {
+		return MediaVideo
+	}
+	return MediaImage
+}
+
+// newProjectMedia validates a request adding media, returning the media with
+// its URLs in canonical form.
+func newProjectMedia(req MediaRequest) (ProjectMedia, error) {
+	u, err := mediaURL("url", req.URL)
+	if err != nil {
+		return ProjectMedia{}, err
+	}
+
+	kind := strings.ToLower(strings.TrimSpace(req.Kind))
+	if kind == "" {
+		kind = detectMediaKind(u)
+	}
+
+	media := ProjectMedia{
+		Kind:    kind,
+		Caption: strings.TrimSpace(req.Caption),
+		AltText: strings.TrimSpace(req.AltText),
+	}
+	switch kind {
+	case MediaImage:
+		media.URL = u.String()
+		media.ThumbnailURL = media.URL
+	case MediaVideo:
+		media.URL = u.String()
+	case MediaYouTube:
+		id, ok := youTubeID(u)
+		if !ok {
+			return ProjectMedia{}, fmt.Errorf("%w: url is not a YouTube video", errInvalidMedia)
+		}
+		media.URL = "https://www.youtube.com/watch?v=" + id
+		media.EmbedURL = "https://www.youtube-nocookie.com/embed/" + id
+		media.ThumbnailURL = "https://i.ytimg.com/vi/" + id + "/hqdefault.jpg"
+	case MediaVimeo:
+		id, hash, ok := vimeoID(u)
+		if !ok {
+			return ProjectMedia{}, fmt.Errorf("%w: url is not a Vimeo video", errInvalidMedia)
+		}
+		media.URL = "https://vimeo.com/" + id
+		media.EmbedURL = "https://player.vimeo.com/video/" + id
+		if hash != "" {
+			media.URL += "/" + hash
+			media.EmbedURL += "?h=" + hash
+		}
+	default:
+		return ProjectMedia{}, fmt.Errorf("%w: kind must be one of %q", errInvalidMedia,
+			[]string{MediaImage, MediaVideo, MediaYouTube, MediaVimeo})
+	}
+
+	if err := media.setThumbnail(req.ThumbnailURL); err != nil {
+		return ProjectMedia{}, err
+	}
+	return media, nil
+}
+
+// setThumbnail sets the poster of a video file or Vimeo video. Images are
+// their own thumbnail and YouTube provides one, so theirs can't be changed.
+func (m *ProjectMedia) setThumbnail(raw string) error {
+	if m.Kind != MediaVideo && m.Kind != MediaVimeo {
+		return nil
+	}
+	if strings.TrimSpace(raw) == "" {
+		m.ThumbnailURL = ""
+		return nil
+	}
+	u, err := mediaURL("thumbnail_url", raw)
+	if err != nil {
+		return err
+	}
+	m.ThumbnailURL = u.String()
+	return nil
+}
+
+// setProjectCover makes media the cover of its project, and its thumbnail the
+// project's image, which is what lists of projects show.
+func setProjectCover(tx *gorm.DB, media ProjectMedia) error {
+	if result := tx.Model(&ProjectMedia{}).Where("project_id = ? AND id <> ?", media.ProjectID, media.ID).UpdateColumn("is_cover", false); result.Error != nil {
+		return result.Error
+	}
+	if result := tx.Model(&ProjectMedia{}).Where("id = ?", media.ID).UpdateColumn("is_cover", true); result.Error != nil {
+		return result.Error
+	}
+	return tx.Model(&Project{}).Where("id = ?", media.ProjectID).UpdateColumn("image_url", media.ThumbnailURL).Error
+}
+
+// chooseProjectCover makes the first media with a thumbnail the project's
+// cover if it has none, clearing the project's image if nothing qualifies.
+func chooseProjectCover(tx *gorm.DB, projectID uint) error {
+	var covers int64
+	if result := tx.Model(&ProjectMedia{}).Where("project_id = ? AND is_cover", projectID).Count(&covers); result.Error != nil {
+		return result.Error
+	}
+	if covers > 0 {
+		return nil
+	}
+
+	var media ProjectMedia
+	result := tx.Where("project_id = ? AND thumbnail_url <> ''", projectID).Order("position asc, id asc").Limit(1).Find(&media)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return tx.Model(&Project{}).Where("id = ?", projectID).UpdateColumn("image_url", "").Error
+	}
+	return setProjectCover(tx, media)
+}
+
+// setProjectImage makes an image at imageURL the cover of a project, for
+// clients that set a project's image directly rather than through its
+// gallery. The image is added to the gallery unless it is already the
+// cover; an empty URL leaves the gallery as it is, as media is only removed
+// through its own endpoints.
+func setProjectImage(tx *gorm.DB, project *Project, imageURL string) error {
+	imageURL = strings.TrimSpace(imageURL)
+	if imageURL == "" || imageURL == project.ImageURL {
+		return nil
+	}
+	media, err := newProjectMedia(MediaRequest{Kind: MediaImage, URL: imageURL})
+	if err != nil {
+		return err
+	}
+	media.ProjectID = project.ID
+
+	var count int64
+	if result := tx.Model(&ProjectMedia{}).Where("project_id = ?", project.ID).Count(&count); result.Error != nil {
+		return result.Error
+	}
+	if count >= maxProjectMedia {
+		return errTooManyMedia
+	}
+	if media.Position, err = nextPositionIn(tx, &ProjectMedia{}, "project_id", project.ID); err != nil {
+		return err
+	}
+	if err := tx.Create(&media).Error; err != nil {
+		return err
+	}
+	if err := setProjectCover(tx, media); err != nil {
+		return err
+	}
+	project.ImageURL = media.ThumbnailURL
+	return nil
+}
+
+// findOwnedProject loads the project identified by the "id" route variable if
+// it belongs to the authenticated user.
+func findOwnedProject(r *http.Request, userID uint) (Project, int, string) {
+	projectID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return Project{}, http.StatusBadRequest, "Invalid project ID"
+	}
+
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
+		return Project{}, http.StatusNotFound, "Portfolio not found for user"
+	}
+
+	var project Project
+	if result := DB.Where("id = ? AND portfolio_id = ?", projectID, portfolio.ID).First(&project); result.Error != nil {
+		return Project{}, http.StatusNotFound, "Project not found or not authorized"
+	}
+	return project, 0, ""
+}
+
+// findOwnedMedia loads the media identified by the "media" route variable of
+// a project belonging to the authenticated user.
+func findOwnedMedia(r *http.Request, userID uint) (ProjectMedia, int, string) {
+	project, status, message := findOwnedProject(r, userID)
+	if status != 0 {
+		return ProjectMedia{}, status, message
+	}
+
+	mediaID, err := strconv.ParseUint(mux.Vars(r)["media"], 10, 64)
+	if err != nil {
+		return ProjectMedia{}, http.StatusBadRequest, "Invalid media ID"
+	}
+
+	var media ProjectMedia
+	if result := DB.Where("id = ? AND project_id = ?", mediaID, project.ID).First(&media); result.Error != nil {
+		return ProjectMedia{}, http.StatusNotFound, "Media not found"
+	}
+	return media, 0, ""
+}
+
+// writeMediaError writes the response for media that failed to save.
+func writeMediaError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, errInvalidMedia), errors.Is(err, errTooManyMedia), errors.Is(err, errOrderMismatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+// projectMedia returns a project's media in order.
+func projectMedia(projectID uint) ([]ProjectMedia, error) {
+	var media []ProjectMedia
+	result := DB.Where("project_id = ?", projectID).Order("position asc, id asc").Find(&media)
+	return media, result.Error
+}
+
+// orderedMedia preloads projects' media in order.
+func orderedMedia(db *gorm.DB) *gorm.DB {
+	return db.Order("position asc, id asc")
+}
+
+// GetProjectMedia handles listing the images and videos of a project
+// belonging to the authenticated user.
+func GetProjectMedia(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	project, status, message := findOwnedProject(r, userID)
+	if status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	media, err := projectMedia(project.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve media", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(media)
+}
+
+// CreateProjectMedia handles adding an image or video to a project belonging
+// to the authenticated user. It goes after the project's other media, and
+// becomes the cover if the project has none.
+func CreateProjectMedia(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	project, status, message := findOwnedProject(r, userID)
+	if status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	var req MediaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	media, err := newProjectMedia(req)
+	if err != nil {
+		writeMediaError(w, err, "Failed to add media")
+		return
+	}
+	media.ProjectID = project.ID
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if result := tx.Model(&ProjectMedia{}).Where("project_id = ?", project.ID).Count(&count); result.Error != nil {
+			return result.Error
+		}
+		if count >= maxProjectMedia {
+			return errTooManyMedia
+		}
+		var err error
+		if media.Position, err = nextPositionIn(tx, &ProjectMedia{}, "project_id", project.ID); err != nil {
+			return err
+		}
+		if err := tx.Create(&media).Error; err != nil {
+			return err
+		}
+		if err := chooseProjectCover(tx, project.ID); err != nil {
+			return err
+		}
+		return tx.First(&media, media.ID).Error
+	})
+	if err != nil {
+		writeMediaError(w, err, "Failed to add media")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(media)
+}
+
+// UpdateProjectMedia handles changing the caption, alt text and thumbnail of
+// a project's image or video. To show something else, the media is deleted
+// and new media added.
+func UpdateProjectMedia(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	media, status, message := findOwnedMedia(r, userID)
+	if status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	var req MediaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	media.Caption = strings.TrimSpace(req.Caption)
+	media.AltText = strings.TrimSpace(req.AltText)
+	if err := media.setThumbnail(req.ThumbnailURL); err != nil {
+		writeMediaError(w, err, "Failed to update media")
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&media).Error; err != nil {
+			return err
+		}
+		// A cover whose thumbnail was removed can't be the cover any more
+		if media.IsCover && media.ThumbnailURL == "" {
+			if err := tx.Model(&media).UpdateColumn("is_cover", false).Error; err != nil {
+				return err
+			}
+			media.IsCover = false
+		}
+		if media.IsCover {
+			return setProjectCover(tx, media)
+		}
+		return chooseProjectCover(tx, media.ProjectID)
+	})
+	if err != nil {
+		writeMediaError(w, err, "Failed to update media")
+		return
+	}
+
+	json.NewEncoder(w).Encode(media)
+}
+
+// SetProjectCover handles choosing which of a project's images or videos is
+// its cover. The cover's thumbnail becomes the project's image.
+func SetProjectCover(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	media, status, message := findOwnedMedia(r, userID)
+	if status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+	if media.ThumbnailURL == "" {
+		http.Error(w, "Media without a thumbnail can't be the cover", http.StatusBadRequest)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		return setProjectCover(tx, media)
+	})
+	if err != nil {
+		http.Error(w, "Failed to set cover", http.StatusInternalServerError)
+		return
+	}
+
+	all, err := projectMedia(media.ProjectID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve media", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(all)
+}
+
+// ReorderProjectMedia handles setting the order of a project's images and
+// videos. The request lists every media id in the new order; the whole order
+// is applied or none of it is.
+func ReorderProjectMedia(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	project, status, message := findOwnedProject(r, userID)
+	if status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	var req struct {
+		MediaIDs []uint `json:"media_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		return applyOrderIn(tx, &ProjectMedia{}, "project_id", project.ID, req.MediaIDs)
+	})
+	if err != nil {
+		writeMediaError(w, err, "Failed to reorder media")
+		return
+	}
+
+	media, err := projectMedia(project.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve media", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(media)
+}
+
+// DeleteProjectMedia handles removing an image or video from a project
+// belonging to the authenticated user. When the cover is removed, the first
+// remaining media with a thumbnail becomes the cover.
+func DeleteProjectMedia(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	media, status, message := findOwnedMedia(r, userID)
+	if status != 0 {
+		http.Error(w, message, status)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&media).Error; err != nil {
+			return err
+		}
+		return chooseProjectCover(tx, media.ProjectID)
+	})
+	if err != nil {
+		http.Error(w, "Failed to delete media", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// backfillProjectMedia turns the image of projects created before projects
+// had galleries into their cover.
+func backfillProjectMedia() {
+	var projects []Project
+	DB.Where("image_url <> '' AND NOT EXISTS (SELECT 1 FROM project_media WHERE project_media.project_id = projects.id AND project_media.deleted_at IS NULL)").Find(&projects)
+	for _, project := range projects {
+		media := ProjectMedia{
+			ProjectID:    project.ID,
+			Kind:         MediaImage,
+			URL:          project.ImageURL,
+			ThumbnailURL: project.ImageURL,
+			Position:     1,
+			IsCover:      true,
+		}
+		if result := DB.Create(&media); result.Error != nil {
+			log.Printf("Failed to create cover for project %d: %v", project.ID, result.Error)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetProjectImageChecksBeforeSaving(t *testing.T) {
+	project := Project{ImageURL: "https://example.com/cover.png"}
+	project.ID = 1
+
+	// Sending back the current image, or none, leaves the gallery alone
+	for _, imageURL := range []string{"", "  ", "https://example.com/cover.png"} {
+		if err := setProjectImage(nil, &project, imageURL); err != nil || project.ImageURL != "https://example.com/cover.png" {
+			t.Errorf("setProjectImage(%q) = %v, image %q", imageURL, err, project.ImageURL)
+		}
+	}
+	for _, imageURL := range []string{"javascript:alert(1)", "ftp://example.com/a.png", "relative.png"} {
+		if err := setProjectImage(nil, &project, imageURL); !errors.Is(err, errInvalidMedia) {
+			t.Errorf("setProjectImage(%q) = %v, want errInvalidMedia", imageURL, err)
+		}
+	}
+}
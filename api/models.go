@@ -13,36 +13,92 @@ type User struct {
 	Email             string `gorm:"unique;not null"`
 	Password          string `gorm:"not null"`
 	Bio               string
-	SocialMediaLinks  string // JSON encoded map[string]string
+	SocialLinks       []SocialLink `gorm:"foreignKey:UserID"`
 	ProfilePictureURL string
-	// A user can have one portfolio
+	// The user's main portfolio; see Portfolio
 	Portfolio Portfolio `gorm:"foreignKey:UserID"`
 }
 
-// Portfolio represents a user's portfolio
+// Portfolio represents a user's portfolio. A user's first portfolio is their
+// main portfolio: it owns all of their projects and achievements and shows
+// them all. Further portfolios show a chosen subset of them.
 type Portfolio struct {
 	gorm.Model
-	UserID      uint   `gorm:"not null;unique"` // Each user has one portfolio
-	Title       string // e.g., "John Doe's Portfolio"
-	Description string // A short bio or tagline
-	AboutMe     string // Detailed about me section
-	ContactInfo string // How to contact the user
-	Layout      string `gorm:"default:'default'"`
-	Projects    []Project `gorm:"foreignKey:PortfolioID"`
-	Achievements []Achievement `gorm:"foreignKey:PortfolioID"`
+	UserID          uint          `gorm:"not null;index;uniqueIndex:idx_portfolios_user_slug"`                          // A user can have several portfolios
+	Slug            string        `gorm:"uniqueIndex:idx_portfolios_user_slug,where:deleted_at IS NULL AND slug <> ''"` // Unique among the user's portfolios
+	IsDefault       bool          `gorm:"not null;default:false"`                                                       // Served at /api/portfolio/{username}
+	Visibility      string        `gorm:"not null;default:'public'"`                                                    // public, unlisted, private or password
+	PasswordHash    string        `json:"-"`                                                                            // Set for password-protected portfolios
+	Title           string        // e.g., "John Doe's Portfolio"
+	Description     string        // A short bio or tagline
+	AboutMe         string        // Detailed about me section
+	ContactInfo     string        // How to contact the user
+	Layout          string        `gorm:"default:'default'"`                // Name of a theme in the registry, see themes.go
+	ThemeSettings   ThemeSettings `gorm:"type:jsonb;not null;default:'{}'"` // Values for the layout's settings
+	HideBrokenLinks bool          `gorm:"not null;default:false"`           // Leave out links the link checker found broken
+	Projects        []Project     `gorm:"foreignKey:PortfolioID"`
+	Achievements    []Achievement `gorm:"foreignKey:PortfolioID"`
+	Experiences     []Experience  `gorm:"foreignKey:PortfolioID"`
+	Educations      []Education   `gorm:"foreignKey:PortfolioID"`
+	Skills          []Skill       `gorm:"foreignKey:PortfolioID"`
 }
 
 // Project represents a project in a portfolio
 type Project struct {
 	gorm.Model
-	PortfolioID  uint   `gorm:"not null"`
+	PortfolioID  uint   `gorm:"not null;uniqueIndex:idx_projects_portfolio_slug"`
 	Title        string `gorm:"not null"`
+	Slug         string `gorm:"uniqueIndex:idx_projects_portfolio_slug,where:deleted_at IS NULL AND slug <> ''"` // Unique within the portfolio, used in public URLs
 	Description  string
-	Technologies string // Comma-separated list of technologies
-	Link         string // Link to the project (e.g., GitHub, live demo)
-	ImageURL     string // URL for a project image/thumbnail
-	Featured     bool   `gorm:"default:false"`
-	Likes        []Like `gorm:"foreignKey:ProjectID"`
+	Technologies string             // Comma-separated list of technologies
+	Link         string             // Link to the project (e.g., GitHub, live demo)
+	ImageURL     string             // Thumbnail of the cover media, kept in step with it
+	Featured     bool               `gorm:"default:false"`
+	Position     int                `gorm:"not null;default:0"` // Order chosen by the owner; featured projects still come first
+	Likes        []Like             `gorm:"foreignKey:ProjectID"`
+	Media        []ProjectMedia     `gorm:"foreignKey:ProjectID"` // Gallery of images and videos, in order
+	Repository   *ProjectRepository `gorm:"foreignKey:ProjectID"` // Linked git repository, if any
+}
+
+// ProjectRepository links a project to a repository on a git host, whose
+// details are pulled periodically; see gitsync.go.
+type ProjectRepository struct {
+	gorm.Model
+	ProjectID uint   `gorm:"not null;uniqueIndex"`
+	Provider  string `gorm:"not null"` // Name of a provider in repoProviders
+	BaseURL   string // Instance of self-hosted providers
+	Owner     string `gorm:"not null"`
+	Name      string `gorm:"not null"`
+	URL       string // The repository's web page
+	// Which of the project's fields are replaced on every sync
+	OverwriteDescription  bool `gorm:"not null;default:false"`
+	OverwriteTechnologies bool `gorm:"not null;default:false"`
+	OverwriteLink         bool `gorm:"not null;default:false"`
+	// Last pulled from the repository
+	Homepage     string
+	Description  string
+	Topics       string // Comma-separated
+	Languages    string // Comma-separated, most used first
+	Stars        int
+	LastCommitAt *time.Time
+	Readme       string `gorm:"type:text"`
+	SyncedAt     *time.Time
+	SyncError    string // Why the last sync failed, empty if it succeeded
+}
+
+// ProjectMedia is an image or video in a project's gallery. One of a
+// project's media can be its cover, whose thumbnail is the project's ImageURL.
+type ProjectMedia struct {
+	gorm.Model
+	ProjectID    uint   `gorm:"not null;index"`
+	Kind         string `gorm:"not null"` // image, video, youtube or vimeo
+	URL          string `gorm:"not null"` // The image or video file, or the video's page
+	EmbedURL     string // Player to embed for YouTube and Vimeo videos
+	ThumbnailURL string // Image shown for the media in lists; empty for videos without a poster
+	Caption      string
+	AltText      string
+	Position     int  `gorm:"not null;default:0"` // Order chosen by the owner
+	IsCover      bool `gorm:"not null;default:false"`
 }
 
 // Like represents a like on a project
@@ -56,17 +112,325 @@ type Like struct {
 // Achievement represents an achievement in a portfolio
 type Achievement struct {
 	gorm.Model
-	PortfolioID uint      `gorm:"not null"`
-	Title       string    `gorm:"not null"`
+	PortfolioID uint   `gorm:"not null"`
+	Title       string `gorm:"not null"`
 	Description string
 	Date        time.Time // Date of the achievement
+	Position    int       `gorm:"not null;default:0"` // Order chosen by the owner
+}
+
+// Experience represents a job or other role in a portfolio
+type Experience struct {
+	gorm.Model
+	PortfolioID  uint   `gorm:"not null;index"`
+	Title        string `gorm:"not null"` // e.g., "Backend Engineer"
+	Organization string `gorm:"not null"` // Company or organisation
+	Location     string
+	Description  string `gorm:"type:text"`
+	StartDate    time.Time
+	EndDate      *time.Time // nil while the role is current
+}
+
+// Education represents a degree or other course of study in a portfolio
+type Education struct {
+	gorm.Model
+	PortfolioID  uint   `gorm:"not null;index"`
+	Institution  string `gorm:"not null"`
+	Degree       string // e.g., "BSc"
+	FieldOfStudy string // e.g., "Computer Science"
+	Description  string `gorm:"type:text"`
+	StartDate    time.Time
+	EndDate      *time.Time // nil while still studying
+}
+
+// Skill represents a skill listed in a portfolio
+type Skill struct {
+	gorm.Model
+	PortfolioID uint   `gorm:"not null;index"`
+	Name        string `gorm:"not null"`
+	Category    string // e.g., "Languages", used to group skills
+	Level       string // One of skillLevels, or empty
+	Position    int    `gorm:"not null;default:0"` // Order chosen by the owner
 }
 
 // Post represents a blog post
 type Post struct {
 	gorm.Model
-	UserID      uint      `gorm:"not null"` // Author of the post
-	Title       string    `gorm:"not null"`
-	Content     string    `gorm:"type:text"`
+	UserID      uint   `gorm:"not null;uniqueIndex:idx_posts_user_slug"` // Author of the post
+	Title       string `gorm:"not null"`
+	Slug        string `gorm:"uniqueIndex:idx_posts_user_slug,where:deleted_at IS NULL AND slug <> ''"` // Unique among the author's posts, used in public URLs
+	Content     string `gorm:"type:text"`
 	PublishedAt time.Time
-}
\ No newline at end of file
+	// Presentation, computed from the content when the post is saved
+	Summary       string `gorm:"type:text"` // Optional author-supplied summary, used as the excerpt
+	Excerpt       string `gorm:"type:text"`
+	WordCount     int
+	ReadingTime   int    // Estimated minutes to read
+	CoverImage    string // Optional author-chosen cover image
+	CoverImageURL string // The chosen cover image, or else the first image in the content
+	// Organisation
+	CategoryID     *uint `gorm:"index"`
+	Tags           []Tag `gorm:"many2many:post_tags;"`
+	SeriesID       *uint `gorm:"index"`
+	SeriesPosition int   // 1-based position within the series
+	// Comment settings
+	ModerateComments bool `gorm:"default:false"` // Hold new comments for approval by the author
+	CommentsLocked   bool `gorm:"default:false"` // No new comments or edits are accepted
+}
+
+// PostRevision is a snapshot of a post's title and content at a point in time.
+// A new revision is stored every time a post is created, updated or restored.
+type PostRevision struct {
+	gorm.Model
+	PostID   uint   `gorm:"not null;uniqueIndex:idx_post_revision"`
+	UserID   uint   `gorm:"not null"`                               // User who made the change
+	Revision int    `gorm:"not null;uniqueIndex:idx_post_revision"` // Sequential number within the post, starting at 1
+	Title    string `gorm:"not null"`
+	Content  string `gorm:"type:text"`
+	Note     string // e.g., "Restored from revision 2"
+}
+
+// Comment statuses
+const (
+	CommentPending  = "pending"  // Awaiting approval by the post's author
+	CommentApproved = "approved" // Visible to everyone
+	CommentHidden   = "hidden"   // Hidden by the post's author
+	CommentDeleted  = "deleted"  // Removed, but kept as a placeholder for its replies
+)
+
+// Comment represents a comment on a blog post. Replies point at the comment
+// they answer through ParentID.
+type Comment struct {
+	gorm.Model
+	PostID   uint   `gorm:"not null;index"`
+	UserID   uint   `gorm:"not null"` // Author of the comment
+	ParentID *uint  `gorm:"index"`    // Comment being replied to, nil for top-level comments
+	Content  string `gorm:"type:text"`
+	Status   string `gorm:"not null;default:'approved'"`
+}
+
+// SlugHistory records a slug a post or project used to have, so that old
+// links can redirect to the item's current URL.
+type SlugHistory struct {
+	gorm.Model
+	Kind     string `gorm:"not null;index:idx_slug_history_lookup"` // "post" or "project"
+	OwnerID  uint   `gorm:"not null;index:idx_slug_history_lookup"` // User for posts, portfolio for projects
+	Slug     string `gorm:"not null;index:idx_slug_history_lookup"`
+	TargetID uint   `gorm:"not null"` // Post or project the slug now redirects to
+}
+
+// Tag is a free-form topic shared by posts across the platform
+type Tag struct {
+	gorm.Model
+	Name string `gorm:"not null"`
+	Slug string `gorm:"not null;uniqueIndex"`
+}
+
+// Category groups an author's posts by broad subject. Each post has at most one.
+type Category struct {
+	gorm.Model
+	UserID      uint   `gorm:"not null;index;uniqueIndex:idx_categories_user_slug"`
+	Name        string `gorm:"not null"`
+	Slug        string `gorm:"not null;uniqueIndex:idx_categories_user_slug,where:deleted_at IS NULL"` // Unique among the author's categories
+	Description string
+}
+
+// Series is an ordered collection of an author's posts, such as a multi-part tutorial
+type Series struct {
+	gorm.Model
+	UserID      uint   `gorm:"not null;index;uniqueIndex:idx_series_user_slug"`
+	Title       string `gorm:"not null"`
+	Slug        string `gorm:"not null;uniqueIndex:idx_series_user_slug,where:deleted_at IS NULL"` // Unique among the author's series
+	Description string
+}
+
+// ActorKey is the key pair a user's ActivityPub actor signs requests with
+type ActorKey struct {
+	gorm.Model
+	UserID        uint   `gorm:"not null;uniqueIndex"`
+	PublicKeyPEM  string `gorm:"type:text;not null"`
+	PrivateKeyPEM string `gorm:"type:text;not null"`
+}
+
+// Follower is a remote ActivityPub actor following a user's blog
+type Follower struct {
+	gorm.Model
+	UserID      uint   `gorm:"not null;uniqueIndex:idx_follower_actor"` // User being followed
+	ActorID     string `gorm:"not null;uniqueIndex:idx_follower_actor"` // IRI of the remote actor
+	Inbox       string `gorm:"not null"`
+	SharedInbox string // Preferred for delivery when set
+}
+
+// Follow is a user following another user, whose projects, achievements and
+// posts then appear in their feed
+type Follow struct {
+	gorm.Model
+	FollowerID  uint `gorm:"not null;uniqueIndex:idx_follow"`       // User following
+	FollowingID uint `gorm:"not null;uniqueIndex:idx_follow;index"` // User being followed
+}
+
+// Webmention states
+const (
+	WebmentionPending  = "pending"  // Received, waiting to be verified
+	WebmentionVerified = "verified" // Source was fetched and links to the target
+	WebmentionRejected = "rejected" // Source could not be fetched or doesn't link to the target
+	WebmentionBlocked  = "blocked"  // Removed by the owner; never shown again
+)
+
+// Webmention represents another site mentioning one of our posts or portfolios.
+// Mentions of a portfolio have no PostID.
+type Webmention struct {
+	gorm.Model
+	Source      string `gorm:"not null;uniqueIndex:idx_webmention_source_target"`
+	Target      string `gorm:"not null;uniqueIndex:idx_webmention_source_target"`
+	UserID      uint   `gorm:"not null;index"` // Owner of the mentioned post or portfolio
+	PostID      *uint  `gorm:"index"`
+	Status      string `gorm:"not null;default:'pending'"`
+	Type        string // mention, reply, like, repost or bookmark
+	AuthorName  string
+	AuthorURL   string
+	AuthorPhoto string
+	Content     string `gorm:"type:text"` // Plain text
+	URL         string // Permalink of the mentioning entry
+	Published   *time.Time
+	VerifiedAt  *time.Time
+}
+
+// SentWebmention records a site a post linked to and was announced to, so
+// the site can be told again when the post changes or stops linking to it.
+type SentWebmention struct {
+	gorm.Model
+	PostID     uint   `gorm:"not null;uniqueIndex:idx_sent_webmention"`
+	Target     string `gorm:"not null;uniqueIndex:idx_sent_webmention"`
+	Endpoint   string // Empty if the target advertises no endpoint
+	StatusCode int    // Response of the endpoint to the last notification
+}
+
+// PortfolioProject selects one of a user's projects for a portfolio other than their main one
+type PortfolioProject struct {
+	PortfolioID uint `gorm:"primaryKey"`
+	ProjectID   uint `gorm:"primaryKey"`
+}
+
+// PortfolioAchievement selects one of a user's achievements for a portfolio other than their main one
+type PortfolioAchievement struct {
+	PortfolioID   uint `gorm:"primaryKey"`
+	AchievementID uint `gorm:"primaryKey"`
+}
+
+// Portfolio visibilities
+const (
+	VisibilityPublic   = "public"   // Anyone can see it
+	VisibilityUnlisted = "unlisted" // Anyone with the link can see it, but it isn't advertised or indexed
+	VisibilityPrivate  = "private"  // Only the owner and holders of a share link can see it
+	VisibilityPassword = "password" // Visitors must give the portfolio's password or a share link
+)
+
+// ShareLink grants read access to a private or password-protected portfolio
+// until it expires or is revoked. Only a hash of its token is stored.
+type ShareLink struct {
+	gorm.Model
+	PortfolioID    uint      `gorm:"not null;index"`
+	TokenHash      string    `gorm:"not null;uniqueIndex" json:"-"`
+	Label          string    // Who the link was given to, e.g. "Acme recruiter"
+	ExpiresAt      time.Time `gorm:"not null"`
+	AccessCount    int       `gorm:"not null;default:0"`
+	LastAccessedAt *time.Time
+}
+
+// ShareLinkAccess records a visit to a portfolio through a share link
+type ShareLinkAccess struct {
+	gorm.Model
+	ShareLinkID uint `gorm:"not null;index"`
+	UserAgent   string
+	Referrer    string
+}
+
+// CustomDomain is a domain a user serves their portfolio and blog from. It
+// only takes effect once the user has proven they control the domain.
+type CustomDomain struct {
+	gorm.Model
+	UserID      uint   `gorm:"not null;index"`
+	Domain      string `gorm:"not null;index;uniqueIndex:idx_custom_domains_verified,where:verified_at IS NOT NULL AND deleted_at IS NULL"` // Lowercase host name without a port; verified by one user at most
+	PortfolioID *uint  // Portfolio served at the domain's root; the default portfolio if nil
+	Token       string `gorm:"not null"` // Expected in the domain's TXT record or well-known file
+	VerifiedAt  *time.Time
+	LastError   string // Why the last verification attempt failed
+}
+
+// ExportSettings holds a user's choices for exporting their portfolio and
+// blog as a static site.
+type ExportSettings struct {
+	gorm.Model
+	UserID         uint   `gorm:"not null;uniqueIndex"`
+	Theme          string // Empty to use the portfolio's layout
+	PortfolioID    *uint  // The default portfolio if nil
+	BaseURL        string // Where the site is hosted, used for the sitemap and feed
+	AutoRegenerate bool   `gorm:"not null;default:false"` // Regenerate the site after every change
+	GeneratedAt    *time.Time
+	LastError      string // Why the last automatic regeneration failed
+}
+
+// SocialLink is a link from a user's profile to their profile on another
+// site. The link is verified when the page it points to links back to the
+// user with rel="me".
+type SocialLink struct {
+	gorm.Model
+	UserID            uint   `gorm:"not null;index;uniqueIndex:idx_social_links_user_url"`
+	Platform          string `gorm:"not null"`                                                                // Name of a platform in the registry, see sociallinks.go
+	URL               string `gorm:"not null;uniqueIndex:idx_social_links_user_url,where:deleted_at IS NULL"` // Canonical profile URL
+	Handle            string // The user's name on the platform, or the site's host for websites
+	Position          int    `gorm:"not null;default:0"` // Order chosen by the user
+	VerifiedAt        *time.Time
+	LastCheckedAt     *time.Time
+	VerificationError string // Why the last verification failed
+}
+
+// LinkCheck is what the link checker knows about a URL used as a project
+// link or social link; see linkcheck.go.
+type LinkCheck struct {
+	gorm.Model
+	URL           string `gorm:"not null;uniqueIndex"`
+	Status        string // ok, blocked, failing or broken; empty until checked
+	StatusCode    int    // Of the last response, after redirects
+	FinalURL      string // Where redirects led
+	Redirects     int
+	Error         string // Why the last request failed, if it did
+	Failures      int    // Failed checks in a row
+	CheckedAt     *time.Time
+	LastSuccessAt *time.Time
+}
+
+// PageView is a view of a portfolio, project or post, recorded without
+// anything that identifies the visitor; see analytics.go.
+type PageView struct {
+	gorm.Model
+	OwnerID     uint   `gorm:"not null;index"` // User whose page was viewed
+	PortfolioID *uint  `gorm:"index"`
+	ProjectID   *uint  `gorm:"index"`
+	PostID      *uint  `gorm:"index"`
+	VisitorHash string `gorm:"not null"` // Recognises a visitor for one day only
+	NetworkHash string // Recognises the network a view came from for one day only, empty for older views
+	Referrer    string // Host of the referring site, empty for direct visits
+	Country     string // ISO 3166 code from the GeoIP database, empty if unknown
+}
+
+// AnalyticsSalt is the random salt visitor hashes are made with on a day.
+// Only the current day's is kept.
+type AnalyticsSalt struct {
+	gorm.Model
+	Day  string `gorm:"not null;uniqueIndex"` // UTC, as 2006-01-02
+	Salt string `gorm:"not null"`
+}
+
+// ProjectScore is how a project ranks over a period, recomputed
+// periodically from its likes and views; see ranking.go.
+type ProjectScore struct {
+	gorm.Model
+	ProjectID     uint   `gorm:"not null;uniqueIndex:idx_project_score"`
+	Period        string `gorm:"not null;uniqueIndex:idx_project_score"` // day, week or all
+	Likes         int    // Within the period
+	Views         int    // Unique views within the period
+	TopScore      float64
+	TrendingScore float64 // Likes and views weighed by how recent they are
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// Requests to URLs supplied by users or remote servers, such as webmention
+// endpoints, link checks and actor documents, must not reach the server's own
+// network. Clients for them connect only to public addresses. The check is
+// made on the address actually dialled, so it applies to every redirect and
+// can't be bypassed by a host name that resolves differently later.
+
+// allowPrivateAddresses lets public clients connect to any address. Tests
+// set it to reach their local servers.
+var allowPrivateAddresses = false
+
+var errPrivateAddress = errors.New("address is not public")
+
+// nonPublicPrefixes are special-purpose ranges not covered by the netip
+// predicates in isPublicAddr.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // This network
+	netip.MustParsePrefix("100.64.0.0/10"),   // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // Documentation (TEST-NET-1)
+	netip.MustParsePrefix("198.18.0.0/15"),   // Benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // Documentation (TEST-NET-2)
+	netip.MustParsePrefix("203.0.113.0/24"),  // Documentation (TEST-NET-3)
+	netip.MustParsePrefix("240.0.0.0/4"),     // Reserved, including broadcast
+	netip.MustParsePrefix("2001:db8::/32"),   // Documentation
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // Local-use NAT64
+	netip.MustParsePrefix("fec0::/10"),       // Deprecated site-local
+}
+
+var (
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96") // Well-known NAT64, with the IPv4 address in the last 32 bits
+	sixToFour   = netip.MustParsePrefix("2002::/16")    // 6to4, with the IPv4 address in the 32 bits after the prefix
+)
+
+// isPublicAddr reports whether ip is a globally routable unicast address.
+// IPv6 addresses that are translated or tunnelled to an IPv4 address are
+// public only if that address is.
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if nat64Prefix.Contains(ip) {
+		b := ip.As16()
+		return isPublicAddr(netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]}))
+	}
+	if sixToFour.Contains(ip) {
+		b := ip.As16()
+		return isPublicAddr(netip.AddrFrom4([4]byte{b[2], b[3], b[4], b[5]}))
+	}
+	if !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublicOnly is a net.Dialer Control function refusing connections to
+// addresses that aren't public.
+func dialPublicOnly(network, address string, c syscall.RawConn) error {
+	if allowPrivateAddresses {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || !isPublicAddr(addrPort.Addr()) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// newPublicClient returns a client that only connects to public addresses.
+// It ignores proxy settings, which would otherwise make the proxy's address
+// the one checked.
+func newPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: dialPublicOnly}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestPublicClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if _, err := newPublicClient(time.Second).Get(server.URL); err == nil {
+		t.Error("request to a loopback address succeeded")
+	}
+	for addr, public := range map[string]bool{
+		"93.184.216.34": true, "2606:4700::1": true,
+		"127.0.0.1": false, "10.1.2.3": false, "169.254.169.254": false, "100.64.0.1": false,
+		"::1": false, "fd00::1": false, "fe80::1": false, "::ffff:192.168.0.1": false, "0.0.0.0": false,
+		"192.0.2.1": false, "198.51.100.1": false, "203.0.113.1": false,
+		// NAT64 and 6to4 addresses are as public as the IPv4 address they lead to
+		"64:ff9b::a00:1": false, "64:ff9b::7f00:1": false, "64:ff9b::5db8:d822": true, "64:ff9b:1::5db8:d822": false,
+		"2002:a00:1::1": false, "2002:a9fe:a9fe::1": false, "2002:5db8:d822::1": true,
+	} {
+		if got := isPublicAddr(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", addr, got, public)
+		}
+	}
+}
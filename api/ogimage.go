@@ -0,0 +1,608 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // Decoders for avatars and project images
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/webp"
+)
+
+// Open Graph images are the previews shown when a portfolio, project or post
+// is shared. They are rendered from the item's current content and cached on
+// disk by a hash of that content, so a stable URL always serves an image that
+// is up to date.
+
+const (
+	ogWidth  = 1200
+	ogHeight = 630
+	// ogTemplateVersion is part of the content hash; changing the templates
+	// must change it so cached images are rendered again.
+	ogTemplateVersion = "1"
+	ogCacheDir        = "./public/og"
+	maxOGSourceImage  = 5 << 20     // Bytes of an avatar or project image that are read
+	maxOGSourcePixels = 4096 * 4096 // Pixels of an avatar or project image that are decoded
+)
+
+// ogClient fetches avatars and project images hosted elsewhere.
+var ogClient = newPublicClient(5 * time.Second)
+
+var ogRegular, ogBold *opentype.Font
+
+func init() {
+	var err error
+	if ogRegular, err = opentype.Parse(goregular.TTF); err != nil {
+		log.Fatalf("Failed to parse font: %v", err)
+	}
+	if ogBold, err = opentype.Parse(gobold.TTF); err != nil {
+		log.Fatalf("Failed to parse font: %v", err)
+	}
+}
+
+// ogCard is the content of an Open Graph image. Everything that affects the
+// rendered image is in it, as it is what the cache is keyed by.
+type ogCard struct {
+	Template   string // "portfolio", "project" or "post"
+	Title      string
+	Subtitle   string
+	Footer     string
+	AvatarURL  string
+	ImageURL   string
+	Accent     string
+	Background string
+	Text       string
+}
+
+// Hash returns the key the card's image is cached under.
+func (c ogCard) Hash() string {
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(append([]byte(ogTemplateVersion), data...))
+	return hex.EncodeToString(sum[:16])
+}
+
+// ogColors returns the colors of a portfolio's theme, which cards use.
+func ogColors(portfolio Portfolio, card *ogCard) {
+	_, settings := portfolioTheme(portfolio)
+	card.Accent, _ = settings["accent_color"].(string)
+	card.Background, _ = settings["background_color"].(string)
+	card.Text, _ = settings["text_color"].(string)
+}
+
+// parseHexColor parses a "#rrggbb" color, falling back for anything else.
+func parseHexColor(s string, fallback color.RGBA) color.RGBA {
+	if !themeColorPattern.MatchString(s) {
+		return fallback
+	}
+	v, _ := strconv.ParseUint(s[1:], 16, 32)
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+}
+
+// mixColor blends a towards b by t, between 0 and 1.
+func mixColor(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 { return uint8(float64(x)*(1-t) + float64(y)*t) }
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
+
+func ogFace(f *opentype.Font, size float64) font.Face {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		log.Printf("Failed to create font face: %v", err)
+		return nil
+	}
+	return face
+}
+
+// ogWrap breaks s into at most maxLines lines no wider than width, ending the
+// last with an ellipsis if the text doesn't fit.
+func ogWrap(face font.Face, s string, width, maxLines int) []string {
+	limit := fixed.I(width)
+	fits := func(line string) bool { return font.MeasureString(face, line) <= limit }
+
+	var lines []string
+	line := ""
+	words := strings.Fields(s)
+	for len(words) > 0 {
+		candidate := words[0]
+		if line != "" {
+			candidate = line + " " + words[0]
+		}
+		if fits(candidate) {
+			line = candidate
+			words = words[1:]
+			continue
+		}
+		if line == "" {
+			// A word too long for a line of its own is split
+			runes := []rune(words[0])
+			n := len(runes) - 1
+			if n < 1 {
+				n = 1
+			}
+			for n > 1 && !fits(string(runes[:n])) {
+				n--
+			}
+			line = string(runes[:n])
+			words[0] = string(runes[n:])
+		}
+		if len(lines) == maxLines-1 {
+			return ellipsize(face, append(lines, line), limit)
+		}
+		lines = append(lines, line)
+		line = ""
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// ellipsize ends the last line with an ellipsis, shortening it to fit.
+func ellipsize(face font.Face, lines []string, limit fixed.Int26_6) []string {
+	last := []rune(lines[len(lines)-1])
+	for len(last) > 0 && font.MeasureString(face, string(last)+"…") > limit {
+		last = last[:len(last)-1]
+	}
+	lines[len(lines)-1] = strings.TrimRight(string(last), " ,.;:") + "…"
+	return lines
+}
+
+// drawText draws lines starting with the first baseline at y, returning the
+// baseline after the last line.
+func drawText(dst draw.Image, face font.Face, c color.Color, x, y int, lineHeight int, lines []string) int {
+	d := font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face}
+	for _, line := range lines {
+		d.Dot = fixed.P(x, y)
+		d.DrawString(line)
+		y += lineHeight
+	}
+	return y
+}
+
+// circleMask is an opaque disc the size of its bounds.
+type circleMask struct{ r image.Rectangle }
+
+func (m circleMask) ColorModel() color.Model { return color.AlphaModel }
+func (m circleMask) Bounds() image.Rectangle { return m.r }
+func (m circleMask) At(x, y int) color.Color {
+	radius := float64(m.r.Dx()) / 2
+	dx := float64(x-m.r.Min.X) + 0.5 - radius
+	dy := float64(y-m.r.Min.Y) + 0.5 - radius
+	if dx*dx+dy*dy <= radius*radius {
+		return color.Alpha{0xff}
+	}
+	return color.Alpha{0}
+}
+
+// drawCover scales src to fill rect, cropping whatever doesn't fit, and draws
+// it through mask if there is one.
+func drawCover(dst draw.Image, rect image.Rectangle, src image.Image, mask image.Image) {
+	b := src.Bounds()
+	crop := b
+	if b.Dx()*rect.Dy() > b.Dy()*rect.Dx() {
+		w := b.Dy() * rect.Dx() / rect.Dy()
+		crop.Min.X = b.Min.X + (b.Dx()-w)/2
+		crop.Max.X = crop.Min.X + w
+	} else {
+		h := b.Dx() * rect.Dy() / rect.Dx()
+		crop.Min.Y = b.Min.Y + (b.Dy()-h)/2
+		crop.Max.Y = crop.Min.Y + h
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), src, crop, draw.Src, nil)
+	if mask == nil {
+		draw.Draw(dst, rect, scaled, image.Point{}, draw.Over)
+		return
+	}
+	draw.DrawMask(dst, rect, scaled, image.Point{}, mask, rect.Min, draw.Over)
+}
+
+// loadCardImage loads an uploaded image or fetches one hosted elsewhere. It
+// returns nil if the image can't be had, and the card is drawn without it.
+func loadCardImage(ref string) image.Image {
+	var r io.Reader
+	switch {
+	case ref == "":
+		return nil
+	case strings.HasPrefix(ref, "/uploads/"):
+		f, err := os.Open(filepath.Join("./public/uploads", filepath.Base(ref)))
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		r = f
+	case strings.HasPrefix(ref, "http://"), strings.HasPrefix(ref, "https://"):
+		resp, err := ogClient.Get(ref)
+		if err != nil {
+			return nil
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil
+		}
+		r = resp.Body
+	default:
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxOGSourceImage))
+	if err != nil {
+		return nil
+	}
+	// A small file can declare a huge image; check before decoding it
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 || config.Width > maxOGSourcePixels/config.Height {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// renderOGCard draws a card with its template.
+func renderOGCard(card ogCard) *image.RGBA {
+	background := parseHexColor(card.Background, color.RGBA{0xff, 0xff, 0xff, 0xff})
+	text := parseHexColor(card.Text, color.RGBA{0x1f, 0x29, 0x33, 0xff})
+	accent := parseHexColor(card.Accent, color.RGBA{0x25, 0x63, 0xeb, 0xff})
+	muted := mixColor(text, background, 0.4)
+
+	img := image.NewRGBA(image.Rect(0, 0, ogWidth, ogHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	avatar := loadCardImage(card.AvatarURL)
+	const margin = 80
+
+	switch card.Template {
+	case "project":
+		// Project image on the right, text on the left
+		textWidth := ogWidth - 2*margin
+		if picture := loadCardImage(card.ImageURL); picture != nil {
+			drawCover(img, image.Rect(ogWidth-520, 0, ogWidth, ogHeight), picture, nil)
+			textWidth = ogWidth - 520 - 2*margin
+		}
+		draw.Draw(img, image.Rect(0, 0, 16, ogHeight), image.NewUniform(accent), image.Point{}, draw.Src)
+		title := ogFace(ogBold, 60)
+		subtitle := ogFace(ogRegular, 30)
+		y := drawText(img, title, text, margin, 170, 72, ogWrap(title, card.Title, textWidth, 3))
+		drawText(img, subtitle, muted, margin, y+10, 40, ogWrap(subtitle, card.Subtitle, textWidth, 3))
+		ogFooter(img, avatar, card.Footer, accent, margin, textWidth)
+
+	case "post":
+		draw.Draw(img, image.Rect(0, 0, ogWidth, 14), image.NewUniform(accent), image.Point{}, draw.Src)
+		title := ogFace(ogBold, 66)
+		subtitle := ogFace(ogRegular, 30)
+		y := drawText(img, title, text, margin, 160, 78, ogWrap(title, card.Title, ogWidth-2*margin, 3))
+		drawText(img, subtitle, muted, margin, y+10, 40, ogWrap(subtitle, card.Subtitle, ogWidth-2*margin, 2))
+		ogFooter(img, avatar, card.Footer, accent, margin, ogWidth-2*margin)
+
+	default: // portfolio
+		textWidth := ogWidth - 2*margin
+		if avatar != nil {
+			const size = 260
+			rect := image.Rect(ogWidth-margin-size, (ogHeight-size)/2, ogWidth-margin, (ogHeight+size)/2)
+			ring := rect.Inset(-8)
+			draw.DrawMask(img, ring, image.NewUniform(accent), image.Point{}, circleMask{ring}, ring.Min, draw.Over)
+			drawCover(img, rect, avatar, circleMask{rect})
+			textWidth -= size + 60
+		}
+		draw.Draw(img, image.Rect(0, 0, 16, ogHeight), image.NewUniform(accent), image.Point{}, draw.Src)
+		title := ogFace(ogBold, 68)
+		subtitle := ogFace(ogRegular, 32)
+		y := drawText(img, title, text, margin, 200, 80, ogWrap(title, card.Title, textWidth, 3))
+		drawText(img, subtitle, muted, margin, y+10, 44, ogWrap(subtitle, card.Subtitle, textWidth, 3))
+		ogFooter(img, nil, card.Footer, accent, margin, textWidth)
+	}
+	return img
+}
+
+// ogFooter draws the footer line along the bottom of a card, after a small
+// round avatar if there is one.
+func ogFooter(img *image.RGBA, avatar image.Image, footer string, c color.Color, x, width int) {
+	const size = 64
+	top := ogHeight - 60 - size
+	if avatar != nil {
+		rect := image.Rect(x, top, x+size, top+size)
+		drawCover(img, rect, avatar, circleMask{rect})
+		x += size + 20
+		width -= size + 20
+	}
+	face := ogFace(ogBold, 28)
+	drawText(img, face, c, x, top+size/2+10, 0, ogWrap(face, footer, width, 1))
+}
+
+// ogImage returns the PNG for a card, rendering and caching it if needed.
+func ogImage(card ogCard) ([]byte, error) {
+	path := filepath.Join(ogCacheDir, card.Hash()+".png")
+	if data, err := os.ReadFile(path); err == nil {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, renderOGCard(card)); err != nil {
+		return nil, err
+	}
+
+	// Written to a temporary file first so a concurrent request never reads
+	// half an image
+	if err := os.MkdirAll(ogCacheDir, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(ogCacheDir, "render-*.png")
+	if err != nil {
+		return nil, err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Failed to cache Open Graph image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ogSubject is something that can be shared, with the card and page it has.
+type ogSubject struct {
+	Card        ogCard
+	Type        string // Open Graph type, "profile", "website" or "article"
+	Title       string
+	Description string
+	PageURL     string
+	ImagePath   string // Path of the stable image URL
+	Modified    time.Time
+	Portfolio   *Portfolio // The portfolio shown, whose visibility applies
+	Public      bool       // Visible to anyone, without a password, share link or being the owner
+}
+
+// findOGSubject loads the portfolio, project or post a request is for. A
+// status of 0 means it was found and may be shown to the caller.
+func findOGSubject(r *http.Request) (ogSubject, int) {
+	vars := mux.Vars(r)
+	base := publicBaseURL(r)
+
+	switch vars["kind"] {
+	case "portfolio":
+		var user User
+		if result := DB.Where("username = ?", vars["key"]).First(&user); result.Error != nil {
+			return ogSubject{}, http.StatusNotFound
+		}
+		var portfolio Portfolio
+		query := DB.Where("user_id = ?", user.ID)
+		slug := r.URL.Query().Get("portfolio")
+		if slug != "" {
+			query = query.Where("slug = ?", slug)
+		}
+		if result := query.Order("is_default desc, id asc").First(&portfolio); result.Error != nil {
+			return ogSubject{}, http.StatusNotFound
+		}
+		if status := checkPortfolioAccess(r, portfolio); status != 0 {
+			return ogSubject{}, http.StatusNotFound
+		}
+
+		subject := ogSubject{
+			Type:        "profile",
+			Title:       portfolio.Title,
+			Description: firstNonEmpty(portfolio.Description, user.Bio),
+			PageURL:     portfolioURL(base, user.Username),
+			ImagePath:   "/api/og/portfolio/" + user.Username + ".png",
+			Modified:    portfolio.UpdatedAt,
+			Portfolio:   &portfolio,
+			Public:      portfolioPublic(portfolio),
+		}
+		if slug != "" {
+			subject.PageURL += "?portfolio=" + url.QueryEscape(portfolio.Slug)
+			subject.ImagePath += "?portfolio=" + url.QueryEscape(portfolio.Slug)
+		}
+		subject.Card = ogCard{
+			Template:  "portfolio",
+			Title:     firstNonEmpty(portfolio.Title, user.Username),
+			Subtitle:  subject.Description,
+			Footer:    "@" + user.Username,
+			AvatarURL: user.ProfilePictureURL,
+		}
+		ogColors(portfolio, &subject.Card)
+		return subject, 0
+
+	case "project":
+		var project Project
+		if result := DB.First(&project, vars["key"]); result.Error != nil {
+			return ogSubject{}, http.StatusNotFound
+		}
+		var owner Portfolio
+		if result := DB.First(&owner, project.PortfolioID); result.Error != nil {
+			return ogSubject{}, http.StatusNotFound
+		}
+		var user User
+		if result := DB.First(&user, owner.UserID); result.Error != nil {
+			return ogSubject{}, http.StatusNotFound
+		}
+		if !projectVisible(r, user.ID, project) {
+			return ogSubject{}, http.StatusNotFound
+		}
+
+		subject := ogSubject{
+			Type:        "website",
+			Title:       project.Title,
+			Description: project.Description,
+			// Projects are shown on their owner's portfolio
+			PageURL:   portfolioURL(base, user.Username),
+			ImagePath: fmt.Sprintf("/api/og/project/%d.png", project.ID),
+			Modified:  project.UpdatedAt,
+			Public:    projectPublic(user.ID, project),
+		}
+		subject.Card = ogCard{
+			Template:  "project",
+			Title:     project.Title,
+			Subtitle:  firstNonEmpty(project.Technologies, project.Description),
+			Footer:    "@" + user.Username,
+			AvatarURL: user.ProfilePictureURL,
+			ImageURL:  project.ImageURL,
+		}
+		ogColors(owner, &subject.Card)
+		return subject, 0
+
+	case "post":
+		var post Post
+		if result := DB.Where("published_at <= ?", time.Now()).First(&post, vars["key"]); result.Error != nil {
+			return ogSubject{}, http.StatusNotFound
+		}
+		var user User
+		if result := DB.First(&user, post.UserID); result.Error != nil {
+			return ogSubject{}, http.StatusNotFound
+		}
+
+		subject := ogSubject{
+			Type:        "article",
+			Title:       post.Title,
+			Description: post.Excerpt,
+			PageURL:     postURL(base, post),
+			ImagePath:   fmt.Sprintf("/api/og/post/%d.png", post.ID),
+			Modified:    post.UpdatedAt,
+			Public:      true,
+		}
+		footer := "@" + user.Username
+		if post.ReadingTime > 0 {
+			footer += fmt.Sprintf(" · %d min read", post.ReadingTime)
+		}
+		subject.Card = ogCard{
+			Template:  "post",
+			Title:     post.Title,
+			Subtitle:  post.Excerpt,
+			Footer:    footer,
+			AvatarURL: user.ProfilePictureURL,
+		}
+		if portfolio, err := mainPortfolio(DB, user.ID); err == nil {
+			ogColors(portfolio, &subject.Card)
+		}
+		return subject, 0
+	}
+	return ogSubject{}, http.StatusNotFound
+}
+
+// setOGVisibilityHeaders keeps the cards of subjects that aren't public out
+// of search engines and shared caches.
+func setOGVisibilityHeaders(w http.ResponseWriter, subject ogSubject) {
+	if subject.Portfolio != nil {
+		setPortfolioVisibilityHeaders(w, *subject.Portfolio)
+	}
+	if !subject.Public {
+		w.Header().Set("X-Robots-Tag", "noindex")
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// GetOGImage handles serving the Open Graph image of a portfolio, project or
+// post. The URL stays the same as the content changes; the ETag doesn't.
+func GetOGImage(w http.ResponseWriter, r *http.Request) {
+	subject, status := findOGSubject(r)
+	if status != 0 {
+		http.Error(w, "Not found", status)
+		return
+	}
+
+	hash := subject.Card.Hash()
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	setOGVisibilityHeaders(w, subject)
+	if notModified(w, r, hash, time.Time{}) {
+		return
+	}
+
+	data, err := ogImage(subject.Card)
+	if err != nil {
+		http.Error(w, "Failed to render image", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// OGMetaTag is a meta tag to embed in a page's head.
+type OGMetaTag struct {
+	Property string `json:"property,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Content  string `json:"content"`
+}
+
+// GetOGMeta handles listing the Open Graph and Twitter card meta tags for a
+// portfolio, project or post, both as a list and as HTML to embed.
+func GetOGMeta(w http.ResponseWriter, r *http.Request) {
+	subject, status := findOGSubject(r)
+	if status != 0 {
+		http.Error(w, "Not found", status)
+		return
+	}
+	setOGVisibilityHeaders(w, subject)
+
+	image := apiBaseURL(r) + subject.ImagePath
+	tags := []OGMetaTag{
+		{Property: "og:type", Content: subject.Type},
+		{Property: "og:title", Content: subject.Title},
+		{Property: "og:description", Content: truncateWords(strings.Fields(subject.Description), 300)},
+		{Property: "og:url", Content: subject.PageURL},
+		{Property: "og:image", Content: image},
+		{Property: "og:image:type", Content: "image/png"},
+		{Property: "og:image:width", Content: strconv.Itoa(ogWidth)},
+		{Property: "og:image:height", Content: strconv.Itoa(ogHeight)},
+		{Property: "og:image:alt", Content: subject.Title},
+		{Name: "twitter:card", Content: "summary_large_image"},
+		{Name: "twitter:title", Content: subject.Title},
+		{Name: "twitter:description", Content: truncateWords(strings.Fields(subject.Description), 200)},
+		{Name: "twitter:image", Content: image},
+	}
+	if subject.Type == "article" {
+		tags = append(tags, OGMetaTag{Property: "article:modified_time", Content: subject.Modified.UTC().Format(time.RFC3339)})
+	}
+
+	var sb strings.Builder
+	for _, tag := range tags {
+		if tag.Property != "" {
+			fmt.Fprintf(&sb, "<meta property=\"%s\" content=\"%s\">\n", tag.Property, html.EscapeString(tag.Content))
+		} else {
+			fmt.Fprintf(&sb, "<meta name=\"%s\" content=\"%s\">\n", tag.Name, html.EscapeString(tag.Content))
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Tags []OGMetaTag `json:"tags"`
+		HTML string      `json:"html"`
+	}{tags, sb.String()})
+}
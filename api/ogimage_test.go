@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadCardImagePixelBudget(t *testing.T) {
+	var small bytes.Buffer
+	if err := gif.Encode(&small, image.NewPaletted(image.Rect(0, 0, 2, 2), []color.Color{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+	// The same file claiming a 65535x65535 logical screen
+	huge := append([]byte{}, small.Bytes()...)
+	binary.LittleEndian.PutUint16(huge[6:], 65535)
+	binary.LittleEndian.PutUint16(huge[8:], 65535)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small.gif":
+			w.Write(small.Bytes())
+		case "/huge.gif":
+			w.Write(huge)
+		}
+	}))
+	defer server.Close()
+	allowPrivateAddresses = true
+	defer func() { allowPrivateAddresses = false }()
+
+	if img := loadCardImage(server.URL + "/small.gif"); img == nil {
+		t.Error("small image not loaded")
+	}
+	if img := loadCardImage(server.URL + "/huge.gif"); img != nil {
+		t.Errorf("image of %v loaded", img.Bounds())
+	}
+
+	allowPrivateAddresses = false
+	ogClient.CloseIdleConnections() // Connections are checked when dialled
+	if img := loadCardImage(server.URL + "/small.gif"); img != nil {
+		t.Error("image loaded from a loopback address")
+	}
+}
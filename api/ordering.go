@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// errOrderMismatch is returned when a reorder request doesn't list each of
+// the portfolio's items exactly once.
+var errOrderMismatch = errors.New("order must list every item of the portfolio exactly once")
+
+// nextPosition returns the position that places a new project or achievement
+// after every existing one of the portfolio.
+func nextPosition(tx *gorm.DB, model interface{}, portfolioID uint) (int, error) {
+	return nextPositionIn(tx, model, "portfolio_id", portfolioID)
+}
+
+// nextPositionIn returns the position that places a new item after every
+// existing one whose owner column holds ownerID.
+func nextPositionIn(tx *gorm.DB, model interface{}, ownerColumn string, ownerID uint) (int, error) {
+	var last int
+	result := tx.Model(model).Where(ownerColumn+" = ?", ownerID).Select("COALESCE(MAX(position), 0)").Scan(&last)
+	return last + 1, result.Error
+}
+
+// applyOrder numbers a portfolio's projects or achievements in the order of
+// ids, which must list each of them exactly once.
+func applyOrder(tx *gorm.DB, model interface{}, portfolioID uint, ids []uint) error {
+	return applyOrderIn(tx, model, "portfolio_id", portfolioID, ids)
+}
+
+// applyOrderIn numbers the items whose owner column holds ownerID in the
+// order of ids, which must list each of them exactly once.
+func applyOrderIn(tx *gorm.DB, model interface{}, ownerColumn string, ownerID uint, ids []uint) error {
+	if len(uniqueIDs(ids)) != len(ids) {
+		return errOrderMismatch
+	}
+
+	var existing []uint
+	if result := tx.Model(model).Where(ownerColumn+" = ?", ownerID).Pluck("id", &existing); result.Error != nil {
+		return result.Error
+	}
+	if len(existing) != len(ids) {
+		return errOrderMismatch
+	}
+	owned := make(map[uint]bool)
+	for _, id := range existing {
+		owned[id] = true
+	}
+	for _, id := range ids {
+		if !owned[id] {
+			return errOrderMismatch
+		}
+	}
+
+	for i, id := range ids {
+		if result := tx.Model(model).Where("id = ?", id).UpdateColumn("position", i+1); result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+// ReorderProjects handles setting the order of the projects of the
+// authenticated user's portfolio. The request lists every project id in the
+// new order; the whole order is applied or none of it is.
+func ReorderProjects(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		ProjectIDs []uint `json:"project_ids"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		return applyOrder(tx, &Project{}, portfolio.ID, req.ProjectIDs)
+	})
+	if errors.Is(err, errOrderMismatch) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reorder projects", http.StatusInternalServerError)
+		return
+	}
+
+	var projects []Project
+	if result := DB.Where("portfolio_id = ?", portfolio.ID).Order("position asc, id asc").Find(&projects); result.Error != nil {
+		http.Error(w, "Failed to retrieve projects", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(projects)
+}
+
+// ReorderAchievements handles setting the order of the achievements of the
+// authenticated user's portfolio. The request lists every achievement id in
+// the new order; the whole order is applied or none of it is.
+func ReorderAchievements(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		AchievementIDs []uint `json:"achievement_ids"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		return applyOrder(tx, &Achievement{}, portfolio.ID, req.AchievementIDs)
+	})
+	if errors.Is(err, errOrderMismatch) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reorder achievements", http.StatusInternalServerError)
+		return
+	}
+
+	var achievements []Achievement
+	if result := DB.Where("portfolio_id = ?", portfolio.ID).Order("position asc, id asc").Find(&achievements); result.Error != nil {
+		http.Error(w, "Failed to retrieve achievements", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(achievements)
+}
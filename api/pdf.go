@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// A minimal PDF writer for generated documents such as résumés. It only uses
+// the standard Type 1 fonts every PDF reader provides, so nothing needs to be
+// embedded, and text is encoded as WinAnsi.
+
+// Page sizes in points.
+const (
+	pdfA4Width      = 595.28
+	pdfA4Height     = 841.89
+	pdfLetterWidth  = 612
+	pdfLetterHeight = 792
+)
+
+// pdfFont is one of the standard fonts.
+type pdfFont int
+
+const (
+	pdfHelvetica pdfFont = iota
+	pdfHelveticaBold
+	pdfTimes
+	pdfTimesBold
+)
+
+var pdfFontNames = []string{"Helvetica", "Helvetica-Bold", "Times-Roman", "Times-Bold"}
+
+// pdfFontWidths holds the widths of the printable ASCII characters, from the
+// space to the tilde, in thousandths of the font size.
+var pdfFontWidths = [][95]int{
+	pdfHelvetica: {
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	},
+	pdfHelveticaBold: {
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	},
+	pdfTimes: {
+		250, 333, 408, 500, 500, 833, 778, 180, 333, 333, 500, 564, 250, 333, 250, 278,
+		500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 278, 278, 564, 564, 564, 444,
+		921, 722, 667, 667, 722, 611, 556, 722, 722, 333, 389, 722, 611, 889, 722, 722,
+		556, 722, 667, 556, 611, 722, 722, 944, 722, 722, 611, 333, 278, 333, 469, 500,
+		333, 444, 500, 444, 500, 444, 333, 500, 500, 278, 278, 500, 278, 778, 500, 500,
+		500, 500, 333, 389, 278, 500, 500, 722, 500, 500, 444, 480, 200, 480, 541,
+	},
+	pdfTimesBold: {
+		250, 333, 555, 500, 500, 1000, 833, 278, 333, 333, 500, 570, 250, 333, 250, 278,
+		500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 333, 333, 570, 570, 570, 500,
+		930, 722, 667, 722, 722, 667, 611, 778, 778, 389, 500, 778, 667, 944, 722, 778,
+		611, 778, 722, 556, 667, 722, 722, 1000, 722, 722, 667, 333, 278, 333, 581, 500,
+		333, 500, 556, 444, 556, 444, 333, 500, 556, 278, 333, 556, 278, 833, 556, 500,
+		556, 556, 444, 389, 333, 556, 500, 722, 500, 500, 444, 394, 220, 394, 520,
+	},
+}
+
+// pdfWinAnsi maps the characters outside Latin-1 that WinAnsi encoding has.
+var pdfWinAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91,
+	'’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98,
+	'™': 0x99, 'š': 0x9a, '›': 0x9b, 'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// pdfEncode converts s to WinAnsi, replacing characters it lacks with '?'.
+func pdfEncode(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			out = append(out, ' ')
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			out = append(out, byte(r))
+		default:
+			if b, ok := pdfWinAnsi[r]; ok {
+				out = append(out, b)
+			} else {
+				out = append(out, '?')
+			}
+		}
+	}
+	return out
+}
+
+// pdfTextWidth returns the width of s in points.
+func pdfTextWidth(font pdfFont, size float64, s string) float64 {
+	widths := pdfFontWidths[font]
+	total := 0
+	for _, b := range pdfEncode(s) {
+		if b >= 0x20 && b < 0x7f {
+			total += widths[b-0x20]
+		} else {
+			// Accented letters and punctuation are close to a lowercase "o"
+			total += widths['o'-0x20]
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// pdfWrap breaks s into lines no wider than width. Words too long for a line
+// of their own are split.
+func pdfWrap(font pdfFont, size float64, s string, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if pdfTextWidth(font, size, candidate) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			for pdfTextWidth(font, size, word) > width {
+				runes := []rune(word)
+				n := len(runes) - 1
+				for n > 1 && pdfTextWidth(font, size, string(runes[:n])) > width {
+					n--
+				}
+				lines = append(lines, string(runes[:n]))
+				word = string(runes[n:])
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// pdfColor is an RGB color with components between 0 and 1.
+type pdfColor struct{ R, G, B float64 }
+
+// pdfDocument is a PDF being drawn. Coordinates are in points from the
+// bottom-left corner of the page.
+type pdfDocument struct {
+	Width, Height float64
+	Title         string
+	Author        string
+	pages         []*bytes.Buffer
+	current       int // Index of the page being drawn on
+}
+
+func newPDFDocument(width, height float64) *pdfDocument {
+	return &pdfDocument{Width: width, Height: height}
+}
+
+// AddPage starts a new page; later drawing goes to it.
+func (d *pdfDocument) AddPage() {
+	d.pages = append(d.pages, new(bytes.Buffer))
+	d.current = len(d.pages) - 1
+}
+
+// PageCount returns the number of pages added so far.
+func (d *pdfDocument) PageCount() int {
+	return len(d.pages)
+}
+
+// SetPage makes later drawing go to an earlier page, counting from 1.
+func (d *pdfDocument) SetPage(n int) {
+	d.current = n - 1
+}
+
+func (d *pdfDocument) page() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	return d.pages[d.current]
+}
+
+// Text draws s with its baseline starting at (x, y).
+func (d *pdfDocument) Text(x, y float64, font pdfFont, size float64, color pdfColor, s string) {
+	if s == "" {
+		return
+	}
+	fmt.Fprintf(d.page(), "BT %.3f %.3f %.3f rg /F%d %.2f Tf %.2f %.2f Td (%s) Tj ET\n",
+		color.R, color.G, color.B, font+1, size, x, y, pdfEscape(pdfEncode(s)))
+}
+
+// Line draws a straight line.
+func (d *pdfDocument) Line(x1, y1, x2, y2, width float64, color pdfColor) {
+	fmt.Fprintf(d.page(), "%.3f %.3f %.3f RG %.2f w %.2f %.2f m %.2f %.2f l S\n",
+		color.R, color.G, color.B, width, x1, y1, x2, y2)
+}
+
+// Rect fills a rectangle.
+func (d *pdfDocument) Rect(x, y, width, height float64, color pdfColor) {
+	fmt.Fprintf(d.page(), "%.3f %.3f %.3f rg %.2f %.2f %.2f %.2f re f\n",
+		color.R, color.G, color.B, x, y, width, height)
+}
+
+func pdfEscape(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch c {
+		case '(', ')', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// pdfString encodes s as a PDF text string for the document information.
+func pdfString(s string) string {
+	return "(" + pdfEscape(pdfEncode(s)) + ")"
+}
+
+// WriteTo writes the finished document.
+func (d *pdfDocument) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1 and 2 are the catalog and page tree, followed by the fonts,
+	// the document information and a page and content stream per page.
+	firstFont := 3
+	info := firstFont + len(pdfFontNames)
+	firstPage := info + 1
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+2*i))
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Count %d /Kids [%s] >>", len(d.pages), strings.Join(kids, " ")))
+
+	var fonts []string
+	for i, name := range pdfFontNames {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fonts = append(fonts, fmt.Sprintf("/F%d %d 0 R", i+1, firstFont+i))
+	}
+
+	object(fmt.Sprintf("<< /Title %s /Author %s /Producer (Portfolio) /CreationDate (D:%s) >>",
+		pdfString(d.Title), pdfString(d.Author), time.Now().UTC().Format("20060102150405Z")))
+
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			d.Width, d.Height, strings.Join(fonts, " "), firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, info, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
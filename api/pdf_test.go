@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPDFEncode(t *testing.T) {
+	tests := map[string]string{
+		"plain":     "plain",
+		"tab\there": "tab here",
+		"café":      "caf\xe9",
+		"€5 – “ok”": "\x805 \x96 \x93ok\x94",
+		"日本":        "??",
+		"line\nend": "line?end",
+	}
+	for in, want := range tests {
+		if got := string(pdfEncode(in)); got != want {
+			t.Errorf("pdfEncode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPDFEscape(t *testing.T) {
+	tests := map[string]string{
+		"plain":        "plain",
+		"(aside)":      `\(aside\)`,
+		`back\slash`:   `back\\slash`,
+		"caf\xe9 (\\)": `caf` + "\xe9" + ` \(\\\)`,
+	}
+	for in, want := range tests {
+		if got := pdfEscape([]byte(in)); got != want {
+			t.Errorf("pdfEscape(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPDFWrap(t *testing.T) {
+	width := pdfTextWidth(pdfHelvetica, 10, "hello world")
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"hello world", []string{"hello world"}},
+		{"hello world again", []string{"hello world", "again"}},
+		{"  hello   world  ", []string{"hello world"}},
+		{"hello\n\nworld", []string{"hello", "", "world"}},
+		{"", []string{""}},
+	}
+	for _, test := range tests {
+		got := pdfWrap(pdfHelvetica, 10, test.in, width)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("pdfWrap(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestPDFWrapSplitsLongWords(t *testing.T) {
+	word := strings.Repeat("abcdefghij", 10)
+	width := pdfTextWidth(pdfTimes, 12, "abcdefghij")
+	lines := pdfWrap(pdfTimes, 12, word, width)
+	if len(lines) < 10 {
+		t.Fatalf("pdfWrap() = %q, want the word split over at least 10 lines", lines)
+	}
+	for _, line := range lines {
+		if pdfTextWidth(pdfTimes, 12, line) > width {
+			t.Errorf("line %q is wider than %.2f", line, width)
+		}
+	}
+	if got := strings.Join(lines, ""); got != word {
+		t.Errorf("pdfWrap() lost characters: %q", got)
+	}
+}
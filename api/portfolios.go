@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// errSelectionNotOwned is returned when a portfolio is given a project or
+// achievement its owner doesn't have.
+var errSelectionNotOwned = errors.New("selected project or achievement not found")
+
+// mainPortfolio returns a user's main portfolio, the one owning their
+// projects and achievements.
+func mainPortfolio(tx *gorm.DB, userID uint) (Portfolio, error) {
+	var portfolio Portfolio
+	result := tx.Where("user_id = ?", userID).Order("id asc").First(&portfolio)
+	return portfolio, result.Error
+}
+
+// backfillDefaultPortfolios marks the main portfolio of users without a
+// default portfolio as their default, as it was before users could have
+// several portfolios.
+func backfillDefaultPortfolios() {
+	result := DB.Exec(`UPDATE portfolios SET is_default = true
+		WHERE id IN (SELECT MIN(id) FROM portfolios WHERE deleted_at IS NULL GROUP BY user_id)
+		AND user_id NOT IN (SELECT user_id FROM portfolios WHERE is_default AND deleted_at IS NULL)`)
+	if result.Error != nil {
+		log.Printf("Failed to mark default portfolios: %v", result.Error)
+	}
+}
+
+// portfolioContents loads the projects, with their likes, and the
+// achievements a portfolio shows, in the order their owner chose. Featured
+// projects are pinned first.
+func portfolioContents(portfolio Portfolio) ([]Project, []Achievement, error) {
+	primary, err := mainPortfolio(DB, portfolio.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	projectQuery := DB.Preload("Likes").Preload("Media", orderedMedia).Preload("Repository").Where("portfolio_id = ?", primary.ID)
+	achievementQuery := DB.Where("portfolio_id = ?", primary.ID)
+	if portfolio.ID != primary.ID {
+		projectQuery = projectQuery.Where("id IN (?)",
+			DB.Model(&PortfolioProject{}).Select("project_id").Where("portfolio_id = ?", portfolio.ID))
+		achievementQuery = achievementQuery.Where("id IN (?)",
+			DB.Model(&PortfolioAchievement{}).Select("achievement_id").Where("portfolio_id = ?", portfolio.ID))
+	}
+
+	var projects []Project
+	if result := projectQuery.Order("featured desc, position asc, id asc").Find(&projects); result.Error != nil {
+		return nil, nil, result.Error
+	}
+	var achievements []Achievement
+	if result := achievementQuery.Order("position asc, id asc").Find(&achievements); result.Error != nil {
+		return nil, nil, result.Error
+	}
+	return projects, achievements, nil
+}
+
+// GetPortfolioBySlug handles getting one of a user's portfolios by its slug.
+// Old slugs redirect permanently to the portfolio's current URL.
+func GetPortfolioBySlug(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var user User
+	if result := DB.Where("username = ?", vars["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var portfolio Portfolio
+	if result := DB.Where("user_id = ? AND slug = ?", user.ID, vars["slug"]).First(&portfolio); result.Error != nil {
+		targetID, ok := findSlugRedirect(slugKindPortfolio, user.ID, vars["slug"])
+		if ok && DB.Where("user_id = ?", user.ID).First(&portfolio, targetID).Error == nil && checkPortfolioAccess(r, portfolio) != http.StatusNotFound {
+			target := "/api/portfolio/" + user.Username + "/" + portfolio.Slug
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		http.Error(w, "Portfolio not found", http.StatusNotFound)
+		return
+	}
+
+	writePublicPortfolio(w, r, user, portfolio)
+}
+
+// OwnPortfolio is one of the authenticated user's portfolios together with
+// the projects and achievements it shows.
+type OwnPortfolio struct {
+	Portfolio
+	Main           bool   `json:"main"`
+	ProjectIDs     []uint `json:"project_ids"`
+	AchievementIDs []uint `json:"achievement_ids"`
+}
+
+// toOwnPortfolio lists the projects and achievements a portfolio shows.
+func toOwnPortfolio(portfolio Portfolio, mainID uint) (OwnPortfolio, error) {
+	own := OwnPortfolio{Portfolio: portfolio, Main: portfolio.ID == mainID, ProjectIDs: []uint{}, AchievementIDs: []uint{}}
+
+	projects := DB.Model(&Project{}).Where("portfolio_id = ?", mainID)
+	achievements := DB.Model(&Achievement{}).Where("portfolio_id = ?", mainID)
+	if !own.Main {
+		projects = projects.Where("id IN (?)",
+			DB.Model(&PortfolioProject{}).Select("project_id").Where("portfolio_id = ?", portfolio.ID))
+		achievements = achievements.Where("id IN (?)",
+			DB.Model(&PortfolioAchievement{}).Select("achievement_id").Where("portfolio_id = ?", portfolio.ID))
+	}
+
+	if result := projects.Order("id asc").Pluck("id", &own.ProjectIDs); result.Error != nil {
+		return own, result.Error
+	}
+	if result := achievements.Order("id asc").Pluck("id", &own.AchievementIDs); result.Error != nil {
+		return own, result.Error
+	}
+	return own, nil
+}
+
+// portfolioRequest is the payload for creating and updating a portfolio.
+// A nil selection leaves the portfolio's projects or achievements unchanged.
+// Password is only used by password-protected portfolios.
+type portfolioRequest struct {
+	Portfolio
+	Password       string `json:"password"`
+	ProjectIDs     []uint `json:"project_ids"`
+	AchievementIDs []uint `json:"achievement_ids"`
+}
+
+// setPortfolioSelection replaces the projects and achievements a portfolio
+// shows. They must belong to the main portfolio of the same user.
+func setPortfolioSelection(tx *gorm.DB, portfolio Portfolio, mainID uint, projectIDs, achievementIDs []uint) error {
+	if projectIDs != nil {
+		projectIDs = uniqueIDs(projectIDs)
+		var owned int64
+		if len(projectIDs) > 0 {
+			if result := tx.Model(&Project{}).Where("id IN ? AND portfolio_id = ?", projectIDs, mainID).Count(&owned); result.Error != nil {
+				return result.Error
+			}
+		}
+		if int(owned) != len(projectIDs) {
+			return errSelectionNotOwned
+		}
+
+		if result := tx.Where("portfolio_id = ?", portfolio.ID).Delete(&PortfolioProject{}); result.Error != nil {
+			return result.Error
+		}
+		for _, id := range projectIDs {
+			if result := tx.Create(&PortfolioProject{PortfolioID: portfolio.ID, ProjectID: id}); result.Error != nil {
+				return result.Error
+			}
+		}
+	}
+
+	if achievementIDs != nil {
+		achievementIDs = uniqueIDs(achievementIDs)
+		var owned int64
+		if len(achievementIDs) > 0 {
+			if result := tx.Model(&Achievement{}).Where("id IN ? AND portfolio_id = ?", achievementIDs, mainID).Count(&owned); result.Error != nil {
+				return result.Error
+			}
+		}
+		if int(owned) != len(achievementIDs) {
+			return errSelectionNotOwned
+		}
+
+		if result := tx.Where("portfolio_id = ?", portfolio.ID).Delete(&PortfolioAchievement{}); result.Error != nil {
+			return result.Error
+		}
+		for _, id := range achievementIDs {
+			if result := tx.Create(&PortfolioAchievement{PortfolioID: portfolio.ID, AchievementID: id}); result.Error != nil {
+				return result.Error
+			}
+		}
+	}
+	return nil
+}
+
+// uniqueIDs drops repeated ids, keeping the first occurrence.
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool)
+	unique := []uint{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// findOwnedPortfolio loads the portfolio identified by the "id" route
+// variable if it belongs to the authenticated user.
+func findOwnedPortfolio(r *http.Request, userID uint) (Portfolio, int, string) {
+	portfolioID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return Portfolio{}, http.StatusBadRequest, "Invalid portfolio ID"
+	}
+
+	var portfolio Portfolio
+	if result := DB.Where("user_id = ?", userID).First(&portfolio, portfolioID); result.Error != nil {
+		return Portfolio{}, http.StatusNotFound, "Portfolio not found or not authorized"
+	}
+	return portfolio, 0, ""
+}
+
+// writeOwnPortfolio writes one of the authenticated user's portfolios with
+// its selection.
+func writeOwnPortfolio(w http.ResponseWriter, portfolio Portfolio, mainID uint) {
+	own, err := toOwnPortfolio(portfolio, mainID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve portfolio", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(own)
+}
+
+// GetOwnPortfolios handles listing the authenticated user's portfolios.
+func GetOwnPortfolios(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var portfolios []Portfolio
+	if result := DB.Where("user_id = ?", userID).Order("id asc").Find(&portfolios); result.Error != nil {
+		http.Error(w, "Failed to retrieve portfolios", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]OwnPortfolio, len(portfolios))
+	for i, portfolio := range portfolios {
+		// The first portfolio is the main one
+		own, err := toOwnPortfolio(portfolio, portfolios[0].ID)
+		if err != nil {
+			http.Error(w, "Failed to retrieve portfolios", http.StatusInternalServerError)
+			return
+		}
+		response[i] = own
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// CreatePortfolio handles creating an additional portfolio for the
+// authenticated user, showing a chosen subset of their projects and
+// achievements.
+func CreatePortfolio(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req portfolioRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || strings.TrimSpace(req.Title) == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	primary, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Portfolio not found for user", http.StatusNotFound)
+		return
+	}
+
+	portfolio := Portfolio{
+		UserID:          userID,
+		Title:           req.Title,
+		Description:     req.Description,
+		AboutMe:         req.AboutMe,
+		ContactInfo:     req.ContactInfo,
+		Visibility:      VisibilityPublic,
+		HideBrokenLinks: req.HideBrokenLinks,
+	}
+	if err := applyTheme(&portfolio, req.Layout, req.ThemeSettings); err != nil {
+		writeSaveError(w, err, "Failed to create portfolio")
+		return
+	}
+	if err := applyVisibility(&portfolio, req.Visibility, req.Password); err != nil {
+		writeSaveError(w, err, "Failed to create portfolio")
+		return
+	}
+	err = saveWithSlug(&portfolio.Slug, func() error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			slug, err := chooseSlug(tx, slugKindPortfolio, userID, 0, "", req.Slug, portfolio.Title)
+			if err != nil {
+				return err
+			}
+			portfolio.Slug = slug
+			if result := tx.Create(&portfolio); result.Error != nil {
+				return result.Error
+			}
+			if req.ProjectIDs == nil {
+				req.ProjectIDs = []uint{}
+			}
+			if req.AchievementIDs == nil {
+				req.AchievementIDs = []uint{}
+			}
+			return setPortfolioSelection(tx, portfolio, primary.ID, req.ProjectIDs, req.AchievementIDs)
+		})
+	})
+	if err != nil {
+		writeSaveError(w, err, "Failed to create portfolio")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeOwnPortfolio(w, portfolio, primary.ID)
+}
+
+// UpdatePortfolioByID handles updating one of the authenticated user's
+// portfolios. The main portfolio always shows every project and achievement,
+// so a selection sent for it is ignored.
+func UpdatePortfolioByID(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, status, msg := findOwnedPortfolio(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var req portfolioRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || strings.TrimSpace(req.Title) == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	primary, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Failed to update portfolio", http.StatusInternalServerError)
+		return
+	}
+
+	portfolio.Title = req.Title
+	portfolio.Description = req.Description
+	portfolio.AboutMe = req.AboutMe
+	portfolio.ContactInfo = req.ContactInfo
+	portfolio.HideBrokenLinks = req.HideBrokenLinks
+	if err := applyTheme(&portfolio, req.Layout, req.ThemeSettings); err != nil {
+		writeSaveError(w, err, "Failed to update portfolio")
+		return
+	}
+	if err := applyVisibility(&portfolio, req.Visibility, req.Password); err != nil {
+		writeSaveError(w, err, "Failed to update portfolio")
+		return
+	}
+
+	err = saveWithSlug(&portfolio.Slug, func() error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			oldSlug := portfolio.Slug
+			slug, err := chooseSlug(tx, slugKindPortfolio, userID, portfolio.ID, portfolio.Slug, req.Slug, portfolio.Title)
+			if err != nil {
+				return err
+			}
+			portfolio.Slug = slug
+			if err := recordSlugChange(tx, slugKindPortfolio, userID, portfolio.ID, oldSlug, slug); err != nil {
+				return err
+			}
+			if result := tx.Save(&portfolio); result.Error != nil {
+				return result.Error
+			}
+			if portfolio.ID == primary.ID {
+				return nil
+			}
+			return setPortfolioSelection(tx, portfolio, primary.ID, req.ProjectIDs, req.AchievementIDs)
+		})
+	})
+	if err != nil {
+		writeSaveError(w, err, "Failed to update portfolio")
+		return
+	}
+
+	writeOwnPortfolio(w, portfolio, primary.ID)
+}
+
+// SetDefaultPortfolio handles choosing which of the authenticated user's
+// portfolios is served at /api/portfolio/{username}.
+func SetDefaultPortfolio(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, status, msg := findOwnedPortfolio(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Model(&Portfolio{}).Where("user_id = ? AND id <> ?", userID, portfolio.ID).Update("is_default", false); result.Error != nil {
+			return result.Error
+		}
+		return tx.Model(&portfolio).Update("is_default", true).Error
+	})
+	if err != nil {
+		http.Error(w, "Failed to update portfolio", http.StatusInternalServerError)
+		return
+	}
+
+	primary, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve portfolio", http.StatusInternalServerError)
+		return
+	}
+	writeOwnPortfolio(w, portfolio, primary.ID)
+}
+
+// DeletePortfolio handles deleting one of the authenticated user's additional
+// portfolios. The projects and achievements it showed are kept. If it was the
+// default portfolio, the main portfolio becomes the default again.
+func DeletePortfolio(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, status, msg := findOwnedPortfolio(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	primary, err := mainPortfolio(DB, userID)
+	if err != nil {
+		http.Error(w, "Failed to delete portfolio", http.StatusInternalServerError)
+		return
+	}
+	if portfolio.ID == primary.ID {
+		http.Error(w, "The main portfolio can't be deleted", http.StatusBadRequest)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("portfolio_id = ?", portfolio.ID).Delete(&PortfolioProject{}); result.Error != nil {
+			return result.Error
+		}
+		if result := tx.Where("portfolio_id = ?", portfolio.ID).Delete(&PortfolioAchievement{}); result.Error != nil {
+			return result.Error
+		}
+		if result := tx.Delete(&portfolio); result.Error != nil {
+			return result.Error
+		}
+		if portfolio.IsDefault {
+			return tx.Model(&primary).Update("is_default", true).Error
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to delete portfolio", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const (
+	excerptMaxLength = 280 // Characters
+	wordsPerMinute   = 200
+)
+
+// BeforeSave keeps a post's computed metadata in step with its content.
+func (p *Post) BeforeSave(tx *gorm.DB) error {
+	p.computeMetadata()
+	return nil
+}
+
+// computeMetadata derives the excerpt, word count, reading time and, if the
+// author didn't choose one, the cover image from the post's content. A
+// chosen cover that isn't safe to link to is dropped.
+func (p *Post) computeMetadata() {
+	text := PostPlainText(p.Content)
+	words := strings.Fields(text)
+
+	p.WordCount = len(words)
+	p.ReadingTime = 0
+	if p.WordCount > 0 {
+		p.ReadingTime = (p.WordCount + wordsPerMinute - 1) / wordsPerMinute
+	}
+
+	if summary := strings.TrimSpace(p.Summary); summary != "" {
+		p.Excerpt = summary
+	} else {
+		p.Excerpt = truncateWords(words, excerptMaxLength)
+	}
+
+	p.CoverImage = safeURL(p.CoverImage)
+	p.CoverImageURL = p.CoverImage
+	if p.CoverImageURL == "" {
+		p.CoverImageURL = FirstImageURL(p.Content)
+	}
+}
+
+// truncateWords joins words with single spaces, stopping at a word boundary
+// before max characters and adding an ellipsis if anything was cut.
+func truncateWords(words []string, max int) string {
+	var sb strings.Builder
+	length := 0
+	for i, word := range words {
+		n := utf8.RuneCountInString(word)
+		if i > 0 {
+			n++
+		}
+		if length+n > max {
+			if sb.Len() == 0 {
+				// A single word longer than the limit
+				r := []rune(word)
+				return string(r[:max]) + "…"
+			}
+			return sb.String() + "…"
+		}
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(word)
+		length += n
+	}
+	return sb.String()
+}
+
+// PostSummary is the lightweight representation of a post returned by list
+// endpoints. It leaves out the content, which only GetPost returns.
+type PostSummary struct {
+	ID             uint
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	UserID         uint
+	Title          string
+	Slug           string
+	Excerpt        string
+	WordCount      int
+	ReadingTime    int
+	CoverImageURL  string
+	PublishedAt    time.Time
+	CategoryID     *uint
+	SeriesID       *uint
+	SeriesPosition int
+	Tags           []Tag
+	CommentsCount  int64 `json:"comments_count"`
+}
+
+// toPostSummaries converts posts to their summaries with comment counts.
+func toPostSummaries(posts []Post) ([]PostSummary, error) {
+	ids := make([]uint, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	counts, err := commentCounts(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]PostSummary, len(posts))
+	for i, p := range posts {
+		summaries[i] = PostSummary{
+			ID:             p.ID,
+			CreatedAt:      p.CreatedAt,
+			UpdatedAt:      p.UpdatedAt,
+			UserID:         p.UserID,
+			Title:          p.Title,
+			Slug:           p.Slug,
+			Excerpt:        p.Excerpt,
+			WordCount:      p.WordCount,
+			ReadingTime:    p.ReadingTime,
+			CoverImageURL:  p.CoverImageURL,
+			PublishedAt:    p.PublishedAt,
+			CategoryID:     p.CategoryID,
+			SeriesID:       p.SeriesID,
+			SeriesPosition: p.SeriesPosition,
+			Tags:           p.Tags,
+			CommentsCount:  counts[p.ID],
+		}
+	}
+	return summaries, nil
+}
+
+// splitPostCoverImages moves the covers authors chose for their posts into
+// CoverImage, for posts saved when it shared CoverImageURL with the cover
+// derived from the content. A cover other than the first image in the
+// content must have been chosen. It is run once, when CoverImage is added.
+func splitPostCoverImages() {
+	var posts []Post
+	DB.Select("id", "content", "cover_image_url").Where("cover_image_url <> ''").Find(&posts)
+	for _, post := range posts {
+		if post.CoverImageURL == FirstImageURL(post.Content) {
+			continue
+		}
+		chosen := safeURL(post.CoverImageURL)
+		result := DB.Model(&post).UpdateColumns(map[string]interface{}{
+			"cover_image":     chosen,
+			"cover_image_url": firstNonEmpty(chosen, FirstImageURL(post.Content)),
+		})
+		if result.Error != nil {
+			log.Printf("Failed to keep the cover image of post %d: %v", post.ID, result.Error)
+		}
+	}
+}
+
+// backfillPostMetadata computes metadata for posts saved before it existed.
+func backfillPostMetadata() {
+	var posts []Post
+	DB.Where("word_count = 0 AND content <> ''").Find(&posts)
+	for _, post := range posts {
+		post.computeMetadata()
+		result := DB.Model(&post).UpdateColumns(map[string]interface{}{
+			"excerpt":         post.Excerpt,
+			"word_count":      post.WordCount,
+			"reading_time":    post.ReadingTime,
+			"cover_image_url": post.CoverImageURL,
+		})
+		if result.Error != nil {
+			log.Printf("Failed to compute metadata for post %d: %v", post.ID, result.Error)
+		}
+	}
+}
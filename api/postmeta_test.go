@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestPostCoverImage(t *testing.T) {
+	post := Post{Content: `<p>Hello</p><img src="https://example.com/first.png">`}
+	post.computeMetadata()
+	if post.CoverImageURL != "https://example.com/first.png" || post.CoverImage != "" {
+		t.Fatalf("cover = %q (chosen %q), want the first image", post.CoverImageURL, post.CoverImage)
+	}
+
+	// The derived cover follows the content even when it is sent back unchanged
+	post.Content = `<img src="https://example.com/second.png">`
+	post.computeMetadata()
+	if post.CoverImageURL != "https://example.com/second.png" {
+		t.Errorf("cover after editing = %q, want the new first image", post.CoverImageURL)
+	}
+
+	post.CoverImage = "https://example.com/chosen.png"
+	post.computeMetadata()
+	if post.CoverImageURL != "https://example.com/chosen.png" {
+		t.Errorf("cover = %q, want the chosen one", post.CoverImageURL)
+	}
+
+	post.CoverImage = "javascript:alert(1)"
+	post.computeMetadata()
+	if post.CoverImage != "" || post.CoverImageURL != "https://example.com/second.png" {
+		t.Errorf("unsafe cover kept as %q (chosen %q)", post.CoverImageURL, post.CoverImage)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Projects are ranked across the platform by their likes and views. Scores
+// are recomputed periodically into ProjectScore rows so listing the rankings
+// is a cheap sorted query. A project's top score counts its likes and its
+// views from distinct networks within a period; its trending score weighs
+// each of them by how recent it is, halving every half-life, so newly
+// popular projects rise above ones that were popular long ago.
+
+const (
+	projectScoreInterval = 15 * time.Minute
+	likeScoreWeight      = 5 // A like counts as much as this many views
+	viewScoreWeight      = 1
+)
+
+// rankingPeriods are the periods projects are ranked over, keyed by the
+// value of the "period" query parameter.
+var rankingPeriods = map[string]struct {
+	Length   time.Duration // Zero for all time
+	HalfLife time.Duration
+}{
+	"day":  {24 * time.Hour, 6 * time.Hour},
+	"week": {7 * 24 * time.Hour, 36 * time.Hour},
+	"all":  {0, 7 * 24 * time.Hour},
+}
+
+// StartProjectRanking starts the background job that recomputes project
+// scores.
+func StartProjectRanking() {
+	go func() {
+		for {
+			recomputeProjectScores()
+			time.Sleep(projectScoreInterval)
+		}
+	}()
+}
+
+func recomputeProjectScores() {
+	for period := range rankingPeriods {
+		if err := computeProjectScores(period); err != nil {
+			log.Printf("Failed to compute %s project scores: %v", period, err)
+		}
+	}
+}
+
+// activityScore is the amount of one kind of activity on a project.
+type activityScore struct {
+	ProjectID uint
+	Count     int
+	Decayed   float64 // Each row weighed by its age
+}
+
+// scoreActivity counts and weighs by age the rows of activity per project.
+// activity must have project_id and created_at columns.
+func scoreActivity(activity *gorm.DB, halfLife time.Duration) ([]activityScore, error) {
+	var rows []activityScore
+	result := DB.Table("(?) AS activity", activity).
+		Select("project_id, COUNT(*) AS count, "+
+			"SUM(POWER(0.5, EXTRACT(EPOCH FROM (NOW() - created_at)) / ?)) AS decayed", halfLife.Seconds()).
+		Group("project_id").Scan(&rows)
+	return rows, result.Error
+}
+
+// computeProjectScores replaces the scores of one period.
+func computeProjectScores(period string) error {
+	spec := rankingPeriods[period]
+	since := time.Time{}
+	if spec.Length > 0 {
+		since = time.Now().Add(-spec.Length)
+	}
+
+	likes := DB.Model(&Like{}).Select("project_id, created_at").Where("created_at >= ?", since)
+	// Views of a project from one network on a day count once, as of the
+	// latest, so a visitor can't pass as many by changing their user agent.
+	// Views recorded before networks were hashed fall back to the visitor.
+	views := DB.Model(&PageView{}).Select("project_id, MAX(created_at) AS created_at").
+		Where("project_id IS NOT NULL AND created_at >= ?", since).
+		Group("project_id, COALESCE(NULLIF(network_hash, ''), visitor_hash)")
+
+	likeCounts, err := scoreActivity(likes, spec.HalfLife)
+	if err != nil {
+		return err
+	}
+	viewCounts, err := scoreActivity(views, spec.HalfLife)
+	if err != nil {
+		return err
+	}
+
+	scores := make(map[uint]*ProjectScore)
+	score := func(projectID uint) *ProjectScore {
+		if scores[projectID] == nil {
+			scores[projectID] = &ProjectScore{ProjectID: projectID, Period: period}
+		}
+		return scores[projectID]
+	}
+	for _, c := range likeCounts {
+		s := score(c.ProjectID)
+		s.Likes = c.Count
+		s.TopScore += float64(c.Count * likeScoreWeight)
+		s.TrendingScore += c.Decayed * likeScoreWeight
+	}
+	for _, c := range viewCounts {
+		s := score(c.ProjectID)
+		s.Views = c.Count
+		s.TopScore += float64(c.Count * viewScoreWeight)
+		s.TrendingScore += c.Decayed * viewScoreWeight
+	}
+
+	rows := make([]ProjectScore, 0, len(scores))
+	for _, s := range scores {
+		rows = append(rows, *s)
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Unscoped().Where("period = ?", period).Delete(&ProjectScore{}); result.Error != nil {
+			return result.Error
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(rows, 500).Error
+	})
+}
+
+// publicProjectCondition limits a query to projects anyone may see: those of
+// a public main portfolio or shown by another public portfolio. Unlisted
+// portfolios aren't advertised, so their projects aren't ranked.
+const publicProjectCondition = "(projects.portfolio_id IN (SELECT id FROM portfolios WHERE visibility = 'public' AND deleted_at IS NULL) OR " +
+	"projects.id IN (SELECT portfolio_projects.project_id FROM portfolio_projects " +
+	"JOIN portfolios ON portfolios.id = portfolio_projects.portfolio_id WHERE portfolios.visibility = 'public' AND portfolios.deleted_at IS NULL))"
+
+// rankingListSpec describes a ranking of projects by one of the columns of
+// their score for a period. The period has been validated, so it is safe to
+// put in the expression.
+func rankingListSpec(column, period string) ListSpec {
+	return ListSpec{
+		Table: "projects",
+		SortKeys: map[string]ListSortKey{
+			"score": {Expr: fmt.Sprintf("(SELECT %s FROM project_scores WHERE project_scores.project_id = projects.id AND project_scores.period = '%s')", column, period)},
+		},
+		DefaultSort:  "score",
+		AuthorFilter: projectListSpec.AuthorFilter,
+		TagFilter:    projectListSpec.TagFilter,
+		ExtraFilters: map[string]func(db *gorm.DB, value string) *gorm.DB{
+			"technology": projectListSpec.TagFilter,
+		},
+	}
+}
+
+// RankedProject is a project in a ranking, with its owner and the likes and
+// views from distinct networks it had in the period.
+type RankedProject struct {
+	PublicProject
+	Owner string  `json:"owner"`
+	Score float64 `json:"score"`
+	// Within the period
+	PeriodLikes int `json:"period_likes"`
+	PeriodViews int `json:"period_views"`
+}
+
+// writeRankedProjects lists public projects a page at a time ranked by a
+// column of their score. The period query parameter is day, week or all;
+// technology (or tag) and author narrow the ranking.
+func writeRankedProjects(w http.ResponseWriter, r *http.Request, column string, score func(ProjectScore) float64, defaultPeriod string) {
+	currentUserID, _ := getUserIDFromContext(r)
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = defaultPeriod
+	}
+	if _, ok := rankingPeriods[period]; !ok {
+		http.Error(w, "Invalid period; expected day, week or all", http.StatusBadRequest)
+		return
+	}
+
+	spec := rankingListSpec(column, period)
+	opts, err := ParseListOptions(r, spec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	base := DB.Preload("Likes").Preload("Media", orderedMedia).
+		Where("projects.id IN (SELECT project_id FROM project_scores WHERE period = ? AND "+column+" > 0)", period).
+		Where(publicProjectCondition)
+	query, err := spec.Apply(base, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var projects []Project
+	if result := query.Find(&projects); result.Error != nil {
+		http.Error(w, "Failed to retrieve projects", http.StatusInternalServerError)
+		return
+	}
+	if len(projects) > opts.Limit {
+		projects = projects[:opts.Limit]
+		if err := spec.SetNextPage(w, r, opts, projects[len(projects)-1].ID); err != nil {
+			http.Error(w, "Failed to retrieve projects", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	projectIDs := make([]uint, len(projects))
+	portfolioIDs := make([]uint, len(projects))
+	for i, p := range projects {
+		projectIDs[i] = p.ID
+		portfolioIDs[i] = p.PortfolioID
+	}
+	var scores []ProjectScore
+	if result := DB.Where("period = ? AND project_id IN ?", period, projectIDs).Find(&scores); result.Error != nil {
+		http.Error(w, "Failed to retrieve projects", http.StatusInternalServerError)
+		return
+	}
+	scoreOf := make(map[uint]ProjectScore, len(scores))
+	for _, s := range scores {
+		scoreOf[s.ProjectID] = s
+	}
+	var owners []struct {
+		PortfolioID uint
+		Username    string
+	}
+	if result := DB.Table("portfolios").Select("portfolios.id AS portfolio_id, users.username").
+		Joins("JOIN users ON users.id = portfolios.user_id").Where("portfolios.id IN ?", portfolioIDs).Scan(&owners); result.Error != nil {
+		http.Error(w, "Failed to retrieve projects", http.StatusInternalServerError)
+		return
+	}
+	ownerOf := make(map[uint]string, len(owners))
+	for _, o := range owners {
+		ownerOf[o.PortfolioID] = o.Username
+	}
+
+	ranked := make([]RankedProject, len(projects))
+	for i, p := range projects {
+		s := scoreOf[p.ID]
+		ranked[i] = RankedProject{
+			PublicProject: PublicProject{Project: p, LikesCount: int64(len(p.Likes))},
+			Owner:         ownerOf[p.PortfolioID],
+			Score:         score(s),
+			PeriodLikes:   s.Likes,
+			PeriodViews:   s.Views,
+		}
+		for _, like := range p.Likes {
+			if like.UserID == currentUserID {
+				ranked[i].LikedByUser = true
+				break
+			}
+		}
+	}
+
+	json.NewEncoder(w).Encode(ranked)
+}
+
+// GetTrendingProjects handles listing the projects gaining likes and views
+// the fastest, over the past week unless another period is asked for.
+func GetTrendingProjects(w http.ResponseWriter, r *http.Request) {
+	writeRankedProjects(w, r, "trending_score", func(s ProjectScore) float64 { return s.TrendingScore }, "week")
+}
+
+// GetTopProjects handles listing the projects with the most likes and views,
+// of all time unless another period is asked for.
+func GetTopProjects(w http.ResponseWriter, r *http.Request) {
+	writeRankedProjects(w, r, "top_score", func(s ProjectScore) float64 { return s.TopScore }, "all")
+}
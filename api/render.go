@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// draftRaw is the raw content format produced by Draft.js convertToRaw, which
+// is how the frontend's rich text editor stores post content.
+type draftRaw struct {
+	Blocks    []draftBlock           `json:"blocks"`
+	EntityMap map[string]draftEntity `json:"entityMap"`
+}
+
+type draftBlock struct {
+	Text              string             `json:"text"`
+	Type              string             `json:"type"`
+	InlineStyleRanges []draftStyleRange  `json:"inlineStyleRanges"`
+	EntityRanges      []draftEntityRange `json:"entityRanges"`
+}
+
+type draftStyleRange struct {
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	Style  string `json:"style"`
+}
+
+type draftEntityRange struct {
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+	Key    int `json:"key"`
+}
+
+type draftEntity struct {
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// str returns the entity data value for key if it is a string.
+func (e draftEntity) str(key string) string {
+	s, _ := e.Data[key].(string)
+	return s
+}
+
+// draftBlockTags maps Draft.js block types to the HTML element wrapping them.
+var draftBlockTags = map[string]string{
+	"unstyled":            "p",
+	"paragraph":           "p",
+	"header-one":          "h1",
+	"header-two":          "h2",
+	"header-three":        "h3",
+	"header-four":         "h4",
+	"header-five":         "h5",
+	"header-six":          "h6",
+	"blockquote":          "blockquote",
+	"unordered-list-item": "li",
+	"ordered-list-item":   "li",
+}
+
+// draftStyleTags lists the supported inline styles in nesting order.
+var draftStyleTags = []struct {
+	Style string
+	Tag   string
+}{
+	{"BOLD", "strong"},
+	{"ITALIC", "em"},
+	{"UNDERLINE", "u"},
+	{"STRIKETHROUGH", "s"},
+	{"CODE", "code"},
+}
+
+// parseDraftContent reports whether content is Draft.js raw JSON and returns
+// it parsed.
+func parseDraftContent(content string) (draftRaw, bool) {
+	var raw draftRaw
+	trimmed := strings.TrimSpace(content)
+	if !strings.HasPrefix(trimmed, "{") {
+		return raw, false
+	}
+	if err := json.Unmarshal([]byte(trimmed), &raw); err != nil || raw.Blocks == nil {
+		return raw, false
+	}
+	return raw, true
+}
+
+// RenderPostContent returns the HTML for a post's content. Content saved by
+// the rich text editor as Draft.js raw JSON is converted to HTML; anything
+// else is assumed to already be HTML and is cut down to the elements the
+// conversion produces.
+func RenderPostContent(content string) string {
+	raw, ok := parseDraftContent(content)
+	if !ok {
+		return sanitizeHTML(content)
+	}
+
+	var sb strings.Builder
+	listTag := ""
+	inCode := false
+
+	closeOpen := func() {
+		if listTag != "" {
+			sb.WriteString("</" + listTag + ">")
+			listTag = ""
+		}
+		if inCode {
+			sb.WriteString("</code></pre>")
+			inCode = false
+		}
+	}
+
+	for _, block := range raw.Blocks {
+		wantList := ""
+		switch block.Type {
+		case "unordered-list-item":
+			wantList = "ul"
+		case "ordered-list-item":
+			wantList = "ol"
+		}
+		if listTag != wantList || (inCode && block.Type != "code-block") {
+			closeOpen()
+		}
+
+		switch {
+		case block.Type == "code-block":
+			if inCode {
+				sb.WriteString("\n")
+			} else {
+				sb.WriteString("<pre><code>")
+				inCode = true
+			}
+			sb.WriteString(html.EscapeString(block.Text))
+			continue
+		case block.Type == "atomic":
+			sb.WriteString(renderDraftAtomic(block, raw.EntityMap))
+			continue
+		case wantList != "" && listTag == "":
+			sb.WriteString("<" + wantList + ">")
+			listTag = wantList
+		}
+
+		tag, ok := draftBlockTags[block.Type]
+		if !ok {
+			tag = "p"
+		}
+		sb.WriteString("<" + tag + ">")
+		sb.WriteString(renderDraftInline(block, raw.EntityMap))
+		sb.WriteString("</" + tag + ">")
+	}
+	closeOpen()
+
+	return sb.String()
+}
+
+// renderDraftAtomic renders an atomic block, which Draft.js uses for embedded
+// media such as images.
+func renderDraftAtomic(block draftBlock, entities map[string]draftEntity) string {
+	for _, r := range block.EntityRanges {
+		entity, ok := entities[strconv.Itoa(r.Key)]
+		if !ok || strings.ToUpper(entity.Type) != "IMAGE" {
+			continue
+		}
+		src := safeURL(entity.str("src"))
+		if src == "" {
+			continue
+		}
+		return `<figure><img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(entity.str("alt")) + `"></figure>`
+	}
+	return ""
+}
+
+// renderDraftInline renders the text of a block with its inline styles and
+// links. Draft.js offsets count UTF-16 code units, so the text is converted
+// before the ranges are applied.
+func renderDraftInline(block draftBlock, entities map[string]draftEntity) string {
+	units := utf16.Encode([]rune(block.Text))
+	styles := make([]uint, len(units))
+	links := make([]string, len(units))
+
+	for _, r := range block.InlineStyleRanges {
+		for i, s := range draftStyleTags {
+			if s.Style != r.Style {
+				continue
+			}
+			for j := r.Offset; j < r.Offset+r.Length && j < len(units); j++ {
+				if j >= 0 {
+					styles[j] |= 1 << uint(i)
+				}
+			}
+		}
+	}
+	for _, r := range block.EntityRanges {
+		entity, ok := entities[strconv.Itoa(r.Key)]
+		if !ok || strings.ToUpper(entity.Type) != "LINK" {
+			continue
+		}
+		href := entity.str("url")
+		if href == "" {
+			href = entity.str("href")
+		}
+		href = safeURL(href)
+		for j := r.Offset; j < r.Offset+r.Length && j < len(units); j++ {
+			if j >= 0 {
+				links[j] = href
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for start := 0; start < len(units); {
+		end := start + 1
+		for end < len(units) && styles[end] == styles[start] && links[end] == links[start] {
+			end++
+		}
+		// Don't split a surrogate pair across runs.
+		if end < len(units) && utf16.IsSurrogate(rune(units[end])) && units[end] >= 0xDC00 {
+			end++
+		}
+
+		text := html.EscapeString(string(utf16.Decode(units[start:end])))
+		if links[start] != "" {
+			sb.WriteString(`<a href="` + html.EscapeString(links[start]) + `">`)
+		}
+		for i, s := range draftStyleTags {
+			if styles[start]&(1<<uint(i)) != 0 {
+				sb.WriteString("<" + s.Tag + ">")
+			}
+		}
+		sb.WriteString(text)
+		for i := len(draftStyleTags) - 1; i >= 0; i-- {
+			if styles[start]&(1<<uint(i)) != 0 {
+				sb.WriteString("</" + draftStyleTags[i].Tag + ">")
+			}
+		}
+		if links[start] != "" {
+			sb.WriteString("</a>")
+		}
+		start = end
+	}
+	return sb.String()
+}
+
+// safeURL returns u if it is a relative URL or uses a scheme that is safe to
+// link to, and an empty string otherwise.
+func safeURL(u string) string {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "", "http", "https", "mailto":
+		return parsed.String()
+	}
+	return ""
+}
+
+// PostPlainText returns the text of a post's content without any markup,
+// with blocks separated by newlines.
+func PostPlainText(content string) string {
+	raw, ok := parseDraftContent(content)
+	if !ok {
+		return htmlToText(content)
+	}
+
+	lines := make([]string, 0, len(raw.Blocks))
+	for _, block := range raw.Blocks {
+		lines = append(lines, block.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// htmlBlockTags are elements whose boundaries separate words and lines.
+var htmlBlockTags = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "ul": true, "ol": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "pre": true, "tr": true, "td": true, "th": true,
+	"figure": true, "figcaption": true, "section": true, "article": true,
+}
+
+// htmlToText strips tags from an HTML fragment and decodes its entities.
+// Script and style contents are dropped.
+func htmlToText(s string) string {
+	var sb strings.Builder
+	skipUntil := ""
+	for len(s) > 0 {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			if skipUntil == "" {
+				sb.WriteString(html.UnescapeString(s))
+			}
+			break
+		}
+		if skipUntil == "" {
+			sb.WriteString(html.UnescapeString(s[:lt]))
+		}
+		s = s[lt:]
+
+		gt := strings.IndexByte(s, '>')
+		if gt < 0 {
+			break
+		}
+		tag := strings.ToLower(strings.TrimLeft(s[1:gt], "/"))
+		if i := strings.IndexAny(tag, " \t\n/"); i >= 0 {
+			tag = tag[:i]
+		}
+		closing := strings.HasPrefix(s, "</")
+		s = s[gt+1:]
+
+		switch {
+		case skipUntil != "":
+			if closing && tag == skipUntil {
+				skipUntil = ""
+			}
+		case !closing && (tag == "script" || tag == "style"):
+			skipUntil = tag
+		case htmlBlockTags[tag]:
+			sb.WriteString("\n")
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// FirstImageURL returns the source of the first image in a post's content,
+// or an empty string if it has none.
+func FirstImageURL(content string) string {
+	if raw, ok := parseDraftContent(content); ok {
+		for _, block := range raw.Blocks {
+			for _, r := range block.EntityRanges {
+				entity := raw.EntityMap[strconv.Itoa(r.Key)]
+				if strings.ToUpper(entity.Type) == "IMAGE" {
+					if src := safeURL(entity.str("src")); src != "" {
+						return src
+					}
+				}
+			}
+		}
+		return ""
+	}
+
+	lower := strings.ToLower(content)
+	for offset := 0; ; {
+		i := strings.Index(lower[offset:], "<img")
+		if i < 0 {
+			return ""
+		}
+		tagStart := offset + i
+		tagEnd := strings.IndexByte(lower[tagStart:], '>')
+		if tagEnd < 0 {
+			return ""
+		}
+		tag := content[tagStart : tagStart+tagEnd]
+		if src := htmlAttr(tag, "src"); src != "" {
+			if safe := safeURL(src); safe != "" {
+				return safe
+			}
+		}
+		offset = tagStart + tagEnd
+	}
+}
+
+// htmlAttr returns the value of an attribute within a single HTML tag.
+func htmlAttr(tag, name string) string {
+	lower := strings.ToLower(tag)
+	for offset := 0; ; {
+		i := strings.Index(lower[offset:], name+"=")
+		if i < 0 {
+			return ""
+		}
+		start := offset + i
+		offset = start + len(name) + 1
+		// Make sure we matched a whole attribute name
+		if start > 0 && !strings.ContainsAny(lower[start-1:start], " \t\n") {
+			continue
+		}
+
+		rest := tag[offset:]
+		if rest == "" {
+			return ""
+		}
+		if quote := rest[0]; quote == '"' || quote == '\'' {
+			if end := strings.IndexByte(rest[1:], quote); end >= 0 {
+				return html.UnescapeString(rest[1 : end+1])
+			}
+			return ""
+		}
+		if end := strings.IndexAny(rest, " \t\n>"); end >= 0 {
+			rest = rest[:end]
+		}
+		return html.UnescapeString(rest)
+	}
+}
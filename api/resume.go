@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultResumeTemplate = "classic"
+
+// resumeTemplate describes the look of a PDF résumé.
+type resumeTemplate struct {
+	Body, Bold   pdfFont
+	NameSize     float64
+	HeadingSize  float64
+	ItemSize     float64 // Size of project and achievement titles
+	BodySize     float64
+	LineHeight   float64 // Multiple of the font size
+	Margin       float64
+	Text         pdfColor
+	Muted        pdfColor
+	Accent       pdfColor
+	CenterHeader bool
+	Banner       bool // Draw the header on a band of the accent color
+}
+
+// resumeTemplates are the templates résumés can be rendered with.
+var resumeTemplates = map[string]resumeTemplate{
+	"classic": {
+		Body: pdfTimes, Bold: pdfTimesBold,
+		NameSize: 24, HeadingSize: 13, ItemSize: 11.5, BodySize: 10.5,
+		LineHeight: 1.35, Margin: 56,
+		Text: pdfColor{0.1, 0.1, 0.1}, Muted: pdfColor{0.4, 0.4, 0.4}, Accent: pdfColor{0.1, 0.1, 0.1},
+		CenterHeader: true,
+	},
+	"modern": {
+		Body: pdfHelvetica, Bold: pdfHelveticaBold,
+		NameSize: 26, HeadingSize: 12, ItemSize: 11, BodySize: 9.5,
+		LineHeight: 1.4, Margin: 48,
+		Text: pdfColor{0.12, 0.16, 0.2}, Muted: pdfColor{0.38, 0.43, 0.49}, Accent: pdfColor{0.15, 0.39, 0.92},
+		Banner: true,
+	},
+	"compact": {
+		Body: pdfHelvetica, Bold: pdfHelveticaBold,
+		NameSize: 18, HeadingSize: 10.5, ItemSize: 9.5, BodySize: 8.5,
+		LineHeight: 1.25, Margin: 36,
+		Text: pdfColor{0, 0, 0}, Muted: pdfColor{0.35, 0.35, 0.35}, Accent: pdfColor{0.2, 0.2, 0.2},
+	},
+}
+
+// resumeTemplateNames returns the names of the résumé templates, sorted.
+func resumeTemplateNames() []string {
+	names := make([]string, 0, len(resumeTemplates))
+	for name := range resumeTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resumeWriter lays out a résumé top to bottom, starting new pages as needed.
+type resumeWriter struct {
+	doc  *pdfDocument
+	tmpl resumeTemplate
+	y    float64 // Baseline of the next line
+}
+
+func (rw *resumeWriter) width() float64 {
+	return rw.doc.Width - 2*rw.tmpl.Margin
+}
+
+// bottom is the lowest a line may go, leaving room for the page footer.
+func (rw *resumeWriter) bottom() float64 {
+	return rw.tmpl.Margin + 2*rw.tmpl.BodySize
+}
+
+func (rw *resumeWriter) newPage() {
+	rw.doc.AddPage()
+	rw.y = rw.doc.Height - rw.tmpl.Margin
+}
+
+// ensure starts a new page unless height fits on the current one.
+func (rw *resumeWriter) ensure(height float64) {
+	if rw.y-height < rw.bottom() {
+		rw.newPage()
+	}
+}
+
+// paragraph writes wrapped text, indented from the left margin.
+func (rw *resumeWriter) paragraph(font pdfFont, size float64, color pdfColor, text string, indent float64) {
+	lineHeight := size * rw.tmpl.LineHeight
+	for _, line := range pdfWrap(font, size, text, rw.width()-indent) {
+		rw.ensure(lineHeight)
+		rw.y -= lineHeight
+		rw.doc.Text(rw.tmpl.Margin+indent, rw.y, font, size, color, line)
+	}
+}
+
+// heading writes a section heading, moving it to the next page if the start
+// of the section wouldn't fit below it.
+func (rw *resumeWriter) heading(title string) {
+	t := rw.tmpl
+	rw.y -= t.HeadingSize
+	rw.ensure(t.HeadingSize*2 + t.ItemSize*2 + t.BodySize*2)
+	rw.y -= t.HeadingSize * t.LineHeight
+	rw.doc.Text(t.Margin, rw.y, t.Bold, t.HeadingSize, t.Accent, strings.ToUpper(title))
+	rw.y -= t.HeadingSize * 0.4
+	rw.doc.Line(t.Margin, rw.y, rw.doc.Width-t.Margin, rw.y, 0.75, t.Accent)
+	rw.y -= t.HeadingSize * 0.2
+}
+
+// item writes the title line of an entry, with an optional note such as a
+// date aligned to the right margin.
+func (rw *resumeWriter) item(title, note string) {
+	t := rw.tmpl
+	rw.y -= t.ItemSize * 0.5
+	rw.ensure(t.ItemSize*t.LineHeight + t.BodySize*t.LineHeight)
+
+	noteWidth := 0.0
+	if note != "" {
+		noteWidth = pdfTextWidth(t.Body, t.BodySize, note) + t.BodySize
+	}
+	lines := pdfWrap(t.Bold, t.ItemSize, title, rw.width()-noteWidth)
+	for i, line := range lines {
+		rw.ensure(t.ItemSize * t.LineHeight)
+		rw.y -= t.ItemSize * t.LineHeight
+		rw.doc.Text(t.Margin, rw.y, t.Bold, t.ItemSize, t.Text, line)
+		if i == 0 && note != "" {
+			x := rw.doc.Width - t.Margin - pdfTextWidth(t.Body, t.BodySize, note)
+			rw.doc.Text(x, rw.y, t.Body, t.BodySize, t.Muted, note)
+		}
+	}
+}
+
+// resumeOptions are the choices a résumé is rendered with.
+type resumeOptions struct {
+	Template     string
+	FeaturedOnly bool       // Leave out projects that aren't featured
+	From, To     *time.Time // Only include achievements dated within this range
+	Width        float64
+	Height       float64
+}
+
+// renderResume draws a user's portfolio as a résumé.
+func renderResume(user User, portfolio Portfolio, links []SocialLink, projects []Project, achievements []Achievement, opts resumeOptions) *pdfDocument {
+	t := resumeTemplates[opts.Template]
+	name := portfolio.Title
+	if name == "" {
+		name = user.Username
+	}
+
+	doc := newPDFDocument(opts.Width, opts.Height)
+	doc.Title = name
+	doc.Author = user.Username
+	rw := &resumeWriter{doc: doc, tmpl: t}
+	rw.newPage()
+
+	// Header
+	var contact []string
+	if portfolio.ContactInfo != "" {
+		contact = append(contact, portfolio.ContactInfo)
+	}
+	for _, link := range links {
+		contact = append(contact, strings.TrimPrefix(link.URL, "https://"))
+	}
+	contactLine := strings.Join(contact, "  |  ")
+
+	type headerLine struct {
+		font  pdfFont
+		size  float64
+		muted bool
+		text  string
+		gap   float64 // Extra space above the line
+	}
+	var header []headerLine
+	addHeader := func(font pdfFont, size float64, muted bool, text string, gap float64) {
+		for i, line := range pdfWrap(font, size, text, rw.width()) {
+			if i > 0 {
+				gap = 0
+			}
+			header = append(header, headerLine{font, size, muted, line, gap})
+		}
+	}
+	addHeader(t.Bold, t.NameSize, false, name, 0)
+	if portfolio.Description != "" {
+		addHeader(t.Body, t.BodySize*1.15, true, portfolio.Description, t.BodySize*0.3)
+	}
+	if contactLine != "" {
+		addHeader(t.Body, t.BodySize, true, contactLine, t.BodySize*0.3)
+	}
+
+	textColor, mutedColor := t.Text, t.Muted
+	if t.Banner {
+		// The band runs from the top edge of the page to just below the header
+		height := t.Margin + t.BodySize*t.LineHeight
+		for _, line := range header {
+			height += line.size*t.LineHeight + line.gap
+		}
+		doc.Rect(0, doc.Height-height, doc.Width, height, t.Accent)
+		textColor, mutedColor = pdfColor{1, 1, 1}, pdfColor{0.88, 0.92, 1}
+	}
+	for _, line := range header {
+		rw.y -= line.size*t.LineHeight + line.gap
+		x := t.Margin
+		if t.CenterHeader {
+			x = (doc.Width - pdfTextWidth(line.font, line.size, line.text)) / 2
+		}
+		color := textColor
+		if line.muted {
+			color = mutedColor
+		}
+		doc.Text(x, rw.y, line.font, line.size, color, line.text)
+	}
+	if t.Banner {
+		rw.y -= t.BodySize * t.LineHeight
+	}
+
+	// About
+	about := portfolio.AboutMe
+	if about == "" {
+		about = user.Bio
+	}
+	if paragraphs := splitParagraphs(about); len(paragraphs) > 0 {
+		rw.heading("About")
+		for i, p := range paragraphs {
+			if i > 0 {
+				rw.y -= t.BodySize * 0.5
+			}
+			rw.paragraph(t.Body, t.BodySize, t.Text, p, 0)
+		}
+	}
+
+	// Projects
+	var shown []Project
+	for _, project := range projects {
+		if project.Featured || !opts.FeaturedOnly {
+			shown = append(shown, project)
+		}
+	}
+	if len(shown) > 0 {
+		title := "Projects"
+		if opts.FeaturedOnly {
+			title = "Featured projects"
+		}
+		rw.heading(title)
+		for _, project := range shown {
+			rw.item(project.Title, "")
+			if project.Technologies != "" {
+				rw.paragraph(t.Body, t.BodySize, t.Muted, project.Technologies, 0)
+			}
+			if project.Description != "" {
+				rw.paragraph(t.Body, t.BodySize, t.Text, project.Description, 0)
+			}
+			if project.Link != "" {
+				rw.paragraph(t.Body, t.BodySize, t.Accent, project.Link, 0)
+			}
+		}
+	}
+
+	// Achievements
+	var dated []Achievement
+	for _, achievement := range achievements {
+		if opts.From != nil && achievement.Date.Before(*opts.From) {
+			continue
+		}
+		if opts.To != nil && achievement.Date.After(*opts.To) {
+			continue
+		}
+		dated = append(dated, achievement)
+	}
+	if len(dated) > 0 {
+		rw.heading("Achievements")
+		for _, achievement := range dated {
+			date := ""
+			if !achievement.Date.IsZero() {
+				date = achievement.Date.Format("January 2006")
+			}
+			rw.item(achievement.Title, date)
+			if achievement.Description != "" {
+				rw.paragraph(t.Body, t.BodySize, t.Text, achievement.Description, 0)
+			}
+		}
+	}
+
+	// Footers, now that the number of pages is known
+	pages := doc.PageCount()
+	for i := 1; i <= pages; i++ {
+		doc.SetPage(i)
+		footer := fmt.Sprintf("%s  -  Page %d of %d", name, i, pages)
+		x := (doc.Width - pdfTextWidth(t.Body, t.BodySize*0.85, footer)) / 2
+		doc.Text(x, t.Margin/2, t.Body, t.BodySize*0.85, t.Muted, footer)
+	}
+	return doc
+}
+
+// parseResumeOptions reads the template, featured, from, to and paper query
+// parameters.
+func parseResumeOptions(r *http.Request) (resumeOptions, error) {
+	q := r.URL.Query()
+	opts := resumeOptions{Template: defaultResumeTemplate, Width: pdfA4Width, Height: pdfA4Height}
+
+	if s := q.Get("template"); s != "" {
+		if _, ok := resumeTemplates[s]; !ok {
+			return opts, fmt.Errorf("unknown template %q; choose one of %s", s, strings.Join(resumeTemplateNames(), ", "))
+		}
+		opts.Template = s
+	}
+
+	switch q.Get("featured") {
+	case "", "false", "0":
+	case "true", "1":
+		opts.FeaturedOnly = true
+	default:
+		return opts, fmt.Errorf("featured must be true or false")
+	}
+
+	if s := q.Get("from"); s != "" {
+		from, err := parseListTime(s)
+		if err != nil {
+			return opts, err
+		}
+		opts.From = from
+	}
+	if s := q.Get("to"); s != "" {
+		to, err := parseListTime(s)
+		if err != nil {
+			return opts, err
+		}
+		// A plain date includes the whole day
+		if !strings.Contains(s, "T") {
+			end := to.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			to = &end
+		}
+		opts.To = to
+	}
+	if opts.From != nil && opts.To != nil && opts.To.Before(*opts.From) {
+		return opts, fmt.Errorf("to must not be before from")
+	}
+
+	switch q.Get("paper") {
+	case "", "a4":
+	case "letter":
+		opts.Width, opts.Height = pdfLetterWidth, pdfLetterHeight
+	default:
+		return opts, fmt.Errorf("paper must be a4 or letter")
+	}
+	return opts, nil
+}
+
+// GetResumePDF handles rendering a user's portfolio as a PDF résumé. The
+// default portfolio is used unless the portfolio query parameter names
+// another; it is subject to the same visibility rules as the portfolio.
+func GetResumePDF(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if result := DB.Where("username = ?", mux.Vars(r)["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	opts, err := parseResumeOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var portfolio Portfolio
+	query := DB.Where("user_id = ?", user.ID)
+	if slug := r.URL.Query().Get("portfolio"); slug != "" {
+		query = query.Where("slug = ?", slug)
+	}
+	if result := query.Order("is_default desc, id asc").First(&portfolio); result.Error != nil {
+		http.Error(w, "Portfolio not found", http.StatusNotFound)
+		return
+	}
+	if status := checkPortfolioAccess(r, portfolio); status != 0 {
+		writePortfolioAccessError(w, status)
+		return
+	}
+	setPortfolioVisibilityHeaders(w, portfolio)
+
+	projects, achievements, err := portfolioContents(portfolio)
+	if err != nil {
+		http.Error(w, "Failed to retrieve portfolio", http.StatusInternalServerError)
+		return
+	}
+
+	links, err := userSocialLinks(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve portfolio", http.StatusInternalServerError)
+		return
+	}
+	if portfolio.HideBrokenLinks {
+		projects, links, err = hideBrokenLinks(projects, links)
+		if err != nil {
+			http.Error(w, "Failed to retrieve portfolio", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	doc := renderResume(user, portfolio, links, projects, achievements, opts)
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s-resume.pdf"`, user.Username))
+	doc.WriteTo(w)
+}
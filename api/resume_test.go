@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseResumeOptions(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"", false},
+		{"template=modern&featured=true&paper=letter", false},
+		{"from=2023-01-01&to=2023-12-31", false},
+		{"from=2023-06-01T12:00:00Z&to=2023-06-01", false},
+		{"template=fancy", true},
+		{"featured=maybe", true},
+		{"from=yesterday", true},
+		{"to=2023-13-01", true},
+		{"from=2024-01-01&to=2023-12-31", true},
+		{"paper=a5", true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/users/alice/resume.pdf?"+test.query, nil)
+		_, err := parseResumeOptions(r)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseResumeOptions(%q) error = %v, want error %v", test.query, err, test.wantErr)
+		}
+	}
+}
+
+func TestParseResumeOptionsValues(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/users/alice/resume.pdf?paper=letter&featured=1&to=2023-12-31", nil)
+	opts, err := parseResumeOptions(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Template != defaultResumeTemplate || !opts.FeaturedOnly {
+		t.Errorf("template %q, featured only %v", opts.Template, opts.FeaturedOnly)
+	}
+	if opts.Width != pdfLetterWidth || opts.Height != pdfLetterHeight {
+		t.Errorf("paper is %vx%v, want letter", opts.Width, opts.Height)
+	}
+	wantTo := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)
+	if opts.From != nil || opts.To == nil || !opts.To.Equal(wantTo) {
+		t.Errorf("range is %v to %v, want up to %v", opts.From, opts.To, wantTo)
+	}
+}
+
+func TestResumeWriterStartsNewPages(t *testing.T) {
+	tmpl := resumeTemplates["compact"]
+	rw := &resumeWriter{doc: newPDFDocument(pdfA4Width, pdfA4Height), tmpl: tmpl}
+	rw.newPage()
+
+	const lines = 200
+	rw.paragraph(tmpl.Body, tmpl.BodySize, tmpl.Text, strings.TrimSpace(strings.Repeat("line\n", lines)), 0)
+
+	lineHeight := tmpl.BodySize * tmpl.LineHeight
+	perPage := math.Floor((pdfA4Height - tmpl.Margin - rw.bottom()) / lineHeight)
+	if want := int(math.Ceil(lines / perPage)); rw.doc.PageCount() != want {
+		t.Errorf("got %d pages, want %d", rw.doc.PageCount(), want)
+	}
+	if rw.y < rw.bottom() {
+		t.Errorf("last line at %.2f is below the bottom margin at %.2f", rw.y, rw.bottom())
+	}
+}
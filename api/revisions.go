@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// lockPostRevisions locks a post's row until the transaction ends, so
+// concurrent saves of the post number their revisions one after the other.
+func lockPostRevisions(tx *gorm.DB, postID uint) error {
+	var locked Post
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&locked, postID).Error
+}
+
+// recordPostRevision stores the current title and content of a post as its
+// next revision.
+func recordPostRevision(tx *gorm.DB, post *Post, userID uint, note string) (PostRevision, error) {
+	if err := lockPostRevisions(tx, post.ID); err != nil {
+		return PostRevision{}, err
+	}
+	var latest int
+	if result := tx.Model(&PostRevision{}).Where("post_id = ?", post.ID).Select("COALESCE(MAX(revision), 0)").Scan(&latest); result.Error != nil {
+		return PostRevision{}, result.Error
+	}
+
+	revision := PostRevision{
+		PostID:   post.ID,
+		UserID:   userID,
+		Revision: latest + 1,
+		Title:    post.Title,
+		Content:  post.Content,
+		Note:     note,
+	}
+	if result := tx.Create(&revision); result.Error != nil {
+		return PostRevision{}, result.Error
+	}
+	return revision, nil
+}
+
+// renumberPostRevisions numbers each post's revisions 1, 2, 3... in the
+// order they were made, so numbers concurrent saves duplicated before they
+// were unique don't stop the unique index being created.
+func renumberPostRevisions() {
+	if !DB.Migrator().HasTable(&PostRevision{}) {
+		return
+	}
+	result := DB.Exec(`UPDATE post_revisions SET revision = numbered.n
+FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY post_id ORDER BY revision, id) AS n FROM post_revisions) AS numbered
+WHERE post_revisions.id = numbered.id AND post_revisions.revision <> numbered.n`)
+	if result.Error != nil {
+		log.Printf("Failed to renumber post revisions: %v", result.Error)
+	}
+}
+
+// ensurePostRevisionBaseline records the current state of a post as its first
+// revision if it has none yet, so posts created before revisions existed do
+// not lose their original text on the next update.
+func ensurePostRevisionBaseline(tx *gorm.DB, post *Post) error {
+	if err := lockPostRevisions(tx, post.ID); err != nil {
+		return err
+	}
+	var count int64
+	if result := tx.Model(&PostRevision{}).Where("post_id = ?", post.ID).Count(&count); result.Error != nil {
+		return result.Error
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := recordPostRevision(tx, post, post.UserID, "")
+	return err
+}
+
+// findOwnedPost loads the post identified by the "id" route variable if it
+// belongs to the authenticated user.
+func findOwnedPost(r *http.Request, userID uint) (Post, int, string) {
+	postID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return Post{}, http.StatusBadRequest, "Invalid post ID"
+	}
+
+	var post Post
+	if result := DB.Where("user_id = ?", userID).First(&post, postID); result.Error != nil {
+		return Post{}, http.StatusNotFound, "Post not found or not authorized"
+	}
+	return post, 0, ""
+}
+
+// findPostRevision loads a single revision of a post by its revision number.
+func findPostRevision(postID uint, revisionStr string) (PostRevision, int, string) {
+	number, err := strconv.Atoi(revisionStr)
+	if err != nil {
+		return PostRevision{}, http.StatusBadRequest, "Invalid revision number"
+	}
+
+	var revision PostRevision
+	if result := DB.Where("post_id = ? AND revision = ?", postID, number).First(&revision); result.Error != nil {
+		return PostRevision{}, http.StatusNotFound, "Revision not found"
+	}
+	return revision, 0, ""
+}
+
+// GetPostRevisions handles listing the revisions of one of the authenticated user's posts.
+func GetPostRevisions(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	post, status, msg := findOwnedPost(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var revisions []PostRevision
+	if result := DB.Where("post_id = ?", post.ID).Order("revision desc").Find(&revisions); result.Error != nil {
+		http.Error(w, "Failed to retrieve revisions", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// GetPostRevision handles getting a single revision of one of the authenticated user's posts.
+func GetPostRevision(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	post, status, msg := findOwnedPost(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	revision, status, msg := findPostRevision(post.ID, mux.Vars(r)["revision"])
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	json.NewEncoder(w).Encode(revision)
+}
+
+// DiffPostRevisions handles comparing two revisions of a post. The revisions
+// are selected with the "from" and "to" query parameters, defaulting to the
+// latest revision and the one before it. The "mode" parameter selects either
+// a "unified" line diff (the default) or a "word" level diff.
+func DiffPostRevisions(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	post, status, msg := findOwnedPost(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "unified"
+	}
+	if mode != "unified" && mode != "word" {
+		http.Error(w, "Invalid diff mode", http.StatusBadRequest)
+		return
+	}
+
+	toStr := r.URL.Query().Get("to")
+	if toStr == "" {
+		var latest int
+		if result := DB.Model(&PostRevision{}).Where("post_id = ?", post.ID).Select("COALESCE(MAX(revision), 0)").Scan(&latest); result.Error != nil {
+			http.Error(w, "Failed to retrieve revisions", http.StatusInternalServerError)
+			return
+		}
+		toStr = strconv.Itoa(latest)
+	}
+	to, status, msg := findPostRevision(post.ID, toStr)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	// The first revision is diffed against an empty one
+	from := PostRevision{PostID: post.ID}
+	fromStr := r.URL.Query().Get("from")
+	if fromStr == "" && to.Revision > 1 {
+		fromStr = strconv.Itoa(to.Revision - 1)
+	}
+	if fromStr != "" {
+		from, status, msg = findPostRevision(post.ID, fromStr)
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(revisionDiff(from, to, mode))
+}
+
+// revisionDiff compares two revisions of a post in the given mode. A zero
+// revision stands for an empty post.
+func revisionDiff(from, to PostRevision, mode string) map[string]interface{} {
+	response := map[string]interface{}{
+		"from": from.Revision,
+		"to":   to.Revision,
+		"mode": mode,
+	}
+	if mode == "word" {
+		response["title"] = WordDiff(from.Title, to.Title)
+		response["content"] = WordDiff(from.Content, to.Content)
+	} else {
+		fromName := fmt.Sprintf("revision %d", from.Revision)
+		if from.Revision == 0 {
+			fromName = "empty"
+		}
+		toName := fmt.Sprintf("revision %d", to.Revision)
+		response["title"] = UnifiedDiff(fromName, toName, from.Title, to.Title, 0)
+		response["content"] = UnifiedDiff(fromName, toName, from.Content, to.Content, 3)
+	}
+	return response
+}
+
+// RestorePostRevision handles restoring an old revision of a post. The old
+// title and content are saved as a new revision rather than discarding the
+// revisions made after it.
+func RestorePostRevision(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	post, status, msg := findOwnedPost(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	revision, status, msg := findPostRevision(post.ID, mux.Vars(r)["revision"])
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := ensurePostRevisionBaseline(tx, &post); err != nil {
+			return err
+		}
+
+		post.Title = revision.Title
+		post.Content = revision.Content
+		if result := tx.Save(&post); result.Error != nil {
+			return result.Error
+		}
+
+		_, err := recordPostRevision(tx, &post, userID, fmt.Sprintf("Restored from revision %d", revision.Revision))
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+	federatePost(r, "Update", post)
+	sendPostWebmentions(r, post)
+
+	json.NewEncoder(w).Encode(post)
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestRevisionDiffOfFirstRevision(t *testing.T) {
+	from := PostRevision{PostID: 1}
+	to := PostRevision{PostID: 1, Revision: 1, Title: "Hello", Content: "one\ntwo\n"}
+
+	got := revisionDiff(from, to, "unified")
+	if got["from"] != 0 || got["to"] != 1 {
+		t.Errorf("revisionDiff() compares %v with %v, want 0 with 1", got["from"], got["to"])
+	}
+	wantTitle := "--- empty\n+++ revision 1\n@@ -0,0 +1,1 @@\n+Hello\n"
+	if got["title"] != wantTitle {
+		t.Errorf("title diff =\n%s\nwant\n%s", got["title"], wantTitle)
+	}
+	wantContent := "--- empty\n+++ revision 1\n@@ -0,0 +1,2 @@\n+one\n+two\n"
+	if got["content"] != wantContent {
+		t.Errorf("content diff =\n%s\nwant\n%s", got["content"], wantContent)
+	}
+
+	words := revisionDiff(from, to, "word")["content"].([]DiffSegment)
+	if len(words) != 1 || words[0].Op != DiffInsert || words[0].Text != to.Content {
+		t.Errorf("word diff = %v, want a single insertion", words)
+	}
+}
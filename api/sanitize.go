@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Posts whose content is HTML rather than Draft.js raw JSON are published
+// with the same elements the Draft.js conversion in render.go produces, so
+// content from either source is equally safe to embed in feeds, federated
+// activities and exported sites.
+
+// sanitizedTags are the elements kept by sanitizeHTML: those of Draft.js
+// blocks and inline styles, and the list, code, link and image markup around
+// them. Line breaks are kept too, as HTML content often has them.
+var sanitizedTags = func() map[string]bool {
+	tags := map[string]bool{"ul": true, "ol": true, "pre": true, "a": true, "figure": true, "img": true, "br": true}
+	for _, tag := range draftBlockTags {
+		tags[tag] = true
+	}
+	for _, s := range draftStyleTags {
+		tags[s.Tag] = true
+	}
+	return tags
+}()
+
+// droppedTags are elements left out of sanitized HTML together with
+// everything inside them.
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true, "template": true,
+	"noscript": true, "svg": true, "math": true, "textarea": true, "select": true, "title": true, "head": true,
+}
+
+// sanitizeHTML keeps the text of an HTML fragment and the elements in
+// sanitizedTags, without attributes other than safe link targets and image
+// sources and alt text. Other elements are replaced by their contents.
+func sanitizeHTML(s string) string {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(s), body)
+	if err != nil {
+		return html.EscapeString(htmlToText(s))
+	}
+	var sb strings.Builder
+	for _, n := range nodes {
+		writeSanitized(&sb, n)
+	}
+	return sb.String()
+}
+
+func writeSanitized(sb *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		sb.WriteString(html.EscapeString(n.Data))
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	tag := strings.ToLower(n.Data)
+	if droppedTags[tag] {
+		return
+	}
+	writeChildren := func() {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeSanitized(sb, c)
+		}
+	}
+	if !sanitizedTags[tag] {
+		writeChildren()
+		return
+	}
+
+	switch tag {
+	case "img":
+		if src := safeURL(attr(n, "src")); src != "" {
+			sb.WriteString(`<img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(attr(n, "alt")) + `">`)
+		}
+		return
+	case "br":
+		sb.WriteString("<br>")
+		return
+	case "a":
+		href := safeURL(attr(n, "href"))
+		if href == "" {
+			writeChildren()
+			return
+		}
+		sb.WriteString(`<a href="` + html.EscapeString(href) + `">`)
+	default:
+		sb.WriteString("<" + tag + ">")
+	}
+	writeChildren()
+	sb.WriteString("</" + tag + ">")
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestRenderPostContentSanitizesHTML(t *testing.T) {
+	tests := []struct {
+		content, want string
+	}{
+		{`<p>Hello <strong>world</strong></p>`, `<p>Hello <strong>world</strong></p>`},
+		{`<p onclick="steal()" class="x">Hi</p>`, `<p>Hi</p>`},
+		{`<script>steal()</script><p>Hi</p>`, `<p>Hi</p>`},
+		{`<a href="javascript:steal()">link</a>`, `link`},
+		{`<a href="https://example.com" target="_blank">link</a>`, `<a href="https://example.com">link</a>`},
+		{`<img src="https://example.com/a.png" alt="A" onerror="steal()">`, `<img src="https://example.com/a.png" alt="A">`},
+		{`<img src="data:image/svg+xml,&lt;svg&gt;">`, ``},
+		{`<div><span>Kept text</span></div><iframe src="https://evil.example"></iframe>`, `Kept text`},
+		{`Line<br/>break &amp; <b>bold</b>`, `Line<br>break &amp; bold`},
+		{`<ul><li>One</li><li>Two</li></ul>`, `<ul><li>One</li><li>Two</li></ul>`},
+		{`<p>Unclosed <em>emphasis`, `<p>Unclosed <em>emphasis</em></p>`},
+	}
+	for _, tt := range tests {
+		if got := RenderPostContent(tt.content); got != tt.want {
+			t.Errorf("RenderPostContent(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// PostLink is a minimal reference to a post, used for navigation.
+type PostLink struct {
+	ID    uint   `json:"id"`
+	Title string `json:"title"`
+	Slug  string `json:"slug"`
+}
+
+// SeriesNav places a post within its series.
+type SeriesNav struct {
+	ID       uint      `json:"id"`
+	Title    string    `json:"title"`
+	Slug     string    `json:"slug"`
+	Position int       `json:"position"`
+	Total    int       `json:"total"`
+	Previous *PostLink `json:"previous"`
+	Next     *PostLink `json:"next"`
+}
+
+// SeriesWithPosts is a series together with its posts in reading order.
+type SeriesWithPosts struct {
+	Series
+	Posts []PostLink `json:"posts"`
+}
+
+// seriesPosts returns the published posts of a series in reading order.
+func seriesPosts(seriesID uint) ([]Post, error) {
+	var posts []Post
+	result := DB.Select("id", "title", "slug", "series_position").
+		Where("series_id = ? AND published_at <= NOW()", seriesID).
+		Order("series_position asc, id asc").
+		Find(&posts)
+	return posts, result.Error
+}
+
+func toPostLinks(posts []Post) []PostLink {
+	links := make([]PostLink, len(posts))
+	for i, p := range posts {
+		links[i] = PostLink{ID: p.ID, Title: p.Title, Slug: p.Slug}
+	}
+	return links
+}
+
+// seriesNavFor returns the series navigation for a post, or nil if the post
+// is not part of a series.
+func seriesNavFor(post Post) (*SeriesNav, error) {
+	if post.SeriesID == nil {
+		return nil, nil
+	}
+
+	var series Series
+	if result := DB.First(&series, *post.SeriesID); result.Error != nil {
+		return nil, nil
+	}
+
+	posts, err := seriesPosts(series.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	links := toPostLinks(posts)
+	nav := &SeriesNav{ID: series.ID, Title: series.Title, Slug: series.Slug, Total: len(links)}
+	for i, link := range links {
+		if link.ID != post.ID {
+			continue
+		}
+		nav.Position = i + 1
+		if i > 0 {
+			nav.Previous = &links[i-1]
+		}
+		if i < len(links)-1 {
+			nav.Next = &links[i+1]
+		}
+	}
+	return nav, nil
+}
+
+// writePublicPost writes a single post with its comment count and, if it is
+// part of a series, its previous and next posts.
+func writePublicPost(w http.ResponseWriter, post Post) {
+	publicPosts, err := toPublicPosts([]Post{post})
+	if err != nil {
+		http.Error(w, "Failed to retrieve post", http.StatusInternalServerError)
+		return
+	}
+
+	publicPost := publicPosts[0]
+	if publicPost.Series, err = seriesNavFor(post); err != nil {
+		http.Error(w, "Failed to retrieve post", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(publicPost)
+}
+
+// findOwnedSeries loads the series identified by the "id" route variable if
+// it belongs to the authenticated user.
+func findOwnedSeries(r *http.Request, userID uint) (Series, int, string) {
+	seriesID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return Series{}, http.StatusBadRequest, "Invalid series ID"
+	}
+
+	var series Series
+	if result := DB.Where("user_id = ?", userID).First(&series, seriesID); result.Error != nil {
+		return Series{}, http.StatusNotFound, "Series not found or not authorized"
+	}
+	return series, 0, ""
+}
+
+// GetUserSeries handles listing a user's series with their posts.
+func GetUserSeries(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if result := DB.Where("username = ?", mux.Vars(r)["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var seriesList []Series
+	if result := DB.Where("user_id = ?", user.ID).Order("created_at desc").Find(&seriesList); result.Error != nil {
+		http.Error(w, "Failed to retrieve series", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]SeriesWithPosts, len(seriesList))
+	for i, series := range seriesList {
+		posts, err := seriesPosts(series.ID)
+		if err != nil {
+			http.Error(w, "Failed to retrieve series", http.StatusInternalServerError)
+			return
+		}
+		response[i] = SeriesWithPosts{Series: series, Posts: toPostLinks(posts)}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetSeriesBySlug handles getting one of a user's series with its posts.
+func GetSeriesBySlug(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var user User
+	if result := DB.Where("username = ?", vars["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var series Series
+	if result := DB.Where("user_id = ? AND slug = ?", user.ID, vars["slug"]).First(&series); result.Error != nil {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+
+	posts, err := seriesPosts(series.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve series", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(SeriesWithPosts{Series: series, Posts: toPostLinks(posts)})
+}
+
+// CreateSeries handles creating a series for the authenticated user's posts.
+func CreateSeries(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var series Series
+	err = json.NewDecoder(r.Body).Decode(&series)
+	if err != nil || strings.TrimSpace(series.Title) == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	series.ID = 0
+	series.UserID = userID
+	err = saveWithSlug(&series.Slug, func() error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			slug, err := chooseSlug(tx, slugKindSeries, userID, 0, "", series.Slug, series.Title)
+			if err != nil {
+				return err
+			}
+			series.Slug = slug
+			return tx.Create(&series).Error
+		})
+	})
+	if err != nil {
+		writeSaveError(w, err, "Failed to create series")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(series)
+}
+
+// UpdateSeries handles updating one of the authenticated user's series.
+func UpdateSeries(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	series, status, msg := findOwnedSeries(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var updatedSeries Series
+	err = json.NewDecoder(r.Body).Decode(&updatedSeries)
+	if err != nil || strings.TrimSpace(updatedSeries.Title) == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	series.Title = updatedSeries.Title
+	series.Description = updatedSeries.Description
+
+	err = saveWithSlug(&series.Slug, func() error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			slug, err := chooseSlug(tx, slugKindSeries, userID, series.ID, series.Slug, updatedSeries.Slug, series.Title)
+			if err != nil {
+				return err
+			}
+			series.Slug = slug
+			return tx.Save(&series).Error
+		})
+	})
+	if err != nil {
+		writeSaveError(w, err, "Failed to update series")
+		return
+	}
+
+	json.NewEncoder(w).Encode(series)
+}
+
+// SetSeriesPosts handles setting which of the authenticated user's posts
+// belong to a series and in what order. The request lists every post of the
+// series; posts previously in the series but left out are removed from it.
+func SetSeriesPosts(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	series, status, msg := findOwnedSeries(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var req struct {
+		PostIDs []uint `json:"post_ids"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	seen := make(map[uint]bool)
+	for _, id := range req.PostIDs {
+		if seen[id] {
+			http.Error(w, "Duplicate post in series", http.StatusBadRequest)
+			return
+		}
+		seen[id] = true
+	}
+
+	var owned int64
+	if len(req.PostIDs) > 0 {
+		if result := DB.Model(&Post{}).Where("id IN ? AND user_id = ?", req.PostIDs, userID).Count(&owned); result.Error != nil {
+			http.Error(w, "Failed to update series", http.StatusInternalServerError)
+			return
+		}
+	}
+	if int(owned) != len(req.PostIDs) {
+		http.Error(w, "Post not found or not authorized", http.StatusBadRequest)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Post{}).Where("series_id = ?", series.ID).
+			Updates(map[string]interface{}{"series_id": nil, "series_position": 0})
+		if result.Error != nil {
+			return result.Error
+		}
+		for i, id := range req.PostIDs {
+			result := tx.Model(&Post{}).Where("id = ?", id).
+				Updates(map[string]interface{}{"series_id": series.ID, "series_position": i + 1})
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to update series", http.StatusInternalServerError)
+		return
+	}
+
+	posts, err := seriesPosts(series.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve series", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(SeriesWithPosts{Series: series, Posts: toPostLinks(posts)})
+}
+
+// DeleteSeries handles deleting one of the authenticated user's series. Its
+// posts are kept as standalone posts.
+func DeleteSeries(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	series, status, msg := findOwnedSeries(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Post{}).Where("series_id = ?", series.ID).
+			Updates(map[string]interface{}{"series_id": nil, "series_position": 0})
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Delete(&series).Error
+	})
+	if err != nil {
+		http.Error(w, "Failed to delete series", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
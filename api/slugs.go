@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+const (
+	slugKindPost      = "post"
+	slugKindProject   = "project"
+	slugKindCategory  = "category"
+	slugKindSeries    = "series"
+	slugKindPortfolio = "portfolio"
+
+	maxSlugLength = 80
+)
+
+// reservedPortfolioSlugs are used by routes under /api/portfolio/{username}
+// and can't name a portfolio.
+var reservedPortfolioSlugs = map[string]bool{"webmentions": true}
+
+var (
+	// errSlugTaken is returned when a slug chosen by the user is already in use.
+	errSlugTaken = errors.New("slug is already in use")
+	// errInvalidSlug is returned when a slug chosen by the user has no usable characters.
+	errInvalidSlug = errors.New("invalid slug")
+)
+
+// slugify turns a title into a lowercase, hyphen-separated slug made of ASCII
+// letters and digits. Accented letters lose their accents first.
+func slugify(s string) string {
+	var sb strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(norm.NFKD.String(s)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if hyphen && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			hyphen = false
+			sb.WriteRune(r)
+		default:
+			hyphen = true
+		}
+		if sb.Len() >= maxSlugLength {
+			break
+		}
+	}
+	return strings.Trim(sb.String(), "-")
+}
+
+// slugTaken reports whether another item of the same kind and owner already
+// uses slug. Projects are owned by portfolios and everything else by users.
+// Reserved portfolio slugs always count as taken.
+func slugTaken(tx *gorm.DB, kind string, ownerID uint, slug string, excludeID uint) (bool, error) {
+	var count int64
+	var result *gorm.DB
+	switch kind {
+	case slugKindPost:
+		result = tx.Model(&Post{}).Where("user_id = ? AND slug = ? AND id <> ?", ownerID, slug, excludeID).Count(&count)
+	case slugKindProject:
+		result = tx.Model(&Project{}).Where("portfolio_id = ? AND slug = ? AND id <> ?", ownerID, slug, excludeID).Count(&count)
+	case slugKindCategory:
+		result = tx.Model(&Category{}).Where("user_id = ? AND slug = ? AND id <> ?", ownerID, slug, excludeID).Count(&count)
+	case slugKindSeries:
+		result = tx.Model(&Series{}).Where("user_id = ? AND slug = ? AND id <> ?", ownerID, slug, excludeID).Count(&count)
+	case slugKindPortfolio:
+		if reservedPortfolioSlugs[slug] {
+			return true, nil
+		}
+		result = tx.Model(&Portfolio{}).Where("user_id = ? AND slug = ? AND id <> ?", ownerID, slug, excludeID).Count(&count)
+	default:
+		return false, errors.New("unknown slug kind")
+	}
+	return count > 0, result.Error
+}
+
+// uniqueSlug derives a slug from title that no other item of the same owner
+// uses, appending a number if needed.
+func uniqueSlug(tx *gorm.DB, kind string, ownerID uint, title string, excludeID uint) (string, error) {
+	base := slugify(title)
+	if base == "" {
+		base = kind
+	}
+
+	slug := base
+	for i := 2; ; i++ {
+		taken, err := slugTaken(tx, kind, ownerID, slug, excludeID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return slug, nil
+		}
+		slug = base + "-" + strconv.Itoa(i)
+	}
+}
+
+// chooseSlug returns the slug an item should have after a create or update.
+// A slug requested by the user is normalised and must be free; otherwise the
+// current slug is kept, or one is generated from the title if there is none.
+func chooseSlug(tx *gorm.DB, kind string, ownerID, itemID uint, current, requested, title string) (string, error) {
+	if requested != "" {
+		slug := slugify(requested)
+		if slug == "" {
+			return "", errInvalidSlug
+		}
+		if slug == current {
+			return current, nil
+		}
+		taken, err := slugTaken(tx, kind, ownerID, slug, itemID)
+		if err != nil {
+			return "", err
+		}
+		if taken {
+			return "", errSlugTaken
+		}
+		return slug, nil
+	}
+	if current != "" {
+		return current, nil
+	}
+	return uniqueSlug(tx, kind, ownerID, title, itemID)
+}
+
+// slugIndexes are the unique indexes that keep slugs unique per owner. See
+// the Slug fields in models.go.
+var slugIndexes = []string{
+	"idx_posts_user_slug",
+	"idx_projects_portfolio_slug",
+	"idx_portfolios_user_slug",
+	"idx_categories_user_slug",
+	"idx_series_user_slug",
+}
+
+// maxSlugAttempts is how many times an item is saved before giving up on a
+// slug that keeps being taken by concurrent saves.
+const maxSlugAttempts = 3
+
+// isSlugConflict reports whether err is a violation of one of slugIndexes.
+func isSlugConflict(err error) bool {
+	return isUniqueViolation(err, slugIndexes...)
+}
+
+// saveWithSlug runs save, a transaction that chooses *slug with chooseSlug
+// and saves the item. slugTaken can't see a concurrent save that hasn't
+// committed yet, so if the unique index rejects the slug the save is run
+// again, with *slug restored, to choose another one. A slug requested by the
+// user is then found taken.
+func saveWithSlug(slug *string, save func() error) error {
+	original := *slug
+	for attempt := 1; ; attempt++ {
+		*slug = original
+		err := save()
+		if !isSlugConflict(err) {
+			return err
+		}
+		if attempt == maxSlugAttempts {
+			return errSlugTaken
+		}
+	}
+}
+
+// recordSlugChange remembers an item's previous slug so links to it keep
+// working after a rename.
+func recordSlugChange(tx *gorm.DB, kind string, ownerID, itemID uint, oldSlug, newSlug string) error {
+	// The new slug is live again, so it should no longer redirect anywhere
+	if result := tx.Where("kind = ? AND owner_id = ? AND slug = ?", kind, ownerID, newSlug).Delete(&SlugHistory{}); result.Error != nil {
+		return result.Error
+	}
+	if oldSlug == "" || oldSlug == newSlug {
+		return nil
+	}
+	history := SlugHistory{Kind: kind, OwnerID: ownerID, Slug: oldSlug, TargetID: itemID}
+	return tx.Create(&history).Error
+}
+
+// writeSaveError writes the response for a failed create or update. Problems
+// with the slug, category, selection or visibility chosen by the user are
+// reported to them; anything else is reported with the fallback message.
+func writeSaveError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, errSlugTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errInvalidSlug), errors.Is(err, errCategoryNotFound), errors.Is(err, errSelectionNotOwned),
+		errors.Is(err, errInvalidVisibility), errors.Is(err, errPasswordRequired),
+		errors.Is(err, errUnknownLayout), errors.Is(err, errInvalidThemeSettings),
+		errors.Is(err, errInvalidMedia), errors.Is(err, errTooManyMedia):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+// backfillSlugs generates slugs for posts, projects and portfolios created
+// before slugs existed.
+func backfillSlugs() {
+	var posts []Post
+	DB.Where("slug IS NULL OR slug = ''").Find(&posts)
+	for _, post := range posts {
+		slug, err := uniqueSlug(DB, slugKindPost, post.UserID, post.Title, post.ID)
+		if err == nil {
+			err = DB.Model(&post).UpdateColumn("slug", slug).Error
+		}
+		if err != nil {
+			log.Printf("Failed to generate slug for post %d: %v", post.ID, err)
+		}
+	}
+
+	var projects []Project
+	DB.Where("slug IS NULL OR slug = ''").Find(&projects)
+	for _, project := range projects {
+		slug, err := uniqueSlug(DB, slugKindProject, project.PortfolioID, project.Title, project.ID)
+		if err == nil {
+			err = DB.Model(&project).UpdateColumn("slug", slug).Error
+		}
+		if err != nil {
+			log.Printf("Failed to generate slug for project %d: %v", project.ID, err)
+		}
+	}
+
+	var portfolios []Portfolio
+	DB.Where("slug IS NULL OR slug = ''").Find(&portfolios)
+	for _, portfolio := range portfolios {
+		slug, err := uniqueSlug(DB, slugKindPortfolio, portfolio.UserID, portfolio.Title, portfolio.ID)
+		if err == nil {
+			err = DB.Model(&portfolio).UpdateColumn("slug", slug).Error
+		}
+		if err != nil {
+			log.Printf("Failed to generate slug for portfolio %d: %v", portfolio.ID, err)
+		}
+	}
+}
+
+// dedupeSlugs renames slugs shared by items of the same owner, which were
+// possible before slugs were uniquely indexed, by appending the item's ID to
+// all but the first of them.
+func dedupeSlugs() {
+	owners := []struct{ Table, Owner string }{
+		{"posts", "user_id"},
+		{"projects", "portfolio_id"},
+		{"portfolios", "user_id"},
+		{"categories", "user_id"},
+		{"series", "user_id"},
+	}
+	for _, o := range owners {
+		if !DB.Migrator().HasTable(o.Table) {
+			continue
+		}
+		result := DB.Exec(`UPDATE ` + o.Table + ` SET slug = ` + o.Table + `.slug || '-' || ` + o.Table + `.id
+FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY ` + o.Owner + `, slug ORDER BY id) AS n FROM ` + o.Table + `
+WHERE deleted_at IS NULL AND slug <> '') AS numbered
+WHERE ` + o.Table + `.id = numbered.id AND numbered.n > 1`)
+		if result.Error != nil {
+			log.Printf("Failed to rename duplicate %s slugs: %v", o.Table, result.Error)
+		}
+	}
+}
+
+// findSlugRedirect looks up the item an old slug used to point to.
+func findSlugRedirect(kind string, ownerID uint, slug string) (uint, bool) {
+	var history SlugHistory
+	if result := DB.Where("kind = ? AND owner_id = ? AND slug = ?", kind, ownerID, slug).Order("id desc").First(&history); result.Error != nil {
+		return 0, false
+	}
+	return history.TargetID, true
+}
+
+// GetPostBySlug handles getting a user's blog post by its slug. Old slugs
+// redirect permanently to the post's current URL.
+func GetPostBySlug(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var user User
+	if result := DB.Where("username = ?", vars["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var post Post
+	if result := DB.Preload("Tags").Where("user_id = ? AND slug = ?", user.ID, vars["slug"]).First(&post); result.Error != nil {
+		targetID, ok := findSlugRedirect(slugKindPost, user.ID, vars["slug"])
+		if ok && DB.Where("user_id = ?", user.ID).First(&post, targetID).Error == nil {
+			http.Redirect(w, r, "/api/users/"+user.Username+"/posts/"+post.Slug, http.StatusMovedPermanently)
+			return
+		}
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	recordPostView(r, post)
+	writePublicPost(w, post)
+}
+
+// GetProjectBySlug handles getting a single project from a user's portfolio
+// by its slug. Old slugs redirect permanently to the project's current URL.
+// Projects only shown by portfolios the caller may not see are not found.
+func GetProjectBySlug(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	currentUserID, _ := getUserIDFromContext(r)
+
+	var user User
+	if result := DB.Where("username = ?", vars["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	portfolio, err := mainPortfolio(DB, user.ID)
+	if err != nil {
+		http.Error(w, "Portfolio not found", http.StatusNotFound)
+		return
+	}
+
+	var project Project
+	if result := DB.Preload("Likes").Where("portfolio_id = ? AND slug = ?", portfolio.ID, vars["slug"]).First(&project); result.Error != nil {
+		targetID, ok := findSlugRedirect(slugKindProject, portfolio.ID, vars["slug"])
+		if ok && DB.Where("portfolio_id = ?", portfolio.ID).First(&project, targetID).Error == nil && projectVisible(r, user.ID, project) {
+			http.Redirect(w, r, "/api/users/"+user.Username+"/projects/"+project.Slug, http.StatusMovedPermanently)
+			return
+		}
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if !projectVisible(r, user.ID, project) {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	shown, err := hideBrokenProjectLinks([]Project{project})
+	if err != nil {
+		http.Error(w, "Failed to retrieve project", http.StatusInternalServerError)
+		return
+	}
+	project = shown[0]
+
+	recordView(r, PageView{OwnerID: user.ID, ProjectID: &project.ID})
+
+	publicProject := PublicProject{
+		Project:    project,
+		LikesCount: int64(len(project.Likes)),
+	}
+	for _, like := range project.Likes {
+		if like.UserID == currentUserID {
+			publicProject.LikedByUser = true
+			break
+		}
+	}
+
+	json.NewEncoder(w).Encode(publicProject)
+}
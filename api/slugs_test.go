@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm/schema"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Hello, World!":       "hello-world",
+		"  Go -- fast  ":      "go-fast",
+		"Ünïcode café":        "unicode-cafe",
+		"Crème brûlée":        "creme-brulee",
+		"???":                 "",
+		"Part 2: the sequel.": "part-2-the-sequel",
+	}
+	for in, want := range tests {
+		if got := slugify(in); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSlugIndexes(t *testing.T) {
+	for _, model := range []interface{}{&Post{}, &Project{}, &Portfolio{}, &Category{}, &Series{}} {
+		s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, idx := range s.ParseIndexes() {
+			slugIndex := false
+			for _, name := range slugIndexes {
+				slugIndex = slugIndex || name == idx.Name
+			}
+			if !slugIndex {
+				continue
+			}
+			found = true
+			if idx.Class != "UNIQUE" || len(idx.Fields) != 2 || idx.Fields[1].DBName != "slug" {
+				t.Errorf("%s: index %s isn't unique on the owner and slug", s.Table, idx.Name)
+			}
+		}
+		if !found {
+			t.Errorf("%s: no unique slug index", s.Table)
+		}
+	}
+}
+
+func TestSaveWithSlugRetriesConflicts(t *testing.T) {
+	conflict := fmt.Errorf("create: %w", &pgconn.PgError{Code: "23505", ConstraintName: "idx_posts_user_slug"})
+
+	slug := "requested"
+	attempts := 0
+	err := saveWithSlug(&slug, func() error {
+		if slug != "requested" {
+			t.Errorf("attempt %d started with slug %q", attempts+1, slug)
+		}
+		attempts++
+		slug = "chosen"
+		if attempts < 2 {
+			return conflict
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("got %v after %d attempts, want success after 2", err, attempts)
+	}
+
+	attempts = 0
+	err = saveWithSlug(&slug, func() error {
+		attempts++
+		return conflict
+	})
+	if !errors.Is(err, errSlugTaken) || attempts != maxSlugAttempts {
+		t.Errorf("got %v after %d attempts, want errSlugTaken after %d", err, attempts, maxSlugAttempts)
+	}
+
+	other := &pgconn.PgError{Code: "23505", ConstraintName: "idx_tags_name"}
+	attempts = 0
+	err = saveWithSlug(&slug, func() error {
+		attempts++
+		return other
+	})
+	if err != other || attempts != 1 {
+		t.Errorf("got %v after %d attempts, want other violations returned at once", err, attempts)
+	}
+}
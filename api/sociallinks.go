@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxSocialLinks is how many social links a user can have.
+const maxSocialLinks = 20
+
+// errInvalidSocialLink is wrapped by errors describing an invalid social link.
+var errInvalidSocialLink = errors.New("invalid social link")
+
+// SocialPlatform describes a site social links can point at. Profiles on most
+// platforms live at a fixed URL containing the user's handle; Mastodon
+// profiles live on the user's instance, and personal sites anywhere.
+type SocialPlatform struct {
+	Name  string   `json:"name"`
+	Label string   `json:"label"`
+	Hosts []string `json:"hosts,omitempty"` // Hosts profile URLs may be on, the canonical one first
+	// Path before the handle in profile URLs, e.g. "/in/" for LinkedIn
+	PathPrefix    string         `json:"-"`
+	HandlePattern *regexp.Regexp `json:"-"`
+	HandleExample string         `json:"handle_example,omitempty"`
+}
+
+// Platform names with special handling.
+const (
+	PlatformWebsite  = "website"
+	PlatformMastodon = "mastodon"
+)
+
+// socialPlatforms is the registry of known platforms.
+var socialPlatforms = []SocialPlatform{
+	{Name: "github", Label: "GitHub", Hosts: []string{"github.com"}, PathPrefix: "/",
+		HandlePattern: regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9]|-[A-Za-z0-9]){0,38}$`), HandleExample: "octocat"},
+	{Name: "gitlab", Label: "GitLab", Hosts: []string{"gitlab.com"}, PathPrefix: "/",
+		HandlePattern: regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,254}$`), HandleExample: "jdoe"},
+	{Name: "linkedin", Label: "LinkedIn", Hosts: []string{"www.linkedin.com", "linkedin.com"}, PathPrefix: "/in/",
+		HandlePattern: regexp.MustCompile(`^[A-Za-z0-9_%-]{3,100}$`), HandleExample: "jane-doe"},
+	{Name: PlatformMastodon, Label: "Mastodon",
+		HandlePattern: regexp.MustCompile(`^[A-Za-z0-9_]{1,30}$`), HandleExample: "@jdoe@mastodon.social"},
+	{Name: "x", Label: "X (Twitter)", Hosts: []string{"x.com", "twitter.com"}, PathPrefix: "/",
+		HandlePattern: regexp.MustCompile(`^[A-Za-z0-9_]{1,15}$`), HandleExample: "jdoe"},
+	{Name: "bluesky", Label: "Bluesky", Hosts: []string{"bsky.app"}, PathPrefix: "/profile/",
+		HandlePattern: regexp.MustCompile(`^[A-Za-z0-9.-]{3,253}$`), HandleExample: "jdoe.bsky.social"},
+	{Name: "instagram", Label: "Instagram", Hosts: []string{"www.instagram.com", "instagram.com"}, PathPrefix: "/",
+		HandlePattern: regexp.MustCompile(`^[A-Za-z0-9._]{1,30}$`), HandleExample: "jdoe"},
+	{Name: "youtube", Label: "YouTube", Hosts: []string{"www.youtube.com", "youtube.com"}, PathPrefix: "/@",
+		HandlePattern: regexp.MustCompile(`^[A-Za-z0-9._-]{3,30}$`), HandleExample: "jdoe"},
+	{Name: "stackoverflow", Label: "Stack Overflow", Hosts: []string{"stackoverflow.com"}, PathPrefix: "/users/",
+		HandlePattern: regexp.MustCompile(`^[0-9]{1,12}$`), HandleExample: "22656"},
+	{Name: "devto", Label: "DEV", Hosts: []string{"dev.to"}, PathPrefix: "/",
+		HandlePattern: regexp.MustCompile(`^[A-Za-z0-9_]{2,30}$`), HandleExample: "jdoe"},
+	{Name: PlatformWebsite, Label: "Website"},
+}
+
+// findSocialPlatform returns the platform with the given name.
+func findSocialPlatform(name string) (SocialPlatform, bool) {
+	for _, platform := range socialPlatforms {
+		if platform.Name == name {
+			return platform, true
+		}
+	}
+	return SocialPlatform{}, false
+}
+
+// platformForHost returns the platform whose profiles live on host, if any.
+func platformForHost(host string) (SocialPlatform, bool) {
+	host = strings.TrimPrefix(host, "m.")
+	for _, platform := range socialPlatforms {
+		for _, h := range platform.Hosts {
+			if host == h || "www."+host == h || host == "mobile."+h {
+				return platform, true
+			}
+		}
+	}
+	return SocialPlatform{}, false
+}
+
+// profileURL returns the canonical URL of a handle's profile.
+func (p SocialPlatform) profileURL(handle string) string {
+	return "https://" + p.Hosts[0] + p.PathPrefix + handle
+}
+
+// socialLinkInput is a social link as sent by clients: a profile URL, or a
+// platform and handle.
+type socialLinkInput struct {
+	Platform string `json:"platform"`
+	URL      string `json:"url"`
+	Handle   string `json:"handle"`
+}
+
+// normalizeSocialLink validates a social link and returns it in canonical
+// form. The platform is detected from the URL when it isn't given.
+func normalizeSocialLink(in socialLinkInput) (SocialLink, error) {
+	in.Platform = strings.ToLower(strings.TrimSpace(in.Platform))
+	in.URL = strings.TrimSpace(in.URL)
+	in.Handle = strings.TrimSpace(in.Handle)
+
+	var u *url.URL
+	if in.URL != "" {
+		raw := in.URL
+		if !strings.Contains(raw, "://") {
+			raw = "https://" + raw
+		}
+		var err error
+		if u, err = parseHTTPURL(raw); err != nil {
+			return SocialLink{}, fmt.Errorf("%w: %q is not a valid URL", errInvalidSocialLink, in.URL)
+		}
+		u.Host = strings.ToLower(u.Host)
+		u.Fragment = ""
+	}
+
+	if in.Platform == "" {
+		switch {
+		case u != nil:
+			in.Platform = PlatformWebsite
+			if platform, ok := platformForHost(u.Host); ok {
+				in.Platform = platform.Name
+			} else if strings.HasPrefix(u.Path, "/@") {
+				in.Platform = PlatformMastodon
+			}
+		case strings.Count(in.Handle, "@") == 2 || (strings.Count(in.Handle, "@") == 1 && !strings.HasPrefix(in.Handle, "@")):
+			in.Platform = PlatformMastodon
+		default:
+			return SocialLink{}, fmt.Errorf("%w: a platform is needed for a handle", errInvalidSocialLink)
+		}
+	}
+	platform, ok := findSocialPlatform(in.Platform)
+	if !ok {
+		return SocialLink{}, fmt.Errorf("%w: unknown platform %q", errInvalidSocialLink, in.Platform)
+	}
+
+	switch platform.Name {
+	case PlatformWebsite:
+		if u == nil {
+			return SocialLink{}, fmt.Errorf("%w: a website needs a URL", errInvalidSocialLink)
+		}
+		return SocialLink{Platform: platform.Name, URL: u.String(), Handle: u.Host}, nil
+
+	case PlatformMastodon:
+		var user, instance string
+		if u != nil {
+			user, instance = strings.TrimPrefix(strings.Trim(u.Path, "/"), "@"), u.Host
+			if !strings.HasPrefix(u.Path, "/@") {
+				return SocialLink{}, fmt.Errorf("%w: Mastodon profile URLs look like https://instance/@user", errInvalidSocialLink)
+			}
+		} else {
+			parts := strings.Split(strings.TrimPrefix(in.Handle, "@"), "@")
+			if len(parts) != 2 {
+				return SocialLink{}, fmt.Errorf("%w: Mastodon handles look like %s", errInvalidSocialLink, platform.HandleExample)
+			}
+			user, instance = parts[0], strings.ToLower(parts[1])
+		}
+		instance, err := normalizeDomain(instance)
+		if err != nil || !platform.HandlePattern.MatchString(user) {
+			return SocialLink{}, fmt.Errorf("%w: Mastodon handles look like %s", errInvalidSocialLink, platform.HandleExample)
+		}
+		return SocialLink{
+			Platform: platform.Name,
+			URL:      "https://" + instance + "/@" + user,
+			Handle:   "@" + user + "@" + instance,
+		}, nil
+	}
+
+	handle := strings.TrimPrefix(in.Handle, "@")
+	if u != nil {
+		if p, ok := platformForHost(u.Host); !ok || p.Name != platform.Name {
+			return SocialLink{}, fmt.Errorf("%w: %s is not a %s URL", errInvalidSocialLink, in.URL, platform.Label)
+		}
+		if !strings.HasPrefix(u.Path, platform.PathPrefix) {
+			return SocialLink{}, fmt.Errorf("%w: %s is not a %s profile URL", errInvalidSocialLink, in.URL, platform.Label)
+		}
+		handle = strings.SplitN(strings.TrimPrefix(u.Path, platform.PathPrefix), "/", 2)[0]
+	}
+	if !platform.HandlePattern.MatchString(handle) {
+		return SocialLink{}, fmt.Errorf("%w: %q is not a valid %s handle, e.g. %s", errInvalidSocialLink, handle, platform.Label, platform.HandleExample)
+	}
+	return SocialLink{Platform: platform.Name, URL: platform.profileURL(handle), Handle: handle}, nil
+}
+
+// parseLegacySocialLinks reads the links users entered before social links
+// had their own table: a JSON object of platform to URL, a JSON array of URLs,
+// or URLs separated by commas or whitespace.
+func parseLegacySocialLinks(s string) []socialLinkInput {
+	s = strings.TrimSpace(s)
+	var inputs []socialLinkInput
+
+	var byPlatform map[string]string
+	if json.Unmarshal([]byte(s), &byPlatform) == nil {
+		platforms := make([]string, 0, len(byPlatform))
+		for platform := range byPlatform {
+			platforms = append(platforms, platform)
+		}
+		sort.Strings(platforms)
+		for _, platform := range platforms {
+			input := socialLinkInput{Platform: strings.ToLower(platform), URL: byPlatform[platform]}
+			if _, ok := findSocialPlatform(input.Platform); !ok {
+				input.Platform = ""
+			}
+			if strings.HasPrefix(strings.TrimSpace(input.URL), "@") {
+				input.Handle, input.URL = input.URL, ""
+			}
+			inputs = append(inputs, input)
+		}
+		return inputs
+	}
+
+	var list []string
+	if json.Unmarshal([]byte(s), &list) != nil {
+		list = strings.FieldsFunc(s, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\n' || r == '\t' || r == '\r'
+		})
+	}
+	for _, link := range list {
+		inputs = append(inputs, socialLinkInput{URL: link})
+	}
+	return inputs
+}
+
+// dedupeSocialLinks deletes repeated links of a user, keeping the first. They
+// were created by migrateSocialMediaLinks running again on each start before
+// links were uniquely indexed.
+func dedupeSocialLinks() {
+	if !DB.Migrator().HasTable(&SocialLink{}) {
+		return
+	}
+	result := DB.Exec(`DELETE FROM social_links WHERE deleted_at IS NULL AND id NOT IN
+(SELECT MIN(id) FROM social_links WHERE deleted_at IS NULL GROUP BY user_id, url)`)
+	if result.Error != nil {
+		log.Printf("Failed to delete duplicate social links: %v", result.Error)
+	}
+}
+
+// migrateSocialMediaLinks moves the links in the old users.social_media_links
+// column into social links. Values that couldn't be fully migrated are left in
+// place and logged, and links migrated on an earlier run are skipped.
+func migrateSocialMediaLinks() {
+	if !DB.Migrator().HasColumn("users", "social_media_links") {
+		return
+	}
+
+	var rows []struct {
+		ID               uint
+		SocialMediaLinks string
+	}
+	DB.Table("users").Select("id, social_media_links").
+		Where("social_media_links IS NOT NULL AND social_media_links <> ''").Scan(&rows)
+
+	for _, row := range rows {
+		complete := true
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			var existing []string
+			if result := tx.Model(&SocialLink{}).Where("user_id = ?", row.ID).Pluck("url", &existing); result.Error != nil {
+				return result.Error
+			}
+			seen := make(map[string]bool)
+			for _, existingURL := range existing {
+				seen[existingURL] = true
+			}
+			for _, input := range parseLegacySocialLinks(row.SocialMediaLinks) {
+				link, err := normalizeSocialLink(input)
+				if err != nil {
+					log.Printf("Not migrating social link %q of user %d: %v", input.URL, row.ID, err)
+					complete = false
+					continue
+				}
+				if seen[link.URL] {
+					continue
+				}
+				seen[link.URL] = true
+				link.UserID = row.ID
+				link.Position = len(seen)
+				if result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&link); result.Error != nil {
+					return result.Error
+				}
+			}
+			if !complete {
+				return nil
+			}
+			return tx.Table("users").Where("id = ?", row.ID).Update("social_media_links", "").Error
+		})
+		if err != nil {
+			log.Printf("Failed to migrate social links of user %d: %v", row.ID, err)
+		}
+	}
+}
+
+// rel="me" verification
+
+// identityURLs returns the URLs that identify a user on this site, any of
+// which a profile may link back to with rel="me".
+func identityURLs(r *http.Request, user User) []string {
+	urls := []string{
+		portfolioURL(publicBaseURL(r), user.Username),
+		apiBaseURL(r) + "/ap/users/" + user.Username,
+	}
+	var domains []CustomDomain
+	DB.Where("user_id = ? AND verified_at IS NOT NULL", user.ID).Find(&domains)
+	for _, domain := range domains {
+		urls = append(urls, "https://"+domain.Domain, "http://"+domain.Domain)
+	}
+	return urls
+}
+
+// relMeLinks returns the targets of the rel="me" links in a document.
+func relMeLinks(n *html.Node, base *url.URL) []string {
+	var links []string
+	if n.Type == html.ElementNode && (n.Data == "a" || n.Data == "link") {
+		for _, rel := range strings.Fields(strings.ToLower(attr(n, "rel"))) {
+			if rel != "me" {
+				continue
+			}
+			if href, err := base.Parse(attr(n, "href")); err == nil {
+				links = append(links, href.String())
+			}
+			break
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		links = append(links, relMeLinks(c, base)...)
+	}
+	return links
+}
+
+// socialLinkJobs holds rel="me" verifications of social links waiting to be
+// run in the background.
+var socialLinkJobs = make(chan func(), 1000)
+
+// StartSocialLinkVerifiers starts the background workers that verify social
+// links.
+func StartSocialLinkVerifiers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for job := range socialLinkJobs {
+				job()
+			}
+		}()
+	}
+}
+
+// enqueueSocialLinkJob queues a job, reporting false if the queue is full
+// and the job was dropped.
+func enqueueSocialLinkJob(job func()) bool {
+	select {
+	case socialLinkJobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// verifySocialLink fetches the page a social link points to and checks that
+// it links back to one of identities with rel="me".
+func verifySocialLink(linkID uint, identities []string) {
+	var link SocialLink
+	if result := DB.First(&link, linkID); result.Error != nil {
+		return
+	}
+
+	verr := func() error {
+		resp, body, err := fetchPage(link.URL)
+		if err != nil {
+			return errors.New("the page could not be fetched")
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("the page returned status %d", resp.StatusCode)
+		}
+		if !isHTML(resp) {
+			return errors.New("the page is not HTML")
+		}
+		doc, err := html.Parse(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("parsing the page: %v", err)
+		}
+		for _, target := range relMeLinks(doc, resp.Request.URL) {
+			for _, identity := range identities {
+				if sameURL(target, identity) {
+					return nil
+				}
+			}
+		}
+		return errors.New(`the page has no rel="me" link back to your profile`)
+	}()
+
+	now := time.Now()
+	updates := map[string]interface{}{"last_checked_at": now, "verification_error": ""}
+	if verr != nil {
+		updates["verification_error"] = verr.Error()
+		updates["verified_at"] = nil
+	} else {
+		updates["verified_at"] = now
+	}
+	if result := DB.Model(&link).UpdateColumns(updates); result.Error != nil {
+		log.Printf("Failed to record verification of social link %d: %v", link.ID, result.Error)
+	}
+}
+
+// userSocialLinks returns a user's social links in their chosen order.
+func userSocialLinks(userID uint) ([]SocialLink, error) {
+	var links []SocialLink
+	result := DB.Where("user_id = ?", userID).Order("position asc, id asc").Find(&links)
+	return links, result.Error
+}
+
+// GetSocialPlatforms handles listing the platforms social links can point at.
+func GetSocialPlatforms(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(socialPlatforms)
+}
+
+// GetSocialLinks handles listing the authenticated user's social links.
+func GetSocialLinks(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	links, err := userSocialLinks(userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve social links", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(links)
+}
+
+// UpdateSocialLinks handles replacing the authenticated user's social links.
+// The request lists every link in display order; links that were already
+// there keep their verification, and new ones are verified in the background.
+func UpdateSocialLinks(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var inputs []socialLinkInput
+	err = json.NewDecoder(r.Body).Decode(&inputs)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if len(inputs) > maxSocialLinks {
+		http.Error(w, fmt.Sprintf("At most %d social links are allowed", maxSocialLinks), http.StatusBadRequest)
+		return
+	}
+
+	links := make([]SocialLink, 0, len(inputs))
+	seen := make(map[string]bool)
+	for _, input := range inputs {
+		link, err := normalizeSocialLink(input)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if seen[link.URL] {
+			http.Error(w, fmt.Sprintf("%s is listed more than once", link.URL), http.StatusBadRequest)
+			return
+		}
+		seen[link.URL] = true
+		links = append(links, link)
+	}
+
+	var unverified []uint
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var existing []SocialLink
+		if result := tx.Where("user_id = ?", userID).Find(&existing); result.Error != nil {
+			return result.Error
+		}
+		byURL := make(map[string]SocialLink)
+		for _, link := range existing {
+			byURL[link.URL] = link
+		}
+
+		for i := range links {
+			links[i].UserID = userID
+			links[i].Position = i + 1
+			if old, ok := byURL[links[i].URL]; ok {
+				links[i].ID = old.ID
+				links[i].CreatedAt = old.CreatedAt
+				links[i].VerifiedAt = old.VerifiedAt
+				links[i].LastCheckedAt = old.LastCheckedAt
+				links[i].VerificationError = old.VerificationError
+				delete(byURL, links[i].URL)
+			}
+			if result := tx.Save(&links[i]); result.Error != nil {
+				return result.Error
+			}
+			if links[i].LastCheckedAt == nil {
+				unverified = append(unverified, links[i].ID)
+			}
+		}
+		for _, removed := range byURL {
+			if result := tx.Delete(&removed); result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to update social links", http.StatusInternalServerError)
+		return
+	}
+
+	if len(unverified) > 0 {
+		var user User
+		if result := DB.First(&user, userID); result.Error == nil {
+			identities := identityURLs(r, user)
+			for _, id := range unverified {
+				id := id
+				if !enqueueSocialLinkJob(func() { verifySocialLink(id, identities) }) {
+					log.Printf("Social link queue is full, not verifying social link %d", id)
+				}
+			}
+		}
+	}
+
+	json.NewEncoder(w).Encode(links)
+}
+
+// VerifySocialLink handles checking straight away that one of the
+// authenticated user's social links points at a page linking back to them
+// with rel="me".
+func VerifySocialLink(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	linkID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid social link ID", http.StatusBadRequest)
+		return
+	}
+
+	var link SocialLink
+	if result := DB.Where("user_id = ?", userID).First(&link, linkID); result.Error != nil {
+		http.Error(w, "Social link not found or not authorized", http.StatusNotFound)
+		return
+	}
+
+	var user User
+	if result := DB.First(&user, userID); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	verifySocialLink(link.ID, identityURLs(r, user))
+
+	DB.First(&link, link.ID)
+	json.NewEncoder(w).Encode(link)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// UnmarshalJSON lets tags in request payloads be given either as plain names
+// or as tag objects.
+func (t *Tag) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = Tag{Name: name}
+		return nil
+	}
+
+	type tagAlias Tag
+	var alias tagAlias
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+	*t = Tag(alias)
+	return nil
+}
+
+// resolveTags finds or creates the tags with the given names, ignoring
+// blank and duplicate names.
+func resolveTags(tx *gorm.DB, requested []Tag) ([]Tag, error) {
+	tags := []Tag{}
+	seen := make(map[string]bool)
+	for _, t := range requested {
+		name := strings.TrimSpace(t.Name)
+		slug := slugify(name)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+
+		tag := Tag{Name: name, Slug: slug}
+		if result := tx.Where("slug = ?", slug).FirstOrCreate(&tag); result.Error != nil {
+			return nil, result.Error
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// errCategoryNotFound is returned when a post is assigned a category its author doesn't have.
+var errCategoryNotFound = errors.New("category not found")
+
+// resolveCategory checks that a category chosen for a post belongs to its
+// author. A zero id removes the post from its category.
+func resolveCategory(tx *gorm.DB, userID uint, categoryID *uint) (*uint, error) {
+	if categoryID == nil || *categoryID == 0 {
+		return nil, nil
+	}
+	var category Category
+	if result := tx.Where("user_id = ?", userID).First(&category, *categoryID); result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, errCategoryNotFound
+		}
+		return nil, result.Error
+	}
+	return &category.ID, nil
+}
+
+// TagCount is a tag together with the number of published posts using it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int64  `json:"count"`
+}
+
+// GetTags handles getting the tag cloud: every tag used by a published post,
+// with the number of posts using it. An "author" query parameter restricts
+// the cloud to one user's posts.
+func GetTags(w http.ResponseWriter, r *http.Request) {
+	query := DB.Table("tags").
+		Select("tags.name, tags.slug, COUNT(posts.id) AS count").
+		Joins("JOIN post_tags ON post_tags.tag_id = tags.id").
+		Joins("JOIN posts ON posts.id = post_tags.post_id AND posts.deleted_at IS NULL").
+		Where("tags.deleted_at IS NULL AND posts.published_at <= ?", time.Now())
+
+	if username := r.URL.Query().Get("author"); username != "" {
+		var user User
+		if result := DB.Where("username = ?", username).First(&user); result.Error != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		query = query.Where("posts.user_id = ?", user.ID)
+	}
+
+	tags := []TagCount{}
+	if result := query.Group("tags.name, tags.slug").Order("count desc, tags.slug asc").Scan(&tags); result.Error != nil {
+		http.Error(w, "Failed to retrieve tags", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tags)
+}
+
+// GetTagPosts handles listing the posts with a tag. It accepts the same
+// pagination and filtering parameters as GetPosts.
+func GetTagPosts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	q.Set("tag", mux.Vars(r)["slug"])
+	r.URL.RawQuery = q.Encode()
+	GetPosts(w, r)
+}
+
+// CategoryCount is a category together with the number of posts in it.
+type CategoryCount struct {
+	Category
+	Count int64 `json:"count"`
+}
+
+// GetCategories handles listing a user's categories with their post counts.
+func GetCategories(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if result := DB.Where("username = ?", mux.Vars(r)["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var categories []Category
+	if result := DB.Where("user_id = ?", user.ID).Order("name asc").Find(&categories); result.Error != nil {
+		http.Error(w, "Failed to retrieve categories", http.StatusInternalServerError)
+		return
+	}
+
+	var rows []struct {
+		CategoryID uint
+		Count      int64
+	}
+	result := DB.Model(&Post{}).
+		Select("category_id, COUNT(*) AS count").
+		Where("user_id = ? AND category_id IS NOT NULL AND published_at <= ?", user.ID, time.Now()).
+		Group("category_id").
+		Scan(&rows)
+	if result.Error != nil {
+		http.Error(w, "Failed to retrieve categories", http.StatusInternalServerError)
+		return
+	}
+	counts := make(map[uint]int64)
+	for _, row := range rows {
+		counts[row.CategoryID] = row.Count
+	}
+
+	response := make([]CategoryCount, len(categories))
+	for i, c := range categories {
+		response[i] = CategoryCount{Category: c, Count: counts[c.ID]}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetCategoryPosts handles listing a user's posts in one category. It accepts
+// the same pagination and filtering parameters as GetPosts.
+func GetCategoryPosts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	q := r.URL.Query()
+	q.Set("author", vars["username"])
+	q.Set("category", vars["slug"])
+	r.URL.RawQuery = q.Encode()
+	GetPosts(w, r)
+}
+
+// CreateCategory handles creating a category for the authenticated user's posts.
+func CreateCategory(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var category Category
+	err = json.NewDecoder(r.Body).Decode(&category)
+	if err != nil || strings.TrimSpace(category.Name) == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	category.ID = 0
+	category.UserID = userID
+	err = saveWithSlug(&category.Slug, func() error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			slug, err := chooseSlug(tx, slugKindCategory, userID, 0, "", category.Slug, category.Name)
+			if err != nil {
+				return err
+			}
+			category.Slug = slug
+			return tx.Create(&category).Error
+		})
+	})
+	if err != nil {
+		writeSaveError(w, err, "Failed to create category")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(category)
+}
+
+// UpdateCategory handles renaming one of the authenticated user's categories.
+func UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	categoryID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var category Category
+	if result := DB.Where("user_id = ?", userID).First(&category, categoryID); result.Error != nil {
+		http.Error(w, "Category not found or not authorized", http.StatusNotFound)
+		return
+	}
+
+	var updatedCategory Category
+	err = json.NewDecoder(r.Body).Decode(&updatedCategory)
+	if err != nil || strings.TrimSpace(updatedCategory.Name) == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	category.Name = updatedCategory.Name
+	category.Description = updatedCategory.Description
+
+	err = saveWithSlug(&category.Slug, func() error {
+		return DB.Transaction(func(tx *gorm.DB) error {
+			slug, err := chooseSlug(tx, slugKindCategory, userID, category.ID, category.Slug, updatedCategory.Slug, category.Name)
+			if err != nil {
+				return err
+			}
+			category.Slug = slug
+			return tx.Save(&category).Error
+		})
+	})
+	if err != nil {
+		writeSaveError(w, err, "Failed to update category")
+		return
+	}
+
+	json.NewEncoder(w).Encode(category)
+}
+
+// DeleteCategory handles deleting one of the authenticated user's categories.
+// Its posts are kept but no longer belong to a category.
+func DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	categoryID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var category Category
+	if result := DB.Where("user_id = ?", userID).First(&category, categoryID); result.Error != nil {
+		http.Error(w, "Category not found or not authorized", http.StatusNotFound)
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Model(&Post{}).Where("category_id = ?", category.ID).Update("category_id", nil); result.Error != nil {
+			return result.Error
+		}
+		return tx.Delete(&category).Error
+	})
+	if err != nil {
+		http.Error(w, "Failed to delete category", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
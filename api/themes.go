@@ -0,0 +1,348 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+)
+
+const defaultLayout = "default"
+
+var (
+	// errUnknownLayout is returned for a portfolio layout that isn't in the registry.
+	errUnknownLayout = errors.New("unknown layout")
+	// errInvalidThemeSettings is wrapped by errors describing an invalid theme setting.
+	errInvalidThemeSettings = errors.New("invalid theme settings")
+)
+
+// Types of theme settings.
+const (
+	ThemeSettingColor   = "color"   // A "#rrggbb" colour
+	ThemeSettingFont    = "font"    // One of themeFonts
+	ThemeSettingBoolean = "boolean" // Usually toggles a section
+	ThemeSettingSelect  = "select"  // One of the setting's options
+	ThemeSettingInteger = "integer" // A whole number between the setting's minimum and maximum
+)
+
+// themeFonts are the font families themes can be set in.
+var themeFonts = []string{"sans", "serif", "mono"}
+
+var themeColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ThemeSetting describes one setting of a theme.
+type ThemeSetting struct {
+	Key     string      `json:"key"`
+	Label   string      `json:"label"`
+	Type    string      `json:"type"`
+	Default interface{} `json:"default"`
+	Options []string    `json:"options,omitempty"`
+	Minimum int         `json:"minimum,omitempty"`
+	Maximum int         `json:"maximum,omitempty"`
+}
+
+// Theme is a layout portfolios can be shown with, together with the settings
+// it takes.
+type Theme struct {
+	Name        string         `json:"name"`
+	Label       string         `json:"label"`
+	Description string         `json:"description"`
+	Settings    []ThemeSetting `json:"settings"`
+}
+
+// commonThemeSettings returns the colour, font and section settings every
+// theme has, with the theme's own defaults.
+func commonThemeSettings(accent, text, background, font string) []ThemeSetting {
+	return []ThemeSetting{
+		{Key: "accent_color", Label: "Accent colour", Type: ThemeSettingColor, Default: accent},
+		{Key: "text_color", Label: "Text colour", Type: ThemeSettingColor, Default: text},
+		{Key: "background_color", Label: "Background colour", Type: ThemeSettingColor, Default: background},
+		{Key: "font", Label: "Font", Type: ThemeSettingFont, Default: font},
+		{Key: "show_about", Label: "Show about section", Type: ThemeSettingBoolean, Default: true},
+		{Key: "show_achievements", Label: "Show achievements", Type: ThemeSettingBoolean, Default: true},
+		{Key: "show_posts", Label: "Show recent posts", Type: ThemeSettingBoolean, Default: true},
+		{Key: "show_contact", Label: "Show contact details", Type: ThemeSettingBoolean, Default: true},
+	}
+}
+
+// themeRegistry lists the layouts a portfolio can use.
+var themeRegistry = []Theme{
+	{
+		Name:        "default",
+		Label:       "Default",
+		Description: "Projects as cards below an introduction and about section.",
+		Settings:    commonThemeSettings("#2563eb", "#1f2933", "#ffffff", "sans"),
+	},
+	{
+		Name:        "compact",
+		Label:       "Compact",
+		Description: "A single narrow column of text, for portfolios that are mostly words.",
+		Settings: append(commonThemeSettings("#222222", "#222222", "#fffdf8", "serif"),
+			ThemeSetting{Key: "show_project_images", Label: "Show project images", Type: ThemeSettingBoolean, Default: false}),
+	},
+	{
+		Name:        "grid",
+		Label:       "Grid",
+		Description: "Projects in an image-led grid.",
+		Settings: append(commonThemeSettings("#2563eb", "#1f2933", "#f5f7fa", "sans"),
+			ThemeSetting{Key: "columns", Label: "Columns", Type: ThemeSettingInteger, Default: 3, Minimum: 2, Maximum: 4}),
+	},
+}
+
+// findTheme returns the theme with the given name.
+func findTheme(name string) (Theme, bool) {
+	for _, theme := range themeRegistry {
+		if theme.Name == name {
+			return theme, true
+		}
+	}
+	return Theme{}, false
+}
+
+// validate checks a setting's value, returning it in the form it is stored in.
+func (s ThemeSetting) validate(value interface{}) (interface{}, error) {
+	invalid := func(expected string) error {
+		return fmt.Errorf("%w: %s must be %s", errInvalidThemeSettings, s.Key, expected)
+	}
+	switch s.Type {
+	case ThemeSettingColor:
+		str, ok := value.(string)
+		if !ok || !themeColorPattern.MatchString(str) {
+			return nil, invalid("a colour like #1a2b3c")
+		}
+		return str, nil
+	case ThemeSettingFont, ThemeSettingSelect:
+		options := s.Options
+		if s.Type == ThemeSettingFont {
+			options = themeFonts
+		}
+		if str, ok := value.(string); ok {
+			for _, option := range options {
+				if str == option {
+					return str, nil
+				}
+			}
+		}
+		return nil, invalid(fmt.Sprintf("one of %q", options))
+	case ThemeSettingBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, invalid("true or false")
+		}
+		return b, nil
+	case ThemeSettingInteger:
+		var n float64
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case int:
+			n = float64(v)
+		default:
+			return nil, invalid(fmt.Sprintf("a whole number from %d to %d", s.Minimum, s.Maximum))
+		}
+		if n != math.Trunc(n) || n < float64(s.Minimum) || n > float64(s.Maximum) {
+			return nil, invalid(fmt.Sprintf("a whole number from %d to %d", s.Minimum, s.Maximum))
+		}
+		return int(n), nil
+	}
+	return nil, invalid("valid")
+}
+
+// Validate checks settings against the theme, rejecting unknown settings and
+// invalid values.
+func (t Theme) Validate(settings ThemeSettings) (ThemeSettings, error) {
+	valid := make(ThemeSettings)
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		setting, ok := t.setting(key)
+		if !ok {
+			return nil, fmt.Errorf("%w: the %s layout has no setting %q", errInvalidThemeSettings, t.Name, key)
+		}
+		value, err := setting.validate(settings[key])
+		if err != nil {
+			return nil, err
+		}
+		valid[key] = value
+	}
+	return valid, nil
+}
+
+// Prune returns the settings that are valid for the theme, dropping the rest.
+// It is used when a portfolio changes layout without sending new settings.
+func (t Theme) Prune(settings ThemeSettings) ThemeSettings {
+	valid := make(ThemeSettings)
+	for key, value := range settings {
+		setting, ok := t.setting(key)
+		if !ok {
+			continue
+		}
+		if value, err := setting.validate(value); err == nil {
+			valid[key] = value
+		}
+	}
+	return valid
+}
+
+// Resolve returns settings with the theme's defaults filled in.
+func (t Theme) Resolve(settings ThemeSettings) ThemeSettings {
+	resolved := t.Prune(settings)
+	for _, setting := range t.Settings {
+		if _, ok := resolved[setting.Key]; !ok {
+			resolved[setting.Key] = setting.Default
+		}
+	}
+	return resolved
+}
+
+func (t Theme) setting(key string) (ThemeSetting, bool) {
+	for _, setting := range t.Settings {
+		if setting.Key == key {
+			return setting, true
+		}
+	}
+	return ThemeSetting{}, false
+}
+
+// Schema returns a JSON Schema describing the theme's settings.
+func (t Theme) Schema() map[string]interface{} {
+	properties := make(map[string]interface{})
+	for _, setting := range t.Settings {
+		property := map[string]interface{}{"title": setting.Label, "default": setting.Default}
+		switch setting.Type {
+		case ThemeSettingColor:
+			property["type"] = "string"
+			property["format"] = "color"
+			property["pattern"] = themeColorPattern.String()
+		case ThemeSettingFont:
+			property["type"] = "string"
+			property["enum"] = themeFonts
+		case ThemeSettingSelect:
+			property["type"] = "string"
+			property["enum"] = setting.Options
+		case ThemeSettingBoolean:
+			property["type"] = "boolean"
+		case ThemeSettingInteger:
+			property["type"] = "integer"
+			property["minimum"] = setting.Minimum
+			property["maximum"] = setting.Maximum
+		}
+		properties[setting.Key] = property
+	}
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                t.Label + " layout settings",
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// ThemeSettings are a portfolio's values for its layout's settings. Only
+// values that differ from the defaults need to be stored.
+type ThemeSettings map[string]interface{}
+
+// Value stores the settings as JSON.
+func (s ThemeSettings) Value() (driver.Value, error) {
+	if s == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(s)
+	return string(data), err
+}
+
+// Scan reads settings stored as JSON.
+func (s *ThemeSettings) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*s = ThemeSettings{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into ThemeSettings", src)
+	}
+	settings := ThemeSettings{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	*s = settings
+	return nil
+}
+
+// applyTheme sets a portfolio's layout and theme settings. Settings that are
+// sent must all be valid for the layout; when none are sent, the current ones
+// are kept as far as the layout allows. An empty layout means the default.
+func applyTheme(portfolio *Portfolio, layout string, settings ThemeSettings) error {
+	if layout == "" {
+		layout = defaultLayout
+	}
+	theme, ok := findTheme(layout)
+	if !ok {
+		return fmt.Errorf("%w %q", errUnknownLayout, layout)
+	}
+
+	if settings == nil {
+		portfolio.ThemeSettings = theme.Prune(portfolio.ThemeSettings)
+	} else {
+		valid, err := theme.Validate(settings)
+		if err != nil {
+			return err
+		}
+		portfolio.ThemeSettings = valid
+	}
+	portfolio.Layout = layout
+	return nil
+}
+
+// portfolioTheme returns a portfolio's theme and its resolved settings.
+func portfolioTheme(portfolio Portfolio) (Theme, ThemeSettings) {
+	theme, ok := findTheme(portfolio.Layout)
+	if !ok {
+		theme, _ = findTheme(defaultLayout)
+	}
+	return theme, theme.Resolve(portfolio.ThemeSettings)
+}
+
+// ThemeInfo is a theme as listed by the API, with its settings as a JSON
+// Schema and their defaults.
+type ThemeInfo struct {
+	Theme
+	Schema   map[string]interface{} `json:"schema"`
+	Defaults ThemeSettings          `json:"defaults"`
+}
+
+// GetThemes handles listing the layouts portfolios can use and their settings.
+func GetThemes(w http.ResponseWriter, r *http.Request) {
+	themes := make([]ThemeInfo, len(themeRegistry))
+	for i, theme := range themeRegistry {
+		themes[i] = ThemeInfo{Theme: theme, Schema: theme.Schema(), Defaults: theme.Resolve(nil)}
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(themes)
+}
+
+// backfillLayouts moves portfolios whose layout isn't in the registry to the
+// default layout.
+func backfillLayouts() {
+	names := make([]string, len(themeRegistry))
+	for i, theme := range themeRegistry {
+		names[i] = theme.Name
+	}
+	result := DB.Model(&Portfolio{}).Where("layout IS NULL OR layout NOT IN ?", names).UpdateColumn("layout", defaultLayout)
+	if result.Error != nil {
+		log.Printf("Failed to reset unknown portfolio layouts: %v", result.Error)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	// portfolioPasswordHeader carries the password of a password-protected portfolio.
+	portfolioPasswordHeader = "X-Portfolio-Password"
+	// shareTokenParam is the query parameter carrying a share link's token.
+	shareTokenParam = "share"
+
+	defaultShareLinkLifetime = 14 * 24 * time.Hour
+	maxShareLinkLifetime     = 365 * 24 * time.Hour
+
+	// A client may get a portfolio's password wrong this many times per
+	// window before further attempts are refused until the window ends.
+	maxPasswordFailures   = 5
+	passwordFailureWindow = 15 * time.Minute
+)
+
+var (
+	// errInvalidVisibility is returned for an unknown portfolio visibility.
+	errInvalidVisibility = errors.New("visibility must be public, unlisted, private or password")
+	// errPasswordRequired is returned when a portfolio is made password-protected without a password.
+	errPasswordRequired = errors.New("a password is required for password-protected portfolios")
+)
+
+// applyVisibility sets a portfolio's visibility. A password is required when
+// a portfolio first becomes password-protected; sending one later changes it.
+// An empty visibility leaves the current one.
+func applyVisibility(portfolio *Portfolio, visibility, password string) error {
+	if visibility == "" {
+		visibility = portfolio.Visibility
+	}
+	switch visibility {
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		portfolio.Visibility = visibility
+		portfolio.PasswordHash = ""
+		return nil
+	case VisibilityPassword:
+		if password == "" {
+			if portfolio.PasswordHash == "" {
+				return errPasswordRequired
+			}
+			portfolio.Visibility = visibility
+			return nil
+		}
+		hash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+		portfolio.Visibility = visibility
+		portfolio.PasswordHash = hash
+		return nil
+	}
+	return errInvalidVisibility
+}
+
+// passwordFailureKey identifies the attempts of one client at one portfolio's password.
+type passwordFailureKey struct {
+	IP          string
+	PortfolioID uint
+}
+
+// passwordFailures counts the wrong passwords given for portfolios in the
+// current window of each client, so passwords can't be guessed quickly and
+// bcrypt's deliberate slowness can't be used to load the server.
+var passwordFailures = struct {
+	sync.Mutex
+	windows map[passwordFailureKey]*passwordFailureCount
+}{windows: make(map[passwordFailureKey]*passwordFailureCount)}
+
+type passwordFailureCount struct {
+	Start time.Time
+	Count int
+}
+
+// passwordAttemptsExhausted reports whether a client has given too many wrong
+// passwords for a portfolio in the current window.
+func passwordAttemptsExhausted(key passwordFailureKey) bool {
+	passwordFailures.Lock()
+	defer passwordFailures.Unlock()
+	window := passwordFailures.windows[key]
+	return window != nil && time.Since(window.Start) < passwordFailureWindow && window.Count >= maxPasswordFailures
+}
+
+// recordPasswordFailure counts a wrong password, forgetting windows that
+// have ended.
+func recordPasswordFailure(key passwordFailureKey) {
+	passwordFailures.Lock()
+	defer passwordFailures.Unlock()
+	now := time.Now()
+	window := passwordFailures.windows[key]
+	if window == nil || now.Sub(window.Start) >= passwordFailureWindow {
+		for k, w := range passwordFailures.windows {
+			if now.Sub(w.Start) >= passwordFailureWindow {
+				delete(passwordFailures.windows, k)
+			}
+		}
+		window = &passwordFailureCount{Start: now}
+		passwordFailures.windows[key] = window
+	}
+	window.Count++
+}
+
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkPortfolioAccess decides whether the caller may see a portfolio. It
+// returns 0 if they may, http.StatusUnauthorized if the portfolio needs a
+// password they haven't given, http.StatusTooManyRequests if they have given
+// too many wrong ones, and http.StatusNotFound otherwise so that private
+// portfolios can't be told apart from missing ones. Visits through a share
+// link are recorded.
+func checkPortfolioAccess(r *http.Request, portfolio Portfolio) int {
+	return portfolioAccess(r, portfolio, true)
+}
+
+// portfolioAccess is checkPortfolioAccess, trying the caller's password only
+// if tryPassword is set.
+func portfolioAccess(r *http.Request, portfolio Portfolio, tryPassword bool) int {
+	if currentUserID, err := getUserIDFromContext(r); err == nil && currentUserID == portfolio.UserID {
+		return 0
+	}
+
+	if portfolioPublic(portfolio) {
+		return 0
+	}
+
+	if token := r.URL.Query().Get(shareTokenParam); token != "" {
+		var link ShareLink
+		result := DB.Where("token_hash = ? AND portfolio_id = ? AND expires_at > ?", hashShareToken(token), portfolio.ID, time.Now()).First(&link)
+		if result.Error == nil {
+			recordShareAccess(r, link)
+			return 0
+		}
+	}
+
+	if portfolio.Visibility == VisibilityPassword {
+		password := r.Header.Get(portfolioPasswordHeader)
+		if password == "" || !tryPassword {
+			return http.StatusUnauthorized
+		}
+		key := passwordFailureKey{IP: clientIP(r), PortfolioID: portfolio.ID}
+		if passwordAttemptsExhausted(key) {
+			return http.StatusTooManyRequests
+		}
+		if CheckPasswordHash(password, portfolio.PasswordHash) {
+			return 0
+		}
+		recordPasswordFailure(key)
+		return http.StatusUnauthorized
+	}
+	return http.StatusNotFound
+}
+
+// writePortfolioAccessError writes the response for a portfolio the caller may not see.
+func writePortfolioAccessError(w http.ResponseWriter, status int) {
+	switch status {
+	case http.StatusUnauthorized:
+		http.Error(w, "Password required", http.StatusUnauthorized)
+		return
+	case http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", strconv.Itoa(int(passwordFailureWindow.Seconds())))
+		http.Error(w, "Too many wrong passwords, try again later", http.StatusTooManyRequests)
+		return
+	}
+	http.Error(w, "Portfolio not found", http.StatusNotFound)
+}
+
+// setPortfolioVisibilityHeaders keeps portfolios that aren't public out of
+// search engines and, when they need a password or link, out of shared caches.
+func setPortfolioVisibilityHeaders(w http.ResponseWriter, portfolio Portfolio) {
+	switch portfolio.Visibility {
+	case VisibilityUnlisted:
+		w.Header().Set("X-Robots-Tag", "noindex")
+	case VisibilityPrivate, VisibilityPassword:
+		w.Header().Set("X-Robots-Tag", "noindex")
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+}
+
+// recordShareAccess adds a visit to a share link's access log.
+func recordShareAccess(r *http.Request, link ShareLink) {
+	access := ShareLinkAccess{
+		ShareLinkID: link.ID,
+		UserAgent:   r.UserAgent(),
+		Referrer:    r.Referer(),
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Create(&access); result.Error != nil {
+			return result.Error
+		}
+		return tx.Model(&link).UpdateColumns(map[string]interface{}{
+			"access_count":     gorm.Expr("access_count + 1"),
+			"last_accessed_at": access.CreatedAt,
+		}).Error
+	})
+	if err != nil {
+		log.Printf("Failed to record access to share link %d: %v", link.ID, err)
+	}
+}
+
+// projectVisible reports whether the caller may see a project: it must be
+// shown by a portfolio of its owner that they may see. The main portfolio
+// shows every project.
+func projectVisible(r *http.Request, userID uint, project Project) bool {
+	var portfolios []Portfolio
+	result := DB.Where("user_id = ?", userID).
+		Where("id = (SELECT MIN(id) FROM portfolios WHERE user_id = ? AND deleted_at IS NULL) OR id IN (SELECT portfolio_id FROM portfolio_projects WHERE project_id = ?)", userID, project.ID).
+		Order("id asc").Find(&portfolios)
+	if result.Error != nil {
+		return false
+	}
+	return anyPortfolioAccessible(r, portfolios)
+}
+
+// anyPortfolioAccessible reports whether the caller may see one of the
+// portfolios showing a project. A portfolio named with the "portfolio" query
+// parameter is the only one checked. Otherwise a password is only tried
+// against the first portfolio needing one, so a request costs at most one
+// password check and a password for one portfolio isn't counted as a wrong
+// guess for the others.
+func anyPortfolioAccessible(r *http.Request, portfolios []Portfolio) bool {
+	if slug := r.URL.Query().Get("portfolio"); slug != "" {
+		for _, portfolio := range portfolios {
+			if portfolio.Slug == slug {
+				return checkPortfolioAccess(r, portfolio) == 0
+			}
+		}
+		return false
+	}
+
+	for _, portfolio := range portfolios {
+		if portfolioAccess(r, portfolio, false) == 0 {
+			return true
+		}
+	}
+	for _, portfolio := range portfolios {
+		if portfolio.Visibility == VisibilityPassword {
+			return checkPortfolioAccess(r, portfolio) == 0
+		}
+	}
+	return false
+}
+
+// portfolioPublic reports whether anyone may see a portfolio.
+func portfolioPublic(portfolio Portfolio) bool {
+	switch portfolio.Visibility {
+	case VisibilityPublic, VisibilityUnlisted, "":
+		return true
+	}
+	return false
+}
+
+// projectPublic reports whether anyone may see a project, without a
+// password or share link: it must be shown by a portfolio of its owner that
+// is public or unlisted.
+func projectPublic(userID uint, project Project) bool {
+	if main, err := mainPortfolio(DB, userID); err == nil && portfolioPublic(main) {
+		return true
+	}
+	var count int64
+	DB.Model(&PortfolioProject{}).
+		Joins("JOIN portfolios ON portfolios.id = portfolio_projects.portfolio_id AND portfolios.deleted_at IS NULL").
+		Where("portfolio_projects.project_id = ? AND portfolios.visibility IN ?", project.ID, []string{VisibilityPublic, VisibilityUnlisted, ""}).
+		Count(&count)
+	return count > 0
+}
+
+// NewShareLink is a share link as returned when it is created, the only time
+// its token is available.
+type NewShareLink struct {
+	ShareLink
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// CreateShareLink handles creating a share link for one of the authenticated
+// user's portfolios. Links expire after two weeks unless an expiry of up to a
+// year is chosen.
+func CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, status, msg := findOwnedPortfolio(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var req struct {
+		Label     string     `json:"label"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(defaultShareLinkLifetime)
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+	if !expiresAt.After(now) || expiresAt.After(now.Add(maxShareLinkLifetime)) {
+		http.Error(w, "Expiry must be in the future and within a year", http.StatusBadRequest)
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	link := ShareLink{
+		PortfolioID: portfolio.ID,
+		TokenHash:   hashShareToken(token),
+		Label:       req.Label,
+		ExpiresAt:   expiresAt,
+	}
+	if result := DB.Create(&link); result.Error != nil {
+		http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+		return
+	}
+
+	var user User
+	if result := DB.First(&user, userID); result.Error != nil {
+		http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(NewShareLink{
+		ShareLink: link,
+		Token:     token,
+		URL:       portfolioURL(publicBaseURL(r), user.Username) + "?portfolio=" + url.QueryEscape(portfolio.Slug) + "&" + shareTokenParam + "=" + token,
+	})
+}
+
+// GetShareLinks handles listing the share links of one of the authenticated
+// user's portfolios, including expired ones.
+func GetShareLinks(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	portfolio, status, msg := findOwnedPortfolio(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var links []ShareLink
+	if result := DB.Where("portfolio_id = ?", portfolio.ID).Order("created_at desc").Find(&links); result.Error != nil {
+		http.Error(w, "Failed to retrieve share links", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(links)
+}
+
+// findOwnedShareLink loads the share link identified by the "share" route
+// variable if it belongs to the portfolio identified by the "id" route
+// variable and that portfolio belongs to the authenticated user.
+func findOwnedShareLink(r *http.Request, userID uint) (ShareLink, int, string) {
+	portfolio, status, msg := findOwnedPortfolio(r, userID)
+	if status != 0 {
+		return ShareLink{}, status, msg
+	}
+
+	linkID, err := strconv.ParseUint(mux.Vars(r)["share"], 10, 64)
+	if err != nil {
+		return ShareLink{}, http.StatusBadRequest, "Invalid share link ID"
+	}
+
+	var link ShareLink
+	if result := DB.Where("portfolio_id = ?", portfolio.ID).First(&link, linkID); result.Error != nil {
+		return ShareLink{}, http.StatusNotFound, "Share link not found or not authorized"
+	}
+	return link, 0, ""
+}
+
+// GetShareLinkAccesses handles getting the access log of a share link, most
+// recent visits first.
+func GetShareLinkAccesses(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	link, status, msg := findOwnedShareLink(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var accesses []ShareLinkAccess
+	if result := DB.Where("share_link_id = ?", link.ID).Order("created_at desc").Find(&accesses); result.Error != nil {
+		http.Error(w, "Failed to retrieve access log", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(accesses)
+}
+
+// RevokeShareLink handles revoking a share link. Its access log is kept.
+func RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	link, status, msg := findOwnedShareLink(r, userID)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	if result := DB.Delete(&link); result.Error != nil {
+		http.Error(w, "Failed to revoke share link", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
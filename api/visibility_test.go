@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPortfolioPasswordAttemptsLimited(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	portfolio := Portfolio{Visibility: VisibilityPassword, PasswordHash: string(hash)}
+	portfolio.ID = 1
+
+	attempt := func(remoteAddr, password string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/portfolio/alice", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set(portfolioPasswordHeader, password)
+		return checkPortfolioAccess(r, portfolio)
+	}
+
+	if status := attempt("192.0.2.1:1234", "secret"); status != 0 {
+		t.Fatalf("right password refused with %d", status)
+	}
+	for i := 0; i < maxPasswordFailures; i++ {
+		if status := attempt("192.0.2.1:1234", "guess"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d answered %d, want 401", i+1, status)
+		}
+	}
+	if status := attempt("192.0.2.1:1234", "secret"); status != http.StatusTooManyRequests {
+		t.Errorf("password after too many failures answered %d, want 429", status)
+	}
+	if status := attempt("192.0.2.2:1234", "secret"); status != 0 {
+		t.Errorf("right password from another client refused with %d", status)
+	}
+}
+
+func TestProjectPasswordCheckedAgainstOnePortfolio(t *testing.T) {
+	portfolios := make([]Portfolio, 3)
+	for i, password := range []string{"first", "second", "third"} {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		portfolios[i] = Portfolio{Slug: password, Visibility: VisibilityPassword, PasswordHash: string(hash)}
+		portfolios[i].ID = uint(100 + i)
+	}
+	visible := func(query, password string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/api/users/alice/projects/site"+query, nil)
+		r.RemoteAddr = "192.0.2.10:1234"
+		r.Header.Set(portfolioPasswordHeader, password)
+		return anyPortfolioAccessible(r, portfolios)
+	}
+	failures := func(portfolio Portfolio) int {
+		passwordFailures.Lock()
+		defer passwordFailures.Unlock()
+		if window := passwordFailures.windows[passwordFailureKey{IP: "192.0.2.10", PortfolioID: portfolio.ID}]; window != nil {
+			return window.Count
+		}
+		return 0
+	}
+
+	if !visible("", "first") {
+		t.Error("project hidden with the first portfolio's password")
+	}
+	if !visible("?portfolio=third", "third") {
+		t.Error("project hidden with the named portfolio's password")
+	}
+	if visible("?portfolio=second", "third") {
+		t.Error("project shown with another portfolio's password")
+	}
+	if visible("", "third") {
+		t.Error("password tried against a portfolio that wasn't named")
+	}
+	// Only the portfolio the password was checked against counts failures
+	if got := failures(portfolios[0]); got != 1 {
+		t.Errorf("first portfolio has %d failures, want 1", got)
+	}
+	if got := failures(portfolios[1]); got != 1 {
+		t.Errorf("second portfolio has %d failures, want 1", got)
+	}
+	if got := failures(portfolios[2]); got != 0 {
+		t.Errorf("third portfolio has %d failures, want 0", got)
+	}
+
+	public := append([]Portfolio{{Slug: "public", Visibility: VisibilityPublic}}, portfolios...)
+	r := httptest.NewRequest(http.MethodGet, "/api/users/alice/projects/site", nil)
+	r.Header.Set(portfolioPasswordHeader, "guess")
+	if !anyPortfolioAccessible(r, public) {
+		t.Error("project shown by a public portfolio hidden")
+	}
+}
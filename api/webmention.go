@@ -0,0 +1,851 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+)
+
+const (
+	maxWebmentionPage    = 1 << 20 // Bytes of a source or target page that are read
+	maxWebmentionContent = 1000    // Characters of a mention's content that are kept
+)
+
+// webmentionClient is used to fetch mentioning pages and to notify other sites.
+var webmentionClient = newPublicClient(10 * time.Second)
+
+// webmentionJobs holds verifications of received mentions and notifications
+// of sites our posts link to, waiting to be run in the background.
+var webmentionJobs = make(chan func(), 1000)
+
+// StartWebmentionWorkers starts the background workers that verify received
+// Webmentions and send outgoing ones.
+func StartWebmentionWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for job := range webmentionJobs {
+				job()
+			}
+		}()
+	}
+}
+
+// enqueueWebmentionJob queues a job, reporting false if the queue is full
+// and the job was dropped.
+func enqueueWebmentionJob(job func()) bool {
+	select {
+	case webmentionJobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// PublicWebmention is a verified mention as shown alongside a post or portfolio.
+type PublicWebmention struct {
+	ID          uint       `json:"id"`
+	Type        string     `json:"type"`
+	Source      string     `json:"source"`
+	URL         string     `json:"url"`
+	AuthorName  string     `json:"author_name"`
+	AuthorURL   string     `json:"author_url"`
+	AuthorPhoto string     `json:"author_photo"`
+	Content     string     `json:"content"`
+	Published   *time.Time `json:"published"`
+	VerifiedAt  *time.Time `json:"verified_at"`
+}
+
+func toPublicWebmentions(mentions []Webmention) []PublicWebmention {
+	public := make([]PublicWebmention, len(mentions))
+	for i, m := range mentions {
+		public[i] = PublicWebmention{
+			ID:          m.ID,
+			Type:        m.Type,
+			Source:      m.Source,
+			URL:         m.URL,
+			AuthorName:  m.AuthorName,
+			AuthorURL:   m.AuthorURL,
+			AuthorPhoto: m.AuthorPhoto,
+			Content:     m.Content,
+			Published:   m.Published,
+			VerifiedAt:  m.VerifiedAt,
+		}
+	}
+	return public
+}
+
+// parseHTTPURL parses an absolute http or https URL.
+func parseHTTPURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("not an absolute http(s) URL")
+	}
+	return u, nil
+}
+
+// sameURL compares two URLs, ignoring fragments and a trailing slash.
+func sameURL(a, b string) bool {
+	normalize := func(s string) string {
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+		return strings.TrimRight(s, "/")
+	}
+	return normalize(a) == normalize(b)
+}
+
+// resolveWebmentionTarget finds the post or portfolio a target URL refers to.
+// Posts live at /blog/{id} and portfolios at /users/{username} on the public
+// site. The post id is nil for portfolios.
+func resolveWebmentionTarget(r *http.Request, target *url.URL) (uint, *uint, bool) {
+	site, err := url.Parse(publicBaseURL(r))
+	if err != nil || !strings.EqualFold(site.Host, target.Host) {
+		return 0, nil, false
+	}
+
+	parts := strings.Split(strings.Trim(target.Path, "/"), "/")
+	if len(parts) != 2 {
+		return 0, nil, false
+	}
+
+	switch parts[0] {
+	case "blog":
+		postID, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return 0, nil, false
+		}
+		var post Post
+		if result := DB.Where("published_at <= ?", time.Now()).First(&post, postID); result.Error != nil {
+			return 0, nil, false
+		}
+		return post.UserID, &post.ID, true
+	case "users":
+		var user User
+		if result := DB.Where("username = ?", parts[1]).First(&user); result.Error != nil {
+			return 0, nil, false
+		}
+		return user.ID, nil, true
+	}
+	return 0, nil, false
+}
+
+// ReceiveWebmention handles an incoming Webmention. The mention is recorded
+// as pending and its source is fetched and checked in the background. A
+// source sending the same mention again has it verified again, which is how
+// updates and deletions are signalled.
+func ReceiveWebmention(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	source, err := parseHTTPURL(r.PostForm.Get("source"))
+	if err != nil {
+		http.Error(w, "Invalid source URL", http.StatusBadRequest)
+		return
+	}
+	target, err := parseHTTPURL(r.PostForm.Get("target"))
+	if err != nil {
+		http.Error(w, "Invalid target URL", http.StatusBadRequest)
+		return
+	}
+	if sameURL(source.String(), target.String()) {
+		http.Error(w, "Source and target must differ", http.StatusBadRequest)
+		return
+	}
+
+	userID, postID, ok := resolveWebmentionTarget(r, target)
+	if !ok {
+		http.Error(w, "Target is not a post or portfolio on this site", http.StatusBadRequest)
+		return
+	}
+
+	var mention Webmention
+	result := DB.Unscoped().Where("source = ? AND target = ?", source.String(), target.String()).First(&mention)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		http.Error(w, "Failed to record webmention", http.StatusInternalServerError)
+		return
+	}
+	if mention.Status == WebmentionBlocked {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if mention.ID == 0 || mention.DeletedAt.Valid {
+		mention.Status = WebmentionPending
+	}
+	mention.Source = source.String()
+	mention.Target = target.String()
+	mention.UserID = userID
+	mention.PostID = postID
+	mention.DeletedAt = gorm.DeletedAt{}
+	if result := DB.Unscoped().Save(&mention); result.Error != nil {
+		http.Error(w, "Failed to record webmention", http.StatusInternalServerError)
+		return
+	}
+
+	// The mention stays pending until the source sends it again
+	mentionID := mention.ID
+	if !enqueueWebmentionJob(func() { verifyWebmention(mentionID) }) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many webmentions waiting to be verified, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// fetchPage downloads a page for Webmention processing, returning the final
+// response and up to maxWebmentionPage bytes of its body.
+func fetchPage(pageURL string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "text/html, */*;q=0.5")
+
+	resp, err := webmentionClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxWebmentionPage))
+	return resp, body, err
+}
+
+func isHTML(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// verifyWebmention fetches the source of a received mention and checks that it
+// links to the target. Verified mentions are filled in from the source's
+// microformats. A source that is gone, or no longer links to the target,
+// removes a previously verified mention.
+func verifyWebmention(mentionID uint) {
+	var mention Webmention
+	if result := DB.First(&mention, mentionID); result.Error != nil || mention.Status == WebmentionBlocked {
+		return
+	}
+
+	reject := func(reason string) {
+		if mention.Status == WebmentionVerified {
+			DB.Delete(&mention)
+			return
+		}
+		log.Printf("Rejected webmention from %s: %s", mention.Source, reason)
+		DB.Model(&mention).Update("status", WebmentionRejected)
+	}
+
+	resp, body, err := fetchPage(mention.Source)
+	if err != nil {
+		// Don't drop a verified mention because its site is briefly unreachable
+		if mention.Status != WebmentionVerified {
+			reject(err.Error())
+		}
+		return
+	}
+	if resp.StatusCode == http.StatusGone {
+		DB.Delete(&mention)
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		reject("source responded " + resp.Status)
+		return
+	}
+
+	mention.Type = "mention"
+	mention.URL = mention.Source
+	mention.AuthorName, mention.AuthorURL, mention.AuthorPhoto = "", "", ""
+	mention.Content = ""
+	mention.Published = nil
+
+	if isHTML(resp) {
+		doc, err := html.Parse(bytes.NewReader(body))
+		if err != nil {
+			reject("source is not valid HTML")
+			return
+		}
+		if !linksTo(doc, resp.Request.URL, mention.Target) {
+			reject("source does not link to target")
+			return
+		}
+		if entry := findMicroformat(doc, "h-entry"); entry != nil {
+			parseEntry(entry, resp.Request.URL, &mention)
+		}
+	} else if !bytes.Contains(body, []byte(mention.Target)) {
+		reject("source does not mention target")
+		return
+	}
+
+	now := time.Now()
+	mention.Status = WebmentionVerified
+	mention.VerifiedAt = &now
+	if result := DB.Save(&mention); result.Error != nil {
+		log.Printf("Failed to save webmention %d: %v", mention.ID, result.Error)
+	}
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// isMicroformatRoot reports whether n is the root of a microformat, such as
+// an h-entry or h-card.
+func isMicroformatRoot(n *html.Node) bool {
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if strings.HasPrefix(c, "h-") {
+			return true
+		}
+	}
+	return false
+}
+
+// linkAttr returns the URL an element points to, if any.
+func linkAttr(n *html.Node) string {
+	switch n.Data {
+	case "a", "area", "link":
+		return attr(n, "href")
+	case "img", "audio", "video", "source", "iframe":
+		return attr(n, "src")
+	case "object":
+		return attr(n, "data")
+	}
+	return ""
+}
+
+// linksTo reports whether the document contains a link to target.
+func linksTo(n *html.Node, base *url.URL, target string) bool {
+	if n.Type == html.ElementNode {
+		if href := linkAttr(n); href != "" {
+			if u, err := base.Parse(href); err == nil && sameURL(u.String(), target) {
+				return true
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if linksTo(c, base, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// findMicroformat returns the first element of the document with the class.
+func findMicroformat(n *html.Node, class string) *html.Node {
+	if n.Type == html.ElementNode && hasClass(n, class) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findMicroformat(c, class); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findProperties returns the elements carrying a property of the microformat
+// rooted at root. Properties of nested microformats are not included.
+func findProperties(root *html.Node, class string) []*html.Node {
+	var found []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if hasClass(c, class) {
+				found = append(found, c)
+			}
+			if !isMicroformatRoot(c) {
+				walk(c)
+			}
+		}
+	}
+	walk(root)
+	return found
+}
+
+func findProperty(root *html.Node, class string) *html.Node {
+	if found := findProperties(root, class); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// textValue returns the text content of an element with whitespace collapsed.
+func textValue(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			sb.WriteString(n.Data)
+			sb.WriteByte(' ')
+		case n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style"):
+			return
+		case n.Type == html.ElementNode && n.Data == "img":
+			sb.WriteString(attr(n, "alt"))
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// urlValue returns the value of a u-* property. Nested microformats are
+// represented by their own u-url.
+func urlValue(n *html.Node, base *url.URL) string {
+	raw := linkAttr(n)
+	if raw == "" && isMicroformatRoot(n) {
+		if u := findProperty(n, "u-url"); u != nil {
+			return urlValue(u, base)
+		}
+	}
+	if raw == "" {
+		raw = textValue(n)
+	}
+	u, err := base.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
+
+var publishedLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// dateValue parses the value of a dt-* property.
+func dateValue(n *html.Node) *time.Time {
+	raw := attr(n, "datetime")
+	if raw == "" {
+		raw = textValue(n)
+	}
+	for _, layout := range publishedLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(raw)); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// parseEntry fills in a mention from the h-entry of its source page.
+func parseEntry(entry *html.Node, base *url.URL, mention *Webmention) {
+	if u := findProperty(entry, "u-url"); u != nil {
+		if value := urlValue(u, base); value != "" {
+			mention.URL = value
+		}
+	}
+	if dt := findProperty(entry, "dt-published"); dt != nil {
+		mention.Published = dateValue(dt)
+	}
+
+	// The kind of response is given by which property of the entry holds the target
+	for _, kind := range []struct{ class, name string }{
+		{"u-in-reply-to", "reply"},
+		{"u-like-of", "like"},
+		{"u-repost-of", "repost"},
+		{"u-bookmark-of", "bookmark"},
+	} {
+		for _, prop := range findProperties(entry, kind.class) {
+			if sameURL(urlValue(prop, base), mention.Target) {
+				mention.Type = kind.name
+			}
+		}
+	}
+
+	author := findProperty(entry, "p-author")
+	if author == nil {
+		author = findProperty(entry, "u-author")
+	}
+	switch {
+	case author == nil:
+	case hasClass(author, "h-card"):
+		if name := findProperty(author, "p-name"); name != nil {
+			mention.AuthorName = textValue(name)
+		} else {
+			mention.AuthorName = textValue(author)
+		}
+		if u := findProperty(author, "u-url"); u != nil {
+			mention.AuthorURL = urlValue(u, base)
+		} else if linkAttr(author) != "" {
+			mention.AuthorURL = urlValue(author, base)
+		}
+		if photo := findProperty(author, "u-photo"); photo != nil {
+			mention.AuthorPhoto = urlValue(photo, base)
+		}
+	default:
+		mention.AuthorName = textValue(author)
+		if linkAttr(author) != "" {
+			mention.AuthorURL = urlValue(author, base)
+		}
+	}
+
+	for _, class := range []string{"e-content", "p-content", "p-summary", "p-name"} {
+		if prop := findProperty(entry, class); prop != nil {
+			if text := textValue(prop); text != "" {
+				mention.Content = truncateWords(strings.Fields(text), maxWebmentionContent)
+				break
+			}
+		}
+	}
+}
+
+// publicWebmentionListSpec describes the listing of the verified
+// Webmentions of a post or portfolio, oldest first.
+var publicWebmentionListSpec = ListSpec{
+	Table: "webmentions",
+	SortKeys: map[string]ListSortKey{
+		"date": {Expr: "COALESCE(webmentions.published, webmentions.verified_at)", IsTime: true},
+	},
+	DefaultSort:  "date",
+	DefaultOrder: "asc",
+}
+
+// receivedWebmentionListSpec describes the listing of the Webmentions a user
+// received, most recently changed first.
+var receivedWebmentionListSpec = ListSpec{
+	Table: "webmentions",
+	SortKeys: map[string]ListSortKey{
+		"date": {Expr: "webmentions.updated_at", IsTime: true},
+	},
+	DefaultSort: "date",
+}
+
+// listWebmentions loads the page of mentions matching base that the
+// request asks for and sets the headers pointing at the next page.
+func listWebmentions(w http.ResponseWriter, r *http.Request, spec ListSpec, base *gorm.DB) ([]Webmention, int, string) {
+	opts, err := ParseListOptions(r, spec)
+	if err != nil {
+		return nil, http.StatusBadRequest, err.Error()
+	}
+	query, err := spec.Apply(base, opts)
+	if err != nil {
+		return nil, http.StatusBadRequest, err.Error()
+	}
+
+	var mentions []Webmention
+	if result := query.Find(&mentions); result.Error != nil {
+		return nil, http.StatusInternalServerError, "Failed to retrieve webmentions"
+	}
+	if len(mentions) > opts.Limit {
+		mentions = mentions[:opts.Limit]
+		if err := spec.SetNextPage(w, r, opts, mentions[len(mentions)-1].ID); err != nil {
+			return nil, http.StatusInternalServerError, "Failed to retrieve webmentions"
+		}
+	}
+	return mentions, 0, ""
+}
+
+// GetPostWebmentions handles listing the verified Webmentions of a post.
+func GetPostWebmentions(w http.ResponseWriter, r *http.Request) {
+	post, status, msg := findPostFromRoute(r)
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	mentions, status, msg := listWebmentions(w, r, publicWebmentionListSpec,
+		DB.Where("post_id = ? AND status = ?", post.ID, WebmentionVerified))
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	json.NewEncoder(w).Encode(toPublicWebmentions(mentions))
+}
+
+// GetPortfolioWebmentions handles listing the verified Webmentions of a
+// user's portfolio. They are only shown to callers who may see the
+// portfolio.
+func GetPortfolioWebmentions(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if result := DB.Where("username = ?", mux.Vars(r)["username"]).First(&user); result.Error != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var portfolio Portfolio
+	if result := DB.Where("user_id = ?", user.ID).Order("is_default desc, id asc").First(&portfolio); result.Error != nil {
+		http.Error(w, "Portfolio not found", http.StatusNotFound)
+		return
+	}
+	if status := checkPortfolioAccess(r, portfolio); status != 0 {
+		writePortfolioAccessError(w, status)
+		return
+	}
+	setPortfolioVisibilityHeaders(w, portfolio)
+
+	mentions, status, msg := listWebmentions(w, r, publicWebmentionListSpec,
+		DB.Where("user_id = ? AND post_id IS NULL AND status = ?", user.ID, WebmentionVerified))
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	json.NewEncoder(w).Encode(toPublicWebmentions(mentions))
+}
+
+// GetReceivedWebmentions handles listing every Webmention of the authenticated
+// user's posts and portfolio, including pending and rejected ones.
+func GetReceivedWebmentions(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	mentions, status, msg := listWebmentions(w, r, receivedWebmentionListSpec, DB.Where("user_id = ?", userID))
+	if status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+
+	json.NewEncoder(w).Encode(mentions)
+}
+
+// DeleteWebmention handles removing a Webmention of the authenticated user's
+// post or portfolio, such as spam. The mention is blocked rather than deleted
+// so that sending it again doesn't bring it back.
+func DeleteWebmention(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	mentionID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webmention ID", http.StatusBadRequest)
+		return
+	}
+
+	result := DB.Model(&Webmention{}).Where("id = ? AND user_id = ?", mentionID, userID).Update("status", WebmentionBlocked)
+	if result.Error != nil {
+		http.Error(w, "Failed to delete webmention", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Webmention not found or not authorized", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// outgoingLinks returns the absolute links in a post's content that point
+// away from our own site.
+func outgoingLinks(post Post, siteHost string) []string {
+	doc, err := html.Parse(strings.NewReader(RenderPostContent(post.Content)))
+	if err != nil {
+		return nil
+	}
+
+	var links []string
+	seen := make(map[string]bool)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			if u, err := parseHTTPURL(attr(n, "href")); err == nil && !strings.EqualFold(u.Host, siteHost) {
+				u.Fragment = ""
+				if !seen[u.String()] {
+					seen[u.String()] = true
+					links = append(links, u.String())
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return links
+}
+
+// sendPostWebmentions notifies the sites a published post links to. Sites
+// notified before are notified again even if the post no longer links to
+// them, so they can drop the mention. Notifications are sent in the
+// background.
+func sendPostWebmentions(r *http.Request, post Post) {
+	if post.PublishedAt.After(time.Now()) {
+		return
+	}
+
+	base := publicBaseURL(r)
+	site, err := url.Parse(base)
+	if err != nil {
+		return
+	}
+	source := postURL(base, post)
+	targets := outgoingLinks(post, site.Host)
+	postID := post.ID
+
+	queued := enqueueWebmentionJob(func() {
+		var sent []SentWebmention
+		DB.Where("post_id = ?", postID).Find(&sent)
+		previous := make(map[string]SentWebmention)
+		for _, s := range sent {
+			previous[s.Target] = s
+		}
+
+		for _, target := range targets {
+			notifyTarget(postID, source, target, previous[target])
+			delete(previous, target)
+		}
+		for target, s := range previous {
+			notifyTarget(postID, source, target, s)
+		}
+	})
+	if !queued {
+		log.Printf("Webmention queue is full, not notifying links of post %d", postID)
+	}
+}
+
+// notifyTarget sends a Webmention for one link of a post and records the
+// outcome.
+func notifyTarget(postID uint, source, target string, record SentWebmention) {
+	record.PostID = postID
+	record.Target = target
+
+	endpoint, err := discoverWebmentionEndpoint(target)
+	if err != nil {
+		log.Printf("Failed to discover webmention endpoint of %s: %v", target, err)
+	}
+	record.Endpoint = endpoint
+	record.StatusCode = 0
+
+	if endpoint != "" {
+		resp, err := webmentionClient.PostForm(endpoint, url.Values{"source": {source}, "target": {target}})
+		if err != nil {
+			log.Printf("Failed to send webmention to %s: %v", endpoint, err)
+		} else {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+			record.StatusCode = resp.StatusCode
+		}
+	}
+
+	if result := DB.Save(&record); result.Error != nil {
+		log.Printf("Failed to record webmention to %s: %v", target, result.Error)
+	}
+}
+
+// discoverWebmentionEndpoint finds the Webmention endpoint a page advertises,
+// first in its Link headers and then in its HTML. It returns an empty string
+// if there is none.
+func discoverWebmentionEndpoint(target string) (string, error) {
+	resp, body, err := fetchPage(target)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("target responded %s", resp.Status)
+	}
+	base := resp.Request.URL
+
+	resolve := func(href string) string {
+		u, err := base.Parse(href)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return ""
+		}
+		return u.String()
+	}
+
+	for _, header := range resp.Header.Values("Link") {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			href := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(href, "<") || !strings.HasSuffix(href, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || strings.ToLower(strings.TrimSpace(name)) != "rel" {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+					if strings.EqualFold(rel, "webmention") {
+						return resolve(href[1 : len(href)-1]), nil
+					}
+				}
+			}
+		}
+	}
+
+	if !isHTML(resp) {
+		return "", nil
+	}
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	var endpoint *string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if endpoint != nil {
+			return
+		}
+		if n.Type == html.ElementNode && (n.Data == "link" || n.Data == "a") {
+			for _, rel := range strings.Fields(attr(n, "rel")) {
+				if strings.EqualFold(rel, "webmention") {
+					for _, a := range n.Attr {
+						if a.Key == "href" {
+							// An empty href means the page is its own endpoint
+							resolved := resolve(a.Val)
+							endpoint = &resolved
+							return
+						}
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if endpoint == nil {
+		return "", nil
+	}
+	return *endpoint, nil
+}
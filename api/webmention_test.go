@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebmentionListOptions(t *testing.T) {
+	tests := []struct {
+		spec      ListSpec
+		query     string
+		wantOrder string
+		wantErr   bool
+	}{
+		{spec: publicWebmentionListSpec, wantOrder: "asc"},
+		{spec: publicWebmentionListSpec, query: "?order=desc", wantOrder: "desc"},
+		{spec: receivedWebmentionListSpec, wantOrder: "desc"},
+		{spec: publicWebmentionListSpec, query: "?sort=popular", wantErr: true},
+		// A cursor of the default order can't be used for the other one
+		{spec: publicWebmentionListSpec, query: "?order=desc&cursor=" + encodeListCursor(listCursor{Sort: "date", Order: "asc"}), wantErr: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/posts/1/webmentions"+tt.query, nil)
+		opts, err := ParseListOptions(r, tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseListOptions(%s) accepted", tt.query)
+			}
+			continue
+		}
+		if err != nil || opts.Order != tt.wantOrder || opts.Limit != defaultListLimit {
+			t.Errorf("ParseListOptions(%s) = %+v, %v, want order %s", tt.query, opts, err, tt.wantOrder)
+		}
+	}
+}
@@ -36,7 +36,8 @@ export default function BlogPost() {
 
     const fetchPost = async () => {
       try {
-        const response = await fetch(`/api/posts/${postId}`);
+        // Passed on so views can be attributed to the site that linked here
+        const response = await fetch(`/api/posts/${postId}`, { headers: { 'X-Referrer': document.referrer } });
         if (!response.ok) {
           throw new Error('Failed to fetch post');
         }
@@ -7,7 +7,8 @@ import Link from 'next/link';
 interface Post {
   ID: number;
   Title: string;
-  Content: string;
+  Excerpt: string;
+  ReadingTime: number;
   PublishedAt: string;
   UserID: number;
 }
@@ -54,8 +55,8 @@ export default function BlogList() {
           posts.map((post) => (
             <div key={post.ID} className="bg-white shadow-md rounded-lg p-6">
               <h2 className="text-2xl font-semibold mb-2">{post.Title}</h2>
-              <p className="text-gray-600 text-sm mb-4">Published: {new Date(post.PublishedAt).toLocaleDateString()}</p>
-              <p className="text-gray-700 mb-4 line-clamp-3">{post.Content}</p>
+              <p className="text-gray-600 text-sm mb-4">Published: {new Date(post.PublishedAt).toLocaleDateString()} · {post.ReadingTime} min read</p>
+              <p className="text-gray-700 mb-4 line-clamp-3">{post.Excerpt}</p>
               <Link href={`/blog/${post.ID}`} className="text-blue-500 hover:underline">
                 Read More
               </Link>
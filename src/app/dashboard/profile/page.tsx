@@ -40,7 +40,7 @@ export default function ProfileSettings() {
           }
           const data = await response.json();
           setBio(data.user.bio || '');
-          setSocialMediaLinks(data.user.social_media_links || '');
+          setSocialMediaLinks((data.user.social_links || []).map((link: { URL: string }) => link.URL).join(', '));
           setProfilePictureUrl(data.user.profile_picture_url || '');
         } catch (err: any) {
           setError(err.message);
@@ -63,7 +63,7 @@ export default function ProfileSettings() {
           'Content-Type': 'application/json',
           Authorization: `Bearer ${token}`,
         },
-        body: JSON.stringify({ username, email, bio, social_media_links: socialMediaLinks, profile_picture_url: profilePictureUrl }),
+        body: JSON.stringify({ username, email, bio, profile_picture_url: profilePictureUrl }),
       });
 
       if (!response.ok) {
@@ -71,6 +71,19 @@ export default function ProfileSettings() {
         throw new Error(errorData.error || 'Failed to update profile');
       }
 
+      const links = socialMediaLinks.split(',').map((link) => link.trim()).filter(Boolean);
+      const linksResponse = await fetch('/api/auth/social-links', {
+        method: 'PUT',
+        headers: {
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${token}`,
+        },
+        body: JSON.stringify(links.map((url) => ({ url }))),
+      });
+      if (!linksResponse.ok) {
+        throw new Error((await linksResponse.text()) || 'Failed to update social links');
+      }
+
       setMessage('Profile updated successfully!');
       // Optionally, re-fetch user data or update context if needed
     } catch (err: any) {
@@ -41,10 +41,18 @@ interface Achievement {
   Date: string;
 }
 
+interface SocialLink {
+  ID: number;
+  Platform: string;
+  URL: string;
+  Handle: string;
+  VerifiedAt: string | null;
+}
+
 interface User {
   username: string;
   bio: string;
-  social_media_links: string;
+  social_links: SocialLink[];
   profile_picture_url: string;
 }
 
@@ -63,7 +71,16 @@ export default function UserProfile() {
 
     const fetchPortfolio = async () => {
       try {
-        const response = await fetch(`/api/portfolio/${username}`);
+        // Other portfolios and share links are addressed through query parameters
+        const params = new URLSearchParams(window.location.search);
+        const slug = params.get('portfolio');
+        const share = params.get('share');
+        let url = slug ? `/api/portfolio/${username}/${encodeURIComponent(slug)}` : `/api/portfolio/${username}`;
+        if (share) {
+          url += `?share=${encodeURIComponent(share)}`;
+        }
+        // Passed on so views can be attributed to the site that linked here
+        const response = await fetch(url, { headers: { 'X-Referrer': document.referrer } });
         if (!response.ok) {
           throw new Error('Failed to fetch portfolio');
         }
@@ -173,16 +190,13 @@ export default function UserProfile() {
         <h1 className="text-5xl font-bold mb-2">{portfolio.Title}</h1>
         <p className="text-xl text-gray-600">{portfolio.Description}</p>
         {portfolio.user.bio && <p className="text-lg text-gray-800 mt-4">{portfolio.user.bio}</p>}
-        {portfolio.user.social_media_links && (
+        {portfolio.user.social_links?.length > 0 && (
           <div className="flex justify-center space-x-4 mt-4">
-            {portfolio.user.social_media_links.split(',').map((link, index) => {
-              const url = link.trim();
-              return (
-                <a key={index} href={url} target="_blank" rel="noopener noreferrer" className="text-blue-500 hover:underline">
-                  {url}
-                </a>
-              );
-            })}
+            {portfolio.user.social_links.map((link) => (
+              <a key={link.ID} href={link.URL} target="_blank" rel="me noopener noreferrer" className="text-blue-500 hover:underline">
+                {link.Handle || link.URL}
+              </a>
+            ))}
           </div>
         )}
       </header>